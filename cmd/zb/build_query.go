@@ -0,0 +1,232 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+// displayTimeFormat is the layout used to show timestamps to humans.
+const displayTimeFormat = time.DateTime
+
+type buildListOptions struct {
+	status        string
+	startedAfter  timeFlag
+	startedBefore timeFlag
+	drvName       string
+	limit         int
+	jsonFormat    bool
+}
+
+func newBuildListCommand(g *globalConfig) *cobra.Command {
+	c := &cobra.Command{
+		Use:                   "list [options]",
+		Short:                 "list builds run by the store",
+		Long:                  "List builds run by the store, most recent first.",
+		DisableFlagsInUseLine: true,
+		Args:                  cobra.NoArgs,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	opts := new(buildListOptions)
+	c.Flags().StringVar(&opts.status, "status", "", "only show builds with the given `status` (active, success, fail, error, or timedOut)")
+	c.Flags().Var(&opts.startedAfter, "since", "only show builds started at or after the given `time` (a timestamp, date, or duration ago)")
+	c.Flags().Var(&opts.startedBefore, "until", "only show builds started before the given `time` (a timestamp, date, or duration ago)")
+	c.Flags().StringVar(&opts.drvName, "drv", "", "only show builds that realized a derivation with the given `name`")
+	c.Flags().IntVarP(&opts.limit, "limit", "n", 20, "show at most `n` builds (0 for no limit)")
+	c.Flags().BoolVar(&opts.jsonFormat, "json", false, "print builds as JSON")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		return runBuildList(cmd.Context(), g, opts)
+	}
+	return c
+}
+
+func runBuildList(ctx context.Context, g *globalConfig, opts *buildListOptions) error {
+	if opts.limit < 0 {
+		return fmt.Errorf("--limit must not be negative")
+	}
+	status := zbstorerpc.BuildStatus(opts.status)
+	if status != "" && status != zbstorerpc.BuildActive && !status.IsFinished() {
+		return fmt.Errorf("invalid status %q", opts.status)
+	}
+
+	storeClient, waitStoreClient := g.storeClient(nil)
+	defer func() {
+		storeClient.Close()
+		waitStoreClient()
+	}()
+
+	req := &zbstorerpc.ListBuildsRequest{
+		Status:  status,
+		DrvName: opts.drvName,
+	}
+	if t := time.Time(opts.startedAfter); !t.IsZero() {
+		req.StartedAfter = zbstorerpc.NonNull(t)
+	}
+	if t := time.Time(opts.startedBefore); !t.IsZero() {
+		req.StartedBefore = zbstorerpc.NonNull(t)
+	}
+
+	var tw *tabwriter.Writer
+	if !opts.jsonFormat {
+		tw = tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tSTATUS\tSTARTED\tDURATION")
+	}
+	n := 0
+	for opts.limit == 0 || n < opts.limit {
+		if opts.limit > 0 {
+			req.Limit = opts.limit - n
+		}
+		// Keep builds as raw JSON so that --json preserves unknown fields.
+		var resp struct {
+			Builds        []json.RawMessage `json:"builds"`
+			NextPageToken string            `json:"nextPageToken"`
+		}
+		if err := jsonrpc.Do(ctx, storeClient, zbstorerpc.ListBuildsMethod, &resp, req); err != nil {
+			return err
+		}
+		for _, rawBuild := range resp.Builds {
+			if opts.jsonFormat {
+				jsonBytes, err := dedentJSON(rawBuild)
+				if err != nil {
+					return err
+				}
+				jsonBytes = append(jsonBytes, '\n')
+				if _, err := os.Stdout.Write(jsonBytes); err != nil {
+					return err
+				}
+			} else {
+				build := new(zbstorerpc.Build)
+				if err := json.Unmarshal(rawBuild, build); err != nil {
+					return err
+				}
+				fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n",
+					build.ID, build.Status, build.StartedAt.Local().Format(displayTimeFormat), formatBuildDuration(build))
+			}
+			n++
+		}
+		if resp.NextPageToken == "" {
+			break
+		}
+		req.PageToken = resp.NextPageToken
+	}
+	if tw != nil {
+		return tw.Flush()
+	}
+	return nil
+}
+
+type buildShowOptions struct {
+	buildID    string
+	jsonFormat bool
+}
+
+func newBuildShowCommand(g *globalConfig) *cobra.Command {
+	c := &cobra.Command{
+		Use:                   "show [options] BUILD_ID",
+		Short:                 "show the results of a build",
+		DisableFlagsInUseLine: true,
+		Args:                  cobra.ExactArgs(1),
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	opts := new(buildShowOptions)
+	c.Flags().BoolVar(&opts.jsonFormat, "json", false, "print build as JSON")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		opts.buildID = args[0]
+		return runBuildShow(cmd.Context(), g, opts)
+	}
+	return c
+}
+
+func runBuildShow(ctx context.Context, g *globalConfig, opts *buildShowOptions) error {
+	storeClient, waitStoreClient := g.storeClient(nil)
+	defer func() {
+		storeClient.Close()
+		waitStoreClient()
+	}()
+
+	var rawBuild json.RawMessage
+	err := jsonrpc.Do(ctx, storeClient, zbstorerpc.GetBuildMethod, &rawBuild, &zbstorerpc.GetBuildRequest{
+		BuildID: opts.buildID,
+	})
+	if err != nil {
+		return err
+	}
+	build := new(zbstorerpc.Build)
+	if err := json.Unmarshal(rawBuild, build); err != nil {
+		return err
+	}
+	if build.Status == zbstorerpc.BuildUnknown {
+		return fmt.Errorf("build %s not found in store", opts.buildID)
+	}
+
+	var buf []byte
+	if opts.jsonFormat {
+		// Dump response directly to preserve unknown fields.
+		buf, err = dedentJSON(rawBuild)
+		if err != nil {
+			return err
+		}
+		buf = append(buf, '\n')
+	} else {
+		buf = appendBuildText(nil, build, time.Local)
+	}
+	_, err = os.Stdout.Write(buf)
+	return err
+}
+
+// appendBuildText appends a human-readable description of build to dst.
+// Times are shown in the given location.
+func appendBuildText(dst []byte, build *zbstorerpc.Build, loc *time.Location) []byte {
+	dst = fmt.Appendf(dst, "ID:       %s\n", build.ID)
+	dst = fmt.Appendf(dst, "Status:   %s\n", build.Status)
+	dst = fmt.Appendf(dst, "Started:  %s\n", build.StartedAt.In(loc).Format(displayTimeFormat))
+	if build.EndedAt.Valid {
+		dst = fmt.Appendf(dst, "Ended:    %s\n", build.EndedAt.X.In(loc).Format(displayTimeFormat))
+		dst = fmt.Appendf(dst, "Duration: %s\n", formatBuildDuration(build))
+	}
+	if build.Error != "" {
+		dst = fmt.Appendf(dst, "Error:    %s\n", build.Error)
+	}
+
+	for _, result := range build.Results {
+		dst = append(dst, '\n')
+		dst = fmt.Appendf(dst, "%s: %s", result.DrvPath, result.Status)
+		if result.EndedAt.Valid {
+			dst = fmt.Appendf(dst, " in %s", formatElapsed(result.Duration()))
+		}
+		dst = append(dst, '\n')
+		if result.Error != "" {
+			dst = fmt.Appendf(dst, "  error: %s\n", result.Error)
+		}
+		for _, output := range result.Outputs {
+			if output.Path.Valid {
+				dst = fmt.Appendf(dst, "  %s: %s\n", output.Name, output.Path.X)
+			} else {
+				dst = fmt.Appendf(dst, "  %s: (not built)\n", output.Name)
+			}
+			if output.CheckPath.Valid && output.CheckPath != output.Path {
+				dst = fmt.Appendf(dst, "  %s (check): %s\n", output.Name, output.CheckPath.X)
+			}
+		}
+	}
+	return dst
+}
+
+func formatBuildDuration(build *zbstorerpc.Build) string {
+	if !build.EndedAt.Valid {
+		return "-"
+	}
+	return formatElapsed(build.Duration())
+}
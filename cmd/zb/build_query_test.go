@@ -0,0 +1,83 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestAppendBuildText(t *testing.T) {
+	startTime := time.Date(2025, time.January, 2, 15, 4, 5, 0, time.UTC)
+	build := &zbstorerpc.Build{
+		ID:        testBuildID,
+		Status:    zbstorerpc.BuildFail,
+		StartedAt: startTime,
+		EndedAt:   zbstorerpc.NonNull(startTime.Add(90 * time.Second)),
+		Results: []*zbstorerpc.BuildResult{
+			{
+				DrvPath:   testHelloPath,
+				Status:    zbstorerpc.BuildSuccess,
+				StartedAt: zbstorerpc.NonNull(startTime),
+				EndedAt:   zbstorerpc.NonNull(startTime.Add(1500 * time.Millisecond)),
+				Outputs: []*zbstorerpc.RealizeOutput{
+					{
+						Name: zbstore.DefaultDerivationOutputName,
+						Path: zbstorerpc.NonNull(zbstore.Path("/opt/zb/store/2lvf1cavwkainjz32xzja04hfl5cimx6-hello")),
+					},
+				},
+			},
+			{
+				DrvPath:   testFailedPath,
+				Status:    zbstorerpc.BuildFail,
+				StartedAt: zbstorerpc.NonNull(startTime.Add(2 * time.Second)),
+				EndedAt:   zbstorerpc.NonNull(startTime.Add(90 * time.Second)),
+				Error:     "exit status 1",
+				Outputs: []*zbstorerpc.RealizeOutput{
+					{Name: zbstore.DefaultDerivationOutputName},
+				},
+			},
+			{
+				DrvPath:   "/opt/zb/store/s3fvyacpqsbhwbrd7qd7mw1sxqxwqp3n-random.drv",
+				Status:    zbstorerpc.BuildFail,
+				StartedAt: zbstorerpc.NonNull(startTime.Add(2 * time.Second)),
+				EndedAt:   zbstorerpc.NonNull(startTime.Add(3 * time.Second)),
+				Error:     "not deterministic",
+				Outputs: []*zbstorerpc.RealizeOutput{
+					{
+						Name:      zbstore.DefaultDerivationOutputName,
+						Path:      zbstorerpc.NonNull(zbstore.Path("/opt/zb/store/0k1b3pyg3ddq0m3nnv4fvbfvcm1sc1ij-random")),
+						CheckPath: zbstorerpc.NonNull(zbstore.Path("/opt/zb/store/yb2nb3mjv3ha3wfc8m6kfqaw9mfdjlrm-random")),
+					},
+				},
+			},
+		},
+	}
+
+	got := string(appendBuildText(nil, build, time.UTC))
+	want := "ID:       " + testBuildID + "\n" +
+		"Status:   fail\n" +
+		"Started:  2025-01-02 15:04:05\n" +
+		"Ended:    2025-01-02 15:05:35\n" +
+		"Duration: 1m30s\n" +
+		"\n" +
+		string(testHelloPath) + ": success in 1.5s\n" +
+		"  out: /opt/zb/store/2lvf1cavwkainjz32xzja04hfl5cimx6-hello\n" +
+		"\n" +
+		string(testFailedPath) + ": fail in 1m28s\n" +
+		"  error: exit status 1\n" +
+		"  out: (not built)\n" +
+		"\n" +
+		"/opt/zb/store/s3fvyacpqsbhwbrd7qd7mw1sxqxwqp3n-random.drv: fail in 1.0s\n" +
+		"  error: not deterministic\n" +
+		"  out: /opt/zb/store/0k1b3pyg3ddq0m3nnv4fvbfvcm1sc1ij-random\n" +
+		"  out (check): /opt/zb/store/yb2nb3mjv3ha3wfc8m6kfqaw9mfdjlrm-random\n"
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("appendBuildText(...) (-want +got):\n%s", diff)
+	}
+}
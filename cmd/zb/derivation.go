@@ -112,7 +112,9 @@ func runDerivationShow(ctx context.Context, g *globalConfig, opts *derivationSho
 		storeClient.Close()
 		waitStoreClient()
 	}()
-	eval, err := opts.newEval(g, storeClient)
+	progress := newBuildReporter(opts.logFormat)
+	defer progress.close()
+	eval, err := opts.newEval(g, storeClient, progress)
 	if err != nil {
 		return err
 	}
@@ -142,6 +144,8 @@ func runDerivationShow(ctx context.Context, g *globalConfig, opts *derivationSho
 	if len(results) == 0 {
 		return fmt.Errorf("no evaluation results")
 	}
+	// Finish the progress display before writing results.
+	progress.close()
 
 	resultIndex := 0
 	for i := range opts.args {
@@ -356,7 +360,9 @@ func runDerivationEnv(ctx context.Context, g *globalConfig, opts *derivationEnvO
 		storeClient.Close()
 		waitStoreClient()
 	}()
-	eval, err := opts.newEval(g, storeClient)
+	progress := newBuildReporter(opts.logFormat)
+	defer progress.close()
+	eval, err := opts.newEval(g, storeClient, progress)
 	if err != nil {
 		return err
 	}
@@ -395,10 +401,12 @@ func runDerivationEnv(ctx context.Context, g *globalConfig, opts *derivationEnvO
 	if err != nil {
 		return err
 	}
-	build, rawBuild, err := waitForBuild(ctx, storeClient, expandResponse.BuildID)
+	build, rawBuild, err := waitForBuild(ctx, storeClient, progress, expandResponse)
 	if err != nil {
 		return err
 	}
+	// Finish the progress display before writing results.
+	progress.close()
 	if build.Expand == nil {
 		return fmt.Errorf("build %s did not provide expand information", expandResponse.BuildID)
 	}
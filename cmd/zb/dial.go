@@ -0,0 +1,131 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+// parseStoreAddress parses the address of a store server.
+// The address can be a path to a Unix socket, a unix:// URL,
+// or a tls://HOST:PORT URL.
+func parseStoreAddress(addr string) (*url.URL, error) {
+	if !strings.Contains(addr, "://") {
+		path := filepath.ToSlash(addr)
+		if filepath.IsAbs(addr) && !strings.HasPrefix(path, "/") {
+			// Windows drive letter paths (e.g. "C:/foo").
+			path = "/" + path
+		}
+		return &url.URL{Scheme: "unix", Path: path}, nil
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("store address: %v", err)
+	}
+	switch u.Scheme {
+	case "unix":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("store address: unix URLs cannot have a host")
+		}
+		if u.Path == "" {
+			return nil, fmt.Errorf("store address: unix URL missing path")
+		}
+	case "tls":
+		if u.Hostname() == "" || u.Port() == "" {
+			return nil, fmt.Errorf("store address: tls URL must be of the form tls://HOST:PORT")
+		}
+		if u.Path != "" && u.Path != "/" {
+			return nil, fmt.Errorf("store address: tls URLs cannot have a path")
+		}
+	default:
+		return nil, fmt.Errorf("store address: unsupported URL %s", u.Redacted())
+	}
+	return u, nil
+}
+
+// dialStore connects to the store server at the given address
+// (see [parseStoreAddress] for the accepted forms).
+// If token is not empty, it is sent to the store server on TLS connections
+// to authenticate the client.
+func dialStore(ctx context.Context, addr string, token string) (net.Conn, error) {
+	u, err := parseStoreAddress(addr)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "unix" {
+		return (&net.Dialer{}).DialContext(ctx, "unix", urlFilePath(u))
+	}
+
+	config, err := clientTLSConfig(u)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := (&tls.Dialer{Config: config}).DialContext(ctx, "tcp", u.Host)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		if err := zbstorerpc.WriteAuth(conn, token); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+// clientTLSConfig returns the TLS configuration for connecting to a tls:// store URL.
+// The "ca" query parameter names a PEM file of certificate authorities to trust
+// instead of the system's certificate authorities.
+// The "cert" and "key" query parameters name PEM files
+// for a client certificate and its private key.
+func clientTLSConfig(u *url.URL) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: u.Hostname(),
+		MinVersion: tls.VersionTLS12,
+	}
+	query := u.Query()
+	if caFile := query.Get("ca"); caFile != "" {
+		var err error
+		config.RootCAs, err = readCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+	}
+	certFile, keyFile := query.Get("cert"), query.Get("key")
+	if (certFile == "") != (keyFile == "") {
+		return nil, fmt.Errorf("store address: cert and key must be given together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
+
+// readCertPool returns a certificate pool
+// containing the PEM-encoded certificates in the given file.
+func readCertPool(path string) (*x509.CertPool, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("%s: no certificates found", path)
+	}
+	return pool, nil
+}
@@ -0,0 +1,46 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestParseStoreAddress(t *testing.T) {
+	localPath, localURL := "/opt/zb/var/zb/server.sock", "unix:///opt/zb/var/zb/server.sock"
+	if runtime.GOOS == "windows" {
+		localPath, localURL = `C:\zb\var\zb\server.sock`, "unix:///C:/zb/var/zb/server.sock"
+	}
+	tests := []struct {
+		addr string
+		want string
+		err  bool
+	}{
+		{addr: localPath, want: localURL},
+		{addr: "unix:///opt/zb/var/zb/server.sock", want: "unix:///opt/zb/var/zb/server.sock"},
+		{addr: "tls://zb.example.com:7777", want: "tls://zb.example.com:7777"},
+		{addr: "tls://zb.example.com:7777?ca=/etc/zb/ca.pem", want: "tls://zb.example.com:7777?ca=/etc/zb/ca.pem"},
+		{addr: "tls://zb.example.com", err: true},
+		{addr: "tls://zb.example.com:7777/foo", err: true},
+		{addr: "tcp://zb.example.com:7777", err: true},
+		{addr: "unix://example.com/server.sock", err: true},
+	}
+	for _, test := range tests {
+		u, err := parseStoreAddress(test.addr)
+		if err != nil {
+			if !test.err {
+				t.Errorf("parseStoreAddress(%q): %v", test.addr, err)
+			}
+			continue
+		}
+		if test.err {
+			t.Errorf("parseStoreAddress(%q) = %v, <nil>; want error", test.addr, u)
+			continue
+		}
+		if got := u.String(); got != test.want {
+			t.Errorf("parseStoreAddress(%q) = %v; want %v", test.addr, got, test.want)
+		}
+	}
+}
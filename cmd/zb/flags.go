@@ -6,9 +6,12 @@ package main
 import (
 	"bytes"
 	"encoding/csv"
+	"fmt"
+	"math"
 	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"zb.256lights.llc/pkg/sets"
 	"zb.256lights.llc/pkg/zbstore"
@@ -181,3 +184,116 @@ func (f *storeDirectoryFlag) Set(s string) error {
 	*f = storeDirectoryFlag(dir)
 	return nil
 }
+
+// byteSizeFlag is a [github.com/spf13/pflag.Value] for a number of bytes.
+// It accepts an integer followed by an optional unit suffix:
+// K, M, G, or T (or KiB, MiB, GiB, TiB) for powers of 1024
+// or KB, MB, GB, or TB for powers of 1000.
+type byteSizeFlag int64
+
+func (f *byteSizeFlag) Type() string  { return "size" }
+func (f byteSizeFlag) String() string { return strconv.FormatInt(int64(f), 10) }
+func (f byteSizeFlag) Get() any       { return int64(f) }
+
+func (f *byteSizeFlag) Set(s string) error {
+	n, err := parseByteSize(s)
+	if err != nil {
+		return err
+	}
+	*f = byteSizeFlag(n)
+	return nil
+}
+
+func parseByteSize(s string) (int64, error) {
+	numEnd := strings.IndexFunc(s, func(c rune) bool { return c < '0' || c > '9' })
+	if numEnd == -1 {
+		numEnd = len(s)
+	}
+	if numEnd == 0 {
+		return 0, fmt.Errorf("parse size %q: missing number", s)
+	}
+	n, err := strconv.ParseInt(s[:numEnd], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("parse size %q: %v", s, err)
+	}
+	var unit int64
+	switch strings.ToUpper(s[numEnd:]) {
+	case "", "B":
+		unit = 1
+	case "K", "KIB":
+		unit = 1 << 10
+	case "M", "MIB":
+		unit = 1 << 20
+	case "G", "GIB":
+		unit = 1 << 30
+	case "T", "TIB":
+		unit = 1 << 40
+	case "KB":
+		unit = 1e3
+	case "MB":
+		unit = 1e6
+	case "GB":
+		unit = 1e9
+	case "TB":
+		unit = 1e12
+	default:
+		return 0, fmt.Errorf("parse size %q: unknown unit %q", s, s[numEnd:])
+	}
+	if n > math.MaxInt64/unit {
+		return 0, fmt.Errorf("parse size %q: too large", s)
+	}
+	return n * unit, nil
+}
+
+// formatByteSize formats a number of bytes for display to a human.
+func formatByteSize(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	x := float64(n) / 1024
+	i := 0
+	for ; x >= 1024 && i < len(units)-1; i++ {
+		x /= 1024
+	}
+	return fmt.Sprintf("%.1f %ciB", x, units[i])
+}
+
+// timeFlag is a [github.com/spf13/pflag.Value] for a point in time.
+// It accepts an RFC 3339 timestamp (e.g. "2025-01-02T15:04:05Z"),
+// a date in the local time zone (e.g. "2025-01-02"),
+// or a duration before the current time (e.g. "36h").
+// The zero value is an unset flag.
+type timeFlag time.Time
+
+func (f *timeFlag) Type() string { return "time" }
+func (f timeFlag) Get() any      { return time.Time(f) }
+
+func (f timeFlag) String() string {
+	if time.Time(f).IsZero() {
+		return ""
+	}
+	return time.Time(f).Format(time.RFC3339)
+}
+
+func (f *timeFlag) Set(s string) error {
+	t, err := parseTimeFlag(s, time.Now())
+	if err != nil {
+		return err
+	}
+	*f = timeFlag(t)
+	return nil
+}
+
+func parseTimeFlag(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation(time.DateOnly, s, now.Location()); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(s); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("parse time %q: must be a timestamp, a date, or a duration", s)
+}
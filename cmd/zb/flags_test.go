@@ -0,0 +1,71 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseByteSize(t *testing.T) {
+	tests := []struct {
+		s       string
+		want    int64
+		wantErr bool
+	}{
+		{s: "0", want: 0},
+		{s: "123", want: 123},
+		{s: "123B", want: 123},
+		{s: "1K", want: 1024},
+		{s: "1KiB", want: 1024},
+		{s: "1kb", want: 1000},
+		{s: "10M", want: 10 << 20},
+		{s: "2G", want: 2 << 30},
+		{s: "5GB", want: 5e9},
+		{s: "1T", want: 1 << 40},
+		{s: "", wantErr: true},
+		{s: "G", wantErr: true},
+		{s: "-1", wantErr: true},
+		{s: "1.5G", wantErr: true},
+		{s: "1X", wantErr: true},
+		{s: "99999999999T", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseByteSize(test.s)
+		if got != test.want || (err != nil) != test.wantErr {
+			errString := "<nil>"
+			if test.wantErr {
+				errString = "<error>"
+			}
+			t.Errorf("parseByteSize(%q) = %d, %v; want %d, %s", test.s, got, err, test.want, errString)
+		}
+	}
+}
+
+func TestParseTimeFlag(t *testing.T) {
+	now := time.Date(2025, time.March, 14, 15, 9, 26, 0, time.UTC)
+	tests := []struct {
+		s       string
+		want    time.Time
+		wantErr bool
+	}{
+		{s: "2025-01-02T03:04:05Z", want: time.Date(2025, time.January, 2, 3, 4, 5, 0, time.UTC)},
+		{s: "2025-01-02", want: time.Date(2025, time.January, 2, 0, 0, 0, 0, time.UTC)},
+		{s: "36h", want: now.Add(-36 * time.Hour)},
+		{s: "0s", want: now},
+		{s: "", wantErr: true},
+		{s: "-1h", wantErr: true},
+		{s: "yesterday", wantErr: true},
+	}
+	for _, test := range tests {
+		got, err := parseTimeFlag(test.s, now)
+		if !got.Equal(test.want) || (err != nil) != test.wantErr {
+			errString := "<nil>"
+			if test.wantErr {
+				errString = "<error>"
+			}
+			t.Errorf("parseTimeFlag(%q, %v) = %v, %v; want %v, %s", test.s, now, got, err, test.want, errString)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+type logOptions struct {
+	buildID    string
+	drvPath    zbstore.Path
+	outputPath zbstore.Path
+	lastFailed bool
+	follow     bool
+	tail       int
+}
+
+func newLogCommand(g *globalConfig) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "log [options] BUILD_ID [DRV_PATH] | DRV_PATH | OUTPUT_PATH | --last-failed",
+		Short: "show builder logs",
+		Long: "Show the log of a builder run by the store.\n\n" +
+			"Given a build ID, log shows the logs of every derivation in the build,\n" +
+			"or only DRV_PATH if given.\n" +
+			"Given a derivation path, log shows the log of the most recent build of that derivation.\n" +
+			"Given any other store path (or a symlink to one, like result),\n" +
+			"log shows the log of the most recent build that produced it.",
+		DisableFlagsInUseLine: true,
+		Args: func(c *cobra.Command, args []string) error {
+			if lastFailed, _ := c.Flags().GetBool("last-failed"); lastFailed {
+				return cobra.NoArgs(c, args)
+			}
+			return cobra.RangeArgs(1, 2)(c, args)
+		},
+		SilenceErrors: true,
+		SilenceUsage:  true,
+	}
+	opts := new(logOptions)
+	c.Flags().BoolVar(&opts.lastFailed, "last-failed", false, "show the log of the most recent derivation that failed to build")
+	c.Flags().BoolVarP(&opts.follow, "follow", "f", false, "wait for more output until the builder finishes")
+	c.Flags().IntVarP(&opts.tail, "tail", "n", -1, "show only the last `n` lines of each log")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		if err := opts.parseArgs(g.storeDir, args); err != nil {
+			return err
+		}
+		return runLog(cmd.Context(), g, opts)
+	}
+	return c
+}
+
+func (opts *logOptions) parseArgs(dir zbstore.Directory, args []string) error {
+	if len(args) == 0 {
+		return nil
+	}
+	if _, err := uuid.Parse(args[0]); err == nil {
+		opts.buildID = args[0]
+		if len(args) > 1 {
+			drvPath, err := parseLogPathArg(dir, args[1])
+			if err != nil {
+				return err
+			}
+			if _, isDrv := drvPath.DerivationName(); !isDrv {
+				return fmt.Errorf("%s is not a derivation", drvPath)
+			}
+			opts.drvPath = drvPath
+		}
+		return nil
+	}
+	if len(args) > 1 {
+		return fmt.Errorf("%s is not a build ID", args[0])
+	}
+	path, err := parseLogPathArg(dir, args[0])
+	if err != nil {
+		return fmt.Errorf("%s is neither a build ID nor a store path", args[0])
+	}
+	if _, isDrv := path.DerivationName(); isDrv {
+		opts.drvPath = path
+	} else {
+		opts.outputPath = path
+	}
+	return nil
+}
+
+// parseLogPathArg parses a command-line argument as a store path,
+// following symlinks if needed.
+func parseLogPathArg(dir zbstore.Directory, arg string) (zbstore.Path, error) {
+	if path, err := zbstore.ParsePath(arg); err == nil {
+		return path, nil
+	}
+	resolved, err := filepath.EvalSymlinks(arg)
+	if err != nil {
+		return "", err
+	}
+	resolved, err = filepath.Abs(resolved)
+	if err != nil {
+		return "", err
+	}
+	path, _, err := dir.ParsePath(resolved)
+	if err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func runLog(ctx context.Context, g *globalConfig, opts *logOptions) error {
+	storeClient, waitStoreClient := g.storeClient(nil)
+	defer func() {
+		storeClient.Close()
+		waitStoreClient()
+	}()
+
+	if opts.buildID == "" {
+		resp := new(zbstorerpc.FindBuildResultResponse)
+		err := jsonrpc.Do(ctx, storeClient, zbstorerpc.FindBuildResultMethod, resp, &zbstorerpc.FindBuildResultRequest{
+			DrvPath:    opts.drvPath,
+			OutputPath: opts.outputPath,
+			Failed:     opts.lastFailed,
+		})
+		if err != nil {
+			return err
+		}
+		if resp.Result == nil {
+			switch {
+			case opts.lastFailed:
+				return fmt.Errorf("no failed builds found")
+			case opts.outputPath != "":
+				return fmt.Errorf("no builds found that produced %s", opts.outputPath)
+			default:
+				return fmt.Errorf("no builds found for %s", opts.drvPath)
+			}
+		}
+		log.Infof(ctx, "Showing log for %s in build %s", resp.Result.DrvPath, resp.BuildID)
+		return printLog(ctx, os.Stdout, storeClient, resp.BuildID, resp.Result, opts)
+	}
+
+	if opts.drvPath != "" {
+		result := new(zbstorerpc.BuildResult)
+		err := jsonrpc.Do(ctx, storeClient, zbstorerpc.GetBuildResultMethod, result, &zbstorerpc.GetBuildResultRequest{
+			BuildID: opts.buildID,
+			DrvPath: opts.drvPath,
+		})
+		if err != nil {
+			return err
+		}
+		if result.Status == zbstorerpc.BuildUnknown {
+			return fmt.Errorf("%s not found in build %s", opts.drvPath, opts.buildID)
+		}
+		return printLog(ctx, os.Stdout, storeClient, opts.buildID, result, opts)
+	}
+
+	build := new(zbstorerpc.Build)
+	err := jsonrpc.Do(ctx, storeClient, zbstorerpc.GetBuildMethod, build, &zbstorerpc.GetBuildRequest{
+		BuildID: opts.buildID,
+	})
+	if err != nil {
+		return err
+	}
+	if build.Status == zbstorerpc.BuildUnknown {
+		return fmt.Errorf("build %s not found in store", opts.buildID)
+	}
+	if len(build.Results) == 1 && build.Status != zbstorerpc.BuildActive {
+		return printLog(ctx, os.Stdout, storeClient, opts.buildID, build.Results[0], opts)
+	}
+	if opts.follow && build.Status == zbstorerpc.BuildActive {
+		if opts.tail >= 0 {
+			return fmt.Errorf("--tail cannot be used with --follow on a running build unless a derivation is given")
+		}
+		// Derivations may start while we are following the build,
+		// so watch the whole build instead of reading each log in turn.
+		progress := newRawReporter(os.Stdout)
+		_, _, err := watchBuild(ctx, storeClient, progress, opts.buildID)
+		if code, _ := jsonrpc.CodeFromError(err); code == jsonrpc.MethodNotFound {
+			_, _, err = pollBuild(ctx, storeClient, progress, opts.buildID)
+		}
+		progress.finishBuild(opts.buildID, zbstorerpc.BuildUnknown)
+		return err
+	}
+
+	// Label each line with its derivation
+	// so that logs from different derivations can be told apart.
+	progress := newRawReporter(os.Stdout)
+	for _, result := range build.Results {
+		w := &logEventWriter{
+			progress: progress,
+			buildID:  opts.buildID,
+			drvPath:  result.DrvPath,
+		}
+		if err := printLog(ctx, w, storeClient, opts.buildID, result, opts); err != nil {
+			return err
+		}
+		progress.buildEvent(&zbstorerpc.BuildEvent{
+			BuildID: opts.buildID,
+			Kind:    zbstorerpc.DerivationFinishedEvent,
+			DrvPath: result.DrvPath,
+		})
+	}
+	return nil
+}
+
+// printLog writes the log of a single build result to w.
+// If opts.tail is non-negative, then only the last opts.tail lines are written.
+// If opts.follow is true and the result is not finished,
+// then printLog waits for the builder to finish.
+func printLog(ctx context.Context, w io.Writer, storeClient jsonrpc.Handler, buildID string, result *zbstorerpc.BuildResult, opts *logOptions) error {
+	size := result.LogSize
+	start := int64(0)
+	if opts.tail >= 0 {
+		var err error
+		start, err = tailStart(ctx, storeClient, buildID, result.DrvPath, size, opts.tail)
+		if err != nil {
+			return err
+		}
+	}
+	follow := opts.follow && !result.Status.IsFinished()
+	for off := start; follow || off < size; {
+		req := &zbstorerpc.ReadLogRequest{
+			BuildID:    buildID,
+			DrvPath:    result.DrvPath,
+			RangeStart: off,
+		}
+		if !follow {
+			req.RangeEnd = zbstorerpc.NonNull(size)
+		}
+		payload, err := readLog(ctx, storeClient, req)
+		if _, err := w.Write(payload); err != nil {
+			return err
+		}
+		off += int64(len(payload))
+		if err == io.EOF && follow && off == 0 {
+			// The store reports an empty log before the builder starts.
+			// Wait for the builder to start or for the derivation to finish.
+			result, err = pollBuildResult(ctx, storeClient, buildID, result.DrvPath)
+			if err != nil {
+				return err
+			}
+			follow = !result.Status.IsFinished()
+			size = result.LogSize
+			continue
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pollBuildResult waits a short interval
+// and then returns the current state of the given build result.
+func pollBuildResult(ctx context.Context, storeClient jsonrpc.Handler, buildID string, drvPath zbstore.Path) (*zbstorerpc.BuildResult, error) {
+	t := time.NewTimer(250 * time.Millisecond)
+	select {
+	case <-t.C:
+	case <-ctx.Done():
+		t.Stop()
+		return nil, ctx.Err()
+	}
+	result := new(zbstorerpc.BuildResult)
+	err := jsonrpc.Do(ctx, storeClient, zbstorerpc.GetBuildResultMethod, result, &zbstorerpc.GetBuildResultRequest{
+		BuildID: buildID,
+		DrvPath: drvPath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// tailStart returns the offset of the start of the last n lines
+// of a build log that is size bytes long.
+func tailStart(ctx context.Context, storeClient jsonrpc.Handler, buildID string, drvPath zbstore.Path, size int64, n int) (int64, error) {
+	if n == 0 {
+		return size, nil
+	}
+	const chunkSize = 64 * 1024
+	newlines := 0
+	buf := new(bytes.Buffer)
+	for end := size; end > 0; {
+		start := max(end-chunkSize, 0)
+		buf.Reset()
+		for off := start; off < end; {
+			payload, err := readLog(ctx, storeClient, &zbstorerpc.ReadLogRequest{
+				BuildID:    buildID,
+				DrvPath:    drvPath,
+				RangeStart: off,
+				RangeEnd:   zbstorerpc.NonNull(end),
+			})
+			buf.Write(payload)
+			off += int64(len(payload))
+			if err == io.EOF || len(payload) == 0 {
+				break
+			}
+			if err != nil {
+				return 0, err
+			}
+		}
+		chunk := buf.Bytes()
+		for i := len(chunk) - 1; i >= 0; i-- {
+			// A newline at the very end of the log ends the last line
+			// rather than separating it from another.
+			if chunk[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			newlines++
+			if newlines == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// logEventWriter is an [io.Writer] that reports its input
+// to a [buildReporter] as the log of a single derivation.
+type logEventWriter struct {
+	progress buildReporter
+	buildID  string
+	drvPath  zbstore.Path
+	off      int64
+}
+
+func (w *logEventWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	chunk := &zbstorerpc.LogChunk{Offset: w.off}
+	chunk.SetPayload(p)
+	w.progress.buildEvent(&zbstorerpc.BuildEvent{
+		BuildID: w.buildID,
+		Kind:    zbstorerpc.BuildLogEvent,
+		DrvPath: w.drvPath,
+		Log:     chunk,
+	})
+	w.off += int64(len(p))
+	return len(p), nil
+}
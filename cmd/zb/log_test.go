@@ -0,0 +1,141 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestPrintLogTail(t *testing.T) {
+	const logText = "one\ntwo\nthree\nfour\n"
+	tests := []struct {
+		log  string
+		tail int
+		want string
+	}{
+		{log: logText, tail: -1, want: logText},
+		{log: logText, tail: 0, want: ""},
+		{log: logText, tail: 1, want: "four\n"},
+		{log: logText, tail: 2, want: "three\nfour\n"},
+		{log: logText, tail: 4, want: logText},
+		{log: logText, tail: 10, want: logText},
+		{log: "one\ntwo", tail: 1, want: "two"},
+		{log: "one\ntwo", tail: 2, want: "one\ntwo"},
+		{log: "", tail: 3, want: ""},
+		{log: "\n\n", tail: 1, want: "\n"},
+		{
+			log:  strings.Repeat("x", 100_000) + "\n" + strings.Repeat("y", 70_000) + "\nlast\n",
+			tail: 2,
+			want: strings.Repeat("y", 70_000) + "\nlast\n",
+		},
+	}
+
+	ctx := context.Background()
+	for _, test := range tests {
+		store := fakeLogStore(test.log)
+		result := &zbstorerpc.BuildResult{
+			DrvPath: testHelloPath,
+			Status:  zbstorerpc.BuildSuccess,
+			LogSize: int64(len(test.log)),
+		}
+		got := new(bytes.Buffer)
+		err := printLog(ctx, got, store, testBuildID, result, &logOptions{tail: test.tail})
+		if err != nil {
+			t.Errorf("printLog(%q, tail=%d): %v", truncateLine(test.log, 20), test.tail, err)
+			continue
+		}
+		if got.String() != test.want {
+			t.Errorf("printLog(%q, tail=%d) = %q; want %q",
+				truncateLine(test.log, 20), test.tail, truncateLine(got.String(), 40), truncateLine(test.want, 40))
+		}
+	}
+}
+
+func TestLogOptionsParseArgs(t *testing.T) {
+	dir := zbstore.Directory("/opt/zb/store")
+	const outputPath = zbstore.Path("/opt/zb/store/2lvf1cavwkainjz32xzja04hfl5cimx6-hello")
+	tests := []struct {
+		args    []string
+		want    logOptions
+		wantErr bool
+	}{
+		{
+			args: []string{testBuildID},
+			want: logOptions{buildID: testBuildID},
+		},
+		{
+			args: []string{testBuildID, string(testHelloPath)},
+			want: logOptions{buildID: testBuildID, drvPath: testHelloPath},
+		},
+		{
+			args: []string{string(testHelloPath)},
+			want: logOptions{drvPath: testHelloPath},
+		},
+		{
+			args: []string{string(outputPath)},
+			want: logOptions{outputPath: outputPath},
+		},
+		{
+			args:    []string{testBuildID, string(outputPath)},
+			wantErr: true,
+		},
+		{
+			args:    []string{string(testHelloPath), testBuildID},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		got := new(logOptions)
+		err := got.parseArgs(dir, test.args)
+		if err != nil {
+			if !test.wantErr {
+				t.Errorf("parseArgs(%q): %v", test.args, err)
+			}
+			continue
+		}
+		if test.wantErr {
+			t.Errorf("parseArgs(%q) = %+v, <nil>; want error", test.args, *got)
+			continue
+		}
+		if *got != test.want {
+			t.Errorf("parseArgs(%q) = %+v; want %+v", test.args, *got, test.want)
+		}
+	}
+}
+
+// fakeLogStore returns a [jsonrpc.Handler] that serves [zbstorerpc.ReadLogMethod]
+// for a finished build with the given log.
+// Like the real store, it returns at most 64 KiB per call.
+func fakeLogStore(logText string) jsonrpc.Handler {
+	return jsonrpc.ServeMux{
+		zbstorerpc.ReadLogMethod: jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+			var args zbstorerpc.ReadLogRequest
+			if err := json.Unmarshal(req.Params, &args); err != nil {
+				return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+			}
+			end := min(int64(len(logText)), args.RangeStart+64*1024)
+			if args.RangeEnd.Valid {
+				end = min(end, args.RangeEnd.X)
+			}
+			if args.RangeStart > end {
+				return nil, jsonrpc.Error(jsonrpc.InvalidParams, nil)
+			}
+			resp := &zbstorerpc.ReadLogResponse{EOF: end == int64(len(logText))}
+			resp.SetPayload([]byte(logText[args.RangeStart:end]))
+			data, err := json.Marshal(resp)
+			if err != nil {
+				return nil, err
+			}
+			return &jsonrpc.Response{Result: data}, nil
+		}),
+	}
+}
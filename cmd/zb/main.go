@@ -10,14 +10,16 @@ import (
 	"fmt"
 	"io"
 	"io/fs"
-	"net"
+	"net/http"
 	"os"
 	"os/signal"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"sync"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/spf13/cobra"
 	"github.com/spf13/pflag"
 	"zb.256lights.llc/pkg/bytebuffer"
@@ -34,13 +36,20 @@ import (
 type globalConfig struct {
 	storeDir    zbstore.Directory
 	storeSocket string
+	storeToken  string
 	cacheDB     string
 }
 
 func (g *globalConfig) storeClient(opts *zbstorerpc.CodecOptions) (_ *jsonrpc.Client, wait func()) {
+	return newStoreClient(g.storeSocket, g.storeToken, opts)
+}
+
+// newStoreClient returns a client for the store server listening on the given address.
+// See [dialStore] for the accepted addresses.
+func newStoreClient(addr string, token string, opts *zbstorerpc.CodecOptions) (_ *jsonrpc.Client, wait func()) {
 	var wg sync.WaitGroup
 	c := jsonrpc.NewClient(func(ctx context.Context) (jsonrpc.ClientCodec, error) {
-		conn, err := (&net.Dialer{}).DialContext(ctx, "unix", g.storeSocket)
+		conn, err := dialStore(ctx, addr, token)
 		if err != nil {
 			return nil, err
 		}
@@ -60,6 +69,7 @@ func main() {
 	g := &globalConfig{
 		cacheDB:     filepath.Join(cacheDir(), "zb", "cache.db"),
 		storeSocket: os.Getenv("ZB_STORE_SOCKET"),
+		storeToken:  os.Getenv("ZB_STORE_TOKEN"),
 	}
 	var err error
 	g.storeDir, err = zbstore.DirectoryFromEnvironment()
@@ -77,7 +87,7 @@ func main() {
 
 	rootCommand.PersistentFlags().StringVar(&g.cacheDB, "cache", g.cacheDB, "`path` to cache database")
 	rootCommand.PersistentFlags().Var((*storeDirectoryFlag)(&g.storeDir), "store", "path to store `dir`ectory")
-	rootCommand.PersistentFlags().StringVar(&g.storeSocket, "store-socket", g.storeSocket, "`path` to store server socket")
+	rootCommand.PersistentFlags().StringVar(&g.storeSocket, "store-socket", g.storeSocket, "`path` to store server socket (or a tls://HOST:PORT URL)")
 	showDebug := rootCommand.PersistentFlags().Bool("debug", false, "show debugging output")
 	versionCobraFlag := rootCommand.PersistentFlags().VarPF(versionFlag{ctx}, "version", "", "show version information")
 	versionCobraFlag.NoOptDefVal = "true"
@@ -94,7 +104,8 @@ func main() {
 		newBuildCommand(g),
 		newDerivationCommand(g),
 		newEvalCommand(g),
-		newNARCommand(),
+		newLogCommand(g),
+		newNARCommand(g),
 		newServeCommand(g),
 		newStoreCommand(g),
 		newVersionCommand(g),
@@ -118,16 +129,20 @@ type evalOptions struct {
 	args       []string
 	allowEnv   stringAllowList
 	keepFailed bool
+	logFormat  logFormat
 }
 
-func (opts *evalOptions) newEval(g *globalConfig, storeClient *jsonrpc.Client) (*frontend.Eval, error) {
+func (opts *evalOptions) newEval(g *globalConfig, storeClient *jsonrpc.Client, progress buildReporter) (*frontend.Eval, error) {
 	return frontend.NewEval(&frontend.Options{
 		Store: &rpcStore{
 			client:     storeClient,
+			dial:       g.storeClient,
 			keepFailed: opts.keepFailed,
+			progress:   progress,
 		},
-		StoreDirectory: g.storeDir,
-		CacheDBPath:    g.cacheDB,
+		StoreDirectory:      g.storeDir,
+		CacheDBPath:         g.cacheDB,
+		StoreCacheDirectory: filepath.Join(cacheDir(), "zb", "store"),
 		LookupEnv: func(ctx context.Context, key string) (string, bool) {
 			if !opts.allowEnv.Has(key) {
 				log.Warnf(ctx, "os.getenv(%s) not permitted (use --allow-env=%s if this is intentional)", lualex.Quote(key), key)
@@ -135,6 +150,12 @@ func (opts *evalOptions) newEval(g *globalConfig, storeClient *jsonrpc.Client) (
 			}
 			return os.LookupEnv(key)
 		},
+		HTTPClient: &http.Client{
+			Transport: progressTransport{
+				base:     http.DefaultTransport,
+				progress: progress,
+			},
+		},
 		DownloadBufferCreator: bytebuffer.TempFileCreator{
 			Pattern: "zb-download-*",
 		},
@@ -159,6 +180,7 @@ func newEvalCommand(g *globalConfig) *cobra.Command {
 	c.Flags().BoolVarP(&opts.expression, "expression", "e", false, "interpret argument as Lua expression")
 	c.Flags().BoolVarP(&opts.keepFailed, "keep-failed", "k", false, "keep temporary directories of failed builds")
 	addEnvAllowListFlag(c.Flags(), &opts.allowEnv)
+	addLogFormatFlag(c.Flags(), &opts.logFormat)
 	c.RunE = func(cmd *cobra.Command, args []string) error {
 		opts.args = args
 		return runEval(cmd.Context(), g, opts)
@@ -172,7 +194,9 @@ func runEval(ctx context.Context, g *globalConfig, opts *evalOptions) error {
 		storeClient.Close()
 		waitStoreClient()
 	}()
-	eval, err := opts.newEval(g, storeClient)
+	progress := newBuildReporter(opts.logFormat)
+	defer progress.close()
+	eval, err := opts.newEval(g, storeClient, progress)
 	if err != nil {
 		return err
 	}
@@ -192,6 +216,8 @@ func runEval(ctx context.Context, g *globalConfig, opts *evalOptions) error {
 	if err != nil {
 		return err
 	}
+	// Finish the progress display before writing results.
+	progress.close()
 
 	for _, result := range results {
 		fmt.Println(result)
@@ -203,6 +229,8 @@ func runEval(ctx context.Context, g *globalConfig, opts *evalOptions) error {
 type buildOptions struct {
 	evalOptions
 	outLink string
+	noLink  bool
+	check   bool
 }
 
 func newBuildCommand(g *globalConfig) *cobra.Command {
@@ -223,11 +251,18 @@ func newBuildCommand(g *globalConfig) *cobra.Command {
 	c.Flags().BoolVarP(&opts.expression, "expression", "e", false, "interpret argument as a Lua expression")
 	c.Flags().BoolVarP(&opts.keepFailed, "keep-failed", "k", false, "keep temporary directories of failed builds")
 	addEnvAllowListFlag(c.Flags(), &opts.allowEnv)
+	addLogFormatFlag(c.Flags(), &opts.logFormat)
 	c.Flags().StringVarP(&opts.outLink, "out-link", "o", "result", "change the name of the output path symlink to `path`")
+	c.Flags().BoolVar(&opts.noLink, "no-link", false, "do not create symlinks to the outputs")
+	c.Flags().BoolVar(&opts.check, "check", false, "build the derivations again and compare against their existing outputs")
 	c.RunE = func(cmd *cobra.Command, args []string) error {
 		opts.args = args
 		return runBuild(cmd.Context(), g, opts)
 	}
+	c.AddCommand(
+		newBuildListCommand(g),
+		newBuildShowCommand(g),
+	)
 	return c
 }
 
@@ -237,7 +272,9 @@ func runBuild(ctx context.Context, g *globalConfig, opts *buildOptions) error {
 		storeClient.Close()
 		waitStoreClient()
 	}()
-	eval, err := opts.newEval(g, storeClient)
+	progress := newBuildReporter(opts.logFormat)
+	defer progress.close()
+	eval, err := opts.newEval(g, storeClient, progress)
 	if err != nil {
 		return err
 	}
@@ -262,45 +299,120 @@ func runBuild(ctx context.Context, g *globalConfig, opts *buildOptions) error {
 	}
 
 	drvPaths := make([]zbstore.Path, 0, len(results))
+	// selectedOutputs[i] is the output of drvPaths[i] to report,
+	// or the empty string to report all outputs.
+	selectedOutputs := make([]string, 0, len(results))
 	for _, result := range results {
-		drv, _ := result.(*frontend.Derivation)
-		if drv == nil {
+		switch result := result.(type) {
+		case *frontend.Derivation:
+			drvPaths = append(drvPaths, result.Path)
+			selectedOutputs = append(selectedOutputs, "")
+		case zbstore.OutputReference:
+			drvPaths = append(drvPaths, result.DrvPath)
+			selectedOutputs = append(selectedOutputs, result.OutputName)
+		default:
 			return fmt.Errorf("%v is not a derivation", result)
 		}
-		drvPaths = append(drvPaths, drv.Path)
 	}
 	realizeResponse := new(zbstorerpc.RealizeResponse)
 	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
 		DrvPaths:   drvPaths,
 		KeepFailed: opts.keepFailed,
+		Check:      opts.check,
 	})
 	if err != nil {
 		return err
 	}
-	build, _, buildError := waitForBuild(ctx, storeClient, realizeResponse.BuildID)
+	build, _, buildError := waitForBuild(ctx, storeClient, progress, realizeResponse)
+	// Finish the progress display before writing results.
+	progress.close()
 	if build != nil {
-		for _, drvPath := range drvPaths {
+		for i, drvPath := range drvPaths {
 			result, err := build.ResultForPath(drvPath)
 			if err != nil {
 				continue
 			}
 			for _, output := range result.Outputs {
-				if output.Path.Valid {
-					fmt.Println(output.Path.X)
+				if !output.Path.Valid || (selectedOutputs[i] != "" && output.Name != selectedOutputs[i]) {
+					continue
 				}
+				if output.CheckPath.Valid && output.CheckPath != output.Path {
+					log.Errorf(ctx, "%s!%s differs when built again: %s (previously %s). Run `zb nar diff %s %s` to see what changed.",
+						drvPath, output.Name, output.CheckPath.X, output.Path.X, output.Path.X, output.CheckPath.X)
+				}
+				if !opts.noLink {
+					link := outLinkName(opts.outLink, i, output.Name)
+					if err := createOutLink(ctx, storeClient, link, output.Path.X); err != nil {
+						return err
+					}
+				}
+				fmt.Println(output.Path.X)
 			}
 		}
 	}
 	return buildError
 }
 
+// outLinkName returns the name of the symlink
+// for the given output of the i'th (zero-based) derivation built.
+// For example, given a base of "result",
+// the default output of the first derivation is "result",
+// the "dev" output of the first derivation is "result-dev",
+// and the default output of the second derivation is "result-2".
+func outLinkName(base string, i int, outputName string) string {
+	name := base
+	if i > 0 {
+		name += "-" + strconv.Itoa(i+1)
+	}
+	if outputName != zbstore.DefaultDerivationOutputName {
+		name += "-" + outputName
+	}
+	return name
+}
+
+// createOutLink creates (or replaces) a symlink at link that points to the given store path
+// and registers it with the store as a garbage collection root.
+func createOutLink(ctx context.Context, storeClient jsonrpc.Handler, link string, path zbstore.Path) error {
+	link, err := filepath.Abs(link)
+	if err != nil {
+		return err
+	}
+	if info, err := os.Lstat(link); err == nil && info.Mode().Type() != fs.ModeSymlink {
+		return fmt.Errorf("create %s: file exists and is not a symlink", link)
+	}
+	// Create the symlink under a temporary name and rename it into place
+	// so that an existing link is replaced atomically.
+	tmpLink := filepath.Join(filepath.Dir(link), ".tmp-"+filepath.Base(link)+"-"+uuid.NewString())
+	if err := os.Symlink(string(path), tmpLink); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpLink, link); err != nil {
+		os.Remove(tmpLink)
+		return err
+	}
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.AddRootMethod, nil, &zbstorerpc.AddRootRequest{
+		Link: link,
+	})
+	if err != nil {
+		// The store may not be able to see our filesystem (e.g. on another machine).
+		// The link is still useful, so don't fail the build.
+		log.Warnf(ctx, "Unable to register %s as a garbage collection root: %v", link, err)
+	}
+	return nil
+}
+
 // rpcStore is an implementation of [frontend.Store]
 // that communicates with a store over RPC.
-// It copies builder logs to stderr
+// It reports build progress to a [buildReporter]
 // and propagates options from [evalOptions].
 type rpcStore struct {
-	client     *jsonrpc.Client
+	client *jsonrpc.Client
+	// dial opens a new connection to the store.
+	// It is used for exports,
+	// since the codec handles incoming exports for the whole connection.
+	dial       func(opts *zbstorerpc.CodecOptions) (*jsonrpc.Client, func())
 	keepFailed bool
+	progress   buildReporter
 }
 
 func (store *rpcStore) Exists(ctx context.Context, path string) (bool, error) {
@@ -318,6 +430,32 @@ func (store *rpcStore) Import(ctx context.Context, r io.Reader) error {
 	return importToStore(ctx, store.client, r, -1)
 }
 
+func (store *rpcStore) Export(ctx context.Context, w io.Writer, path zbstore.Path) error {
+	recv := &singleNARReceiver{w: w}
+	client, waitClient := store.dial(&zbstorerpc.CodecOptions{
+		Importer: zbstorerpc.ImportFunc(func(header jsonrpc.Header, body io.Reader) error {
+			return zbstore.ReceiveExport(recv, body)
+		}),
+	})
+	defer func() {
+		client.Close()
+		waitClient()
+	}()
+	err := jsonrpc.Do(ctx, client, zbstorerpc.ExportMethod, nil, &zbstorerpc.ExportRequest{
+		Paths:             []zbstore.Path{path},
+		ExcludeReferences: true,
+	})
+	if err != nil {
+		return fmt.Errorf("export %s: %w", path, err)
+	}
+	// The export message is sent before the RPC response, so if we received the response,
+	// the export is complete.
+	if recv.n != 1 {
+		return fmt.Errorf("export %s: received %d store objects", path, recv.n)
+	}
+	return nil
+}
+
 func (store *rpcStore) Realize(ctx context.Context, want sets.Set[zbstore.OutputReference]) ([]*zbstorerpc.BuildResult, error) {
 	var realizeResponse zbstorerpc.RealizeResponse
 	err := jsonrpc.Do(ctx, store.client, zbstorerpc.RealizeMethod, &realizeResponse, &zbstorerpc.RealizeRequest{
@@ -333,20 +471,26 @@ func (store *rpcStore) Realize(ctx context.Context, want sets.Set[zbstore.Output
 	if err != nil {
 		return nil, err
 	}
-	build, _, err := waitForBuild(ctx, store.client, realizeResponse.BuildID)
+	build, _, err := waitForBuild(ctx, store.client, store.progress, &realizeResponse)
 	if err != nil {
 		return nil, err
 	}
 	return build.Results, nil
 }
 
-// waitForBuild polls the store until the given build is no longer active,
+// waitForBuild waits until the given build is no longer active,
 // returning the last response that it received.
 // The second return value is the raw JSON of the build response.
 // If the build was not successful,
 // the build response is returned along with a non-nil error.
-// waitForBuild will also copy build logs to stderr.
-func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, buildID string) (_ *zbstorerpc.Build, _ json.RawMessage, err error) {
+// waitForBuild reports the build's progress (including its logs) to progress.
+func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, progress buildReporter, realizeResponse *zbstorerpc.RealizeResponse) (_ *zbstorerpc.Build, _ json.RawMessage, err error) {
+	buildID := realizeResponse.BuildID
+	progress.startBuild(buildID, realizeResponse.Derivations)
+	lastStatus := zbstorerpc.BuildUnknown
+	defer func() {
+		progress.finishBuild(buildID, lastStatus)
+	}()
 	defer func() {
 		if err != nil && ctx.Err() != nil {
 			log.Debugf(ctx, "Context canceled while waiting for build %s. Canceling build...", buildID)
@@ -361,6 +505,93 @@ func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, buildID stri
 		}
 	}()
 
+	buildResponse, rawBuildResponse, err := watchBuild(ctx, storeClient, progress, buildID)
+	if code, _ := jsonrpc.CodeFromError(err); code == jsonrpc.MethodNotFound {
+		log.Debugf(ctx, "Store does not support %s. Polling build %s instead...", zbstorerpc.WatchBuildMethod, buildID)
+		buildResponse, rawBuildResponse, err = pollBuild(ctx, storeClient, progress, buildID)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	lastStatus = buildResponse.Status
+	switch buildResponse.Status {
+	case zbstorerpc.BuildUnknown:
+		return nil, nil, fmt.Errorf("wait for build %s: not found in store", buildID)
+	case zbstorerpc.BuildSuccess:
+		return buildResponse, rawBuildResponse, nil
+	case zbstorerpc.BuildFail:
+		return buildResponse, rawBuildResponse, fmt.Errorf("build %s failed", buildID)
+	case zbstorerpc.BuildError:
+		return buildResponse, rawBuildResponse, fmt.Errorf("build %s encountered an internal error", buildID)
+	case zbstorerpc.BuildTimedOut:
+		return buildResponse, rawBuildResponse, fmt.Errorf("build %s timed out", buildID)
+	default:
+		return buildResponse, rawBuildResponse, fmt.Errorf("build %s finished with status %q", buildID, buildResponse.Status)
+	}
+}
+
+// watchBuild waits until the given build is no longer active
+// using [zbstorerpc.WatchBuildMethod],
+// reporting events to progress as the store sends them.
+func watchBuild(ctx context.Context, storeClient jsonrpc.Handler, progress buildReporter, buildID string) (*zbstorerpc.Build, json.RawMessage, error) {
+	watchID := uuid.NewString()
+	paramsJSON, err := json.Marshal(&zbstorerpc.WatchBuildRequest{
+		BuildID: buildID,
+		Logs:    true,
+		WatchID: watchID,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("wait for build %s: watch request: %v", buildID, err)
+	}
+
+	watchCtx := jsonrpc.WithNotificationHandler(ctx, jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+		if req.Method != zbstorerpc.BuildEventMethod {
+			return nil, nil
+		}
+		event := new(zbstorerpc.BuildEvent)
+		if err := json.Unmarshal(req.Params, event); err != nil {
+			return nil, err
+		}
+		if event.BuildID != buildID || event.WatchID != watchID {
+			return nil, nil
+		}
+		switch event.Kind {
+		case zbstorerpc.DerivationStartedEvent:
+			log.Debugf(ctx, "Build %s started %s", buildID, event.DrvPath)
+		case zbstorerpc.DerivationFinishedEvent, zbstorerpc.DerivationFailedEvent:
+			if event.Result != nil {
+				log.Debugf(ctx, "Build %s finished %s with status %q", buildID, event.DrvPath, event.Result.Status)
+			}
+		case zbstorerpc.OutputRealizedEvent:
+			if event.Output != nil && event.Output.Path.Valid {
+				log.Debugf(ctx, "Build %s realized %s!%s as %s", buildID, event.DrvPath, event.Output.Name, event.Output.Path.X)
+			}
+		}
+		progress.buildEvent(event)
+		return nil, nil
+	}))
+	log.Debugf(ctx, "Watching build %s...", buildID)
+	buildRPCResponse, err := storeClient.JSONRPC(watchCtx, &jsonrpc.Request{
+		Method: zbstorerpc.WatchBuildMethod,
+		Params: paramsJSON,
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("wait for build %s: %w", buildID, err)
+	}
+	buildResponse := new(zbstorerpc.Build)
+	if err := json.Unmarshal(buildRPCResponse.Result, buildResponse); err != nil {
+		return nil, nil, fmt.Errorf("wait for build %s: %v", buildID, err)
+	}
+	log.Debugf(ctx, "Build %s finished with status %q", buildID, buildResponse.Status)
+	return buildResponse, buildRPCResponse.Result, nil
+}
+
+// pollBuild polls the store until the given build is no longer active,
+// returning the last response that it received.
+// pollBuild is used for stores that do not support [zbstorerpc.WatchBuildMethod].
+// pollBuild reports the changes it observes to progress
+// as if they were sent as [zbstorerpc.BuildEvent] notifications.
+func pollBuild(ctx context.Context, storeClient jsonrpc.Handler, progress buildReporter, buildID string) (*zbstorerpc.Build, json.RawMessage, error) {
 	paramsJSON, err := json.Marshal(&zbstorerpc.GetBuildRequest{
 		BuildID: buildID,
 	})
@@ -369,6 +600,7 @@ func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, buildID stri
 	}
 
 	visited := make(sets.Set[zbstore.Path])
+	finished := make(sets.Set[zbstore.Path])
 	ticker := time.NewTicker(250 * time.Millisecond)
 	defer ticker.Stop()
 	for {
@@ -387,50 +619,73 @@ func waitForBuild(ctx context.Context, storeClient jsonrpc.Handler, buildID stri
 		}
 		log.Debugf(ctx, "Build %s is currently in status %q", buildID, buildResponse.Status)
 		if buildResponse.Status == zbstorerpc.BuildUnknown {
-			return nil, nil, fmt.Errorf("wait for build %s: not found in store", buildID)
+			return buildResponse, buildRPCResponse.Result, nil
 		}
 
 		for _, result := range buildResponse.Results {
-			if visited.Has(result.DrvPath) {
-				continue
-			}
-			visited.Add(result.DrvPath)
-			log.Debugf(ctx, "Found new log in build %s for %s", buildID, result.DrvPath)
-
-			// The overall build response might be successful
-			// even if log-reading was interrupted.
-			// Don't prevent errors in log-reading from failing the overall operation.
-			if err := ctx.Err(); err != nil {
-				log.Debugf(ctx, "Context canceled while reading logs for build %s: %v", buildID, err)
-				break
+			if !visited.Has(result.DrvPath) {
+				visited.Add(result.DrvPath)
+				log.Debugf(ctx, "Found new log in build %s for %s", buildID, result.DrvPath)
+				progress.buildEvent(&zbstorerpc.BuildEvent{
+					BuildID: buildID,
+					Kind:    zbstorerpc.DerivationStartedEvent,
+					DrvPath: result.DrvPath,
+					Result:  result,
+				})
+
+				// The overall build response might be successful
+				// even if log-reading was interrupted.
+				// Don't prevent errors in log-reading from failing the overall operation.
+				if err := ctx.Err(); err != nil {
+					log.Debugf(ctx, "Context canceled while reading logs for build %s: %v", buildID, err)
+					break
+				}
+				if err := copyLog(ctx, storeClient, progress, buildID, result.DrvPath); err != nil {
+					log.Warnf(ctx, "Failed to read logs for %s in build %s: %v", result.DrvPath, buildID, err)
+				}
 			}
-			if err := copyLogToStderr(ctx, storeClient, buildID, result.DrvPath); err != nil {
-				log.Warnf(ctx, "Failed to read logs for %s in build %s: %v", result.DrvPath, buildID, err)
+
+			if result.Status.IsFinished() && !finished.Has(result.DrvPath) {
+				finished.Add(result.DrvPath)
+				for _, output := range result.Outputs {
+					if output.Path.Valid {
+						progress.buildEvent(&zbstorerpc.BuildEvent{
+							BuildID: buildID,
+							Kind:    zbstorerpc.OutputRealizedEvent,
+							DrvPath: result.DrvPath,
+							Output:  output,
+						})
+					}
+				}
+				kind := zbstorerpc.DerivationFinishedEvent
+				if result.Status != zbstorerpc.BuildSuccess {
+					kind = zbstorerpc.DerivationFailedEvent
+				}
+				progress.buildEvent(&zbstorerpc.BuildEvent{
+					BuildID: buildID,
+					Kind:    kind,
+					DrvPath: result.DrvPath,
+					Result:  result,
+				})
 			}
 		}
 
-		switch buildResponse.Status {
-		case zbstorerpc.BuildActive:
-			// Poll again after a brief delay.
-			log.Debugf(ctx, "Waiting to poll build %s again...", buildID)
-			select {
-			case <-ticker.C:
-			case <-ctx.Done():
-				return nil, nil, fmt.Errorf("wait for build %s: %w", buildID, ctx.Err())
-			}
-		case zbstorerpc.BuildSuccess:
+		if buildResponse.Status != zbstorerpc.BuildActive {
 			return buildResponse, buildRPCResponse.Result, nil
-		case zbstorerpc.BuildFail:
-			return buildResponse, buildRPCResponse.Result, fmt.Errorf("build %s failed", buildID)
-		case zbstorerpc.BuildError:
-			return buildResponse, buildRPCResponse.Result, fmt.Errorf("build %s encountered an internal error", buildID)
-		default:
-			return buildResponse, buildRPCResponse.Result, fmt.Errorf("build %s finished with status %q", buildID, buildResponse.Status)
+		}
+		// Poll again after a brief delay.
+		log.Debugf(ctx, "Waiting to poll build %s again...", buildID)
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("wait for build %s: %w", buildID, ctx.Err())
 		}
 	}
 }
 
-func copyLogToStderr(ctx context.Context, storeClient jsonrpc.Handler, buildID string, drvPath zbstore.Path) error {
+// copyLog reads the log for a derivation in a build
+// and reports it to progress as [zbstorerpc.BuildLogEvent] events.
+func copyLog(ctx context.Context, storeClient jsonrpc.Handler, progress buildReporter, buildID string, drvPath zbstore.Path) error {
 	off := int64(0)
 	for {
 		payload, err := readLog(ctx, storeClient, &zbstorerpc.ReadLogRequest{
@@ -439,18 +694,14 @@ func copyLogToStderr(ctx context.Context, storeClient jsonrpc.Handler, buildID s
 			RangeStart: off,
 		})
 		if len(payload) > 0 {
-			toWrite := payload
-			if off == 0 {
-				// Write header.
-				toWrite = nil
-				toWrite = append(toWrite, "--- "...)
-				toWrite = append(toWrite, drvPath...)
-				toWrite = append(toWrite, " ---\n"...)
-				toWrite = append(toWrite, payload...)
-			}
-			if _, err := os.Stderr.Write(toWrite); err != nil {
-				return err
-			}
+			chunk := &zbstorerpc.LogChunk{Offset: off}
+			chunk.SetPayload(payload)
+			progress.buildEvent(&zbstorerpc.BuildEvent{
+				BuildID: buildID,
+				Kind:    zbstorerpc.BuildLogEvent,
+				DrvPath: drvPath,
+				Log:     chunk,
+			})
 		}
 		if err != nil {
 			if err == io.EOF {
@@ -531,6 +782,10 @@ func addEnvAllowListFlag(fset *pflag.FlagSet, list *stringAllowList) {
 	all.NoOptDefVal = "true"
 }
 
+func addLogFormatFlag(fset *pflag.FlagSet, format *logFormat) {
+	fset.Var(format, "log-format", "`format` of build progress on stderr: bar, raw, or json (default is bar when stderr is a terminal, raw otherwise)")
+}
+
 var initLogOnce sync.Once
 
 func initLogging(showDebug bool) {
@@ -541,7 +796,7 @@ func initLogging(showDebug bool) {
 		}
 		log.SetDefault(&log.LevelFilter{
 			Min:    minLogLevel,
-			Output: log.New(os.Stderr, "zb: ", log.StdFlags, nil),
+			Output: log.New(stderr, "zb: ", log.StdFlags, nil),
 		})
 	})
 }
@@ -0,0 +1,82 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestOutLinkName(t *testing.T) {
+	tests := []struct {
+		base       string
+		i          int
+		outputName string
+		want       string
+	}{
+		{base: "result", i: 0, outputName: "out", want: "result"},
+		{base: "result", i: 0, outputName: "dev", want: "result-dev"},
+		{base: "result", i: 1, outputName: "out", want: "result-2"},
+		{base: "result", i: 2, outputName: "lib", want: "result-3-lib"},
+		{base: "foo/bar", i: 0, outputName: "out", want: "foo/bar"},
+	}
+	for _, test := range tests {
+		if got := outLinkName(test.base, test.i, test.outputName); got != test.want {
+			t.Errorf("outLinkName(%q, %d, %q) = %q; want %q", test.base, test.i, test.outputName, got, test.want)
+		}
+	}
+}
+
+func TestCreateOutLink(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses symlinks")
+	}
+	dir := t.TempDir()
+	link := filepath.Join(dir, "result")
+	// The store is not reachable, so registering the root fails.
+	// createOutLink should still create the link.
+	storeClient := jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+		return nil, errors.New("store not available")
+	})
+
+	for _, target := range []zbstore.Path{
+		"/zb/store/s66mzxpvicwk07gjbjfw9izjfa797vsw-hello-1.0",
+		"/zb/store/vfm4ilhvfx2z6pahf0p3pc8hwm4mdwb4-hello-2.0",
+	} {
+		if err := createOutLink(context.Background(), storeClient, link, target); err != nil {
+			t.Fatal(err)
+		}
+		if got, err := os.Readlink(link); err != nil {
+			t.Error(err)
+		} else if got != string(target) {
+			t.Errorf("os.Readlink(%q) = %q; want %q", link, got, target)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		var names []string
+		for _, ent := range entries {
+			names = append(names, ent.Name())
+		}
+		t.Errorf("directory contains %q; want only %q", names, filepath.Base(link))
+	}
+
+	regularFile := filepath.Join(dir, "file")
+	if err := os.WriteFile(regularFile, []byte("hello\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	if err := createOutLink(context.Background(), storeClient, regularFile, "/zb/store/s66mzxpvicwk07gjbjfw9izjfa797vsw-hello-1.0"); err == nil {
+		t.Errorf("createOutLink over regular file did not return an error")
+	}
+}
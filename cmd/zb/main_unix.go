@@ -11,6 +11,7 @@ import (
 
 	"go4.org/xdgdir"
 	"golang.org/x/sys/unix"
+	"golang.org/x/term"
 )
 
 var interruptSignals = []os.Signal{
@@ -25,3 +26,10 @@ func cacheDir() string {
 func ignoreSIGPIPE() {
 	signal.Ignore(unix.SIGPIPE)
 }
+
+// enableTerminalEscapes reports whether f is a terminal
+// that can interpret ANSI escape sequences,
+// enabling them if necessary.
+func enableTerminalEscapes(f *os.File) bool {
+	return term.IsTerminal(int(f.Fd())) && os.Getenv("TERM") != "dumb"
+}
@@ -3,7 +3,11 @@
 
 package main
 
-import "os"
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
 
 var interruptSignals = []os.Signal{os.Interrupt}
 
@@ -16,3 +20,18 @@ func cacheDir() string {
 }
 
 func ignoreSIGPIPE() {}
+
+// enableTerminalEscapes reports whether f is a console
+// that can interpret ANSI escape sequences,
+// enabling them if necessary.
+func enableTerminalEscapes(f *os.File) bool {
+	h := windows.Handle(f.Fd())
+	var mode uint32
+	if err := windows.GetConsoleMode(h, &mode); err != nil {
+		return false
+	}
+	if mode&windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING != 0 {
+		return true
+	}
+	return windows.SetConsoleMode(h, mode|windows.ENABLE_VIRTUAL_TERMINAL_PROCESSING) == nil
+}
@@ -19,7 +19,7 @@ import (
 	"zombiezen.com/go/nix/nar"
 )
 
-func newNARCommand() *cobra.Command {
+func newNARCommand(g *globalConfig) *cobra.Command {
 	c := &cobra.Command{
 		Use:                   "nar COMMAND",
 		Short:                 "operate on NAR files",
@@ -29,6 +29,7 @@ func newNARCommand() *cobra.Command {
 	}
 	c.AddCommand(
 		newPackNARCommand(),
+		newNARDiffCommand(g),
 	)
 	return c
 }
@@ -0,0 +1,818 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/cobra"
+	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix/nar"
+)
+
+type narDiffOptions struct {
+	a, b  string
+	brief bool
+}
+
+//go:embed docs/nar_diff.txt
+var narDiffDoc string
+
+func newNARDiffCommand(g *globalConfig) *cobra.Command {
+	c := &cobra.Command{
+		Use:                   "diff [options] A B",
+		Short:                 "compare two filesystem objects",
+		Long:                  narDiffDoc,
+		DisableFlagsInUseLine: true,
+		Args:                  cobra.ExactArgs(2),
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	opts := new(narDiffOptions)
+	c.Flags().BoolVarP(&opts.brief, "brief", "q", false, "report only which files differ")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		opts.a = args[0]
+		opts.b = args[1]
+		return runNARDiff(cmd.Context(), g, opts)
+	}
+	return c
+}
+
+func runNARDiff(ctx context.Context, g *globalConfig, opts *narDiffOptions) error {
+	a, err := openNARDiffSide(ctx, g, opts.a)
+	if err != nil {
+		return err
+	}
+	defer a.close()
+	b, err := openNARDiffSide(ctx, g, opts.b)
+	if err != nil {
+		return err
+	}
+	defer b.close()
+
+	out := bufio.NewWriter(os.Stdout)
+	if err := diffNARs(ctx, out, a, b, opts.brief); err != nil {
+		return err
+	}
+	return out.Flush()
+}
+
+// narDiffSide is one of the filesystem objects compared by `zb nar diff`.
+type narDiffSide struct {
+	// label is the name used to refer to the object in output.
+	label string
+	// digest is the digest of the object's store path
+	// or the empty string if the object is not known to be a store object.
+	digest string
+	// nar is the object's NAR serialization.
+	nar     io.ReadSeekCloser
+	entries map[string]*nar.Header
+}
+
+// openNARDiffSide obtains the NAR serialization
+// of the filesystem object named by a `zb nar diff` argument.
+func openNARDiffSide(ctx context.Context, g *globalConfig, arg string) (_ *narDiffSide, err error) {
+	side := &narDiffSide{label: arg}
+	defer func() {
+		if err != nil {
+			side.close()
+		}
+	}()
+
+	path, err := filepath.Abs(arg)
+	if err != nil {
+		return nil, err
+	}
+	storePath, sub, storePathError := g.storeDir.ParsePath(path)
+	info, err := os.Lstat(path)
+	switch {
+	case err == nil && storePathError == nil:
+		// A store object (or a file inside one) on the local filesystem.
+		side.digest = storePath.Digest()
+		side.nar, err = dumpNARForDiff(path)
+	case err == nil && info.Mode().IsRegular():
+		side.nar, side.digest, err = openNARDiffFile(path)
+	case err == nil:
+		side.nar, err = dumpNARForDiff(path)
+	case errors.Is(err, os.ErrNotExist) && storePathError == nil && sub == "":
+		side.nar, side.digest, err = exportNARForDiff(ctx, g, storePath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	side.entries, err = readNAREntries(side.nar)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", arg, err)
+	}
+	return side, nil
+}
+
+func (side *narDiffSide) close() {
+	if side.nar != nil {
+		side.nar.Close()
+	}
+}
+
+// openNARDiffFile opens a regular file given to `zb nar diff`.
+// If the file is a NAR file (either by extension or by content),
+// then openNARDiffFile returns the file.
+// If the file is a `zb store object export` file containing a single store object,
+// then openNARDiffFile returns the store object's NAR serialization and digest.
+// Otherwise, openNARDiffFile returns the NAR serialization of the file itself.
+func openNARDiffFile(path string) (_ io.ReadSeekCloser, digest string, err error) {
+	const narMagic = "\x0d\x00\x00\x00\x00\x00\x00\x00nix-archive-1\x00\x00\x00"
+	const exportMagic = "\x01\x00\x00\x00\x00\x00\x00\x00"
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+	header := make([]byte, len(narMagic))
+	n, err := io.ReadFull(f, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		f.Close()
+		return nil, "", err
+	}
+	header = header[:n]
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		f.Close()
+		return nil, "", err
+	}
+
+	switch {
+	case filepath.Ext(path) == ".nar" || string(header) == narMagic:
+		return f, "", nil
+	case string(header[:min(len(header), len(exportMagic))]) == exportMagic:
+		defer f.Close()
+		buf, err := narDiffBufferCreator.CreateBuffer(-1)
+		if err != nil {
+			return nil, "", err
+		}
+		recv := &singleNARReceiver{w: buf}
+		if err := zbstore.ReceiveExport(recv, bufio.NewReader(f)); err != nil {
+			buf.Close()
+			return nil, "", fmt.Errorf("%s: %v", path, err)
+		}
+		if recv.n != 1 {
+			buf.Close()
+			return nil, "", fmt.Errorf("%s: export contains %d store objects (want 1; use `zb store object export --references=false`)", path, recv.n)
+		}
+		return buf, recv.storePath.Digest(), nil
+	default:
+		f.Close()
+		rs, err := dumpNARForDiff(path)
+		return rs, "", err
+	}
+}
+
+var narDiffBufferCreator = bytebuffer.TempFileCreator{Pattern: "zb-nar-diff-*"}
+
+// dumpNARForDiff serializes the file at path to a temporary file.
+func dumpNARForDiff(path string) (io.ReadSeekCloser, error) {
+	buf, err := narDiffBufferCreator.CreateBuffer(-1)
+	if err != nil {
+		return nil, err
+	}
+	if err := nar.DumpPath(buf, path); err != nil {
+		buf.Close()
+		return nil, err
+	}
+	return buf, nil
+}
+
+// exportNARForDiff exports the given store object from the store server to a temporary file.
+func exportNARForDiff(ctx context.Context, g *globalConfig, storePath zbstore.Path) (_ io.ReadSeekCloser, digest string, err error) {
+	buf, err := narDiffBufferCreator.CreateBuffer(-1)
+	if err != nil {
+		return nil, "", err
+	}
+	recv := &singleNARReceiver{w: buf}
+	storeClient, waitStoreClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: zbstorerpc.ImportFunc(func(header jsonrpc.Header, body io.Reader) error {
+			return zbstore.ReceiveExport(recv, body)
+		}),
+	})
+	defer func() {
+		storeClient.Close()
+		waitStoreClient()
+	}()
+	err = jsonrpc.Do(ctx, storeClient, zbstorerpc.ExportMethod, nil, &zbstorerpc.ExportRequest{
+		Paths:             []zbstore.Path{storePath},
+		ExcludeReferences: true,
+	})
+	if err != nil {
+		buf.Close()
+		return nil, "", err
+	}
+	// The export message is sent before the RPC response, so if we received the response,
+	// the export is complete.
+	if recv.n != 1 {
+		buf.Close()
+		return nil, "", fmt.Errorf("export %s: received %d store objects", storePath, recv.n)
+	}
+	return buf, storePath.Digest(), nil
+}
+
+// singleNARReceiver is a [zbstore.NARReceiver]
+// that writes the first NAR it receives to w
+// and discards any others.
+type singleNARReceiver struct {
+	w         io.Writer
+	n         int
+	storePath zbstore.Path
+}
+
+func (r *singleNARReceiver) Write(p []byte) (int, error) {
+	if r.n > 0 {
+		return len(p), nil
+	}
+	return r.w.Write(p)
+}
+
+func (r *singleNARReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
+	if r.n == 0 {
+		r.storePath = trailer.StorePath
+	}
+	r.n++
+}
+
+// readNAREntries reads the headers in the NAR serialization in rs.
+func readNAREntries(rs io.ReadSeeker) (map[string]*nar.Header, error) {
+	if _, err := rs.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	entries := make(map[string]*nar.Header)
+	nr := nar.NewReader(bufio.NewReader(rs))
+	for {
+		hdr, err := nr.Next()
+		if err == io.EOF {
+			return entries, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries[hdr.Path] = hdr
+	}
+}
+
+// normalizeSelfReferences returns a copy of side's NAR serialization
+// with its self-references rewritten to newDigest,
+// or nil if side does not contain any self-references.
+func normalizeSelfReferences(ctx context.Context, side *narDiffSide, newDigest string) (io.ReadSeekCloser, error) {
+	if _, err := side.nar.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	_, analysis, err := zbstore.SourceSHA256ContentAddress(bufio.NewReader(side.nar), &zbstore.ContentAddressOptions{
+		Digest:     side.digest,
+		CreateTemp: bytebuffer.TempFileCreator{Pattern: contentAddressTempFilePattern},
+		Log:        func(msg string) { log.Debugf(ctx, "%s", msg) },
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", side.label, err)
+	}
+	if !analysis.HasSelfReferences() {
+		return nil, nil
+	}
+
+	buf, err := narDiffBufferCreator.CreateBuffer(-1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := side.nar.Seek(0, io.SeekStart); err != nil {
+		buf.Close()
+		return nil, err
+	}
+	if _, err := io.Copy(buf, side.nar); err != nil {
+		buf.Close()
+		return nil, err
+	}
+	if err := zbstore.Rewrite(buf, 0, newDigest, analysis.Rewrites); err != nil {
+		buf.Close()
+		return nil, fmt.Errorf("%s: %v", side.label, err)
+	}
+	return buf, nil
+}
+
+// diffNARs writes a report of the differences between a and b to w.
+// If brief is true, then only the names of differing files are reported,
+// not the differences in their content.
+func diffNARs(ctx context.Context, w io.Writer, a, b *narDiffSide, brief bool) error {
+	// If the objects are store objects with different digests,
+	// then compare a with its self-references rewritten to b's digest
+	// so that self-references don't show up as changes.
+	normalized := &narDiffSide{
+		label:   a.label,
+		digest:  a.digest,
+		nar:     a.nar,
+		entries: a.entries,
+	}
+	if a.digest != "" && b.digest != "" && a.digest != b.digest {
+		rs, err := normalizeSelfReferences(ctx, a, b.digest)
+		if err != nil {
+			return err
+		}
+		if rs != nil {
+			defer rs.Close()
+			normalized.nar = rs
+			normalized.digest = b.digest
+			normalized.entries, err = readNAREntries(rs)
+			if err != nil {
+				return fmt.Errorf("%s: %v", a.label, err)
+			}
+			if !brief {
+				fmt.Fprintf(w, "Comparing with self-references in %s rewritten to %s\n", a.label, b.digest)
+			}
+		}
+	}
+
+	paths := make([]string, 0, len(a.entries)+len(b.entries))
+	for p := range a.entries {
+		paths = append(paths, p)
+	}
+	for p := range b.entries {
+		if _, ok := a.entries[p]; !ok {
+			paths = append(paths, p)
+		}
+	}
+	slices.SortFunc(paths, compareNARPaths)
+
+	skipPrefix := ""
+	skipping := false
+	for _, p := range paths {
+		if skipping && isNARPathWithin(p, skipPrefix) {
+			continue
+		}
+		skipping = false
+		aHeader, bHeader := a.entries[p], b.entries[p]
+		switch {
+		case bHeader == nil:
+			fmt.Fprintf(w, "Only in %s: %s\n", a.label, displayNARPath(p))
+			skipPrefix, skipping = p, true
+		case aHeader == nil:
+			fmt.Fprintf(w, "Only in %s: %s\n", b.label, displayNARPath(p))
+			skipPrefix, skipping = p, true
+		case aHeader.Mode.Type() != bHeader.Mode.Type():
+			fmt.Fprintf(w, "Type of %s changed: %s -> %s\n",
+				displayNARPath(p), describeNARType(aHeader.Mode), describeNARType(bHeader.Mode))
+			skipPrefix, skipping = p, true
+		case aHeader.Mode.Type() == fs.ModeSymlink:
+			if aHeader.LinkTarget == bHeader.LinkTarget {
+				continue
+			}
+			if normalized.entries[p].LinkTarget == bHeader.LinkTarget {
+				fmt.Fprintf(w, "Target of %s differs only in self-references\n", displayNARPath(p))
+			} else {
+				fmt.Fprintf(w, "Target of %s changed: %s -> %s\n", displayNARPath(p), aHeader.LinkTarget, bHeader.LinkTarget)
+			}
+		case aHeader.Mode.IsRegular():
+			if aHeader.Mode != bHeader.Mode {
+				fmt.Fprintf(w, "Mode of %s changed: %v -> %v\n", displayNARPath(p), aHeader.Mode, bHeader.Mode)
+			}
+			if err := diffNARFiles(w, a, normalized, b, p, brief); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// diffNARFiles reports the differences in the regular file at path p
+// in a and b.
+// normalized is a with its self-references rewritten to b's digest.
+func diffNARFiles(w io.Writer, a, normalized, b *narDiffSide, p string, brief bool) error {
+	aHeader, bHeader := a.entries[p], b.entries[p]
+	if same, err := sameNARContent(a.nar, aHeader, b.nar, bHeader); err != nil {
+		return err
+	} else if same {
+		return nil
+	}
+	if normalized.nar != a.nar {
+		if same, err := sameNARContent(normalized.nar, normalized.entries[p], b.nar, bHeader); err != nil {
+			return err
+		} else if same {
+			fmt.Fprintf(w, "Contents of %s differ only in self-references\n", displayNARPath(p))
+			return nil
+		}
+	}
+	if brief {
+		fmt.Fprintf(w, "Contents of %s differ\n", displayNARPath(p))
+		return nil
+	}
+
+	aHeader = normalized.entries[p]
+	if aHeader.Size <= maxTextDiffSize && bHeader.Size <= maxTextDiffSize {
+		aContent, err := readNARContent(normalized.nar, aHeader)
+		if err != nil {
+			return err
+		}
+		bContent, err := readNARContent(b.nar, bHeader)
+		if err != nil {
+			return err
+		}
+		if isText(aContent) && isText(bContent) {
+			fmt.Fprintf(w, "Contents of %s differ:\n", displayNARPath(p))
+			fmt.Fprintf(w, "--- %s\n+++ %s\n", joinNARPath(a.label, p), joinNARPath(b.label, p))
+			_, err := w.Write(appendUnifiedDiff(nil, splitLines(aContent), splitLines(bContent)))
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "Binary contents of %s differ", displayNARPath(p))
+	if aHeader.Size != bHeader.Size {
+		fmt.Fprintf(w, " (size %d -> %d)", aHeader.Size, bHeader.Size)
+	}
+	fmt.Fprintf(w, ":\n")
+	if _, err := normalized.nar.Seek(aHeader.ContentOffset, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := b.nar.Seek(bHeader.ContentOffset, io.SeekStart); err != nil {
+		return err
+	}
+	return writeHexDiff(
+		w,
+		bufio.NewReader(io.LimitReader(normalized.nar, aHeader.Size)),
+		bufio.NewReader(io.LimitReader(b.nar, bHeader.Size)),
+	)
+}
+
+// maxTextDiffSize is the largest file size in bytes
+// that `zb nar diff` will attempt to show a line-based diff for.
+const maxTextDiffSize = 1 << 20
+
+// sameNARContent reports whether the regular files described by the given headers
+// have the same content.
+func sameNARContent(a io.ReadSeeker, aHeader *nar.Header, b io.ReadSeeker, bHeader *nar.Header) (bool, error) {
+	if aHeader.Size != bHeader.Size {
+		return false, nil
+	}
+	if _, err := a.Seek(aHeader.ContentOffset, io.SeekStart); err != nil {
+		return false, err
+	}
+	if _, err := b.Seek(bHeader.ContentOffset, io.SeekStart); err != nil {
+		return false, err
+	}
+	const chunkSize = 32 << 10
+	aBuf := make([]byte, chunkSize)
+	bBuf := make([]byte, chunkSize)
+	for remaining := aHeader.Size; remaining > 0; {
+		n := int(min(remaining, chunkSize))
+		if _, err := io.ReadFull(a, aBuf[:n]); err != nil {
+			return false, err
+		}
+		if _, err := io.ReadFull(b, bBuf[:n]); err != nil {
+			return false, err
+		}
+		if !bytes.Equal(aBuf[:n], bBuf[:n]) {
+			return false, nil
+		}
+		remaining -= int64(n)
+	}
+	return true, nil
+}
+
+func readNARContent(rs io.ReadSeeker, hdr *nar.Header) ([]byte, error) {
+	if _, err := rs.Seek(hdr.ContentOffset, io.SeekStart); err != nil {
+		return nil, err
+	}
+	content := make([]byte, hdr.Size)
+	if _, err := io.ReadFull(rs, content); err != nil {
+		return nil, err
+	}
+	return content, nil
+}
+
+// isText reports whether data appears to be human-readable text.
+func isText(data []byte) bool {
+	return utf8.Valid(data) && bytes.IndexByte(data, 0) < 0
+}
+
+// compareNARPaths compares NAR paths in the order that they appear in a NAR file:
+// component-by-component.
+func compareNARPaths(p1, p2 string) int {
+	if p1 == "" || p2 == "" {
+		return strings.Compare(p1, p2)
+	}
+	return slices.Compare(strings.Split(p1, "/"), strings.Split(p2, "/"))
+}
+
+// isNARPathWithin reports whether p is a descendant of dir.
+func isNARPathWithin(p, dir string) bool {
+	if dir == "" {
+		return p != ""
+	}
+	return strings.HasPrefix(p, dir+"/")
+}
+
+func displayNARPath(p string) string {
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func joinNARPath(label, p string) string {
+	if p == "" {
+		return label
+	}
+	return label + "/" + p
+}
+
+func describeNARType(mode fs.FileMode) string {
+	switch mode.Type() {
+	case fs.ModeDir:
+		return "directory"
+	case fs.ModeSymlink:
+		return "symlink"
+	default:
+		return "regular file"
+	}
+}
+
+// writeHexDiff writes the 16-byte rows that differ between a and b
+// in the style of `hexdump -C`.
+// Rows from a are prefixed with "-" and rows from b are prefixed with "+".
+// At most maxHexDiffRows differing rows are written.
+func writeHexDiff(w io.Writer, a, b io.Reader) error {
+	const rowSize = 16
+	const maxHexDiffRows = 16
+	aRow := make([]byte, rowSize)
+	bRow := make([]byte, rowSize)
+	var line []byte
+	shown, omitted := 0, 0
+	for offset := int64(0); ; offset += rowSize {
+		aN, aErr := io.ReadFull(a, aRow)
+		if aErr != nil && aErr != io.EOF && aErr != io.ErrUnexpectedEOF {
+			return aErr
+		}
+		bN, bErr := io.ReadFull(b, bRow)
+		if bErr != nil && bErr != io.EOF && bErr != io.ErrUnexpectedEOF {
+			return bErr
+		}
+		if aN == 0 && bN == 0 {
+			break
+		}
+		if bytes.Equal(aRow[:aN], bRow[:bN]) {
+			continue
+		}
+		if shown >= maxHexDiffRows {
+			omitted++
+			continue
+		}
+		shown++
+		if aN > 0 {
+			line = appendHexRow(append(line[:0], '-'), offset, aRow[:aN])
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+		if bN > 0 {
+			line = appendHexRow(append(line[:0], '+'), offset, bRow[:bN])
+			if _, err := w.Write(line); err != nil {
+				return err
+			}
+		}
+	}
+	if omitted > 0 {
+		if _, err := fmt.Fprintf(w, "(%d more differing rows)\n", omitted); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func appendHexRow(dst []byte, offset int64, row []byte) []byte {
+	const hexDigits = "0123456789abcdef"
+	dst = fmt.Appendf(dst, "%08x ", offset)
+	for i := range 16 {
+		if i == 8 {
+			dst = append(dst, ' ')
+		}
+		if i < len(row) {
+			dst = append(dst, ' ', hexDigits[row[i]>>4], hexDigits[row[i]&0xf])
+		} else {
+			dst = append(dst, "   "...)
+		}
+	}
+	dst = append(dst, "  |"...)
+	for _, c := range row {
+		if ' ' <= c && c <= '~' {
+			dst = append(dst, c)
+		} else {
+			dst = append(dst, '.')
+		}
+	}
+	dst = append(dst, "|\n"...)
+	return dst
+}
+
+// splitLines splits data into lines,
+// each of which ends in "\n" except possibly the last.
+func splitLines(data []byte) []string {
+	lines := strings.SplitAfter(string(data), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// lineEdit is a single line in a line-based diff.
+type lineEdit struct {
+	// op is ' ' for a line common to both sides,
+	// '-' for a line only in the first side,
+	// or '+' for a line only in the second side.
+	op   byte
+	line string
+	// aLine and bLine are the zero-based indices of the line in each side.
+	// For lines only in one side, the other index
+	// is the index of the next line in that side.
+	aLine, bLine int
+}
+
+// maxLineEdits is the maximum number of inserted or deleted lines
+// that [diffLines] searches for.
+const maxLineEdits = 2000
+
+// diffLines computes a minimal sequence of edits that transforms a into b
+// using the Myers diff algorithm.
+// If more than [maxLineEdits] lines need to be inserted or deleted,
+// then diffLines returns a single replacement of all of a with all of b.
+func diffLines(a, b []string) []lineEdit {
+	// Trim the common prefix and suffix to reduce the size of the search.
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var edits []lineEdit
+	for i := range prefix {
+		edits = append(edits, lineEdit{op: ' ', line: a[i], aLine: i, bLine: i})
+	}
+	middle := myersDiff(a[prefix:len(a)-suffix], b[prefix:len(b)-suffix])
+	for _, e := range middle {
+		e.aLine += prefix
+		e.bLine += prefix
+		edits = append(edits, e)
+	}
+	for i := range suffix {
+		aLine := len(a) - suffix + i
+		bLine := len(b) - suffix + i
+		edits = append(edits, lineEdit{op: ' ', line: a[aLine], aLine: aLine, bLine: bLine})
+	}
+	return edits
+}
+
+func myersDiff(a, b []string) []lineEdit {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxLineEdits)
+	// v[offset+k] is the furthest x reached on diagonal k.
+	offset := limit + 1
+	v := make([]int, 2*limit+3)
+	// trace[d] is a snapshot of v[offset-d-1:offset+d+2] at the start of round d.
+	var trace [][]int
+	found := false
+search:
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, slices.Clone(v[offset-d-1:offset+d+2]))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				found = true
+				break search
+			}
+		}
+	}
+	if !found {
+		edits := make([]lineEdit, 0, n+m)
+		for i, line := range a {
+			edits = append(edits, lineEdit{op: '-', line: line, aLine: i, bLine: 0})
+		}
+		for i, line := range b {
+			edits = append(edits, lineEdit{op: '+', line: line, aLine: n, bLine: i})
+		}
+		return edits
+	}
+
+	// Walk backward through the trace to recover the edits.
+	var edits []lineEdit
+	x, y := n, m
+	for d := len(trace) - 1; d >= 0; d-- {
+		snapshot := trace[d]
+		at := func(k int) int { return snapshot[k+d+1] }
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && at(k-1) < at(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := at(prevK)
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, lineEdit{op: ' ', line: a[x], aLine: x, bLine: y})
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, lineEdit{op: '+', line: b[y], aLine: x, bLine: y})
+		} else {
+			x--
+			edits = append(edits, lineEdit{op: '-', line: a[x], aLine: x, bLine: y})
+		}
+	}
+	slices.Reverse(edits)
+	return edits
+}
+
+// appendUnifiedDiff appends a unified diff of a and b
+// with three lines of context to dst.
+func appendUnifiedDiff(dst []byte, a, b []string) []byte {
+	const context = 3
+	edits := diffLines(a, b)
+	for i := 0; i < len(edits); {
+		if edits[i].op == ' ' {
+			i++
+			continue
+		}
+		// Extend the hunk until there are more than 2*context unchanged lines.
+		start := max(i-context, 0)
+		end := i
+		for j := i; j < len(edits); j++ {
+			if edits[j].op != ' ' {
+				end = j + 1
+			} else if j-end >= 2*context {
+				break
+			}
+		}
+		end = min(end+context, len(edits))
+
+		aCount, bCount := 0, 0
+		for _, e := range edits[start:end] {
+			if e.op != '+' {
+				aCount++
+			}
+			if e.op != '-' {
+				bCount++
+			}
+		}
+		dst = fmt.Appendf(dst, "@@ -%s +%s @@\n",
+			formatHunkRange(edits[start].aLine, aCount),
+			formatHunkRange(edits[start].bLine, bCount))
+		for _, e := range edits[start:end] {
+			dst = append(dst, e.op)
+			dst = append(dst, e.line...)
+			if !strings.HasSuffix(e.line, "\n") {
+				dst = append(dst, "\n\\ No newline at end of file\n"...)
+			}
+		}
+		i = end
+	}
+	return dst
+}
+
+func formatHunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, count)
+	}
+}
@@ -0,0 +1,292 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestAppendUnifiedDiff(t *testing.T) {
+	tests := []struct {
+		name string
+		a    string
+		b    string
+		want string
+	}{
+		{
+			name: "Same",
+			a:    "a\nb\nc\n",
+			b:    "a\nb\nc\n",
+			want: "",
+		},
+		{
+			name: "Change",
+			a:    "a\nb\nc\n",
+			b:    "a\nB\nc\n",
+			want: "@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n",
+		},
+		{
+			name: "AddToEmpty",
+			a:    "",
+			b:    "a\n",
+			want: "@@ -0,0 +1 @@\n+a\n",
+		},
+		{
+			name: "NoNewlineAtEnd",
+			a:    "a\nb\n",
+			b:    "a\nb",
+			want: "@@ -1,2 +1,2 @@\n a\n-b\n+b\n\\ No newline at end of file\n",
+		},
+		{
+			name: "SeparateHunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\n12\n",
+			b:    "x\n2\n3\n4\n5\n6\n7\n8\n9\n10\n11\ny\n",
+			want: "@@ -1,4 +1,4 @@\n-1\n+x\n 2\n 3\n 4\n" +
+				"@@ -9,4 +9,4 @@\n 9\n 10\n 11\n-12\n+y\n",
+		},
+		{
+			name: "MergedHunks",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n",
+			b:    "1\nx\n3\n4\n5\n6\n7\ny\n",
+			want: "@@ -1,8 +1,8 @@\n 1\n-2\n+x\n 3\n 4\n 5\n 6\n 7\n-8\n+y\n",
+		},
+		{
+			name: "Insert",
+			a:    "a\nc\n",
+			b:    "a\nb\nc\n",
+			want: "@@ -1,2 +1,3 @@\n a\n+b\n c\n",
+		},
+		{
+			name: "Interleaved",
+			a:    "a\nb\nc\nd\n",
+			b:    "b\nx\nd\ne\n",
+			want: "@@ -1,4 +1,4 @@\n-a\n b\n-c\n+x\n d\n+e\n",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var a, b []string
+			if test.a != "" {
+				a = splitLines([]byte(test.a))
+			}
+			if test.b != "" {
+				b = splitLines([]byte(test.b))
+			}
+			got := string(appendUnifiedDiff(nil, a, b))
+			if diff := cmp.Diff(test.want, got); diff != "" {
+				t.Errorf("appendUnifiedDiff(nil, %q, %q) (-want +got):\n%s", test.a, test.b, diff)
+			}
+		})
+	}
+}
+
+func TestDiffNARs(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses symlinks and executable bits")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a")
+	b := filepath.Join(dir, "b")
+	writeTestTree(t, a, map[string]string{
+		"bin/hello":        "hello\n",
+		"data.txt":         "a\nb\nc\n",
+		"lib/x.so":         "\x00\x01\x02\x03",
+		"link":             "-> bin/hello",
+		"same.txt":         "same\n",
+		"share/doc/README": "Hello\n",
+		"thing":            "file\n",
+	})
+	writeTestTree(t, b, map[string]string{
+		"bin/hello":   "+x hello\n",
+		"data.txt":    "a\nB\nc\n",
+		"lib/x.so":    "\x00\x01\x02\x04\x05",
+		"link":        "-> lib/x.so",
+		"new.txt":     "new\n",
+		"same.txt":    "same\n",
+		"share/other": "",
+		"thing/file":  "file\n",
+	})
+	g := &globalConfig{storeDir: zbstore.Directory(filepath.Join(dir, "store"))}
+	aSide, err := openNARDiffSide(ctx, g, a)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aSide.close()
+	bSide, err := openNARDiffSide(ctx, g, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bSide.close()
+
+	t.Run("Full", func(t *testing.T) {
+		got := new(strings.Builder)
+		if err := diffNARs(ctx, got, aSide, bSide, false); err != nil {
+			t.Fatal(err)
+		}
+		want := "Mode of bin/hello changed: -r--r--r-- -> -r-xr-xr-x\n" +
+			"Contents of data.txt differ:\n" +
+			"--- " + a + "/data.txt\n" +
+			"+++ " + b + "/data.txt\n" +
+			"@@ -1,3 +1,3 @@\n a\n-b\n+B\n c\n" +
+			"Binary contents of lib/x.so differ (size 4 -> 5):\n" +
+			"-00000000  00 01 02 03                                       |....|\n" +
+			"+00000000  00 01 02 04 05                                    |.....|\n" +
+			"Target of link changed: bin/hello -> lib/x.so\n" +
+			"Only in " + b + ": new.txt\n" +
+			"Only in " + a + ": share/doc\n" +
+			"Only in " + b + ": share/other\n" +
+			"Type of thing changed: regular file -> directory\n"
+		if diff := cmp.Diff(want, got.String()); diff != "" {
+			t.Errorf("diff (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Brief", func(t *testing.T) {
+		got := new(strings.Builder)
+		if err := diffNARs(ctx, got, aSide, bSide, true); err != nil {
+			t.Fatal(err)
+		}
+		want := "Mode of bin/hello changed: -r--r--r-- -> -r-xr-xr-x\n" +
+			"Contents of data.txt differ\n" +
+			"Contents of lib/x.so differ\n" +
+			"Target of link changed: bin/hello -> lib/x.so\n" +
+			"Only in " + b + ": new.txt\n" +
+			"Only in " + a + ": share/doc\n" +
+			"Only in " + b + ": share/other\n" +
+			"Type of thing changed: regular file -> directory\n"
+		if diff := cmp.Diff(want, got.String()); diff != "" {
+			t.Errorf("diff (-want +got):\n%s", diff)
+		}
+	})
+}
+
+func TestDiffNARsSelfReferences(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses symlinks")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := zbstore.Directory(t.TempDir())
+	aPath, err := storeDir.Object("s3fvyacpqsbhwbrd7qd7mw1sxqxwqp3n-hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	bPath, err := storeDir.Object("0k1b3pyg3ddq0m3nnv4fvbfvcm1sc1ij-hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestTree(t, string(aPath), map[string]string{
+		"bin/hello":   "#!/bin/sh\nexec " + string(aPath) + "/libexec/hello\n",
+		"libexec/foo": "-> " + string(aPath) + "/bin/hello",
+		"version":     "1\n",
+	})
+	writeTestTree(t, string(bPath), map[string]string{
+		"bin/hello":   "#!/bin/sh\nexec " + string(bPath) + "/libexec/hello\n",
+		"libexec/foo": "-> " + string(bPath) + "/bin/hello",
+		"version":     "2\n",
+	})
+	g := &globalConfig{storeDir: storeDir}
+	aSide, err := openNARDiffSide(ctx, g, string(aPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aSide.close()
+	bSide, err := openNARDiffSide(ctx, g, string(bPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer bSide.close()
+
+	got := new(strings.Builder)
+	if err := diffNARs(ctx, got, aSide, bSide, false); err != nil {
+		t.Fatal(err)
+	}
+	want := "Comparing with self-references in " + string(aPath) + " rewritten to " + bPath.Digest() + "\n" +
+		"Contents of bin/hello differ only in self-references\n" +
+		"Target of libexec/foo differs only in self-references\n" +
+		"Contents of version differ:\n" +
+		"--- " + string(aPath) + "/version\n" +
+		"+++ " + string(bPath) + "/version\n" +
+		"@@ -1 +1 @@\n-1\n+2\n"
+	if diff := cmp.Diff(want, got.String()); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestOpenNARDiffFileExport(t *testing.T) {
+	dir := t.TempDir()
+	exportPath := filepath.Join(dir, "hello.export")
+	f, err := os.Create(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	exporter := zbstore.NewExporter(f)
+	storePath, _, err := storetest.ExportText(exporter, zbstore.DefaultUnixDirectory, "hello.txt", []byte("Hello, World!\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	rs, digest, err := openNARDiffFile(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rs.Close()
+	if want := storePath.Digest(); digest != want {
+		t.Errorf("digest = %q; want %q", digest, want)
+	}
+	entries, err := readNAREntries(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hdr := entries[""]
+	if len(entries) != 1 || hdr == nil || !hdr.Mode.IsRegular() {
+		t.Fatalf("entries = %v; want a single regular file", entries)
+	}
+	if got, err := readNARContent(rs, hdr); err != nil {
+		t.Error(err)
+	} else if want := "Hello, World!\n"; string(got) != want {
+		t.Errorf("content = %q; want %q", got, want)
+	}
+}
+
+// writeTestTree creates a directory at root with the given files.
+// Content starting with "-> " creates a symlink
+// and content starting with "+x " creates an executable file.
+func writeTestTree(tb testing.TB, root string, files map[string]string) {
+	tb.Helper()
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+			tb.Fatal(err)
+		}
+		var err error
+		if target, ok := strings.CutPrefix(content, "-> "); ok {
+			err = os.Symlink(target, path)
+		} else if content, ok := strings.CutPrefix(content, "+x "); ok {
+			err = os.WriteFile(path, []byte(content), 0o777)
+		} else {
+			err = os.WriteFile(path, []byte(content), 0o666)
+		}
+		if err != nil {
+			tb.Fatal(err)
+		}
+	}
+}
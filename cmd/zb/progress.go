@@ -0,0 +1,938 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/term"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// logFormat is the format used to display build progress on stderr.
+// It implements [github.com/spf13/pflag.Value].
+type logFormat string
+
+// Log formats.
+const (
+	// logFormatAuto uses logFormatBar if stderr is a terminal
+	// and logFormatRaw otherwise.
+	logFormatAuto logFormat = ""
+	// logFormatBar displays a multi-line progress display
+	// that is redrawn as the build progresses.
+	logFormatBar logFormat = "bar"
+	// logFormatRaw writes builder logs as they are received,
+	// prefixing each line with the name of the derivation.
+	logFormatRaw logFormat = "raw"
+	// logFormatJSON writes a JSON object per line for each build event.
+	logFormatJSON logFormat = "json"
+)
+
+func (f *logFormat) Type() string  { return "string" }
+func (f logFormat) String() string { return string(f) }
+func (f logFormat) Get() any       { return f }
+
+func (f *logFormat) Set(s string) error {
+	switch lf := logFormat(s); lf {
+	case logFormatBar, logFormatRaw, logFormatJSON:
+		*f = lf
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q (must be one of bar, raw, or json)", s)
+	}
+}
+
+// buildReporter displays the progress of builds and downloads.
+// Methods on a buildReporter are safe to call concurrently.
+type buildReporter interface {
+	// startBuild is called when the store has started a build.
+	// derivations is the number of derivations the build may realize
+	// or zero if the store did not report it.
+	startBuild(buildID string, derivations int)
+	// buildEvent is called for each event the store reports for a started build.
+	buildEvent(event *zbstorerpc.BuildEvent)
+	// finishBuild is called when a started build is no longer being watched.
+	// status is the build's last known status.
+	finishBuild(buildID string, status zbstorerpc.BuildStatus)
+
+	// startDownload is called when the evaluator starts reading an HTTP response.
+	startDownload(d *download)
+	// finishDownload is called once the evaluator has read or closed the response.
+	finishDownload(d *download)
+
+	// close stops the display and writes any summary.
+	// It is safe to call close multiple times.
+	close() error
+}
+
+// newBuildReporter returns a new [buildReporter] that writes to stderr.
+func newBuildReporter(format logFormat) buildReporter {
+	if format == logFormatAuto {
+		format = logFormatRaw
+		if enableTerminalEscapes(os.Stderr) {
+			format = logFormatBar
+		}
+	}
+	switch format {
+	case logFormatBar:
+		r := newBarReporter(os.Stderr, func() int {
+			width, _, err := term.GetSize(int(os.Stderr.Fd()))
+			if err != nil || width <= 0 {
+				return 80
+			}
+			return width
+		})
+		stderr.setBar(r)
+		return r
+	case logFormatJSON:
+		return &jsonReporter{w: stderr}
+	default:
+		return newRawReporter(stderr)
+	}
+}
+
+// stderr is the writer used for diagnostic messages.
+// While a [*barReporter] is displayed,
+// messages written to stderr are placed above the bar.
+var stderr = new(stderrWriter)
+
+type stderrWriter struct {
+	mu  sync.Mutex
+	bar *barReporter
+}
+
+func (sw *stderrWriter) setBar(bar *barReporter) {
+	sw.mu.Lock()
+	sw.bar = bar
+	sw.mu.Unlock()
+}
+
+func (sw *stderrWriter) Write(p []byte) (int, error) {
+	sw.mu.Lock()
+	bar := sw.bar
+	sw.mu.Unlock()
+	if bar != nil {
+		return bar.writeAbove(p)
+	}
+	return os.Stderr.Write(p)
+}
+
+// buildDerivation identifies a derivation in a particular build.
+type buildDerivation struct {
+	buildID string
+	drvPath zbstore.Path
+}
+
+// derivationDisplayName returns the name of the derivation at drvPath
+// for use in progress messages.
+func derivationDisplayName(drvPath zbstore.Path) string {
+	if name, ok := drvPath.DerivationName(); ok {
+		return name
+	}
+	return drvPath.Name()
+}
+
+// maxLogLineLength is the maximum number of bytes of a log line
+// buffered by [*lineSplitter] before it is reported without a newline.
+const maxLogLineLength = 4096
+
+// lineSplitter splits a stream of log data into lines.
+type lineSplitter struct {
+	partial []byte
+}
+
+// write calls yield for each complete line in payload
+// (excluding the line ending),
+// buffering any incomplete line for the next call to write.
+func (ls *lineSplitter) write(payload []byte, yield func(line []byte)) {
+	for len(payload) > 0 {
+		i := bytes.IndexByte(payload, '\n')
+		if i < 0 {
+			ls.partial = append(ls.partial, payload...)
+			if len(ls.partial) >= maxLogLineLength {
+				ls.flush(yield)
+			}
+			return
+		}
+		line := payload[:i]
+		if len(ls.partial) > 0 {
+			ls.partial = append(ls.partial, line...)
+			line = ls.partial
+		}
+		yield(bytes.TrimSuffix(line, []byte("\r")))
+		ls.partial = ls.partial[:0]
+		payload = payload[i+1:]
+	}
+}
+
+// flush calls yield with any incomplete line.
+func (ls *lineSplitter) flush(yield func(line []byte)) {
+	if len(ls.partial) > 0 {
+		yield(ls.partial)
+		ls.partial = ls.partial[:0]
+	}
+}
+
+// rawReporter is a [buildReporter] for [logFormatRaw].
+type rawReporter struct {
+	w io.Writer
+
+	mu    sync.Mutex
+	lines map[buildDerivation]*lineSplitter
+}
+
+func newRawReporter(w io.Writer) *rawReporter {
+	return &rawReporter{
+		w:     w,
+		lines: make(map[buildDerivation]*lineSplitter),
+	}
+}
+
+func (r *rawReporter) startBuild(buildID string, derivations int) {}
+
+func (r *rawReporter) buildEvent(event *zbstorerpc.BuildEvent) {
+	key := buildDerivation{event.BuildID, event.DrvPath}
+	name := derivationDisplayName(event.DrvPath)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch event.Kind {
+	case zbstorerpc.BuildLogEvent:
+		if event.Log == nil {
+			return
+		}
+		payload, err := event.Log.Payload()
+		if err != nil {
+			return
+		}
+		ls := r.lines[key]
+		if ls == nil {
+			ls = new(lineSplitter)
+			r.lines[key] = ls
+		}
+		ls.write(payload, func(line []byte) {
+			r.writeLine(name, line)
+		})
+	case zbstorerpc.DerivationFinishedEvent, zbstorerpc.DerivationFailedEvent:
+		if ls := r.lines[key]; ls != nil {
+			ls.flush(func(line []byte) {
+				r.writeLine(name, line)
+			})
+			delete(r.lines, key)
+		}
+	}
+}
+
+// writeLine writes a single line of a builder's log.
+// The caller must hold r.mu.
+func (r *rawReporter) writeLine(name string, line []byte) {
+	buf := make([]byte, 0, len(name)+len(line)+4)
+	buf = append(buf, "["...)
+	buf = append(buf, name...)
+	buf = append(buf, "] "...)
+	buf = append(buf, line...)
+	buf = append(buf, "\n"...)
+	r.w.Write(buf)
+}
+
+func (r *rawReporter) finishBuild(buildID string, status zbstorerpc.BuildStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, ls := range r.lines {
+		if key.buildID != buildID {
+			continue
+		}
+		ls.flush(func(line []byte) {
+			r.writeLine(derivationDisplayName(key.drvPath), line)
+		})
+		delete(r.lines, key)
+	}
+}
+
+func (r *rawReporter) startDownload(d *download) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	fmt.Fprintf(r.w, "[download] %s\n", d.url)
+}
+
+func (r *rawReporter) finishDownload(d *download) {}
+
+func (r *rawReporter) close() error { return nil }
+
+// jsonReporter is a [buildReporter] for [logFormatJSON].
+type jsonReporter struct {
+	w io.Writer
+
+	mu    sync.Mutex
+	lines map[buildDerivation]*lineSplitter
+}
+
+// jsonProgressRecord is a single line of [logFormatJSON] output.
+// Kind is one of the [zbstorerpc.BuildEventKind] values
+// or one of "buildStarted", "buildFinished", "downloadStarted", or "downloadFinished".
+// Build log events are split into lines,
+// with one record per line.
+type jsonProgressRecord struct {
+	Time        time.Time                 `json:"time"`
+	Kind        string                    `json:"kind"`
+	BuildID     string                    `json:"buildID,omitempty"`
+	DrvPath     zbstore.Path              `json:"drvPath,omitempty"`
+	Derivations int                       `json:"derivations,omitempty"`
+	Status      zbstorerpc.BuildStatus    `json:"status,omitempty"`
+	Result      *zbstorerpc.BuildResult   `json:"result,omitempty"`
+	Output      *zbstorerpc.RealizeOutput `json:"output,omitempty"`
+	Line        *string                   `json:"line,omitempty"`
+	URL         string                    `json:"url,omitempty"`
+	Size        *int64                    `json:"size,omitempty"`
+}
+
+func (r *jsonReporter) startBuild(buildID string, derivations int) {
+	r.write(&jsonProgressRecord{
+		Kind:        "buildStarted",
+		BuildID:     buildID,
+		Derivations: derivations,
+	})
+}
+
+func (r *jsonReporter) buildEvent(event *zbstorerpc.BuildEvent) {
+	key := buildDerivation{event.BuildID, event.DrvPath}
+	writeLine := func(line []byte) {
+		s := string(line)
+		r.write(&jsonProgressRecord{
+			Kind:    string(zbstorerpc.BuildLogEvent),
+			BuildID: event.BuildID,
+			DrvPath: event.DrvPath,
+			Line:    &s,
+		})
+	}
+
+	switch event.Kind {
+	case zbstorerpc.BuildLogEvent:
+		if event.Log == nil {
+			return
+		}
+		payload, err := event.Log.Payload()
+		if err != nil {
+			return
+		}
+		r.mu.Lock()
+		if r.lines == nil {
+			r.lines = make(map[buildDerivation]*lineSplitter)
+		}
+		ls := r.lines[key]
+		if ls == nil {
+			ls = new(lineSplitter)
+			r.lines[key] = ls
+		}
+		var lines [][]byte
+		ls.write(payload, func(line []byte) {
+			lines = append(lines, bytes.Clone(line))
+		})
+		r.mu.Unlock()
+		for _, line := range lines {
+			writeLine(line)
+		}
+		return
+	case zbstorerpc.DerivationFinishedEvent, zbstorerpc.DerivationFailedEvent:
+		r.mu.Lock()
+		var rest []byte
+		if ls := r.lines[key]; ls != nil {
+			ls.flush(func(line []byte) {
+				rest = bytes.Clone(line)
+			})
+			delete(r.lines, key)
+		}
+		r.mu.Unlock()
+		if rest != nil {
+			writeLine(rest)
+		}
+	}
+
+	r.write(&jsonProgressRecord{
+		Kind:    string(event.Kind),
+		BuildID: event.BuildID,
+		DrvPath: event.DrvPath,
+		Result:  event.Result,
+		Output:  event.Output,
+	})
+}
+
+func (r *jsonReporter) finishBuild(buildID string, status zbstorerpc.BuildStatus) {
+	r.write(&jsonProgressRecord{
+		Kind:    "buildFinished",
+		BuildID: buildID,
+		Status:  status,
+	})
+}
+
+func (r *jsonReporter) startDownload(d *download) {
+	rec := &jsonProgressRecord{
+		Kind: "downloadStarted",
+		URL:  d.url,
+	}
+	if d.size >= 0 {
+		rec.Size = &d.size
+	}
+	r.write(rec)
+}
+
+func (r *jsonReporter) finishDownload(d *download) {
+	n := d.n.Load()
+	r.write(&jsonProgressRecord{
+		Kind: "downloadFinished",
+		URL:  d.url,
+		Size: &n,
+	})
+}
+
+func (r *jsonReporter) write(rec *jsonProgressRecord) {
+	rec.Time = time.Now().UTC()
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return
+	}
+	data = append(data, '\n')
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w.Write(data)
+}
+
+func (r *jsonReporter) close() error { return nil }
+
+// Bar display parameters.
+const (
+	// barRefreshInterval is how often the bar is redrawn.
+	barRefreshInterval = 200 * time.Millisecond
+	// maxBarDerivations is the maximum number of running derivations
+	// shown in the bar at once.
+	maxBarDerivations = 10
+	// failureLogLines is the number of log lines
+	// shown when a derivation fails.
+	failureLogLines = 10
+)
+
+// barReporter is a [buildReporter] for [logFormatBar].
+// It draws a multi-line display at the bottom of a terminal
+// that is redrawn periodically until close is called.
+type barReporter struct {
+	w     io.Writer
+	width func() int
+	start time.Time
+
+	mu           sync.Mutex
+	activeBuilds int
+	// total is the sum of the number of derivations in each build.
+	total int
+	// totalUnknown is true if any build did not report its number of derivations.
+	totalUnknown  bool
+	done          int
+	failed        int
+	running       map[buildDerivation]*runningDerivation
+	downloads     map[*download]struct{}
+	downloadCount int
+	downloadBytes int64
+	// drawn is the number of lines currently displayed.
+	drawn  int
+	closed bool
+
+	stop    chan struct{}
+	stopped chan struct{}
+}
+
+// runningDerivation is the state of a running derivation in a [*barReporter].
+type runningDerivation struct {
+	name  string
+	start time.Time
+	lines lineSplitter
+	// tail is the last lines of the derivation's log.
+	tail []string
+	// lastLine is the last non-empty line of the derivation's log.
+	lastLine string
+}
+
+func newBarReporter(w io.Writer, width func() int) *barReporter {
+	r := &barReporter{
+		w:         w,
+		width:     width,
+		start:     time.Now(),
+		running:   make(map[buildDerivation]*runningDerivation),
+		downloads: make(map[*download]struct{}),
+		stop:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	go r.refresh()
+	return r
+}
+
+func (r *barReporter) refresh() {
+	defer close(r.stopped)
+	ticker := time.NewTicker(barRefreshInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.mu.Lock()
+			if !r.closed {
+				r.draw(nil)
+			}
+			r.mu.Unlock()
+		case <-r.stop:
+			return
+		}
+	}
+}
+
+func (r *barReporter) startBuild(buildID string, derivations int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.activeBuilds++
+	if derivations > 0 {
+		r.total += derivations
+	} else {
+		r.totalUnknown = true
+	}
+}
+
+func (r *barReporter) buildEvent(event *zbstorerpc.BuildEvent) {
+	key := buildDerivation{event.BuildID, event.DrvPath}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	switch event.Kind {
+	case zbstorerpc.DerivationStartedEvent:
+		if r.running[key] == nil {
+			r.running[key] = &runningDerivation{
+				name:  derivationDisplayName(event.DrvPath),
+				start: time.Now(),
+			}
+		}
+	case zbstorerpc.BuildLogEvent:
+		rd := r.running[key]
+		if rd == nil || event.Log == nil {
+			return
+		}
+		payload, err := event.Log.Payload()
+		if err != nil {
+			return
+		}
+		rd.lines.write(payload, rd.addLine)
+		if len(rd.lines.partial) > 0 {
+			if line := sanitizeLogLine(rd.lines.partial); line != "" {
+				rd.lastLine = line
+			}
+		}
+	case zbstorerpc.DerivationFinishedEvent:
+		delete(r.running, key)
+		r.done++
+	case zbstorerpc.DerivationFailedEvent:
+		rd := r.running[key]
+		delete(r.running, key)
+		r.failed++
+		if r.closed {
+			return
+		}
+		msg := new(bytes.Buffer)
+		status := zbstorerpc.BuildFail
+		if event.Result != nil {
+			status = event.Result.Status
+		}
+		fmt.Fprintf(msg, "zb: build of %s failed (%s)", event.DrvPath, status)
+		if rd != nil {
+			rd.lines.flush(rd.addLine)
+			if len(rd.tail) > 0 {
+				fmt.Fprintf(msg, "; last %d log lines:", len(rd.tail))
+				for _, line := range rd.tail {
+					msg.WriteString("\n> ")
+					msg.WriteString(line)
+				}
+			}
+		}
+		msg.WriteString("\n")
+		r.draw(msg.Bytes())
+	}
+}
+
+func (rd *runningDerivation) addLine(line []byte) {
+	s := sanitizeLogLine(line)
+	if s == "" {
+		return
+	}
+	rd.lastLine = s
+	if len(rd.tail) >= failureLogLines {
+		rd.tail = slices.Delete(rd.tail, 0, 1)
+	}
+	rd.tail = append(rd.tail, s)
+}
+
+func (r *barReporter) finishBuild(buildID string, status zbstorerpc.BuildStatus) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.activeBuilds--
+	for key := range r.running {
+		if key.buildID == buildID {
+			delete(r.running, key)
+		}
+	}
+}
+
+func (r *barReporter) startDownload(d *download) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.downloads[d] = struct{}{}
+}
+
+func (r *barReporter) finishDownload(d *download) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.downloads, d)
+	r.downloadCount++
+	r.downloadBytes += d.n.Load()
+}
+
+// writeAbove writes p to the terminal above the bar.
+func (r *barReporter) writeAbove(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return r.w.Write(p)
+	}
+	if err := r.draw(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// draw erases the bar, writes above (if not empty), then draws the bar again.
+// The caller must hold r.mu.
+func (r *barReporter) draw(above []byte) error {
+	var buf []byte
+	buf = r.appendErase(buf)
+	if len(above) > 0 {
+		buf = append(buf, above...)
+		if above[len(above)-1] != '\n' {
+			buf = append(buf, '\n')
+		}
+	}
+	lines := r.render(time.Now(), r.width())
+	for i, line := range lines {
+		if i > 0 {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, line...)
+	}
+	r.drawn = len(lines)
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(buf)
+	return err
+}
+
+// appendErase appends the terminal escape sequences to erase the bar to buf.
+// The caller must hold r.mu.
+func (r *barReporter) appendErase(buf []byte) []byte {
+	if r.drawn == 0 {
+		return buf
+	}
+	buf = append(buf, '\r')
+	if r.drawn > 1 {
+		buf = fmt.Appendf(buf, "\x1b[%dA", r.drawn-1)
+	}
+	buf = append(buf, "\x1b[J"...)
+	r.drawn = 0
+	return buf
+}
+
+// render returns the lines of the bar.
+// The caller must hold r.mu.
+func (r *barReporter) render(now time.Time, width int) []string {
+	if r.activeBuilds <= 0 && len(r.running) == 0 && len(r.downloads) == 0 {
+		return nil
+	}
+	// Leave the last column empty so that the terminal does not wrap lines.
+	width = max(width-1, 10)
+
+	status := new(strings.Builder)
+	status.WriteString("[")
+	if r.totalUnknown || r.total == 0 {
+		fmt.Fprintf(status, "%d done", r.done)
+	} else {
+		fmt.Fprintf(status, "%d/%d done", r.done, r.total)
+	}
+	fmt.Fprintf(status, ", %d running", len(r.running))
+	if !r.totalUnknown && r.total > 0 {
+		fmt.Fprintf(status, ", %d remaining", max(r.total-r.done-r.failed-len(r.running), 0))
+	}
+	if r.failed > 0 {
+		fmt.Fprintf(status, ", %d failed", r.failed)
+	}
+	fmt.Fprintf(status, "] %s", formatElapsed(now.Sub(r.start)))
+	lines := []string{truncateLine(status.String(), width)}
+
+	running := make([]*runningDerivation, 0, len(r.running))
+	for _, rd := range r.running {
+		running = append(running, rd)
+	}
+	slices.SortFunc(running, func(rd1, rd2 *runningDerivation) int {
+		return cmp.Or(
+			rd1.start.Compare(rd2.start),
+			cmp.Compare(rd1.name, rd2.name),
+		)
+	})
+	for i, rd := range running {
+		if i >= maxBarDerivations {
+			lines = append(lines, fmt.Sprintf("  ... and %d more", len(running)-i))
+			break
+		}
+		line := fmt.Sprintf("  %s (%s)", rd.name, formatElapsed(now.Sub(rd.start)))
+		if rd.lastLine != "" {
+			line += ": " + rd.lastLine
+		}
+		lines = append(lines, truncateLine(line, width))
+	}
+
+	downloads := make([]*download, 0, len(r.downloads))
+	for d := range r.downloads {
+		downloads = append(downloads, d)
+	}
+	slices.SortFunc(downloads, func(d1, d2 *download) int {
+		return cmp.Or(
+			d1.start.Compare(d2.start),
+			cmp.Compare(d1.url, d2.url),
+		)
+	})
+	for _, d := range downloads {
+		progress := formatByteSize(d.n.Load())
+		if d.size >= 0 {
+			progress += " / " + formatByteSize(d.size)
+		}
+		lines = append(lines, truncateLine(fmt.Sprintf("  downloading [%s] %s", progress, d.url), width))
+	}
+	return lines
+}
+
+// summary returns a one-line summary of the work displayed by the bar,
+// or the empty string if nothing was displayed.
+// The caller must hold r.mu.
+func (r *barReporter) summary(now time.Time) string {
+	var parts []string
+	if n := r.done + r.failed; n > 0 {
+		part := pluralize(n, "derivation", "derivations") + " done"
+		if r.failed > 0 {
+			part += fmt.Sprintf(" (%d failed)", r.failed)
+		}
+		parts = append(parts, part)
+	}
+	if r.downloadCount > 0 {
+		parts = append(parts, fmt.Sprintf("downloaded %s (%s)",
+			pluralize(r.downloadCount, "file", "files"),
+			formatByteSize(r.downloadBytes)))
+	}
+	if len(parts) == 0 {
+		return ""
+	}
+	return strings.Join(parts, ", ") + " in " + formatElapsed(now.Sub(r.start))
+}
+
+func (r *barReporter) close() error {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return nil
+	}
+	r.closed = true
+	r.mu.Unlock()
+
+	close(r.stop)
+	<-r.stopped
+	stderr.mu.Lock()
+	if stderr.bar == r {
+		stderr.bar = nil
+	}
+	stderr.mu.Unlock()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	buf := r.appendErase(nil)
+	if s := r.summary(time.Now()); s != "" {
+		buf = append(buf, "zb: "...)
+		buf = append(buf, s...)
+		buf = append(buf, '\n')
+	}
+	if len(buf) == 0 {
+		return nil
+	}
+	_, err := r.w.Write(buf)
+	return err
+}
+
+// sanitizeLogLine returns a log line suitable for display in a single line of the bar.
+// Terminal escape sequences and other control characters are removed,
+// and only the text after the last carriage return is kept.
+func sanitizeLogLine(line []byte) string {
+	if i := bytes.LastIndexByte(line, '\r'); i >= 0 {
+		line = line[i+1:]
+	}
+	sb := new(strings.Builder)
+	for len(line) > 0 {
+		if line[0] == '\x1b' {
+			line = skipEscapeSequence(line)
+			continue
+		}
+		c, size := utf8.DecodeRune(line)
+		line = line[size:]
+		switch {
+		case c == '\t':
+			sb.WriteByte(' ')
+		case c == utf8.RuneError && size == 1:
+			sb.WriteRune(utf8.RuneError)
+		case unicode.IsControl(c):
+		default:
+			sb.WriteRune(c)
+		}
+	}
+	return strings.TrimSpace(sb.String())
+}
+
+// skipEscapeSequence returns line with the escape sequence at its start removed.
+func skipEscapeSequence(line []byte) []byte {
+	if len(line) < 2 {
+		return nil
+	}
+	switch line[1] {
+	case '[':
+		// Control Sequence Introducer: parameters and intermediates end with a final byte.
+		for i := 2; i < len(line); i++ {
+			if line[i] >= 0x40 && line[i] <= 0x7e {
+				return line[i+1:]
+			}
+		}
+		return nil
+	case ']':
+		// Operating System Command: terminated by BEL or ST.
+		for i := 2; i < len(line); i++ {
+			if line[i] == '\a' {
+				return line[i+1:]
+			}
+			if line[i] == '\x1b' && i+1 < len(line) && line[i+1] == '\\' {
+				return line[i+2:]
+			}
+		}
+		return nil
+	default:
+		return line[2:]
+	}
+}
+
+// truncateLine shortens s to at most width runes.
+func truncateLine(s string, width int) string {
+	if utf8.RuneCountInString(s) <= width {
+		return s
+	}
+	const ellipsis = "..."
+	n := 0
+	for i := range s {
+		if n == width-len(ellipsis) {
+			return s[:i] + ellipsis
+		}
+		n++
+	}
+	return s
+}
+
+// formatElapsed formats a duration for display in progress messages.
+func formatElapsed(d time.Duration) string {
+	if d < time.Minute {
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	}
+	d = d.Round(time.Second)
+	if d < time.Hour {
+		return fmt.Sprintf("%dm%02ds", d/time.Minute, (d%time.Minute)/time.Second)
+	}
+	return fmt.Sprintf("%dh%02dm", d/time.Hour, (d%time.Hour)/time.Minute)
+}
+
+func pluralize(n int, singular, plural string) string {
+	if n == 1 {
+		return "1 " + singular
+	}
+	return fmt.Sprintf("%d %s", n, plural)
+}
+
+// download is the state of an HTTP response body being read by the evaluator.
+type download struct {
+	url   string
+	size  int64 // -1 if unknown
+	start time.Time
+	// n is the number of bytes read so far.
+	n atomic.Int64
+}
+
+// progressTransport is an [http.RoundTripper]
+// that reports response bodies read to a [buildReporter].
+type progressTransport struct {
+	base     http.RoundTripper
+	progress buildReporter
+}
+
+func (t progressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	d := &download{
+		url:   req.URL.Redacted(),
+		size:  resp.ContentLength,
+		start: time.Now(),
+	}
+	t.progress.startDownload(d)
+	resp.Body = &downloadBody{
+		ReadCloser: resp.Body,
+		d:          d,
+		progress:   t.progress,
+	}
+	return resp, nil
+}
+
+// downloadBody is an [http.Response] body that updates a [*download].
+type downloadBody struct {
+	io.ReadCloser
+	d        *download
+	progress buildReporter
+	once     sync.Once
+}
+
+func (body *downloadBody) Read(p []byte) (int, error) {
+	n, err := body.ReadCloser.Read(p)
+	body.d.n.Add(int64(n))
+	if err != nil {
+		body.finish()
+	}
+	return n, err
+}
+
+func (body *downloadBody) Close() error {
+	body.finish()
+	return body.ReadCloser.Close()
+}
+
+func (body *downloadBody) finish() {
+	body.once.Do(func() {
+		body.progress.finishDownload(body.d)
+	})
+}
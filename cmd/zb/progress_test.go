@@ -0,0 +1,178 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+const (
+	testBuildID    = "0190f1b1-2c04-7e57-a63a-9a0e4f1ad1cb"
+	testHelloPath  = zbstore.Path("/opt/zb/store/ib4dfwymfd8n7d2kr2kqn9qbmg8r6hgl-hello.drv")
+	testWorldPath  = zbstore.Path("/opt/zb/store/1hjj8qqw9yv5kxj3ar5ld1cdw2nlw5jl-world.drv")
+	testFailedPath = zbstore.Path("/opt/zb/store/s6p5h4k6cz1qj1mxp9j3hj4h6xhnbj3h-broken.drv")
+)
+
+func TestLogFormatFlag(t *testing.T) {
+	for _, s := range []string{"bar", "raw", "json"} {
+		var f logFormat
+		if err := f.Set(s); err != nil {
+			t.Errorf("Set(%q) = %v; want <nil>", s, err)
+		} else if got := f.String(); got != s {
+			t.Errorf("after Set(%q), String() = %q", s, got)
+		}
+	}
+	for _, s := range []string{"", "BAR", "text"} {
+		var f logFormat
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) = <nil>; want error", s)
+		}
+	}
+}
+
+func TestRawReporter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	r := newRawReporter(buf)
+	r.startBuild(testBuildID, 2)
+	r.buildEvent(&zbstorerpc.BuildEvent{BuildID: testBuildID, Kind: zbstorerpc.DerivationStartedEvent, DrvPath: testHelloPath})
+	r.buildEvent(&zbstorerpc.BuildEvent{BuildID: testBuildID, Kind: zbstorerpc.DerivationStartedEvent, DrvPath: testWorldPath})
+	r.buildEvent(testLogEvent(testHelloPath, 0, "Hello, "))
+	r.buildEvent(testLogEvent(testWorldPath, 0, "one\r\ntwo\n"))
+	r.buildEvent(testLogEvent(testHelloPath, 7, "World!\npartial"))
+	r.buildEvent(&zbstorerpc.BuildEvent{BuildID: testBuildID, Kind: zbstorerpc.DerivationFinishedEvent, DrvPath: testHelloPath})
+	r.buildEvent(testLogEvent(testWorldPath, 9, "three"))
+	r.finishBuild(testBuildID, zbstorerpc.BuildSuccess)
+	if err := r.close(); err != nil {
+		t.Error("close:", err)
+	}
+
+	const want = "[world] one\n" +
+		"[world] two\n" +
+		"[hello] Hello, World!\n" +
+		"[hello] partial\n" +
+		"[world] three\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestBarReporter(t *testing.T) {
+	buf := new(bytes.Buffer)
+	r := newBarReporter(buf, func() int { return 80 })
+	r.startBuild(testBuildID, 4)
+	r.buildEvent(&zbstorerpc.BuildEvent{BuildID: testBuildID, Kind: zbstorerpc.DerivationStartedEvent, DrvPath: testHelloPath})
+	r.buildEvent(&zbstorerpc.BuildEvent{BuildID: testBuildID, Kind: zbstorerpc.DerivationFinishedEvent, DrvPath: testHelloPath})
+	r.buildEvent(&zbstorerpc.BuildEvent{BuildID: testBuildID, Kind: zbstorerpc.DerivationStartedEvent, DrvPath: testWorldPath})
+	r.buildEvent(testLogEvent(testWorldPath, 0, "compiling\n\x1b[1mlinking\x1b[0m...\n"))
+	r.buildEvent(&zbstorerpc.BuildEvent{BuildID: testBuildID, Kind: zbstorerpc.DerivationStartedEvent, DrvPath: testFailedPath})
+	r.buildEvent(testLogEvent(testFailedPath, 0, "oh no\n"))
+	d := &download{url: "https://example.com/hello.tar.gz", size: 2048, start: time.Now()}
+	d.n.Store(1024)
+	r.startDownload(d)
+
+	r.mu.Lock()
+	lines := r.render(time.Now(), 80)
+	r.mu.Unlock()
+	if len(lines) != 4 {
+		t.Fatalf("rendered %d lines; want 4. Got:\n%s", len(lines), strings.Join(lines, "\n"))
+	}
+	if got, want := lines[0], "[1/4 done, 2 running, 1 remaining]"; !strings.HasPrefix(got, want) {
+		t.Errorf("status line = %q; want prefix %q", got, want)
+	}
+	if got, want := lines[1], "  world ("; !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "): linking...") {
+		t.Errorf("lines[1] = %q; want %q...): linking...", got, want)
+	}
+	if got, want := lines[2], "  broken ("; !strings.HasPrefix(got, want) || !strings.HasSuffix(got, "): oh no") {
+		t.Errorf("lines[2] = %q; want %q...): oh no", got, want)
+	}
+	if got, want := lines[3], "  downloading [1.0 KiB / 2.0 KiB] https://example.com/hello.tar.gz"; got != want {
+		t.Errorf("lines[3] = %q; want %q", got, want)
+	}
+	for i, line := range lines {
+		if n := len([]rune(line)); n >= 80 {
+			t.Errorf("lines[%d] is %d characters long; want <80", i, n)
+		}
+	}
+
+	r.buildEvent(&zbstorerpc.BuildEvent{
+		BuildID: testBuildID,
+		Kind:    zbstorerpc.DerivationFailedEvent,
+		DrvPath: testFailedPath,
+		Result:  &zbstorerpc.BuildResult{DrvPath: testFailedPath, Status: zbstorerpc.BuildFail},
+	})
+	r.buildEvent(&zbstorerpc.BuildEvent{BuildID: testBuildID, Kind: zbstorerpc.DerivationFinishedEvent, DrvPath: testWorldPath})
+	r.finishDownload(d)
+	r.finishBuild(testBuildID, zbstorerpc.BuildFail)
+	if err := r.close(); err != nil {
+		t.Error("close:", err)
+	}
+	if err := r.close(); err != nil {
+		t.Error("second close:", err)
+	}
+
+	got := buf.String()
+	if want := "zb: build of " + string(testFailedPath) + " failed (fail); last 1 log lines:\n> oh no\n"; !strings.Contains(got, want) {
+		t.Errorf("output does not contain %q. Got:\n%q", want, got)
+	}
+	if want := "zb: 3 derivations done (1 failed), downloaded 1 file (1.0 KiB) in "; !strings.Contains(got, want) {
+		t.Errorf("output does not contain %q. Got:\n%q", want, got)
+	}
+}
+
+func TestSanitizeLogLine(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{line: "", want: ""},
+		{line: "hello", want: "hello"},
+		{line: "  indented\t", want: "indented"},
+		{line: "a\tb", want: "a b"},
+		{line: "\x1b[31mred\x1b[0m", want: "red"},
+		{line: "\x1b]0;title\x07text", want: "text"},
+		{line: "10%\r20%\r30%", want: "30%"},
+		{line: "bell\x07", want: "bell"},
+		{line: "caf\xc3\xa9", want: "café"},
+	}
+	for _, test := range tests {
+		if got := sanitizeLogLine([]byte(test.line)); got != test.want {
+			t.Errorf("sanitizeLogLine(%q) = %q; want %q", test.line, got, test.want)
+		}
+	}
+}
+
+func TestTruncateLine(t *testing.T) {
+	tests := []struct {
+		s     string
+		width int
+		want  string
+	}{
+		{s: "hello", width: 10, want: "hello"},
+		{s: "hello", width: 5, want: "hello"},
+		{s: "hello, world", width: 8, want: "hello..."},
+		{s: "héllo, wörld", width: 8, want: "héllo..."},
+	}
+	for _, test := range tests {
+		if got := truncateLine(test.s, test.width); got != test.want {
+			t.Errorf("truncateLine(%q, %d) = %q; want %q", test.s, test.width, got, test.want)
+		}
+	}
+}
+
+func testLogEvent(drvPath zbstore.Path, off int64, text string) *zbstorerpc.BuildEvent {
+	chunk := &zbstorerpc.LogChunk{Offset: off}
+	chunk.SetPayload([]byte(text))
+	return &zbstorerpc.BuildEvent{
+		BuildID: testBuildID,
+		Kind:    zbstorerpc.BuildLogEvent,
+		DrvPath: drvPath,
+		Log:     chunk,
+	}
+}
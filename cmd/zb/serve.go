@@ -11,6 +11,7 @@ import (
 	"net"
 	"net/http"
 	"net/netip"
+	"net/url"
 	"os"
 	"os/user"
 	"path/filepath"
@@ -37,6 +38,7 @@ import (
 	"zombiezen.com/go/bass/runhttp"
 	"zombiezen.com/go/log"
 	"zombiezen.com/go/log/zstdlog"
+	"zombiezen.com/go/nix"
 )
 
 const contentAddressTempFilePattern = "zb-ca-*"
@@ -50,8 +52,22 @@ type serveOptions struct {
 	sandboxPaths      map[string]backend.SandboxPath
 	allowKeepFailed   bool
 	coresPerBuild     int
+	maxJobs           int
+	builderLimits     backend.ResourceLimits
+	cgroupDir         string
+	builderTimeout    time.Duration
+	maxSilentTime     time.Duration
 	buildLogRetention time.Duration
 	systemdSocket     bool
+	substituters      []string
+	trustedPublicKeys []string
+	builders          []string
+	listenURLs        []string
+	tlsCertFile       string
+	tlsKeyFile        string
+	tlsClientCAFile   string
+	authTokensFile    string
+	roles             []string
 
 	webListenAddress   string
 	allowRemoteWeb     bool
@@ -89,7 +105,25 @@ func newServeCommand(g *globalConfig) *cobra.Command {
 	c.Flags().Var(implicitSystemDeps, "implicit-system-dep", "`path` to always mount in sandbox (can be passed multiple times)")
 	c.Flags().BoolVar(&opts.allowKeepFailed, "allow-keep-failed", true, "allow user to skip cleanup of failed builds")
 	c.Flags().IntVar(&opts.coresPerBuild, "cores-per-build", runtime.NumCPU(), "hint to builders for `number` of concurrent jobs to run")
+	c.Flags().IntVar(&opts.maxJobs, "max-jobs", 1, "maximum `number` of derivations to build concurrently")
+	c.Flags().Var((*byteSizeFlag)(&opts.builderLimits.Memory), "builder-memory-limit", "maximum `size` of memory each builder may use (e.g. 8G)")
+	c.Flags().Float64Var(&opts.builderLimits.CPU, "builder-cpu-limit", 0, "maximum `number` of CPUs each builder may use (e.g. 1.5)")
+	c.Flags().IntVar(&opts.builderLimits.PIDs, "builder-pid-limit", 0, "maximum `number` of processes each builder may run")
+	if runtime.GOOS == "linux" {
+		c.Flags().StringVar(&opts.cgroupDir, "cgroup", "", "cgroup v2 `dir`ectory to run builders in (\"auto\" to use the server's cgroup)")
+	}
+	c.Flags().DurationVar(&opts.builderTimeout, "builder-timeout", 0, "maximum `duration` a builder may run (0 for no limit)")
+	c.Flags().DurationVar(&opts.maxSilentTime, "max-silent-time", 0, "maximum `duration` a builder may run without producing output (0 for no limit)")
 	c.Flags().DurationVar(&opts.buildLogRetention, "build-log-retention", 7*24*time.Hour, "`duration` before deleting finished build logs")
+	c.Flags().StringArrayVar(&opts.substituters, "substituter", nil, "binary cache `URL` to download store objects from (can be passed multiple times)")
+	c.Flags().StringArrayVar(&opts.trustedPublicKeys, "trusted-public-key", nil, "public `key` to trust for substituted store objects (can be passed multiple times)")
+	c.Flags().StringArrayVar(&opts.builders, "builder", nil, "remote `builder` to send derivations to, given as \"URL [SYSTEMS [MAX-JOBS [SPEED-FACTOR]]]\" (can be passed multiple times)")
+	c.Flags().StringArrayVar(&opts.listenURLs, "listen", nil, "additional `URL` to accept TLS connections on, like tcp://0.0.0.0:7777 (can be passed multiple times)")
+	c.Flags().StringVar(&opts.tlsCertFile, "tls-cert", "", "`path` to PEM-encoded TLS certificate for --listen")
+	c.Flags().StringVar(&opts.tlsKeyFile, "tls-key", "", "`path` to PEM-encoded TLS private key for --listen")
+	c.Flags().StringVar(&opts.tlsClientCAFile, "tls-client-ca", "", "`path` to PEM-encoded certificate authorities for verifying client certificates")
+	c.Flags().StringVar(&opts.authTokensFile, "auth-tokens", "", "`path` to file of client names and bearer tokens accepted on --listen")
+	c.Flags().StringArrayVar(&opts.roles, "role", nil, "grant a role to clients, given as `NETWORK:NAME=ROLE` like unix:alice=trusted or tls:*=read-only (can be passed multiple times)")
 	c.Flags().StringVar(&opts.webListenAddress, "ui", "", "`address` to listen on for web UI (disabled by default)")
 	c.Flags().BoolVar(&opts.allowRemoteWeb, "allow-remote-ui", false, "whether to accept non-localhost connections for UI")
 	c.Flags().StringVar(&opts.templatesDirectory, "dev-templates", "", "`directory` to use for templates")
@@ -107,11 +141,85 @@ func runServe(ctx context.Context, g *globalConfig, opts *serveOptions) error {
 	if !g.storeDir.IsNative() {
 		return fmt.Errorf("%s cannot be used on this system", g.storeDir)
 	}
-	if opts.sandbox && !backend.CanSandbox() {
+	if opts.sandbox {
 		if !backend.SystemSupportsSandbox() {
 			return fmt.Errorf("sandboxing requested but not supported on %v", system.Current())
 		}
-		return fmt.Errorf("sandboxing requested but unable to use (are you running with admin privileges?)")
+		if err := backend.CheckSandbox(); err != nil {
+			return fmt.Errorf("sandboxing requested but unable to use: %v (pass --sandbox=0 to disable)", err)
+		}
+		if !osutil.IsRoot() {
+			log.Infof(ctx, "Not running as root; sandboxing builders with user namespaces")
+		}
+	}
+	if opts.cgroupDir == "" && !opts.builderLimits.IsZero() {
+		if runtime.GOOS != "linux" {
+			return fmt.Errorf("builder resource limits not supported on %v", system.Current())
+		}
+		opts.cgroupDir = "auto"
+	}
+	if opts.cgroupDir == "auto" {
+		var err error
+		opts.cgroupDir, err = backend.FindCgroupDirectory()
+		if err != nil {
+			return fmt.Errorf("--cgroup: %v", err)
+		}
+	}
+	if opts.cgroupDir != "" {
+		if err := backend.PrepareCgroupDirectory(opts.cgroupDir); err != nil {
+			return fmt.Errorf("--cgroup: %v (if running under systemd, set Delegate=yes)", err)
+		}
+		log.Debugf(ctx, "Running builders in cgroup %s", opts.cgroupDir)
+	}
+	substituters, err := parseSubstituterURLs(opts.substituters)
+	if err != nil {
+		return err
+	}
+	trustedPublicKeys := make([]*nix.PublicKey, 0, len(opts.trustedPublicKeys))
+	for _, s := range opts.trustedPublicKeys {
+		pub, err := nix.ParsePublicKey(s)
+		if err != nil {
+			return fmt.Errorf("--trusted-public-key: %v", err)
+		}
+		trustedPublicKeys = append(trustedPublicKeys, pub)
+	}
+	if len(substituters) > 0 && len(trustedPublicKeys) == 0 {
+		log.Warnf(ctx, "--substituter given without --trusted-public-key. Nothing will be substituted.")
+	}
+	remoteBuilders := make([]*backend.RemoteBuilder, 0, len(opts.builders))
+	for _, arg := range opts.builders {
+		spec, err := parseBuilderSpec(arg)
+		if err != nil {
+			return err
+		}
+		remoteBuilders = append(remoteBuilders, spec.remoteBuilder())
+	}
+	storeAddress, err := parseStoreAddress(g.storeSocket)
+	if err != nil {
+		return err
+	}
+	if storeAddress.Scheme != "unix" {
+		return fmt.Errorf("cannot serve on %s (use --listen to accept network connections)", storeAddress.Redacted())
+	}
+	socketPath := urlFilePath(storeAddress)
+	tcpAddresses := make([]string, 0, len(opts.listenURLs))
+	for _, arg := range opts.listenURLs {
+		addr, err := parseListenURL(arg)
+		if err != nil {
+			return err
+		}
+		tcpAddresses = append(tcpAddresses, addr)
+	}
+	var auth *clientAuthenticator
+	if len(tcpAddresses) > 0 {
+		auth, err = newClientAuthenticator(opts.tlsCertFile, opts.tlsKeyFile, opts.tlsClientCAFile, opts.authTokensFile)
+		if err != nil {
+			return err
+		}
+	}
+	roles, err := parseRoleFlags(opts.roles)
+	if err != nil {
+		return err
 	}
 	storeDirGroupID, buildUsers, err := buildUsersForGroup(ctx, opts.buildUsersGroup)
 	if err != nil {
@@ -120,7 +228,7 @@ func runServe(ctx context.Context, g *globalConfig, opts *serveOptions) error {
 	if err := ensureStoreDirectory(string(g.storeDir), storeDirGroupID); err != nil {
 		return err
 	}
-	if err := os.MkdirAll(filepath.Dir(g.storeSocket), 0o755); err != nil {
+	if err := os.MkdirAll(filepath.Dir(socketPath), 0o755); err != nil {
 		return err
 	}
 	if err := os.MkdirAll(filepath.Dir(opts.dbPath), 0o755); err != nil {
@@ -159,16 +267,30 @@ func runServe(ctx context.Context, g *globalConfig, opts *serveOptions) error {
 		l = listeners[0]
 	} else {
 		var err error
-		l, err = listenUnix(g.storeSocket)
+		l, err = listenUnix(socketPath)
 		if err != nil {
 			return err
 		}
 		defer func() {
-			if err := os.Remove(g.storeSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+			if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
 				log.Warnf(ctx, "Failed to clean up socket: %v", err)
 			}
 		}()
 	}
+	listeners := []storeListener{{Listener: l}}
+	for _, addr := range tcpAddresses {
+		tcpListener, err := net.Listen("tcp", addr)
+		if err != nil {
+			for _, sl := range listeners {
+				sl.Close()
+			}
+			return err
+		}
+		listeners = append(listeners, storeListener{
+			Listener: tcpListener,
+			auth:     auth,
+		})
+	}
 
 	grp, grpCtx := errgroup.WithContext(ctx)
 
@@ -179,20 +301,25 @@ func runServe(ctx context.Context, g *globalConfig, opts *serveOptions) error {
 		<-grpCtx.Done()
 		log.Infof(grpCtx, "Shutting down (signal received)...")
 
-		if err := l.Close(); err != nil {
-			log.Errorf(grpCtx, "Closing Unix socket: %v", err)
+		for _, sl := range listeners {
+			if err := sl.Close(); err != nil {
+				log.Errorf(grpCtx, "Closing %v: %v", sl.Addr(), err)
+			}
 		}
 		openConnsMu.Lock()
 		for conn := range openConns.All() {
 			if err := closeRead(conn); err != nil {
-				log.Errorf(grpCtx, "Closing Unix socket: %v", err)
+				log.Errorf(grpCtx, "Closing connection: %v", err)
 			}
 		}
 		openConnsMu.Unlock()
 		return nil
 	})
 
-	log.Infof(ctx, "Listening on %s", g.storeSocket)
+	log.Infof(ctx, "Listening on %s", socketPath)
+	for _, sl := range listeners[1:] {
+		log.Infof(ctx, "Listening for TLS on %v", sl.Addr())
+	}
 	backendServer := backend.NewServer(g.storeDir, opts.dbPath, &backend.Options{
 		BuildDirectory:              opts.buildDir,
 		LogDirectory:                opts.logDir,
@@ -202,7 +329,16 @@ func runServe(ctx context.Context, g *globalConfig, opts *serveOptions) error {
 		BuildUsers:                  buildUsers,
 		AllowKeepFailed:             opts.allowKeepFailed,
 		CoresPerBuild:               opts.coresPerBuild,
+		MaxJobs:                     opts.maxJobs,
+		BuilderLimits:               opts.builderLimits,
+		CgroupDirectory:             opts.cgroupDir,
+		BuilderTimeout:              opts.builderTimeout,
+		BuilderMaxSilentTime:        opts.maxSilentTime,
 		BuildLogRetention:           opts.buildLogRetention,
+		Substituters:                substituters,
+		TrustedPublicKeys:           trustedPublicKeys,
+		RemoteBuilders:              remoteBuilders,
+		PeerRole:                    roles.peerRole,
 	})
 	defer func() {
 		if err := backendServer.Close(); err != nil {
@@ -211,42 +347,61 @@ func runServe(ctx context.Context, g *globalConfig, opts *serveOptions) error {
 	}()
 	webHandler.backend = backendServer
 
-	grp.Go(func() error {
-		for {
-			conn, err := l.Accept()
-			if errors.Is(err, net.ErrClosed) {
-				return nil
-			}
-			if err != nil {
-				return err
-			}
-			openConnsMu.Lock()
-			openConns.Add(conn)
-			openConnsMu.Unlock()
-
-			grp.Go(func() error {
-				recv := backendServer.NewNARReceiver(grpCtx, bytebuffer.TempFileCreator{
-					Pattern: "zb-serve-receive-*.nar",
-				})
-				defer recv.Cleanup(grpCtx)
-
-				codec := zbstorerpc.NewCodec(nopCloser{conn}, &zbstorerpc.CodecOptions{
-					NARReceiver: recv,
-				})
-				jsonrpc.Serve(backend.WithExporter(grpCtx, codec), codec, backendServer)
-				codec.Close()
-
+	for _, sl := range listeners {
+		grp.Go(func() error {
+			for {
+				conn, err := sl.Accept()
+				if errors.Is(err, net.ErrClosed) {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
 				openConnsMu.Lock()
-				openConns.Delete(conn)
+				openConns.Add(conn)
 				openConnsMu.Unlock()
 
-				if err := conn.Close(); err != nil {
-					log.Errorf(grpCtx, "%v", err)
-				}
-				return nil
-			})
-		}
-	})
+				grp.Go(func() error {
+					var rwc io.ReadWriteCloser = conn
+					defer func() {
+						openConnsMu.Lock()
+						openConns.Delete(conn)
+						openConnsMu.Unlock()
+
+						if err := rwc.Close(); err != nil {
+							log.Errorf(grpCtx, "%v", err)
+						}
+					}()
+					var peer *backend.Peer
+					if sl.auth != nil {
+						authConn, clientName, err := sl.auth.authenticate(grpCtx, conn)
+						if err != nil {
+							log.Warnf(grpCtx, "Rejected connection from %v: %v", conn.RemoteAddr(), err)
+							return nil
+						}
+						log.Infof(grpCtx, "Accepted connection from %s (%v)", clientName, conn.RemoteAddr())
+						rwc = authConn
+						peer = &backend.Peer{Network: "tls", Name: clientName, UID: -1}
+					} else {
+						peer = unixPeer(grpCtx, conn)
+					}
+					connCtx := backend.WithPeer(grpCtx, peer)
+
+					recv := backendServer.NewNARReceiver(connCtx, bytebuffer.TempFileCreator{
+						Pattern: "zb-serve-receive-*.nar",
+					})
+					defer recv.Cleanup(grpCtx)
+
+					codec := zbstorerpc.NewCodec(nopCloser{rwc}, &zbstorerpc.CodecOptions{
+						NARReceiver: recv,
+					})
+					jsonrpc.Serve(backend.WithExporter(connCtx, codec), codec, backendServer)
+					codec.Close()
+					return nil
+				})
+			}
+		})
+	}
 
 	if opts.webListenAddress != "" {
 		grp.Go(func() error {
@@ -369,6 +524,37 @@ func combineSandboxPathsAndImplicitDeps(sandboxPaths map[string]string, implicit
 	return result
 }
 
+// parseSubstituterURLs parses the arguments to the --substituter flag.
+// Absolute filesystem paths are treated as "file" URLs.
+func parseSubstituterURLs(args []string) ([]*url.URL, error) {
+	urls := make([]*url.URL, 0, len(args))
+	for _, arg := range args {
+		if filepath.IsAbs(arg) {
+			path := filepath.ToSlash(arg)
+			if !strings.HasPrefix(path, "/") {
+				// Windows drive letter paths (e.g. "C:/foo").
+				path = "/" + path
+			}
+			urls = append(urls, &url.URL{
+				Scheme: "file",
+				Path:   path,
+			})
+			continue
+		}
+		u, err := url.Parse(arg)
+		if err != nil {
+			return nil, fmt.Errorf("--substituter: %v", err)
+		}
+		switch u.Scheme {
+		case "file", "http", "https":
+		default:
+			return nil, fmt.Errorf("--substituter: unsupported URL %s", u.Redacted())
+		}
+		urls = append(urls, u)
+	}
+	return urls, nil
+}
+
 func listenUnix(path string) (*net.UnixListener, error) {
 	laddr := &net.UnixAddr{
 		Net:  "unix",
@@ -388,6 +574,29 @@ func listenUnix(path string) (*net.UnixListener, error) {
 	return l, nil
 }
 
+// storeListener is a listener for store clients.
+type storeListener struct {
+	net.Listener
+	// If auth is not nil, then clients must connect using TLS
+	// and authenticate before sending requests.
+	auth *clientAuthenticator
+}
+
+// unixPeer identifies the client on a Unix socket connection.
+// If the client cannot be identified,
+// unixPeer returns a peer with an unknown name and user ID.
+func unixPeer(ctx context.Context, conn net.Conn) *backend.Peer {
+	if uc, ok := conn.(*net.UnixConn); ok {
+		peer, err := backend.UnixPeer(uc)
+		if err == nil {
+			log.Debugf(ctx, "Accepted connection from %v", peer)
+			return peer
+		}
+		log.Debugf(ctx, "%v", err)
+	}
+	return &backend.Peer{Network: "unix", UID: -1}
+}
+
 func closeRead(c net.Conn) error {
 	cr, ok := c.(interface{ CloseRead() error })
 	if !ok {
@@ -0,0 +1,197 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/system"
+)
+
+// builderSpec is a parsed argument to the --builder flag of `zb serve`.
+type builderSpec struct {
+	url         *url.URL
+	systems     []string
+	maxJobs     int
+	speedFactor float64
+}
+
+// parseBuilderSpec parses a remote builder description of the form
+// "URL [SYSTEMS [MAX-JOBS [SPEED-FACTOR]]]",
+// where SYSTEMS is a comma-separated list of systems.
+// SYSTEMS defaults to the host's system.
+func parseBuilderSpec(s string) (*builderSpec, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 {
+		return nil, fmt.Errorf("--builder: empty")
+	}
+	if len(fields) > 4 {
+		return nil, fmt.Errorf("--builder %q: too many fields", s)
+	}
+	spec := &builderSpec{
+		systems:     []string{system.Current().String()},
+		maxJobs:     1,
+		speedFactor: 1,
+	}
+	var err error
+	spec.url, err = url.Parse(fields[0])
+	if err != nil {
+		return nil, fmt.Errorf("--builder: %v", err)
+	}
+	switch spec.url.Scheme {
+	case "unix":
+		if spec.url.Host != "" && spec.url.Host != "localhost" {
+			return nil, fmt.Errorf("--builder: unix URLs cannot have a host")
+		}
+		if spec.url.Path == "" {
+			return nil, fmt.Errorf("--builder: unix URL missing path")
+		}
+	case "ssh":
+		if spec.url.Hostname() == "" || strings.HasPrefix(spec.url.Hostname(), "-") {
+			return nil, fmt.Errorf("--builder: invalid ssh host %q", spec.url.Hostname())
+		}
+		if spec.url.Path != "" && spec.url.Path != "/" {
+			return nil, fmt.Errorf("--builder: ssh URLs cannot have a path")
+		}
+	default:
+		return nil, fmt.Errorf("--builder: unsupported URL %s", spec.url.Redacted())
+	}
+	if len(fields) > 1 {
+		spec.systems = strings.Split(fields[1], ",")
+		for _, sys := range spec.systems {
+			if _, err := system.Parse(sys); err != nil {
+				return nil, fmt.Errorf("--builder %s: %v", spec.url.Redacted(), err)
+			}
+		}
+	}
+	if len(fields) > 2 {
+		spec.maxJobs, err = strconv.Atoi(fields[2])
+		if err != nil || spec.maxJobs < 1 {
+			return nil, fmt.Errorf("--builder %s: invalid max jobs %q", spec.url.Redacted(), fields[2])
+		}
+	}
+	if len(fields) > 3 {
+		spec.speedFactor, err = strconv.ParseFloat(fields[3], 64)
+		if err != nil || !(spec.speedFactor > 0) {
+			return nil, fmt.Errorf("--builder %s: invalid speed factor %q", spec.url.Redacted(), fields[3])
+		}
+	}
+	return spec, nil
+}
+
+// remoteBuilder returns a [*backend.RemoteBuilder] that connects to the builder.
+func (spec *builderSpec) remoteBuilder() *backend.RemoteBuilder {
+	rb := &backend.RemoteBuilder{
+		Name:        spec.url.Redacted(),
+		Systems:     spec.systems,
+		MaxJobs:     spec.maxJobs,
+		SpeedFactor: spec.speedFactor,
+	}
+	switch spec.url.Scheme {
+	case "unix":
+		socketPath := urlFilePath(spec.url)
+		rb.Dial = func(ctx context.Context) (io.ReadWriteCloser, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", socketPath)
+		}
+	case "ssh":
+		args := spec.sshArgs()
+		rb.Dial = func(ctx context.Context) (io.ReadWriteCloser, error) {
+			return startCommandConn(ctx, "ssh", args...)
+		}
+	}
+	return rb
+}
+
+// sshArgs returns the arguments to ssh that run `zb store connect` on the builder.
+// The "remote-program" query parameter overrides the path to zb on the builder
+// and the "remote-store" query parameter sets the builder's store socket.
+func (spec *builderSpec) sshArgs() []string {
+	args := []string{"-o", "BatchMode=yes"}
+	if port := spec.url.Port(); port != "" {
+		args = append(args, "-p", port)
+	}
+	dest := spec.url.Hostname()
+	if user := spec.url.User.Username(); user != "" {
+		dest = user + "@" + dest
+	}
+	query := spec.url.Query()
+	remoteProgram := query.Get("remote-program")
+	if remoteProgram == "" {
+		remoteProgram = "zb"
+	}
+	args = append(args, dest, remoteProgram, "store", "connect")
+	if remoteStore := query.Get("remote-store"); remoteStore != "" {
+		args = append(args, "--store-socket", remoteStore)
+	}
+	return args
+}
+
+// commandConn is a connection to the standard input and output of a subprocess.
+type commandConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+}
+
+func startCommandConn(ctx context.Context, name string, args ...string) (*commandConn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	c := &commandConn{cmd: exec.Command(name, args...)}
+	c.cmd.Stderr = os.Stderr
+	var err error
+	c.stdin, err = c.cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	c.stdout, err = c.cmd.StdoutPipe()
+	if err != nil {
+		c.stdin.Close()
+		return nil, err
+	}
+	if err := c.cmd.Start(); err != nil {
+		c.stdin.Close()
+		c.stdout.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *commandConn) Read(p []byte) (int, error) {
+	return c.stdout.Read(p)
+}
+
+func (c *commandConn) Write(p []byte) (int, error) {
+	return c.stdin.Write(p)
+}
+
+// Close closes the subprocess's standard input
+// and waits briefly for it to exit before stopping it.
+func (c *commandConn) Close() error {
+	c.stdin.Close()
+	done := make(chan struct{})
+	go func() {
+		c.cmd.Wait()
+		close(done)
+	}()
+	t := time.NewTimer(5 * time.Second)
+	defer t.Stop()
+	select {
+	case <-done:
+	case <-t.C:
+		c.cmd.Process.Kill()
+		<-done
+	}
+	return nil
+}
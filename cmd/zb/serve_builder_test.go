@@ -0,0 +1,95 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/system"
+)
+
+func TestParseBuilderSpec(t *testing.T) {
+	currentSystem := system.Current().String()
+	tests := []struct {
+		s           string
+		url         string
+		systems     []string
+		maxJobs     int
+		speedFactor float64
+		sshArgs     []string
+		err         bool
+	}{
+		{
+			s:           "unix:///var/run/zb.sock",
+			url:         "unix:///var/run/zb.sock",
+			systems:     []string{currentSystem},
+			maxJobs:     1,
+			speedFactor: 1,
+		},
+		{
+			s:           "ssh://builder.example.com",
+			url:         "ssh://builder.example.com",
+			systems:     []string{currentSystem},
+			maxJobs:     1,
+			speedFactor: 1,
+			sshArgs:     []string{"-o", "BatchMode=yes", "builder.example.com", "zb", "store", "connect"},
+		},
+		{
+			s:           "ssh://alice@builder.example.com:2222?remote-program=/opt/zb/bin/zb&remote-store=/tmp/zb.sock  x86_64-unknown-linux,aarch64-unknown-linux 4 2.5",
+			url:         "ssh://alice@builder.example.com:2222?remote-program=/opt/zb/bin/zb&remote-store=/tmp/zb.sock",
+			systems:     []string{"x86_64-unknown-linux", "aarch64-unknown-linux"},
+			maxJobs:     4,
+			speedFactor: 2.5,
+			sshArgs: []string{
+				"-o", "BatchMode=yes",
+				"-p", "2222",
+				"alice@builder.example.com",
+				"/opt/zb/bin/zb", "store", "connect",
+				"--store-socket", "/tmp/zb.sock",
+			},
+		},
+		{s: "", err: true},
+		{s: "http://builder.example.com", err: true},
+		{s: "unix://host/var/run/zb.sock", err: true},
+		{s: "unix:", err: true},
+		{s: "ssh:///foo", err: true},
+		{s: "ssh://-oProxyCommand=foo", err: true},
+		{s: "ssh://builder.example.com/foo", err: true},
+		{s: "ssh://builder.example.com linux", err: true},
+		{s: "ssh://builder.example.com x86_64-unknown-linux 0", err: true},
+		{s: "ssh://builder.example.com x86_64-unknown-linux 1 0", err: true},
+		{s: "ssh://builder.example.com x86_64-unknown-linux 1 1 extra", err: true},
+	}
+	for _, test := range tests {
+		spec, err := parseBuilderSpec(test.s)
+		if err != nil {
+			if !test.err {
+				t.Errorf("parseBuilderSpec(%q): %v", test.s, err)
+			}
+			continue
+		}
+		if test.err {
+			t.Errorf("parseBuilderSpec(%q) did not return an error", test.s)
+			continue
+		}
+		if got := spec.url.String(); got != test.url {
+			t.Errorf("parseBuilderSpec(%q).url = %q; want %q", test.s, got, test.url)
+		}
+		if diff := cmp.Diff(test.systems, spec.systems); diff != "" {
+			t.Errorf("parseBuilderSpec(%q).systems (-want +got):\n%s", test.s, diff)
+		}
+		if spec.maxJobs != test.maxJobs {
+			t.Errorf("parseBuilderSpec(%q).maxJobs = %d; want %d", test.s, spec.maxJobs, test.maxJobs)
+		}
+		if spec.speedFactor != test.speedFactor {
+			t.Errorf("parseBuilderSpec(%q).speedFactor = %g; want %g", test.s, spec.speedFactor, test.speedFactor)
+		}
+		if spec.url.Scheme == "ssh" {
+			if diff := cmp.Diff(test.sshArgs, spec.sshArgs()); diff != "" {
+				t.Errorf("parseBuilderSpec(%q).sshArgs() (-want +got):\n%s", test.s, diff)
+			}
+		}
+	}
+}
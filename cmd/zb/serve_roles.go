@@ -0,0 +1,69 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/backend"
+)
+
+// rolePolicy maps store clients to roles
+// based on the --role flags passed to `zb serve`.
+type rolePolicy struct {
+	// roles maps "NETWORK:NAME" or "NETWORK:*" to a role.
+	roles map[string]backend.Role
+	// serverUID is the user ID of the store server process
+	// or -1 if not applicable.
+	serverUID int
+}
+
+// parseRoleFlags parses the arguments to the --role flag.
+// Each argument is of the form "NETWORK:NAME=ROLE",
+// where NETWORK is "unix" or "tls" and NAME may be "*" to match any client.
+func parseRoleFlags(args []string) (*rolePolicy, error) {
+	p := &rolePolicy{
+		roles: map[string]backend.Role{
+			"unix:*": backend.RoleTrusted,
+			"tls:*":  backend.RoleBuild,
+		},
+		serverUID: os.Geteuid(),
+	}
+	for _, arg := range args {
+		client, roleName, ok := strings.Cut(arg, "=")
+		if !ok {
+			return nil, fmt.Errorf("--role %q: must be of the form NETWORK:NAME=ROLE", arg)
+		}
+		network, name, ok := strings.Cut(client, ":")
+		if !ok || (network != "unix" && network != "tls") || name == "" {
+			return nil, fmt.Errorf("--role %q: client must be unix:NAME or tls:NAME", arg)
+		}
+		role, err := backend.ParseRole(roleName)
+		if err != nil {
+			return nil, fmt.Errorf("--role %q: %v", arg, err)
+		}
+		p.roles[client] = role
+	}
+	return p, nil
+}
+
+// peerRole returns the role for the given client.
+// The root user and the user running the store server are always trusted.
+func (p *rolePolicy) peerRole(peer *backend.Peer) backend.Role {
+	if peer == nil {
+		// Requests made inside the server process (i.e. from the web UI).
+		return backend.RoleReadOnly
+	}
+	if peer.Network == "unix" && peer.UID >= 0 && (peer.UID == 0 || peer.UID == p.serverUID) {
+		return backend.RoleTrusted
+	}
+	if peer.Name != "" {
+		if role, ok := p.roles[peer.Network+":"+peer.Name]; ok {
+			return role
+		}
+	}
+	return p.roles[peer.Network+":*"]
+}
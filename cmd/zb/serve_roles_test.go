@@ -0,0 +1,73 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/backend"
+)
+
+func TestRolePolicy(t *testing.T) {
+	policy, err := parseRoleFlags([]string{
+		"unix:*=build",
+		"unix:alice=trusted",
+		"unix:mallory=none",
+		"tls:ci=trusted",
+		"tls:*=read-only",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.serverUID = 500
+
+	tests := []struct {
+		peer *backend.Peer
+		want backend.Role
+	}{
+		{peer: nil, want: backend.RoleReadOnly},
+		{peer: &backend.Peer{Network: "unix", Name: "root", UID: 0}, want: backend.RoleTrusted},
+		{peer: &backend.Peer{Network: "unix", Name: "zb", UID: 500}, want: backend.RoleTrusted},
+		{peer: &backend.Peer{Network: "unix", Name: "alice", UID: 1000}, want: backend.RoleTrusted},
+		{peer: &backend.Peer{Network: "unix", Name: "bob", UID: 1001}, want: backend.RoleBuild},
+		{peer: &backend.Peer{Network: "unix", Name: "mallory", UID: 1002}, want: backend.RoleNone},
+		{peer: &backend.Peer{Network: "unix", UID: -1}, want: backend.RoleBuild},
+		{peer: &backend.Peer{Network: "tls", Name: "ci", UID: -1}, want: backend.RoleTrusted},
+		{peer: &backend.Peer{Network: "tls", Name: "alice", UID: -1}, want: backend.RoleReadOnly},
+	}
+	for _, test := range tests {
+		if got := policy.peerRole(test.peer); got != test.want {
+			t.Errorf("peerRole(%v) = %v; want %v", test.peer, got, test.want)
+		}
+	}
+}
+
+func TestRolePolicyDefaults(t *testing.T) {
+	policy, err := parseRoleFlags(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy.serverUID = 500
+	if got, want := policy.peerRole(&backend.Peer{Network: "unix", Name: "bob", UID: 1001}), backend.RoleTrusted; got != want {
+		t.Errorf("Unix peer role = %v; want %v", got, want)
+	}
+	if got, want := policy.peerRole(&backend.Peer{Network: "tls", Name: "ci", UID: -1}), backend.RoleBuild; got != want {
+		t.Errorf("TLS peer role = %v; want %v", got, want)
+	}
+}
+
+func TestParseRoleFlagsErrors(t *testing.T) {
+	bad := []string{
+		"alice=trusted",
+		"unix:alice",
+		"unix:=trusted",
+		"http:alice=trusted",
+		"unix:alice=admin",
+	}
+	for _, arg := range bad {
+		if _, err := parseRoleFlags([]string{arg}); err == nil {
+			t.Errorf("parseRoleFlags([%q]) did not return an error", arg)
+		}
+	}
+}
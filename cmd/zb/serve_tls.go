@@ -0,0 +1,200 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+// clientAuthTimeout is the maximum amount of time
+// a client has to complete the TLS handshake and authenticate.
+const clientAuthTimeout = 30 * time.Second
+
+// parseListenURL parses an argument to the --listen flag of `zb serve`
+// and returns the TCP address to listen on.
+func parseListenURL(s string) (string, error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", fmt.Errorf("--listen: %v", err)
+	}
+	if u.Scheme != "tcp" {
+		return "", fmt.Errorf("--listen: unsupported URL %s (must be tcp://HOST:PORT)", u.Redacted())
+	}
+	if u.Port() == "" {
+		return "", fmt.Errorf("--listen %s: missing port", s)
+	}
+	if (u.Path != "" && u.Path != "/") || u.RawQuery != "" || u.User != nil {
+		return "", fmt.Errorf("--listen %s: must be of the form tcp://HOST:PORT", s)
+	}
+	return u.Host, nil
+}
+
+// clientAuthenticator authenticates store clients connecting over TLS.
+type clientAuthenticator struct {
+	config *tls.Config
+	// tokens is the list of accepted bearer tokens.
+	// If tokens is empty, then clients must present a client certificate.
+	tokens []authToken
+}
+
+// authToken is a bearer token that identifies a client.
+type authToken struct {
+	name  string
+	token []byte
+}
+
+// newClientAuthenticator returns a new [*clientAuthenticator]
+// from the TLS-related flags to `zb serve`.
+// At least one of clientCAFile or tokensFile must be given.
+func newClientAuthenticator(certFile, keyFile, clientCAFile, tokensFile string) (*clientAuthenticator, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, fmt.Errorf("--listen requires --tls-cert and --tls-key")
+	}
+	if clientCAFile == "" && tokensFile == "" {
+		return nil, fmt.Errorf("--listen requires --tls-client-ca or --auth-tokens")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("--tls-cert: %v", err)
+	}
+	a := &clientAuthenticator{
+		config: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		},
+	}
+	if tokensFile != "" {
+		data, err := os.ReadFile(tokensFile)
+		if err != nil {
+			return nil, fmt.Errorf("--auth-tokens: %v", err)
+		}
+		a.tokens, err = parseAuthTokens(data)
+		if err != nil {
+			return nil, fmt.Errorf("--auth-tokens: %s: %v", tokensFile, err)
+		}
+	}
+	if clientCAFile != "" {
+		a.config.ClientCAs, err = readCertPool(clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("--tls-client-ca: %v", err)
+		}
+		if len(a.tokens) > 0 {
+			a.config.ClientAuth = tls.VerifyClientCertIfGiven
+		} else {
+			a.config.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return a, nil
+}
+
+// parseAuthTokens parses the contents of an --auth-tokens file.
+// Each non-blank line that does not start with '#'
+// has a client name followed by whitespace followed by the client's token.
+func parseAuthTokens(data []byte) ([]authToken, error) {
+	var tokens []authToken
+	for lineno, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("line %d: expected NAME TOKEN", lineno+1)
+		}
+		for _, tok := range tokens {
+			if tok.name == fields[0] {
+				return nil, fmt.Errorf("line %d: duplicate name %q", lineno+1, fields[0])
+			}
+			if string(tok.token) == fields[1] {
+				return nil, fmt.Errorf("line %d: token for %q is the same as %q", lineno+1, fields[0], tok.name)
+			}
+		}
+		tokens = append(tokens, authToken{
+			name:  fields[0],
+			token: []byte(fields[1]),
+		})
+	}
+	if len(tokens) == 0 {
+		return nil, fmt.Errorf("no tokens")
+	}
+	return tokens, nil
+}
+
+// authenticate performs the TLS handshake on conn and identifies the client.
+// Clients that present a certificate are identified by the certificate's common name.
+// Otherwise, the client must send a bearer token as its first message.
+// On success, authenticate returns the connection to use for the rest of the session
+// and the name of the client.
+func (a *clientAuthenticator) authenticate(ctx context.Context, conn net.Conn) (io.ReadWriteCloser, string, error) {
+	ctx, cancel := context.WithTimeout(ctx, clientAuthTimeout)
+	defer cancel()
+	tlsConn := tls.Server(conn, a.config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		return nil, "", err
+	}
+	if chains := tlsConn.ConnectionState().VerifiedChains; len(chains) > 0 {
+		name := chains[0][0].Subject.CommonName
+		if name == "" {
+			return nil, "", errors.New("client certificate missing common name")
+		}
+		return tlsConn, name, nil
+	}
+	if len(a.tokens) == 0 {
+		return nil, "", errors.New("client certificate required")
+	}
+
+	deadline, _ := ctx.Deadline()
+	if err := tlsConn.SetReadDeadline(deadline); err != nil {
+		return nil, "", err
+	}
+	r := bufio.NewReader(tlsConn)
+	token, err := zbstorerpc.ReadAuth(r)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := tlsConn.SetReadDeadline(time.Time{}); err != nil {
+		return nil, "", err
+	}
+	name := a.lookupToken([]byte(token))
+	if name == "" {
+		return nil, "", errors.New("invalid token")
+	}
+	return bufferedConn{r, tlsConn}, name, nil
+}
+
+// lookupToken returns the name of the client with the given token
+// or the empty string if no client has the token.
+func (a *clientAuthenticator) lookupToken(token []byte) string {
+	name := ""
+	// Compare against every token to avoid leaking which ones are close.
+	for _, tok := range a.tokens {
+		if subtle.ConstantTimeCompare(tok.token, token) == 1 {
+			name = tok.name
+		}
+	}
+	return name
+}
+
+// bufferedConn is a connection whose reads are served from a buffer.
+type bufferedConn struct {
+	r *bufio.Reader
+	io.WriteCloser
+}
+
+func (c bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,294 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestParseListenURL(t *testing.T) {
+	tests := []struct {
+		s    string
+		want string
+		err  bool
+	}{
+		{s: "tcp://0.0.0.0:7777", want: "0.0.0.0:7777"},
+		{s: "tcp://[::1]:7777", want: "[::1]:7777"},
+		{s: "tcp://:7777", want: ":7777"},
+		{s: "tcp://localhost", err: true},
+		{s: "tls://0.0.0.0:7777", err: true},
+		{s: "unix:///var/run/zb.sock", err: true},
+		{s: "tcp://0.0.0.0:7777/foo", err: true},
+	}
+	for _, test := range tests {
+		got, err := parseListenURL(test.s)
+		if got != test.want || (err != nil) != test.err {
+			errString := "<nil>"
+			if test.err {
+				errString = "<error>"
+			}
+			t.Errorf("parseListenURL(%q) = %q, %v; want %q, %s", test.s, got, err, test.want, errString)
+		}
+	}
+}
+
+func TestParseAuthTokens(t *testing.T) {
+	got, err := parseAuthTokens([]byte("# CI runners\nrunner1 abc\n\n  runner2\tdef  \n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []authToken{
+		{name: "runner1", token: []byte("abc")},
+		{name: "runner2", token: []byte("def")},
+	}
+	if diff := cmp.Diff(want, got, cmp.AllowUnexported(authToken{})); diff != "" {
+		t.Errorf("tokens (-want +got):\n%s", diff)
+	}
+
+	bad := []string{
+		"",
+		"# Just a comment\n",
+		"runner1\n",
+		"runner1 abc extra\n",
+		"runner1 abc\nrunner1 def\n",
+		"runner1 abc\nrunner2 abc\n",
+	}
+	for _, data := range bad {
+		if got, err := parseAuthTokens([]byte(data)); err == nil {
+			t.Errorf("parseAuthTokens(%q) = %v, <nil>; want error", data, got)
+		}
+	}
+}
+
+func TestClientAuthenticator(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCertificate(t, nil, "Test CA")
+	caFile := filepath.Join(dir, "ca.pem")
+	ca.writeCertificate(t, caFile)
+	server := newTestCertificate(t, ca, "localhost")
+	serverCertFile := filepath.Join(dir, "server.pem")
+	serverKeyFile := filepath.Join(dir, "server-key.pem")
+	server.writeCertificate(t, serverCertFile)
+	server.writeKey(t, serverKeyFile)
+	client := newTestCertificate(t, ca, "runner1")
+	clientCertFile := filepath.Join(dir, "client.pem")
+	clientKeyFile := filepath.Join(dir, "client-key.pem")
+	client.writeCertificate(t, clientCertFile)
+	client.writeKey(t, clientKeyFile)
+	tokensFile := filepath.Join(dir, "tokens")
+	if err := os.WriteFile(tokensFile, []byte("runner2 xyzzy\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		clientCAFile string
+		tokensFile   string
+		clientCert   bool
+		token        string
+		want         string
+	}{
+		{
+			name:       "Token",
+			tokensFile: tokensFile,
+			token:      "xyzzy",
+			want:       "runner2",
+		},
+		{
+			name:       "WrongToken",
+			tokensFile: tokensFile,
+			token:      "plugh",
+		},
+		{
+			name:       "NoToken",
+			tokensFile: tokensFile,
+		},
+		{
+			name:         "ClientCertificate",
+			clientCAFile: caFile,
+			clientCert:   true,
+			want:         "runner1",
+		},
+		{
+			name:         "ClientCertificateRequired",
+			clientCAFile: caFile,
+			token:        "xyzzy",
+		},
+		{
+			name:         "ClientCertificateWithTokens",
+			clientCAFile: caFile,
+			tokensFile:   tokensFile,
+			clientCert:   true,
+			token:        "xyzzy",
+			want:         "runner1",
+		},
+		{
+			name:         "TokenWithClientCA",
+			clientCAFile: caFile,
+			tokensFile:   tokensFile,
+			token:        "xyzzy",
+			want:         "runner2",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := testcontext.New(t)
+			defer cancel()
+			auth, err := newClientAuthenticator(serverCertFile, serverKeyFile, test.clientCAFile, test.tokensFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			l, err := net.Listen("tcp", "localhost:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			type result struct {
+				name string
+				err  error
+			}
+			resultChan := make(chan result, 1)
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					resultChan <- result{err: err}
+					return
+				}
+				rwc, name, err := auth.authenticate(ctx, conn)
+				if err != nil {
+					conn.Close()
+				} else {
+					rwc.Close()
+				}
+				resultChan <- result{name, err}
+			}()
+
+			_, port, err := net.SplitHostPort(l.Addr().String())
+			if err != nil {
+				t.Fatal(err)
+			}
+			query := url.Values{"ca": {caFile}}
+			if test.clientCert {
+				query.Set("cert", clientCertFile)
+				query.Set("key", clientKeyFile)
+			}
+			addr := (&url.URL{
+				Scheme:   "tls",
+				Host:     net.JoinHostPort("localhost", port),
+				RawQuery: query.Encode(),
+			}).String()
+			// The client's credentials have been sent once dialStore returns,
+			// so close the connection so that authenticate does not wait for more.
+			if conn, err := dialStore(ctx, addr, test.token); err != nil {
+				t.Log("dialStore:", err)
+			} else {
+				conn.Close()
+			}
+
+			var got result
+			select {
+			case got = <-resultChan:
+			case <-ctx.Done():
+				t.Fatal("authenticate did not return")
+			}
+			if test.want == "" {
+				if got.err == nil {
+					t.Errorf("authenticate(...) = _, %q, <nil>; want error", got.name)
+				}
+				return
+			}
+			if got.name != test.want || got.err != nil {
+				t.Errorf("authenticate(...) = _, %q, %v; want %q, <nil>", got.name, got.err, test.want)
+			}
+		})
+	}
+}
+
+func TestNewClientAuthenticatorRequiresClientAuth(t *testing.T) {
+	if _, err := newClientAuthenticator("server.pem", "server-key.pem", "", ""); err == nil {
+		t.Error("newClientAuthenticator did not return an error without --tls-client-ca or --auth-tokens")
+	}
+}
+
+type testCertificate struct {
+	cert *x509.Certificate
+	der  []byte
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCertificate returns a new certificate signed by parent.
+// If parent is nil, then newTestCertificate returns a self-signed certificate authority.
+func newTestCertificate(tb testing.TB, parent *testCertificate, commonName string) *testCertificate {
+	tb.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-1 * time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+	}
+	signer := &testCertificate{cert: template, key: key}
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		signer = parent
+		template.KeyUsage = x509.KeyUsageDigitalSignature
+		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		template.DNSNames = []string{commonName}
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer.cert, &key.PublicKey, signer.key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &testCertificate{cert: cert, der: der, key: key}
+}
+
+func (c *testCertificate) writeCertificate(tb testing.TB, path string) {
+	tb.Helper()
+	data := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der})
+	if err := os.WriteFile(path, data, 0o644); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func (c *testCertificate) writeKey(tb testing.TB, path string) {
+	tb.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(c.key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		tb.Fatal(err)
+	}
+}
@@ -4,8 +4,11 @@
 package main
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"html/template"
 	"io"
 	"io/fs"
 	"net/http"
@@ -14,6 +17,7 @@ import (
 	"strings"
 	"unicode/utf8"
 
+	"github.com/google/uuid"
 	"github.com/gorilla/handlers"
 	"golang.org/x/sync/errgroup"
 	"zb.256lights.llc/pkg/internal/backend"
@@ -23,6 +27,8 @@ import (
 	"zb.256lights.llc/pkg/internal/zbstorerpc"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/bass/action"
+	"zombiezen.com/go/bass/templateloader"
+	"zombiezen.com/go/bass/turbostream"
 	"zombiezen.com/go/log"
 )
 
@@ -54,6 +60,9 @@ func (srv *webServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		http.MethodGet:  cfg.NewHandler(srv.showBuild),
 		http.MethodHead: cfg.NewHandler(srv.showBuild),
 	})
+	mux.Handle("/build/{id}/events", handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(srv.buildEvents),
+	})
 
 	mux.Handle("/build/{id}/result", handlers.MethodHandler{
 		http.MethodGet:  cfg.NewHandler(srv.showResult),
@@ -139,6 +148,122 @@ func (srv *webServer) showBuild(ctx context.Context, r *http.Request) (*action.R
 	}
 }
 
+// buildSummaryID is the HTML element ID of the build summary
+// (see _build_summary.html).
+const buildSummaryID = "build-summary"
+
+// buildEvents serves a stream of [server-sent events]
+// that replace the build summary whenever the build's state changes.
+// Each event is a [Turbo Stream] message.
+// The stream ends when the build finishes.
+//
+// [server-sent events]: https://html.spec.whatwg.org/multipage/server-sent-events.html
+// [Turbo Stream]: https://turbo.hotwired.dev/handbook/streams
+func (srv *webServer) buildEvents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	buildID := r.PathValue("id")
+	tmpl, err := templateloader.AddPartials(template.New(buildSummaryID), srv.templateFiles)
+	if err != nil {
+		log.Errorf(ctx, "%v", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	summaryTemplate := tmpl.Lookup("build_summary")
+	if summaryTemplate == nil {
+		log.Errorf(ctx, "build_summary template missing")
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	sendSummary := func(build *zbstorerpc.Build) error {
+		msg, err := (&turbostream.Action{
+			Type:     turbostream.Replace,
+			TargetID: buildSummaryID,
+			Template: summaryTemplate,
+			Data:     build,
+		}).MarshalText()
+		if err != nil {
+			return err
+		}
+		var event []byte
+		for line := range bytes.Lines(msg) {
+			event = append(event, "data: "...)
+			event = append(event, bytes.TrimSuffix(line, []byte("\n"))...)
+			event = append(event, '\n')
+		}
+		event = append(event, '\n')
+		if _, err := w.Write(event); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	// The notification handler is called for every event in the build,
+	// so coalesce them before querying the build.
+	changed := make(chan struct{}, 1)
+	watchID := uuid.NewString()
+	watchCtx := jsonrpc.WithNotificationHandler(ctx, jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+		if req.Method != zbstorerpc.BuildEventMethod {
+			return nil, nil
+		}
+		event := new(zbstorerpc.BuildEvent)
+		if err := json.Unmarshal(req.Params, event); err != nil {
+			return nil, err
+		}
+		if event.WatchID != watchID {
+			return nil, nil
+		}
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+		return nil, nil
+	}))
+	finalBuild := new(zbstorerpc.Build)
+	watchDone := make(chan error, 1)
+	go func() {
+		watchDone <- jsonrpc.Do(watchCtx, srv.backend, zbstorerpc.WatchBuildMethod, finalBuild, &zbstorerpc.WatchBuildRequest{
+			BuildID: buildID,
+			WatchID: watchID,
+		})
+	}()
+	for {
+		select {
+		case <-changed:
+			build := new(zbstorerpc.Build)
+			err := jsonrpc.Do(ctx, srv.backend, zbstorerpc.GetBuildMethod, build, &zbstorerpc.GetBuildRequest{
+				BuildID: buildID,
+			})
+			if err != nil {
+				log.Errorf(ctx, "%v", err)
+				continue
+			}
+			if err := sendSummary(build); err != nil {
+				log.Debugf(ctx, "Send events for build %s: %v", buildID, err)
+			}
+		case err := <-watchDone:
+			if err != nil {
+				log.Debugf(ctx, "Watch build %s: %v", buildID, err)
+				return
+			}
+			if finalBuild.Status == zbstorerpc.BuildUnknown {
+				return
+			}
+			// The final summary does not include the stream source,
+			// so the browser will not reconnect.
+			if err := sendSummary(finalBuild); err != nil {
+				log.Debugf(ctx, "Send events for build %s: %v", buildID, err)
+			}
+			return
+		}
+	}
+}
+
 func (srv *webServer) showResult(ctx context.Context, r *http.Request) (*action.Response, error) {
 	var data struct {
 		BuildID string
@@ -261,6 +386,12 @@ func (srv *webServer) showLog(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if _, hasEnd := spec.End(); !hasEnd && !result.Status.IsFinished() {
+		// Stream the rest of the log as the builder writes it.
+		followLog(ctx, w, srv.backend, buildID, drvPath, spec.Start())
+		return
+	}
+
 	readRequest := &zbstorerpc.ReadLogRequest{
 		BuildID:    buildID,
 		DrvPath:    drvPath,
@@ -288,6 +419,44 @@ func (srv *webServer) showLog(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// followLog copies a derivation's log to w starting at the given offset
+// until the derivation finishes building.
+func followLog(ctx context.Context, w http.ResponseWriter, store jsonrpc.Handler, buildID string, drvPath zbstore.Path, start int64) {
+	rc := http.NewResponseController(w)
+	watchID := uuid.NewString()
+	watchCtx := jsonrpc.WithNotificationHandler(ctx, jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+		if req.Method != zbstorerpc.BuildEventMethod {
+			return nil, nil
+		}
+		event := new(zbstorerpc.BuildEvent)
+		if err := json.Unmarshal(req.Params, event); err != nil {
+			return nil, err
+		}
+		if event.WatchID != watchID || event.Kind != zbstorerpc.BuildLogEvent || event.DrvPath != drvPath || event.Log == nil {
+			return nil, nil
+		}
+		payload, err := event.Log.Payload()
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		rc.Flush()
+		return nil, nil
+	}))
+	err := jsonrpc.Do(watchCtx, store, zbstorerpc.WatchBuildMethod, nil, &zbstorerpc.WatchBuildRequest{
+		BuildID:  buildID,
+		Logs:     true,
+		DrvPath:  drvPath,
+		LogStart: start,
+		WatchID:  watchID,
+	})
+	if err != nil {
+		log.Debugf(ctx, "Read log for %s in build %s: %v", drvPath, buildID, err)
+	}
+}
+
 func trimToUTF8(b []byte) string {
 	n := len(b)
 	for {
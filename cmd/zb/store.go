@@ -38,10 +38,132 @@ func newStoreCommand(g *globalConfig) *cobra.Command {
 	}
 	c.AddCommand(
 		newStoreObjectCommand(g),
+		newStoreCopyCommand(g),
+		newStoreGCCommand(g),
+		newStoreVerifyCommand(g),
+		newStoreConnectCommand(g),
 	)
 	return c
 }
 
+type storeGCOptions struct {
+	maxFreed int64
+	dryRun   bool
+}
+
+func newStoreGCCommand(g *globalConfig) *cobra.Command {
+	c := &cobra.Command{
+		Use:                   "gc [options]",
+		Short:                 "delete store objects that are not reachable from roots",
+		DisableFlagsInUseLine: true,
+		Args:                  cobra.NoArgs,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	opts := new(storeGCOptions)
+	c.Flags().Var((*byteSizeFlag)(&opts.maxFreed), "max-freed", "stop after freeing `size` bytes (e.g. 10G)")
+	c.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "print the store objects that would be deleted without deleting them")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		return runStoreGC(cmd.Context(), g, opts)
+	}
+	return c
+}
+
+func runStoreGC(ctx context.Context, g *globalConfig, opts *storeGCOptions) error {
+	storeClient, waitStoreClient := g.storeClient(nil)
+	defer func() {
+		storeClient.Close()
+		waitStoreClient()
+	}()
+
+	resp := new(zbstorerpc.GCResponse)
+	err := jsonrpc.Do(ctx, storeClient, zbstorerpc.GCMethod, resp, &zbstorerpc.GCRequest{
+		MaxFreed: opts.maxFreed,
+		DryRun:   opts.dryRun,
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range resp.Paths {
+		if _, err := fmt.Println(path); err != nil {
+			return err
+		}
+	}
+	verb := "deleted"
+	if opts.dryRun {
+		verb = "would delete"
+	}
+	fmt.Fprintf(os.Stderr, "%s %d store objects (%s)\n", verb, len(resp.Paths), formatByteSize(resp.BytesFreed))
+	return nil
+}
+
+type storeVerifyOptions struct {
+	checkContents bool
+	repair        bool
+}
+
+func newStoreVerifyCommand(g *globalConfig) *cobra.Command {
+	c := &cobra.Command{
+		Use:                   "verify [options]",
+		Short:                 "check the store for missing, corrupt, or unrecorded objects",
+		DisableFlagsInUseLine: true,
+		Args:                  cobra.NoArgs,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	opts := new(storeVerifyOptions)
+	c.Flags().BoolVar(&opts.checkContents, "check-contents", false, "hash every store object and compare against its recorded NAR hash and content address")
+	c.Flags().BoolVar(&opts.repair, "repair", false, "re-download, forget, or quarantine damaged store objects")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		return runStoreVerify(cmd.Context(), g, opts)
+	}
+	return c
+}
+
+func runStoreVerify(ctx context.Context, g *globalConfig, opts *storeVerifyOptions) error {
+	storeClient, waitStoreClient := g.storeClient(nil)
+	defer func() {
+		storeClient.Close()
+		waitStoreClient()
+	}()
+
+	resp := new(zbstorerpc.VerifyResponse)
+	err := jsonrpc.Do(ctx, storeClient, zbstorerpc.VerifyMethod, resp, &zbstorerpc.VerifyRequest{
+		CheckContents: opts.checkContents,
+		Repair:        opts.repair,
+	})
+	if err != nil {
+		return err
+	}
+	unrepaired := 0
+	for _, problem := range resp.Problems {
+		if problem.Repair == "" {
+			unrepaired++
+		}
+		if _, err := fmt.Println(formatVerifyProblem(problem)); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintf(os.Stderr, "checked %d store objects, found %d problems\n", resp.Checked, len(resp.Problems))
+	if unrepaired > 0 {
+		return fmt.Errorf("%d problems not repaired", unrepaired)
+	}
+	return nil
+}
+
+// formatVerifyProblem returns a single-line description of a problem
+// reported by [zbstorerpc.VerifyMethod].
+func formatVerifyProblem(problem *zbstorerpc.VerifyProblem) string {
+	s := fmt.Sprintf("%s: %s", problem.Kind, problem.Message)
+	switch {
+	case problem.Repair != "":
+		s += " (" + string(problem.Repair) + ")"
+	case problem.RepairError != "":
+		s += " (repair failed: " + problem.RepairError + ")"
+	}
+	return s
+}
+
 func newStoreObjectCommand(g *globalConfig) *cobra.Command {
 	c := &cobra.Command{
 		Use:                   "object COMMAND",
@@ -314,25 +436,7 @@ func catExports(ctx context.Context, client *jsonrpc.Client, exportFiles []strin
 			size = info.Size()
 		}
 
-		// We still need to parse the export to determine store paths to confirm.
-		// If this fails, don't fail the overall operation.
-		pr, pw := io.Pipe()
-		ch := make(chan []zbstore.Path)
-		go func() {
-			rec := &exportPathRecorder{ctx: ctx}
-			if err := zbstore.ReceiveExport(rec, pr); err != nil {
-				log.Warnf(ctx, "Invalid store export format in %s: %v", inputFileName(exportFiles[0]), err)
-			}
-			// If we encountered a parse error, still consume the rest of the stream.
-			io.Copy(io.Discard, pr)
-			pr.Close()
-			ch <- rec.paths
-		}()
-
-		err = importToStore(ctx, client, io.TeeReader(f, pw), size)
-		pw.Close()
-		paths := <-ch
-		return paths, err
+		return importAndRecordPaths(ctx, client, f, size, inputFileName(exportFiles[0]))
 	}
 
 	// Start sending to the store.
@@ -366,6 +470,32 @@ func catExports(ctx context.Context, client *jsonrpc.Client, exportFiles []strin
 	return storePaths, err
 }
 
+// importAndRecordPaths sends the export in r to client verbatim
+// and returns the store paths found in the export.
+// If size is non-negative, then it is used as the message's Content-Length header.
+// name is used to describe r in log messages.
+func importAndRecordPaths(ctx context.Context, client *jsonrpc.Client, r io.Reader, size int64, name string) ([]zbstore.Path, error) {
+	// We still need to parse the export to determine store paths to confirm.
+	// If this fails, don't fail the overall operation.
+	pr, pw := io.Pipe()
+	ch := make(chan []zbstore.Path)
+	go func() {
+		rec := &exportPathRecorder{ctx: ctx}
+		if err := zbstore.ReceiveExport(rec, pr); err != nil {
+			log.Warnf(ctx, "Invalid store export format in %s: %v", name, err)
+		}
+		// If we encountered a parse error, still consume the rest of the stream.
+		io.Copy(io.Discard, pr)
+		pr.Close()
+		ch <- rec.paths
+	}()
+
+	err := importToStore(ctx, client, io.TeeReader(r, pw), size)
+	pw.Close()
+	paths := <-ch
+	return paths, err
+}
+
 // copyToExporter reads the file at path in the `nix-store --export` format
 // and copies each NAR file to the exporter.
 // It appends each of the store paths encountered to storePaths.
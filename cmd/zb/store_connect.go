@@ -0,0 +1,49 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+func newStoreConnectCommand(g *globalConfig) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "connect",
+		Short: "connect standard input and output to the store server",
+		Long: "Connect standard input and output to the store server's socket.\n\n" +
+			"This allows another store to use this machine as a remote builder over SSH.",
+		DisableFlagsInUseLine: true,
+		Args:                  cobra.NoArgs,
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		return runStoreConnect(cmd.Context(), g)
+	}
+	return c
+}
+
+func runStoreConnect(ctx context.Context, g *globalConfig) error {
+	conn, err := dialStore(ctx, g.storeSocket, g.storeToken)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	go func() {
+		io.Copy(conn, os.Stdin)
+		// Let the server finish responding to any requests in flight.
+		if cw, ok := conn.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			conn.Close()
+		}
+	}()
+	_, err = io.Copy(os.Stdout, conn)
+	return err
+}
@@ -0,0 +1,426 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/spf13/cobra"
+	"github.com/ulikunitz/xz"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/remotestore"
+	"zb.256lights.llc/pkg/internal/xio"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+)
+
+type storeCopyOptions struct {
+	paths             []string
+	to                *url.URL
+	includeReferences bool
+	compression       remotestore.CompressionType
+	secretKeyFile     string
+}
+
+func newStoreCopyCommand(g *globalConfig) *cobra.Command {
+	c := &cobra.Command{
+		Use:   "copy [options] --to URL PATH [...]",
+		Short: "copy store objects to a binary cache or another store",
+		Long: "Copy store objects (and their references) to a binary cache or another store.\n\n" +
+			"The destination URL can be a file:// URL for a binary cache directory\n" +
+			"or a unix:// URL for the socket of another store server.",
+		DisableFlagsInUseLine: true,
+		Args:                  cobra.MinimumNArgs(1),
+		SilenceErrors:         true,
+		SilenceUsage:          true,
+	}
+	opts := &storeCopyOptions{
+		compression: remotestore.XZ,
+	}
+	to := c.Flags().String("to", "", "destination `URL`")
+	c.Flags().BoolVar(&opts.includeReferences, "references", true, "include referenced store objects")
+	compression := c.Flags().String("compression", string(opts.compression), "compression `algorithm` for binary caches (one of xz, zstd, or none)")
+	c.Flags().StringVar(&opts.secretKeyFile, "secret-key-file", "", "`path` to a secret key used to sign objects copied to a binary cache")
+	c.RunE = func(cmd *cobra.Command, args []string) error {
+		if *to == "" {
+			return errors.New("--to is required")
+		}
+		var err error
+		opts.to, err = parseCopyDestination(*to)
+		if err != nil {
+			return err
+		}
+		switch ct := remotestore.CompressionType(*compression); ct {
+		case remotestore.XZ, remotestore.Zstandard, remotestore.NoCompression:
+			opts.compression = ct
+		default:
+			return fmt.Errorf("--compression: unsupported algorithm %q", ct)
+		}
+		opts.paths = args
+		return runStoreCopy(cmd.Context(), g, opts)
+	}
+	return c
+}
+
+// parseCopyDestination parses the argument to the --to flag of `zb store copy`.
+// Absolute filesystem paths are treated as "file" URLs.
+func parseCopyDestination(s string) (*url.URL, error) {
+	if filepath.IsAbs(s) {
+		path := filepath.ToSlash(s)
+		if !strings.HasPrefix(path, "/") {
+			// Windows drive letter paths (e.g. "C:/foo").
+			path = "/" + path
+		}
+		return &url.URL{Scheme: "file", Path: path}, nil
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return nil, fmt.Errorf("--to: %v", err)
+	}
+	switch u.Scheme {
+	case "file", "unix":
+		if u.Host != "" && u.Host != "localhost" {
+			return nil, fmt.Errorf("--to: %s URLs cannot have a host", u.Scheme)
+		}
+		if u.Path == "" {
+			return nil, fmt.Errorf("--to: %s URL missing path", u.Scheme)
+		}
+		return u, nil
+	default:
+		return nil, fmt.Errorf("--to: unsupported URL %s", u.Redacted())
+	}
+}
+
+// urlFilePath converts the path of a "file" or "unix" URL to a local filesystem path.
+func urlFilePath(u *url.URL) string {
+	p := u.Path
+	if filepath.Separator == '\\' && len(p) >= len("/C:") && p[0] == '/' && p[2] == ':' {
+		// Drive letter paths (e.g. "/C:/foo").
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+func runStoreCopy(ctx context.Context, g *globalConfig, opts *storeCopyOptions) error {
+	req := &zbstorerpc.ExportRequest{
+		Paths:             make([]zbstore.Path, len(opts.paths)),
+		ExcludeReferences: !opts.includeReferences,
+	}
+	for i, p := range opts.paths {
+		var err error
+		req.Paths[i], err = zbstore.ParsePath(p)
+		if err != nil {
+			return err
+		}
+	}
+
+	var importer zbstorerpc.Importer
+	var finish func() ([]zbstore.Path, error)
+	switch opts.to.Scheme {
+	case "file":
+		cache := &binaryCacheWriter{
+			dir:         urlFilePath(opts.to),
+			storeDir:    g.storeDir,
+			compression: opts.compression,
+		}
+		if opts.secretKeyFile != "" {
+			keyData, err := os.ReadFile(opts.secretKeyFile)
+			if err != nil {
+				return err
+			}
+			cache.key, err = nix.ParsePrivateKey(strings.TrimSpace(string(keyData)))
+			if err != nil {
+				return fmt.Errorf("%s: %v", opts.secretKeyFile, err)
+			}
+		}
+		if err := cache.init(); err != nil {
+			return err
+		}
+		importer = zbstorerpc.ImportFunc(func(header jsonrpc.Header, body io.Reader) error {
+			if err := zbstore.ReceiveExport(cache, body); err != nil {
+				return err
+			}
+			return cache.err
+		})
+		finish = func() ([]zbstore.Path, error) {
+			cache.cleanup()
+			return cache.paths, cache.err
+		}
+	case "unix":
+		if opts.secretKeyFile != "" {
+			return fmt.Errorf("--secret-key-file can only be used with binary caches")
+		}
+		destClient, waitDestClient := newStoreClient(opts.to.String(), "", nil)
+		defer func() {
+			destClient.Close()
+			waitDestClient()
+		}()
+		var paths []zbstore.Path
+		var copyError error
+		importer = zbstorerpc.ImportFunc(func(header jsonrpc.Header, body io.Reader) error {
+			paths, copyError = importAndRecordPaths(ctx, destClient, body, -1, "export")
+			return copyError
+		})
+		finish = func() ([]zbstore.Path, error) {
+			return paths, copyError
+		}
+	default:
+		return fmt.Errorf("unsupported destination %s", opts.to.Redacted())
+	}
+
+	storeClient, waitStoreClient := g.storeClient(&zbstorerpc.CodecOptions{
+		Importer: importer,
+	})
+	defer func() {
+		storeClient.Close()
+		waitStoreClient()
+	}()
+	// The export message is sent before the RPC response, so if we received the response,
+	// the copy is complete.
+	rpcError := jsonrpc.Do(ctx, storeClient, zbstorerpc.ExportMethod, nil, req)
+	paths, copyError := finish()
+	if rpcError != nil {
+		return rpcError
+	}
+	if copyError != nil {
+		return copyError
+	}
+	for _, path := range paths {
+		log.Infof(ctx, "Copied %s", path)
+	}
+	return nil
+}
+
+// binaryCacheWriter is a [zbstore.NARReceiver]
+// that writes the store objects it receives to a binary cache directory.
+// binaryCacheWriter stops writing objects after the first error,
+// which it stores in the err field.
+type binaryCacheWriter struct {
+	dir         string
+	storeDir    zbstore.Directory
+	compression remotestore.CompressionType
+	key         *nix.PrivateKey // optional
+
+	// paths is the list of store paths written to the cache.
+	paths []zbstore.Path
+	err   error
+
+	tmpFile    *os.File
+	compressor io.WriteCloser
+	fileHasher *nix.Hasher
+	fileSize   int64
+	narHasher  *nix.Hasher
+	narSize    int64
+}
+
+// init creates the binary cache directory
+// and its nix-cache-info file if they do not already exist.
+func (w *binaryCacheWriter) init() error {
+	if err := os.MkdirAll(filepath.Join(w.dir, "nar"), 0o777); err != nil {
+		return err
+	}
+	cacheInfoPath := filepath.Join(w.dir, nix.CacheInfoName)
+	if data, err := os.ReadFile(cacheInfoPath); err == nil {
+		info := new(nix.CacheInfo)
+		if err := info.UnmarshalText(data); err != nil {
+			return fmt.Errorf("%s: %v", cacheInfoPath, err)
+		}
+		if string(info.StoreDirectory) != string(w.storeDir) {
+			return fmt.Errorf("%s is a binary cache for %s (copying from %s)", w.dir, info.StoreDirectory, w.storeDir)
+		}
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	data, err := (&nix.CacheInfo{
+		StoreDirectory: nix.StoreDirectory(w.storeDir),
+		WantMassQuery:  true,
+	}).MarshalText()
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(cacheInfoPath, data)
+}
+
+func (w *binaryCacheWriter) Write(p []byte) (n int, err error) {
+	if w.err != nil {
+		return 0, w.err
+	}
+	if w.tmpFile == nil {
+		if err := w.startNAR(); err != nil {
+			w.err = err
+			return 0, err
+		}
+	}
+	n, err = w.compressor.Write(p)
+	w.narHasher.Write(p[:n])
+	w.narSize += int64(n)
+	if err != nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *binaryCacheWriter) startNAR() error {
+	var err error
+	w.tmpFile, err = os.CreateTemp(filepath.Join(w.dir, "nar"), ".tmp-*.nar")
+	if err != nil {
+		return err
+	}
+	w.fileHasher = nix.NewHasher(nix.SHA256)
+	w.fileSize = 0
+	w.narHasher = nix.NewHasher(nix.SHA256)
+	w.narSize = 0
+	dst := io.MultiWriter(w.tmpFile, w.fileHasher, (*countWriter)(&w.fileSize))
+	switch w.compression {
+	case remotestore.NoCompression:
+		w.compressor = nopWriteCloser{dst}
+	case remotestore.XZ:
+		w.compressor, err = xz.NewWriter(dst)
+	case remotestore.Zstandard:
+		w.compressor, err = zstd.NewWriter(dst)
+	default:
+		err = fmt.Errorf("unsupported compression %q", w.compression)
+	}
+	if err != nil {
+		w.cleanup()
+		return err
+	}
+	return nil
+}
+
+func (w *binaryCacheWriter) ReceiveNAR(trailer *zbstore.ExportTrailer) {
+	if w.err != nil {
+		return
+	}
+	if err := w.finishNAR(trailer); err != nil {
+		w.err = fmt.Errorf("copy %s: %v", trailer.StorePath, err)
+		return
+	}
+	w.paths = append(w.paths, trailer.StorePath)
+}
+
+func (w *binaryCacheWriter) finishNAR(trailer *zbstore.ExportTrailer) error {
+	defer w.cleanup()
+	if w.tmpFile == nil {
+		return fmt.Errorf("empty nar")
+	}
+	if err := w.compressor.Close(); err != nil {
+		return err
+	}
+	w.compressor = nil
+	if err := w.tmpFile.Close(); err != nil {
+		return err
+	}
+
+	info := &remotestore.NARInfo{
+		StorePath:   trailer.StorePath,
+		Compression: w.compression,
+		FileHash:    w.fileHasher.SumHash(),
+		FileSize:    w.fileSize,
+		NARHash:     w.narHasher.SumHash(),
+		NARSize:     w.narSize,
+		References:  *trailer.References.Clone(),
+		CA:          trailer.ContentAddress,
+	}
+	info.URL = "nar/" + info.FileHash.RawBase32() + ".nar" + compressionExtension(w.compression)
+	if w.key != nil {
+		sig, err := remotestore.SignNARInfo(w.key, info)
+		if err != nil {
+			return err
+		}
+		info.AddSignatures(sig)
+	}
+	infoData, err := info.MarshalText()
+	if err != nil {
+		return err
+	}
+
+	narPath := filepath.Join(w.dir, filepath.FromSlash(info.URL))
+	if err := os.Rename(w.tmpFile.Name(), narPath); err != nil {
+		return err
+	}
+	w.tmpFile = nil
+	// Write the .narinfo file last so that readers never observe a missing .nar file.
+	if err := writeFileAtomic(filepath.Join(w.dir, trailer.StorePath.Digest()+remotestore.NARInfoExtension), infoData); err != nil {
+		return err
+	}
+	return nil
+}
+
+// cleanup removes any partially written .nar file.
+func (w *binaryCacheWriter) cleanup() {
+	if w.compressor != nil {
+		w.compressor.Close()
+		w.compressor = nil
+	}
+	if w.tmpFile != nil {
+		w.tmpFile.Close()
+		os.Remove(w.tmpFile.Name())
+		w.tmpFile = nil
+	}
+}
+
+func compressionExtension(ct remotestore.CompressionType) string {
+	switch ct {
+	case remotestore.XZ:
+		return ".xz"
+	case remotestore.Zstandard:
+		return ".zst"
+	case remotestore.Bzip2:
+		return ".bz2"
+	case remotestore.Gzip:
+		return ".gz"
+	default:
+		return ""
+	}
+}
+
+// writeFileAtomic writes data to a temporary file in the same directory as path
+// and then renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	f, err := os.CreateTemp(filepath.Dir(path), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	closer := xio.CloseOnce(f)
+	defer func() {
+		closer.Close()
+		if f != nil {
+			os.Remove(f.Name())
+		}
+	}()
+	if _, err := f.Write(data); err != nil {
+		return err
+	}
+	if err := closer.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(f.Name(), 0o644); err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return err
+	}
+	f = nil
+	return nil
+}
+
+// countWriter is an [io.Writer] that counts the number of bytes written to it.
+type countWriter int64
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	*cw += countWriter(len(p))
+	return len(p), nil
+}
@@ -0,0 +1,184 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"zb.256lights.llc/pkg/internal/remotestore"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestBinaryCacheWriter(t *testing.T) {
+	compressionTypes := []remotestore.CompressionType{
+		remotestore.NoCompression,
+		remotestore.XZ,
+		remotestore.Zstandard,
+	}
+	for _, ct := range compressionTypes {
+		t.Run(string(ct), func(t *testing.T) {
+			const storeDir = zbstore.Directory("/zb/store")
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExporter(exportBuffer)
+			depContent := []byte("Hello, World!\n")
+			depPath, _, err := storetest.ExportText(exporter, storeDir, "dep.txt", depContent, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			mainContent := []byte("See " + string(depPath) + "\n")
+			mainPath, _, err := storetest.ExportText(exporter, storeDir, "main.txt", mainContent, sets.NewSorted(depPath))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			pub, pk, err := nix.GenerateKey("example.com-1", rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cacheDir := t.TempDir()
+			w := &binaryCacheWriter{
+				dir:         cacheDir,
+				storeDir:    storeDir,
+				compression: ct,
+				key:         pk,
+			}
+			if err := w.init(); err != nil {
+				t.Fatal(err)
+			}
+			if err := zbstore.ReceiveExport(w, exportBuffer); err != nil {
+				t.Fatal(err)
+			}
+			if w.err != nil {
+				t.Fatal(w.err)
+			}
+			if want := []zbstore.Path{depPath, mainPath}; !slices.Equal(w.paths, want) {
+				t.Errorf("paths = %v; want %v", w.paths, want)
+			}
+
+			cacheInfoData, err := os.ReadFile(filepath.Join(cacheDir, nix.CacheInfoName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			cacheInfo := new(nix.CacheInfo)
+			if err := cacheInfo.UnmarshalText(cacheInfoData); err != nil {
+				t.Error(err)
+			} else if got := zbstore.Directory(cacheInfo.StoreDirectory); got != storeDir {
+				t.Errorf("%s StoreDir = %q; want %q", nix.CacheInfoName, got, storeDir)
+			}
+
+			for _, tc := range []struct {
+				path    zbstore.Path
+				content []byte
+				refs    []zbstore.Path
+			}{
+				{depPath, depContent, nil},
+				{mainPath, mainContent, []zbstore.Path{depPath}},
+			} {
+				infoData, err := os.ReadFile(filepath.Join(cacheDir, tc.path.Digest()+remotestore.NARInfoExtension))
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				info := new(remotestore.NARInfo)
+				if err := info.UnmarshalText(infoData); err != nil {
+					t.Errorf("%s: %v", tc.path, err)
+					continue
+				}
+				if info.StorePath != tc.path {
+					t.Errorf("%s StorePath = %s; want %s", tc.path, info.StorePath, tc.path)
+				}
+				if info.Compression != ct {
+					t.Errorf("%s Compression = %q; want %q", tc.path, info.Compression, ct)
+				}
+				if got := slices.Collect(info.References.Values()); !slices.Equal(got, tc.refs) {
+					t.Errorf("%s References = %v; want %v", tc.path, got, tc.refs)
+				}
+				if err := remotestore.VerifyAnyNARInfo([]*nix.PublicKey{pub}, info); err != nil {
+					t.Error(err)
+				}
+
+				narFile, err := os.ReadFile(filepath.Join(cacheDir, filepath.FromSlash(info.URL)))
+				if err != nil {
+					t.Error(err)
+					continue
+				}
+				if got := int64(len(narFile)); got != info.FileSize {
+					t.Errorf("%s size = %d; want %d", info.URL, got, info.FileSize)
+				}
+				var nar []byte
+				switch ct {
+				case remotestore.NoCompression:
+					nar = narFile
+				case remotestore.XZ:
+					r, err := xz.NewReader(bytes.NewReader(narFile))
+					if err != nil {
+						t.Error(err)
+						continue
+					}
+					nar, err = io.ReadAll(r)
+					if err != nil {
+						t.Error(err)
+						continue
+					}
+				case remotestore.Zstandard:
+					r, err := zstd.NewReader(bytes.NewReader(narFile))
+					if err != nil {
+						t.Error(err)
+						continue
+					}
+					nar, err = io.ReadAll(r)
+					r.Close()
+					if err != nil {
+						t.Error(err)
+						continue
+					}
+				}
+				wantNAR := new(bytes.Buffer)
+				if err := storetest.SingleFileNAR(wantNAR, tc.content); err != nil {
+					t.Fatal(err)
+				}
+				if !bytes.Equal(nar, wantNAR.Bytes()) {
+					t.Errorf("%s NAR content does not match store object", tc.path)
+				}
+				if got := int64(len(nar)); got != info.NARSize {
+					t.Errorf("%s NARSize = %d; want %d", tc.path, info.NARSize, got)
+				}
+			}
+		})
+	}
+}
+
+func TestBinaryCacheWriterStoreDirMismatch(t *testing.T) {
+	cacheDir := t.TempDir()
+	w1 := &binaryCacheWriter{
+		dir:         cacheDir,
+		storeDir:    "/zb/store",
+		compression: remotestore.XZ,
+	}
+	if err := w1.init(); err != nil {
+		t.Fatal(err)
+	}
+	w2 := &binaryCacheWriter{
+		dir:         cacheDir,
+		storeDir:    "/opt/zb/store",
+		compression: remotestore.XZ,
+	}
+	if err := w2.init(); err == nil {
+		t.Error("init with different store directory did not return an error")
+	}
+}
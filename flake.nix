@@ -172,6 +172,7 @@
               serviceConfig = {
                 ExecStart = "${zb}/bin/zb serve --systemd --sandbox-path=/bin/sh=/opt/zb/store/hpsxd175dzfmjrg27pvvin3nzv3yi61k-busybox-1.36.1/bin/sh --implicit-system-dep=/bin/sh --build-users-group=${config.zb.buildGroup}";
                 KillMode = "mixed";
+                Delegate = "yes";
               };
             };
           };
@@ -7,9 +7,11 @@ require (
 	github.com/google/go-cmp v0.6.0
 	github.com/google/uuid v1.6.0
 	github.com/gorilla/handlers v1.5.2
+	github.com/klauspost/compress v1.18.0
 	github.com/spf13/cobra v1.8.0
 	github.com/spf13/pflag v1.0.7-0.20250601092742-8a6c85f2ae48
 	github.com/tailscale/hujson v0.0.0-20250226034555-ec1d1c113d33
+	github.com/ulikunitz/xz v0.5.15
 	go4.org v0.0.0-20230225012048-214862532bf5
 	golang.org/x/sync v0.14.0
 	golang.org/x/sys v0.33.0
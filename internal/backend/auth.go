@@ -0,0 +1,173 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os/user"
+	"strconv"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+// A Role is a level of access that a client has to a [Server].
+// Each role permits everything that the roles less than it permit.
+type Role int8
+
+// Roles.
+const (
+	// RoleNone permits no requests.
+	RoleNone Role = iota
+	// RoleReadOnly permits querying store objects, builds, and logs.
+	RoleReadOnly
+	// RoleBuild permits importing and exporting store objects,
+	// realizing derivations, canceling builds, and adding garbage collection roots.
+	RoleBuild
+	// RoleTrusted permits all requests,
+	// including collecting garbage and keeping failed build directories.
+	RoleTrusted
+)
+
+// ParseRole parses a role name as returned by [Role.String].
+func ParseRole(s string) (Role, error) {
+	for r := RoleNone; r <= RoleTrusted; r++ {
+		if r.String() == s {
+			return r, nil
+		}
+	}
+	return RoleNone, fmt.Errorf("unknown role %q (must be one of none, read-only, build, or trusted)", s)
+}
+
+// String returns the name of the role.
+func (r Role) String() string {
+	switch r {
+	case RoleNone:
+		return "none"
+	case RoleReadOnly:
+		return "read-only"
+	case RoleBuild:
+		return "build"
+	case RoleTrusted:
+		return "trusted"
+	default:
+		return fmt.Sprintf("Role(%d)", int8(r))
+	}
+}
+
+// methodRoles is the minimum role required to call each method.
+// Methods not listed require [RoleTrusted].
+var methodRoles = map[string]Role{
+	zbstorerpc.ExistsMethod:          RoleReadOnly,
+	zbstorerpc.InfoMethod:            RoleReadOnly,
+	zbstorerpc.GetBuildMethod:        RoleReadOnly,
+	zbstorerpc.ListBuildsMethod:      RoleReadOnly,
+	zbstorerpc.GetBuildResultMethod:  RoleReadOnly,
+	zbstorerpc.FindBuildResultMethod: RoleReadOnly,
+	zbstorerpc.ReadLogMethod:         RoleReadOnly,
+	zbstorerpc.WatchBuildMethod:      RoleReadOnly,
+
+	zbstorerpc.ExportMethod:      RoleBuild,
+	zbstorerpc.ExpandMethod:      RoleBuild,
+	zbstorerpc.RealizeMethod:     RoleBuild,
+	zbstorerpc.CancelBuildMethod: RoleBuild,
+	zbstorerpc.AddRootMethod:     RoleBuild,
+}
+
+// Peer identifies the client on the other end of a connection to a [Server].
+type Peer struct {
+	// Network is "unix" for clients connected over a Unix socket
+	// or "tls" for clients connected over TLS.
+	Network string
+	// Name is the name of the client.
+	// For Unix socket clients, Name is the client's user name
+	// or the empty string if it could not be determined.
+	// For TLS clients, Name is the name that the client authenticated as.
+	Name string
+	// UID is the user ID of a Unix socket client
+	// or -1 if it is not known.
+	UID int
+}
+
+// UnixPeer identifies the process on the other end of a Unix socket connection
+// using the operating system's peer credentials.
+func UnixPeer(conn *net.UnixConn) (*Peer, error) {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return nil, fmt.Errorf("identify unix peer: %v", err)
+	}
+	var uid int
+	var credError error
+	err = rawConn.Control(func(fd uintptr) {
+		uid, credError = peerUID(fd)
+	})
+	if err == nil {
+		err = credError
+	}
+	if err != nil {
+		return nil, fmt.Errorf("identify unix peer: %v", err)
+	}
+	peer := &Peer{
+		Network: "unix",
+		UID:     uid,
+	}
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		peer.Name = u.Username
+	}
+	return peer, nil
+}
+
+// String returns the peer in the form "NETWORK:NAME".
+func (peer *Peer) String() string {
+	if peer == nil {
+		return "<unknown>"
+	}
+	switch {
+	case peer.Name != "":
+		return peer.Network + ":" + peer.Name
+	case peer.UID >= 0:
+		return peer.Network + ":uid=" + strconv.Itoa(peer.UID)
+	default:
+		return peer.Network + ":<unknown>"
+	}
+}
+
+type peerContextKey struct{}
+
+// WithPeer returns a copy of parent that carries the given peer.
+// A [Server] uses the peer in a request's context to determine the client's role.
+func WithPeer(parent context.Context, peer *Peer) context.Context {
+	return context.WithValue(parent, peerContextKey{}, peer)
+}
+
+func peerFromContext(ctx context.Context) *Peer {
+	peer, _ := ctx.Value(peerContextKey{}).(*Peer)
+	return peer
+}
+
+// role returns the role of the client making a request.
+func (s *Server) role(ctx context.Context) Role {
+	if s.peerRole == nil {
+		return RoleTrusted
+	}
+	return s.peerRole(peerFromContext(ctx))
+}
+
+// authorize returns an error if the client is not permitted to call the given method.
+func (s *Server) authorize(ctx context.Context, method string) error {
+	want, ok := methodRoles[method]
+	if !ok {
+		want = RoleTrusted
+	}
+	if s.role(ctx) < want {
+		return permissionDenied(ctx, method)
+	}
+	return nil
+}
+
+func permissionDenied(ctx context.Context, what string) error {
+	return jsonrpc.Error(zbstorerpc.PermissionDenied, fmt.Errorf("%v not permitted to call %s", peerFromContext(ctx), what))
+}
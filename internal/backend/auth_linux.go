@@ -0,0 +1,14 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import "golang.org/x/sys/unix"
+
+func peerUID(fd uintptr) (int, error) {
+	cred, err := unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	if err != nil {
+		return -1, err
+	}
+	return int(cred.Uid), nil
+}
@@ -0,0 +1,15 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build !linux && !darwin
+
+package backend
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func peerUID(fd uintptr) (int, error) {
+	return -1, fmt.Errorf("peer credentials not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,387 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	. "zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/remotestore"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestPermissions(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	drvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+		Name:    "hello.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", "echo 'Hello, World!' > $out"},
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		role       Role
+		canImport  bool
+		canRealize bool
+		canGC      bool
+	}{
+		{role: RoleNone},
+		{role: RoleReadOnly},
+		{role: RoleBuild, canImport: true, canRealize: true},
+		{role: RoleTrusted, canImport: true, canRealize: true, canGC: true},
+	}
+	for _, test := range tests {
+		t.Run(test.role.String(), func(t *testing.T) {
+			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+				Options: Options{
+					RealStoreDirectory: t.TempDir(),
+					AllowKeepFailed:    true,
+					PeerRole: func(peer *Peer) Role {
+						return test.role
+					},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, bytes.NewReader(exportBuffer.Bytes()))
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var exists bool
+			err = jsonrpc.Do(ctx, client, zbstorerpc.ExistsMethod, &exists, &zbstorerpc.ExistsRequest{
+				Path: string(drvPath),
+			})
+			if test.role < RoleReadOnly {
+				checkPermissionDenied(t, zbstorerpc.ExistsMethod, err)
+			} else if err != nil {
+				t.Errorf("%s: %v", zbstorerpc.ExistsMethod, err)
+			} else if exists != test.canImport {
+				t.Errorf("after import, %s exists = %t; want %t", drvPath, exists, test.canImport)
+			}
+
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, new(zbstorerpc.RealizeResponse), &zbstorerpc.RealizeRequest{
+				DrvPaths:   []zbstore.Path{drvPath},
+				KeepFailed: true,
+			})
+			if test.role < RoleTrusted {
+				checkPermissionDenied(t, zbstorerpc.RealizeMethod+" with keepFailed", err)
+			} else if err != nil {
+				t.Errorf("%s with keepFailed: %v", zbstorerpc.RealizeMethod, err)
+			}
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, new(zbstorerpc.RealizeResponse), &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if !test.canRealize {
+				checkPermissionDenied(t, zbstorerpc.RealizeMethod, err)
+			} else if err != nil {
+				t.Errorf("%s: %v", zbstorerpc.RealizeMethod, err)
+			}
+
+			err = jsonrpc.Do(ctx, client, zbstorerpc.GCMethod, new(zbstorerpc.GCResponse), &zbstorerpc.GCRequest{
+				DryRun: true,
+			})
+			if !test.canGC {
+				checkPermissionDenied(t, zbstorerpc.GCMethod, err)
+			} else if err != nil {
+				t.Errorf("%s: %v", zbstorerpc.GCMethod, err)
+			}
+		})
+	}
+}
+
+// TestInternalImports verifies that store objects the server fetches on its own behalf
+// are imported even if requests without a peer are not permitted to import.
+func TestInternalImports(t *testing.T) {
+	// newServer mimics `zb serve`:
+	// the client is a build peer and builds run without a peer.
+	newServer := func(ctx context.Context, t *testing.T, dir zbstore.Directory, opts Options) *jsonrpc.Client {
+		t.Helper()
+		clientPeer := &Peer{Network: "tls", Name: "client", UID: -1}
+		opts.PeerRole = func(peer *Peer) Role {
+			if peer == clientPeer {
+				return RoleBuild
+			}
+			return RoleReadOnly
+		}
+		opts.BuildContext = func(_ context.Context, _ string) context.Context {
+			return ctx
+		}
+		_, client, err := backendtest.NewServer(WithPeer(ctx, clientPeer), t, dir, &backendtest.Options{
+			TempDir: t.TempDir(),
+			Options: opts,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return client
+	}
+
+	t.Run("Substitute", func(t *testing.T) {
+		ctx, cancel := testcontext.New(t)
+		defer cancel()
+		dir := backendtest.NewStoreDirectory(t)
+
+		const wantOutputName = "hello.txt"
+		const wantOutputContent = "Hello, World!\n"
+		wantOutputCA := nix.FlatFileContentAddress(mustParseHash(t, "sha256:c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31"))
+		wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, wantOutputCA, zbstore.References{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		pub, pk, err := nix.GenerateKey("example.com-1", rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cacheDir := t.TempDir()
+		writeCacheObject(t, cacheDir, pk, wantOutputPath, []byte(wantOutputContent), wantOutputCA, remotestore.NoCompression)
+		cacheServer := httptest.NewServer(http.FileServer(http.Dir(cacheDir)))
+		t.Cleanup(cacheServer.Close)
+
+		// The builder always fails, so the build can only succeed by substitution.
+		exportBuffer := new(bytes.Buffer)
+		exporter := zbstore.NewExporter(exportBuffer)
+		drvContent := &zbstore.Derivation{
+			Name:   wantOutputName,
+			Dir:    dir,
+			System: system.Current().String(),
+			Env: map[string]string{
+				"out": zbstore.HashPlaceholder("out"),
+			},
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
+			},
+		}
+		drvContent.Builder, drvContent.Args = failingBuilder()
+		drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		client := newServer(ctx, t, dir, Options{
+			Substituters:      []*url.URL{mustParseURL(t, cacheServer.URL)},
+			TrustedPublicKeys: []*nix.PublicKey{pub},
+		})
+		codec, releaseCodec, err := storeCodec(ctx, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = codec.Export(nil, exportBuffer)
+		releaseCodec()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths: []zbstore.Path{drvPath},
+		})
+		if err != nil {
+			t.Fatal("RPC error:", err)
+		}
+		got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+		if err != nil {
+			gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+			t.Fatalf("%v\nlog:\n%s", err, gotLog)
+		}
+		checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+	})
+
+	t.Run("RemoteBuild", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("Test uses Unix sockets")
+		}
+		ctx, cancel := testcontext.New(t)
+		defer cancel()
+		dir := backendtest.NewStoreDirectory(t)
+
+		const inputContent = "Hello, World!\n"
+		exportBuffer := new(bytes.Buffer)
+		exporter := zbstore.NewExporter(exportBuffer)
+		inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+			Name:      "hello.txt",
+			Directory: dir,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		const wantOutputName = "hello2.txt"
+		drvContent := &zbstore.Derivation{
+			Name:   wantOutputName,
+			Dir:    dir,
+			System: system.Current().String(),
+			Env: map[string]string{
+				"in":  string(inputFilePath),
+				"out": zbstore.HashPlaceholder("out"),
+			},
+			InputSources: *sets.NewSorted(
+				inputFilePath,
+			),
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		}
+		drvContent.Builder, drvContent.Args = catcatBuilder()
+		drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := exporter.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		// The remote server stores its files in the real store directory,
+		// so it is the only server that can run builders.
+		remoteServer, _, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+			TempDir: t.TempDir(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		socketPath := listenForTest(ctx, t, remoteServer)
+		localRealDir := t.TempDir()
+		client := newServer(ctx, t, dir, Options{
+			RealStoreDirectory: localRealDir,
+			RemoteBuilders: []*RemoteBuilder{{
+				Name:    "remote",
+				Dial:    dialUnixFunc(socketPath),
+				Systems: []string{system.Current().String()},
+			}},
+		})
+		codec, releaseCodec, err := storeCodec(ctx, client)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = codec.Export(nil, exportBuffer)
+		releaseCodec()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths: []zbstore.Path{drvPath},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+		if err != nil {
+			gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+			t.Fatalf("%v\nlog:\n%s", err, gotLog)
+		}
+		wantOutputContent := strings.Repeat(inputContent, 2)
+		wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+
+		// Outputs should have been copied back to the local store.
+		gotContent, err := os.ReadFile(filepath.Join(localRealDir, wantOutputPath.Base()))
+		if err != nil {
+			t.Error(err)
+		} else if string(gotContent) != wantOutputContent {
+			t.Errorf("local %s content = %q; want %q", wantOutputPath, gotContent, wantOutputContent)
+		}
+	})
+}
+
+func checkPermissionDenied(tb testing.TB, what string, err error) {
+	tb.Helper()
+	if err == nil {
+		tb.Errorf("%s succeeded; want permission denied", what)
+		return
+	}
+	if code, _ := jsonrpc.CodeFromError(err); code != zbstorerpc.PermissionDenied {
+		tb.Errorf("%s: %v (code = %d; want %d)", what, err, code, zbstorerpc.PermissionDenied)
+	}
+}
+
+func TestUnixPeer(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("Peer credentials not supported on", runtime.GOOS)
+	}
+	// Unix socket paths have a short length limit,
+	// so avoid the potentially long test temporary directory.
+	socketDir, err := os.MkdirTemp("", "zb")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(socketDir)
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Net: "unix", Name: filepath.Join(socketDir, "zb.sock")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	client, err := net.Dial("unix", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	server, err := l.AcceptUnix()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	peer, err := UnixPeer(server)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if peer.Network != "unix" {
+		t.Errorf("peer.Network = %q; want \"unix\"", peer.Network)
+	}
+	if want := os.Getuid(); peer.UID != want {
+		t.Errorf("peer.UID = %d; want %d", peer.UID, want)
+	}
+}
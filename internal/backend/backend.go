@@ -11,14 +11,18 @@ import (
 	"fmt"
 	"io"
 	"maps"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
 	"slices"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/google/uuid"
+	"golang.org/x/sync/semaphore"
 	"zb.256lights.llc/pkg/bytebuffer"
 	"zb.256lights.llc/pkg/internal/jsonrpc"
 	"zb.256lights.llc/pkg/internal/xiter"
@@ -26,6 +30,7 @@ import (
 	"zb.256lights.llc/pkg/sets"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
 	"zombiezen.com/go/sqlite"
 	"zombiezen.com/go/sqlite/sqlitemigration"
 	"zombiezen.com/go/sqlite/sqlitex"
@@ -46,12 +51,18 @@ type Options struct {
 	// LogDirectory is where builder logs will be stored.
 	// If empty, defaults to a directory called "log" in the same directory as the database.
 	LogDirectory string
+	// QuarantineDirectory is where files removed from the store directory
+	// by [zbstorerpc.VerifyMethod] repairs will be moved.
+	// It should be on the same filesystem as the real store directory.
+	// If empty, defaults to a directory called "quarantine" in the same directory as the database.
+	QuarantineDirectory string
 	// ContentAddressBufferCreator is used to create buffers for content addressing analysis.
 	// If nil, then in-memory byte slices are used with reasonable limits.
 	ContentAddressBufferCreator bytebuffer.Creator
 
 	// DatabasePoolSize is the maximum permitted number of concurrent connections to the database.
-	// If less than 1, a reasonable default is used.
+	// If less than 1, a reasonable default is used
+	// that accounts for MaxJobs.
 	DatabasePoolSize int
 
 	// If AllowKeepFailed is true, then the KeepFailed field in [zbstore.RealizeRequest] will be respected.
@@ -69,6 +80,32 @@ type Options struct {
 	// on the number of concurrent jobs to perform.
 	// If non-positive, then the number of cores detected on the machine is used.
 	CoresPerBuild int
+	// MaxJobs is the maximum number of derivations
+	// that the server will build concurrently.
+	// If non-positive, then derivations are built one at a time.
+	MaxJobs int
+
+	// BuilderLimits is the set of resource limits to apply to each builder.
+	// Limits are only enforced if CgroupDirectory is set.
+	BuilderLimits ResourceLimits
+	// CgroupDirectory is the path to a cgroup v2 directory (Linux only)
+	// that has been prepared with [PrepareCgroupDirectory].
+	// If not empty, then each builder is run in its own cgroup inside this directory,
+	// which enforces BuilderLimits, records the builder's resource usage,
+	// and ensures that all of the builder's processes are stopped when it finishes.
+	CgroupDirectory string
+
+	// BuilderTimeout is the maximum length of time that a builder may run
+	// before it is stopped and its build result is marked as timed out.
+	// Derivations can override the limit with the __timeout environment variable.
+	// If non-positive, then builders may run for any length of time.
+	BuilderTimeout time.Duration
+	// BuilderMaxSilentTime is the maximum length of time that a builder may run
+	// without writing to its log
+	// before it is stopped and its build result is marked as timed out.
+	// Derivations can override the limit with the __maxSilentTime environment variable.
+	// If non-positive, then builders may be silent for any length of time.
+	BuilderMaxSilentTime time.Duration
 
 	// BuildUsers is the set of user IDs to use for builds on non-Windows systems.
 	// If empty, then builds will use the current process's privileges.
@@ -82,6 +119,50 @@ type Options struct {
 	// BuildLogRetention is the length of time to retain build logs.
 	// If non-positive, then build logs will be not be automatically deleted.
 	BuildLogRetention time.Duration
+
+	// Substituters is a list of binary cache URLs
+	// to consult for store objects before running a builder.
+	// Substituters are tried in order.
+	// Supported schemes are "file", "http", and "https".
+	Substituters []*url.URL
+	// TrustedPublicKeys is the set of keys
+	// that are trusted to sign objects and realizations from Substituters.
+	// Objects without a signature from one of these keys are ignored.
+	TrustedPublicKeys []*nix.PublicKey
+
+	// RemoteBuilders is a list of other stores to send derivations to build.
+	// A derivation is built on a remote builder
+	// whenever a builder that supports the derivation's system has a free job slot.
+	// Otherwise, the derivation is built locally,
+	// or if the host cannot build the derivation,
+	// the server waits for a remote builder to become free.
+	// Remote builds count toward MaxJobs.
+	RemoteBuilders []*RemoteBuilder
+
+	// PeerRole determines the role of the client making a request.
+	// It is called with the peer attached to the request's context by [WithPeer]
+	// or nil if the context does not have a peer.
+	// If PeerRole is nil, then all clients are trusted.
+	PeerRole func(peer *Peer) Role
+}
+
+// ResourceLimits is a set of limits on the resources a builder may consume.
+// A non-positive value for any field means no limit.
+type ResourceLimits struct {
+	// Memory is the maximum number of bytes of memory
+	// that the builder's processes may use in total.
+	Memory int64
+	// CPU is the maximum number of CPUs' worth of time
+	// that the builder's processes may use (e.g. 1.5).
+	CPU float64
+	// PIDs is the maximum number of processes and threads
+	// that the builder may run at once.
+	PIDs int
+}
+
+// IsZero reports whether limits does not set any limits.
+func (limits *ResourceLimits) IsZero() bool {
+	return limits.Memory <= 0 && limits.CPU <= 0 && limits.PIDs <= 0
 }
 
 // A SandboxPath is the set of options for SandboxPaths in [Options].
@@ -113,7 +194,19 @@ func SystemSupportsSandbox() bool {
 
 // CanSandbox reports whether the current execution environment supports sandboxing.
 func CanSandbox() bool {
-	return SystemSupportsSandbox() && os.Geteuid() == 0
+	return CheckSandbox() == nil
+}
+
+// CheckSandbox returns an error describing why sandboxing cannot be used
+// in the current execution environment
+// or nil if sandboxing is available.
+// On Linux, sandboxing is available when running as root
+// or when the kernel permits unprivileged user namespaces.
+func CheckSandbox() error {
+	if !SystemSupportsSandbox() {
+		return fmt.Errorf("sandboxing not supported on %s", runtime.GOOS)
+	}
+	return checkSandbox()
 }
 
 // Server is a local store.
@@ -123,10 +216,12 @@ type Server struct {
 	realDir         string
 	buildDir        string
 	logDir          string
+	quarantineDir   string
 	caCreateTemp    bytebuffer.Creator
 	db              *sqlitemigration.Pool
 	allowKeepFailed bool
 	buildContext    func(context.Context, string) context.Context
+	peerRole        func(*Peer) Role
 
 	sandbox      bool
 	sandboxPaths map[string]SandboxPath
@@ -134,15 +229,31 @@ type Server struct {
 	cancelBackground context.CancelFunc
 	background       sync.WaitGroup
 
+	substituters   []*substituter
+	trustedKeys    []*nix.PublicKey
+	remoteBuilders *remoteBuilderSet // nil if there are no remote builders
+
 	coresPerBuild int
+	maxJobs       int
+	limits        ResourceLimits
+	cgroupDir     string
+	timeout       time.Duration
+	maxSilentTime time.Duration
+	jobs          *semaphore.Weighted // derivations being built or reused
 
 	writing  mutexMap[zbstore.Path] // store objects being written
 	building mutexMap[zbstore.Path] // derivations being built
 	users    *userSet
 
+	tempRoots rootSet    // paths in use by in-flight builds and imports
+	gcMu      sync.Mutex // held while collecting garbage or verifying the store
+
 	activeBuildsMu sync.Mutex
 	activeBuilds   map[uuid.UUID]context.CancelFunc
 	draining       bool
+
+	buildUpdatesMu sync.Mutex
+	buildUpdates   map[uuid.UUID]*buildUpdateWaiters // see buildUpdateChan
 }
 
 // NewServer returns a new [Server] for the given store directory and database path.
@@ -155,24 +266,41 @@ func NewServer(dir zbstore.Directory, dbPath string, opts *Options) *Server {
 	if err != nil {
 		panic(err)
 	}
+	maxJobs := max(1, opts.MaxJobs)
+	poolSize := opts.DatabasePoolSize
+	if poolSize < 1 {
+		// Each job holds a connection for its duration,
+		// so leave room for requests that arrive while builds are running.
+		poolSize = maxJobs + 10
+	}
 	srv := &Server{
 		dir:             dir,
 		realDir:         opts.RealStoreDirectory,
 		buildDir:        opts.BuildDirectory,
 		logDir:          opts.LogDirectory,
+		quarantineDir:   opts.QuarantineDirectory,
 		caCreateTemp:    opts.ContentAddressBufferCreator,
 		allowKeepFailed: opts.AllowKeepFailed,
 		sandbox:         !opts.DisableSandbox && CanSandbox(),
 		sandboxPaths:    maps.Clone(opts.SandboxPaths),
+		trustedKeys:     slices.Clone(opts.TrustedPublicKeys),
 		coresPerBuild:   opts.CoresPerBuild,
+		limits:          opts.BuilderLimits,
+		cgroupDir:       opts.CgroupDirectory,
+		timeout:         opts.BuilderTimeout,
+		maxSilentTime:   opts.BuilderMaxSilentTime,
+		maxJobs:         maxJobs,
+		jobs:            semaphore.NewWeighted(int64(maxJobs)),
 		users:           users,
 		activeBuilds:    make(map[uuid.UUID]context.CancelFunc),
+		buildUpdates:    make(map[uuid.UUID]*buildUpdateWaiters),
 		buildContext:    opts.BuildContext,
+		peerRole:        opts.PeerRole,
 
 		db: sqlitemigration.NewPool(dbPath, loadSchema(), sqlitemigration.Options{
 			Flags:       sqlite.OpenCreate | sqlite.OpenReadWrite,
 			PrepareConn: prepareConn,
-			PoolSize:    opts.DatabasePoolSize,
+			PoolSize:    poolSize,
 			OnStartMigrate: func() {
 				ctx := context.Background()
 				log.Debugf(ctx, "Migrating...")
@@ -187,6 +315,12 @@ func NewServer(dir zbstore.Directory, dbPath string, opts *Options) *Server {
 			},
 		}),
 	}
+	for _, u := range opts.Substituters {
+		srv.substituters = append(srv.substituters, newSubstituter(u))
+	}
+	if len(opts.RemoteBuilders) > 0 {
+		srv.remoteBuilders = newRemoteBuilderSet(opts.RemoteBuilders)
+	}
 	if srv.coresPerBuild <= 0 {
 		srv.coresPerBuild = max(1, runtime.NumCPU())
 	}
@@ -199,6 +333,9 @@ func NewServer(dir zbstore.Directory, dbPath string, opts *Options) *Server {
 	if srv.logDir == "" {
 		srv.logDir = filepath.Join(filepath.Dir(dbPath), "log")
 	}
+	if srv.quarantineDir == "" {
+		srv.quarantineDir = filepath.Join(filepath.Dir(dbPath), "quarantine")
+	}
 	if srv.caCreateTemp == nil {
 		srv.caCreateTemp = bytebuffer.BufferCreator{}
 	}
@@ -242,17 +379,29 @@ func (s *Server) Close() error {
 // JSONRPC implements the [jsonrpc.Handler] interface
 // and serves the [zbstorerpc] API.
 func (s *Server) JSONRPC(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
-	return jsonrpc.ServeMux{
-		zbstorerpc.ExistsMethod:         jsonrpc.HandlerFunc(s.exists),
-		zbstorerpc.InfoMethod:           jsonrpc.HandlerFunc(s.info),
-		zbstorerpc.ExportMethod:         jsonrpc.HandlerFunc(s.export),
-		zbstorerpc.ExpandMethod:         jsonrpc.HandlerFunc(s.expand),
-		zbstorerpc.RealizeMethod:        jsonrpc.HandlerFunc(s.realize),
-		zbstorerpc.GetBuildMethod:       jsonrpc.HandlerFunc(s.getBuild),
-		zbstorerpc.GetBuildResultMethod: jsonrpc.HandlerFunc(s.getBuildResult),
-		zbstorerpc.CancelBuildMethod:    jsonrpc.HandlerFunc(s.cancelBuild),
-		zbstorerpc.ReadLogMethod:        jsonrpc.HandlerFunc(s.readLog),
-	}.JSONRPC(ctx, req)
+	mux := jsonrpc.ServeMux{
+		zbstorerpc.ExistsMethod:          jsonrpc.HandlerFunc(s.exists),
+		zbstorerpc.InfoMethod:            jsonrpc.HandlerFunc(s.info),
+		zbstorerpc.ExportMethod:          jsonrpc.HandlerFunc(s.export),
+		zbstorerpc.ExpandMethod:          jsonrpc.HandlerFunc(s.expand),
+		zbstorerpc.RealizeMethod:         jsonrpc.HandlerFunc(s.realize),
+		zbstorerpc.GetBuildMethod:        jsonrpc.HandlerFunc(s.getBuild),
+		zbstorerpc.ListBuildsMethod:      jsonrpc.HandlerFunc(s.listBuilds),
+		zbstorerpc.GetBuildResultMethod:  jsonrpc.HandlerFunc(s.getBuildResult),
+		zbstorerpc.FindBuildResultMethod: jsonrpc.HandlerFunc(s.findBuildResult),
+		zbstorerpc.CancelBuildMethod:     jsonrpc.HandlerFunc(s.cancelBuild),
+		zbstorerpc.ReadLogMethod:         jsonrpc.HandlerFunc(s.readLog),
+		zbstorerpc.WatchBuildMethod:      jsonrpc.HandlerFunc(s.watchBuild),
+		zbstorerpc.AddRootMethod:         jsonrpc.HandlerFunc(s.addRoot),
+		zbstorerpc.GCMethod:              jsonrpc.HandlerFunc(s.gc),
+		zbstorerpc.VerifyMethod:          jsonrpc.HandlerFunc(s.verify),
+	}
+	if _, known := mux[req.Method]; known {
+		if err := s.authorize(ctx, req.Method); err != nil {
+			return nil, err
+		}
+	}
+	return mux.JSONRPC(ctx, req)
 }
 
 func (s *Server) realPath(path zbstore.Path) string {
@@ -326,7 +475,18 @@ func (s *Server) getBuild(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.R
 			Results: []*zbstorerpc.BuildResult{},
 		})
 	}
+	resp, err := s.findBuild(ctx, buildID)
+	if err != nil {
+		return nil, err
+	}
+	resp.ID = args.BuildID
+	return marshalResponse(resp)
+}
 
+// findBuild reads the state of the build with the given ID.
+// If the build does not exist, then findBuild returns a [*zbstorerpc.Build]
+// with a status of [zbstorerpc.BuildUnknown].
+func (s *Server) findBuild(ctx context.Context, buildID uuid.UUID) (*zbstorerpc.Build, error) {
 	conn, err := s.db.Get(ctx)
 	if err != nil {
 		return nil, err
@@ -346,7 +506,7 @@ func (s *Server) getBuild(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.R
 	defer rollback()
 
 	resp := &zbstorerpc.Build{
-		ID:      args.BuildID,
+		ID:      buildID.String(),
 		Status:  zbstorerpc.BuildUnknown,
 		Results: []*zbstorerpc.BuildResult{},
 	}
@@ -371,6 +531,7 @@ func (s *Server) getBuild(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.R
 			}
 
 			resp.StartedAt = time.UnixMilli(stmt.GetInt64("started_at"))
+			resp.Error = stmt.GetText("internal_error")
 
 			if stmt.GetBool("has_expand") {
 				resp.Expand = &zbstorerpc.ExpandResult{
@@ -395,7 +556,7 @@ func (s *Server) getBuild(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.R
 		return nil, fmt.Errorf("get build %v: %v", buildID, err)
 	}
 	if resp.Status == zbstorerpc.BuildUnknown {
-		return marshalResponse(resp)
+		return resp, nil
 	}
 
 	resp.Results, err = findBuildResults(resp.Results, conn, s.logDir, buildID, "")
@@ -403,9 +564,142 @@ func (s *Server) getBuild(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.R
 		return nil, err
 	}
 
+	return resp, nil
+}
+
+// Limits on the number of builds returned by [zbstorerpc.ListBuildsMethod].
+const (
+	defaultListBuildsLimit = 50
+	maxListBuildsLimit     = 1000
+)
+
+func (s *Server) listBuilds(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.ListBuildsRequest
+	if err := json.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	if args.Limit < 0 {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("negative limit"))
+	}
+	limit := args.Limit
+	if limit == 0 {
+		limit = defaultListBuildsLimit
+	} else {
+		limit = min(limit, maxListBuildsLimit)
+	}
+	namedArgs := map[string]any{
+		":status":         nil,
+		":started_after":  nil,
+		":started_before": nil,
+		":drv_suffix":     nil,
+		":cursor_time":    nil,
+		":cursor_id":      nil,
+		":n":              limit,
+	}
+	switch args.Status {
+	case "":
+	case zbstorerpc.BuildActive, zbstorerpc.BuildSuccess, zbstorerpc.BuildFail, zbstorerpc.BuildError, zbstorerpc.BuildTimedOut:
+		namedArgs[":status"] = string(args.Status)
+	default:
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("invalid status %q", args.Status))
+	}
+	if args.StartedAfter.Valid {
+		namedArgs[":started_after"] = args.StartedAfter.X.UnixMilli()
+	}
+	if args.StartedBefore.Valid {
+		namedArgs[":started_before"] = args.StartedBefore.X.UnixMilli()
+	}
+	if args.DrvName != "" {
+		namedArgs[":drv_suffix"] = "-" + args.DrvName + zbstore.DerivationExt
+	}
+	if args.PageToken != "" {
+		cursorTime, cursorID, ok := parseListBuildsPageToken(args.PageToken)
+		if !ok {
+			return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("invalid page token"))
+		}
+		namedArgs[":cursor_time"] = cursorTime
+		namedArgs[":cursor_id"] = cursorID.String()
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	// Read active status before consulting database.
+	// We write to the database before clearing the active status.
+	s.activeBuildsMu.Lock()
+	activeBuilds := maps.Clone(s.activeBuilds)
+	s.activeBuildsMu.Unlock()
+
+	resp := &zbstorerpc.ListBuildsResponse{
+		Builds: []*zbstorerpc.Build{},
+	}
+	n := 0
+	var lastSortTime int64
+	var lastID uuid.UUID
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/list.sql", &sqlitex.ExecOptions{
+		Named: namedArgs,
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			n++
+			buildID, ok := parseBuildID(stmt.GetText("build_id"))
+			if !ok {
+				return fmt.Errorf("invalid build ID %q", stmt.GetText("build_id"))
+			}
+			lastID = buildID
+			lastSortTime = stmt.GetInt64("sort_time")
+
+			build := &zbstorerpc.Build{
+				ID:        buildID.String(),
+				Status:    zbstorerpc.BuildStatus(stmt.GetText("status")),
+				StartedAt: time.UnixMilli(stmt.GetInt64("started_at")),
+				Results:   []*zbstorerpc.BuildResult{},
+				Error:     stmt.GetText("internal_error"),
+			}
+			if stmt.ColumnType(stmt.ColumnIndex("ended_at")) == sqlite.TypeNull {
+				if _, isActive := activeBuilds[buildID]; !isActive {
+					// Orphaned from a previous run. See [*Server.findBuild].
+					return nil
+				}
+			} else {
+				build.EndedAt = zbstorerpc.NonNull(time.UnixMilli(stmt.GetInt64("ended_at")).UTC())
+			}
+			resp.Builds = append(resp.Builds, build)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list builds: %v", err)
+	}
+	if n == limit {
+		resp.NextPageToken = formatListBuildsPageToken(lastSortTime, lastID)
+	}
 	return marshalResponse(resp)
 }
 
+// formatListBuildsPageToken returns a page token for [zbstorerpc.ListBuildsMethod]
+// that continues the list after the build with the given ID.
+func formatListBuildsPageToken(sortTime int64, buildID uuid.UUID) string {
+	return strconv.FormatInt(sortTime, 10) + "/" + buildID.String()
+}
+
+func parseListBuildsPageToken(token string) (sortTime int64, buildID uuid.UUID, ok bool) {
+	sortTimeString, buildIDString, ok := strings.Cut(token, "/")
+	if !ok {
+		return 0, uuid.UUID{}, false
+	}
+	sortTime, err := strconv.ParseInt(sortTimeString, 10, 64)
+	if err != nil {
+		return 0, uuid.UUID{}, false
+	}
+	buildID, ok = parseBuildID(buildIDString)
+	if !ok {
+		return 0, uuid.UUID{}, false
+	}
+	return sortTime, buildID, true
+}
+
 func (s *Server) getBuildResult(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
 	var args zbstorerpc.GetBuildResultRequest
 	if err := json.Unmarshal(req.Params, &args); err != nil {
@@ -443,6 +737,65 @@ func (s *Server) getBuildResult(ctx context.Context, req *jsonrpc.Request) (*jso
 	return marshalResponse(results[0])
 }
 
+func (s *Server) findBuildResult(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.FindBuildResultRequest
+	if err := json.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+	rollback, err := readonlySavepoint(conn)
+	if err != nil {
+		return nil, fmt.Errorf("find build result: %v", err)
+	}
+	defer rollback()
+
+	var buildID uuid.UUID
+	var drvPath zbstore.Path
+	found := false
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/find_result.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{
+			":drv_path":    string(args.DrvPath),
+			":output_path": string(args.OutputPath),
+			":failed":      args.Failed,
+		},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			var err error
+			buildID, err = uuid.Parse(stmt.GetText("build_id"))
+			if err != nil {
+				return fmt.Errorf("build id: %v", err)
+			}
+			drvPath, err = zbstore.ParsePath(stmt.GetText("drv_path"))
+			if err != nil {
+				return err
+			}
+			found = true
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("find build result: %v", err)
+	}
+	if !found {
+		return marshalResponse(&zbstorerpc.FindBuildResultResponse{})
+	}
+	results, err := findBuildResults(nil, conn, s.logDir, buildID, drvPath)
+	if err != nil {
+		return nil, fmt.Errorf("find build result: %v", err)
+	}
+	if len(results) != 1 {
+		return nil, fmt.Errorf("find build result: internal error: %d results found for %s in build %v", len(results), drvPath, buildID)
+	}
+	return marshalResponse(&zbstorerpc.FindBuildResultResponse{
+		BuildID: buildID.String(),
+		Result:  results[0],
+	})
+}
+
 func (s *Server) cancelBuild(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
 	var args zbstorerpc.CancelBuildNotification
 	if err := json.Unmarshal(req.Params, &args); err != nil {
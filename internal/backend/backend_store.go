@@ -684,6 +684,20 @@ func findBuildResults(dst []*zbstorerpc.BuildResult, conn *sqlite.Conn, logDir s
 					Status:  zbstorerpc.BuildStatus(stmt.GetText("status")),
 					Outputs: []*zbstorerpc.RealizeOutput{},
 				}
+				if stmt.ColumnType(stmt.ColumnIndex("started_at")) != sqlite.TypeNull {
+					curr.StartedAt = zbstorerpc.NonNull(time.UnixMilli(stmt.GetInt64("started_at")).UTC())
+				}
+				if stmt.ColumnType(stmt.ColumnIndex("ended_at")) != sqlite.TypeNull {
+					curr.EndedAt = zbstorerpc.NonNull(time.UnixMilli(stmt.GetInt64("ended_at")).UTC())
+				}
+				curr.Error = stmt.GetText("error")
+				if stmt.ColumnType(stmt.ColumnIndex("peak_memory")) != sqlite.TypeNull {
+					curr.PeakMemory = zbstorerpc.NonNull(stmt.GetInt64("peak_memory"))
+				}
+				if stmt.ColumnType(stmt.ColumnIndex("cpu_time")) != sqlite.TypeNull {
+					cpuTime := time.Duration(stmt.GetInt64("cpu_time")) * time.Microsecond
+					curr.CPUTime = zbstorerpc.NonNull(cpuTime.Seconds())
+				}
 				if logDir != "" {
 					logInfo, err := os.Stat(builderLogPath(logDir, buildID, drvPath))
 					if err != nil && !errors.Is(err, os.ErrNotExist) {
@@ -707,6 +721,13 @@ func findBuildResults(dst []*zbstorerpc.BuildResult, conn *sqlite.Conn, logDir s
 					}
 					newOutput.Path = zbstorerpc.NonNull(p)
 				}
+				if s := stmt.GetText("check_path"); s != "" {
+					p, err := zbstore.ParsePath(s)
+					if err != nil {
+						return fmt.Errorf("output %s: %v", outputName, err)
+					}
+					newOutput.CheckPath = zbstorerpc.NonNull(p)
+				}
 				curr.Outputs = append(curr.Outputs, newOutput)
 			}
 
@@ -778,13 +799,46 @@ func setBuildResultOutputs(conn *sqlite.Conn, buildResultID int64, outputs iter.
 	return nil
 }
 
-func recordBuilderEnd(conn *sqlite.Conn, buildResultID int64, t time.Time) error {
-	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/set_builder_end.sql", &sqlitex.ExecOptions{
+// setBuildResultCheckOutput records the path produced for an output
+// when rebuilding a derivation in check mode.
+// The output must have already been added with [setBuildResultOutputs].
+func setBuildResultCheckOutput(conn *sqlite.Conn, buildResultID int64, outputName string, checkPath zbstore.Path) error {
+	if err := upsertPath(conn, checkPath); err != nil {
+		return fmt.Errorf("record check result %s -> %s: %v", outputName, checkPath, err)
+	}
+	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/set_check_output.sql", &sqlitex.ExecOptions{
 		Named: map[string]any{
-			":id":               buildResultID,
-			":timestamp_millis": t.UnixMilli(),
+			":id":          buildResultID,
+			":output_name": outputName,
+			":check_path":  string(checkPath),
 		},
 	})
+	if err != nil {
+		return fmt.Errorf("record check result %s -> %s: %v", outputName, checkPath, err)
+	}
+	return nil
+}
+
+// recordBuilderEnd records the time the builder finished.
+// usage may be nil if resource usage was not measured.
+func recordBuilderEnd(conn *sqlite.Conn, buildResultID int64, t time.Time, usage *resourceUsage) error {
+	args := map[string]any{
+		":id":               buildResultID,
+		":timestamp_millis": t.UnixMilli(),
+		":peak_memory":      nil,
+		":cpu_time_micros":  nil,
+	}
+	if usage != nil {
+		if usage.peakMemory.Valid {
+			args[":peak_memory"] = usage.peakMemory.X
+		}
+		if usage.cpuTime.Valid {
+			args[":cpu_time_micros"] = usage.cpuTime.X.Microseconds()
+		}
+	}
+	err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "build/set_builder_end.sql", &sqlitex.ExecOptions{
+		Named: args,
+	})
 	if err != nil {
 		return fmt.Errorf("record builder end: %v", err)
 	}
@@ -809,8 +863,12 @@ func finalizeBuildResult(ctx context.Context, conn *sqlite.Conn, logDir string,
 	defer sqlitex.Save(conn)(&err)
 
 	status := zbstorerpc.BuildSuccess
+	var errorArg any
 	if result.error != nil {
-		if isBuilderFailure(result.error) {
+		errorArg = result.error.Error()
+		if isBuilderTimeout(result.error) {
+			status = zbstorerpc.BuildTimedOut
+		} else if isBuilderFailure(result.error) {
 			status = zbstorerpc.BuildFail
 		} else {
 			status = zbstorerpc.BuildError
@@ -829,6 +887,7 @@ func finalizeBuildResult(ctx context.Context, conn *sqlite.Conn, logDir string,
 			":id":               result.id,
 			":status":           string(status),
 			":timestamp_millis": result.endTime.UnixMilli(),
+			":error":            errorArg,
 		},
 	})
 	if err != nil {
@@ -954,6 +1013,7 @@ func prepareConn(conn *sqlite.Conn) error {
 //go:embed sql/*.sql
 //go:embed sql/build/*.sql
 //go:embed sql/delete/*.sql
+//go:embed sql/gc/*.sql
 //go:embed sql/schema/*.sql
 var rawSQLFiles embed.FS
 
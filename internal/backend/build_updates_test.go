@@ -0,0 +1,48 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+func TestBuildUpdateChan(t *testing.T) {
+	s := &Server{buildUpdates: make(map[uuid.UUID]*buildUpdateWaiters)}
+	buildID := uuid.New()
+
+	c1, release1 := s.buildUpdateChan(buildID)
+	c2, release2 := s.buildUpdateChan(buildID)
+	if c1 != c2 {
+		t.Error("concurrent callers received different channels")
+	}
+	release1()
+	if len(s.buildUpdates) != 1 {
+		t.Errorf("after first release, len(s.buildUpdates) = %d; want 1", len(s.buildUpdates))
+	}
+	s.notifyBuildUpdate(buildID)
+	select {
+	case <-c2:
+	default:
+		t.Error("channel not closed after notifyBuildUpdate")
+	}
+
+	// A caller that starts waiting after the notification
+	// must not be affected by a stale release.
+	c3, release3 := s.buildUpdateChan(buildID)
+	release2()
+	if len(s.buildUpdates) != 1 {
+		t.Errorf("after stale release, len(s.buildUpdates) = %d; want 1", len(s.buildUpdates))
+	}
+	release3()
+	if len(s.buildUpdates) != 0 {
+		t.Errorf("after all releases, len(s.buildUpdates) = %d; want 0", len(s.buildUpdates))
+	}
+	select {
+	case <-c3:
+		t.Error("channel closed without notification")
+	default:
+	}
+}
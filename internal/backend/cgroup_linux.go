@@ -0,0 +1,331 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+)
+
+// cgroupRoot is the conventional mount point of the cgroup v2 filesystem.
+const cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupSupervisorName is the name of the cgroup that the server moves itself into
+// when it manages the cgroup it was started in.
+// A cgroup with processes in it cannot delegate controllers to child cgroups
+// (the "no internal processes" rule).
+const cgroupSupervisorName = "supervisor"
+
+// builderControllers is the set of cgroup controllers used for builders.
+var builderControllers = []string{"cpu", "memory", "pids"}
+
+// FindCgroupDirectory returns the path to the cgroup v2 directory
+// that the current process belongs to.
+func FindCgroupDirectory() (string, error) {
+	data, err := os.ReadFile("/proc/self/cgroup")
+	if err != nil {
+		return "", fmt.Errorf("find cgroup: %v", err)
+	}
+	for line := range strings.Lines(string(data)) {
+		if path, ok := strings.CutPrefix(strings.TrimSuffix(line, "\n"), "0::"); ok {
+			// If the server was moved into the supervisor cgroup
+			// by a previous call to PrepareCgroupDirectory,
+			// then use its parent.
+			path = strings.TrimSuffix(path, "/"+cgroupSupervisorName)
+			return filepath.Join(cgroupRoot, filepath.FromSlash(path)), nil
+		}
+	}
+	return "", fmt.Errorf("find cgroup: cgroup v2 not in use")
+}
+
+// PrepareCgroupDirectory prepares a cgroup v2 directory
+// for use as [Options.CgroupDirectory].
+// If the current process is a member of the cgroup,
+// then it will be moved into a child cgroup.
+// The cgroup's cpu, memory, and pids controllers (if available)
+// are enabled for child cgroups.
+func PrepareCgroupDirectory(dir string) error {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return fmt.Errorf("prepare cgroup %s: %w", dir, &os.PathError{Op: "statfs", Path: dir, Err: err})
+	}
+	if st.Type != unix.CGROUP2_SUPER_MAGIC {
+		return fmt.Errorf("prepare cgroup %s: not a cgroup v2 directory", dir)
+	}
+
+	procs, err := os.ReadFile(filepath.Join(dir, "cgroup.procs"))
+	if err != nil {
+		return fmt.Errorf("prepare cgroup %s: %v", dir, err)
+	}
+	if len(bytes.TrimSpace(procs)) > 0 {
+		self := strconv.Itoa(os.Getpid())
+		for _, pid := range strings.Fields(string(procs)) {
+			if pid != self {
+				return fmt.Errorf("prepare cgroup %s: contains processes other than zb (e.g. %s)", dir, pid)
+			}
+		}
+		supervisorDir := filepath.Join(dir, cgroupSupervisorName)
+		if err := os.Mkdir(supervisorDir, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("prepare cgroup %s: %v", dir, err)
+		}
+		if err := writeCgroupFile(filepath.Join(supervisorDir, "cgroup.procs"), self); err != nil {
+			return fmt.Errorf("prepare cgroup %s: move server process: %v", dir, err)
+		}
+	}
+
+	available, err := os.ReadFile(filepath.Join(dir, "cgroup.controllers"))
+	if err != nil {
+		return fmt.Errorf("prepare cgroup %s: %v", dir, err)
+	}
+	availableSet := strings.Fields(string(available))
+	var enable []string
+	for _, c := range builderControllers {
+		for _, a := range availableSet {
+			if a == c {
+				enable = append(enable, "+"+c)
+				break
+			}
+		}
+	}
+	if len(enable) > 0 {
+		subtreeControl := strings.Join(enable, " ")
+		if err := writeCgroupFile(filepath.Join(dir, "cgroup.subtree_control"), subtreeControl); err != nil {
+			return fmt.Errorf("prepare cgroup %s: enable controllers: %v", dir, err)
+		}
+	}
+	return nil
+}
+
+// buildCgroup is a cgroup that contains a single builder's processes.
+// Methods on a nil *buildCgroup are no-ops.
+type buildCgroup struct {
+	dir string
+	fd  *os.File
+
+	escalateMu sync.Mutex
+	// escalate kills the cgroup's processes
+	// if the builder does not exit in time after being canceled.
+	escalate *time.Timer
+}
+
+// newBuildCgroup creates a new cgroup inside parent for a builder.
+func newBuildCgroup(parent string, name string, limits *ResourceLimits) (_ *buildCgroup, err error) {
+	dir, err := os.MkdirTemp(parent, "build-"+name+"-*")
+	if err != nil {
+		return nil, fmt.Errorf("create cgroup: %v", err)
+	}
+	cg := &buildCgroup{dir: dir}
+	defer func() {
+		if err != nil {
+			os.Remove(dir)
+		}
+	}()
+
+	if limits.Memory > 0 {
+		if err := cg.setLimit("memory.max", strconv.FormatInt(limits.Memory, 10)); err != nil {
+			return nil, err
+		}
+		// Prevent the builder from exceeding the limit by swapping.
+		if err := cg.write("memory.swap.max", "0"); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+	}
+	if limits.CPU > 0 {
+		const period = 100_000 // microseconds
+		quota := max(int64(limits.CPU*period), 1000)
+		if err := cg.setLimit("cpu.max", fmt.Sprintf("%d %d", quota, period)); err != nil {
+			return nil, err
+		}
+	}
+	if limits.PIDs > 0 {
+		if err := cg.setLimit("pids.max", strconv.Itoa(limits.PIDs)); err != nil {
+			return nil, err
+		}
+	}
+
+	cg.fd, err = os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("create cgroup: %v", err)
+	}
+	return cg, nil
+}
+
+func (cg *buildCgroup) write(name string, value string) error {
+	if err := writeCgroupFile(filepath.Join(cg.dir, name), value); err != nil {
+		return fmt.Errorf("set cgroup %s: %w", name, err)
+	}
+	return nil
+}
+
+// setLimit writes a limit to a controller's interface file.
+func (cg *buildCgroup) setLimit(name string, value string) error {
+	err := cg.write(name, value)
+	if errors.Is(err, os.ErrNotExist) {
+		controller, _, _ := strings.Cut(name, ".")
+		return fmt.Errorf("set cgroup %s: %s controller not available in %s", name, controller, filepath.Dir(cg.dir))
+	}
+	return err
+}
+
+// attach arranges for c to be started inside the cgroup
+// and for the context cancellation of c to kill every process in the cgroup.
+// If c already has a Cancel function (see [setCancelFunc]),
+// then it is still used to stop the builder
+// and the cgroup's processes are only killed
+// if the builder has not exited after c.WaitDelay.
+func (cg *buildCgroup) attach(c *exec.Cmd) {
+	if cg == nil {
+		return
+	}
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = new(syscall.SysProcAttr)
+	}
+	c.SysProcAttr.UseCgroupFD = true
+	c.SysProcAttr.CgroupFD = int(cg.fd.Fd())
+
+	cancel := c.Cancel
+	if cancel == nil {
+		c.Cancel = cg.kill
+		return
+	}
+	waitDelay := c.WaitDelay
+	c.Cancel = func() error {
+		cg.escalateMu.Lock()
+		if cg.escalate == nil {
+			cg.escalate = time.AfterFunc(waitDelay, func() { cg.kill() })
+		}
+		cg.escalateMu.Unlock()
+		return cancel()
+	}
+}
+
+// kill sends SIGKILL to every process in the cgroup.
+func (cg *buildCgroup) kill() error {
+	if cg == nil {
+		return nil
+	}
+	err := cg.write("cgroup.kill", "1")
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	// cgroup.kill was added in Linux 5.14.
+	// Fall back to signaling each process.
+	// Freezing the cgroup first prevents processes from forking concurrently.
+	if err := cg.write("cgroup.freeze", "1"); err != nil {
+		return err
+	}
+	procs, err := os.ReadFile(filepath.Join(cg.dir, "cgroup.procs"))
+	if err != nil {
+		return err
+	}
+	for _, field := range strings.Fields(string(procs)) {
+		if pid, err := strconv.Atoi(field); err == nil {
+			unix.Kill(pid, unix.SIGKILL)
+		}
+	}
+	return cg.write("cgroup.freeze", "0")
+}
+
+// usage returns the resources consumed by the cgroup's processes.
+func (cg *buildCgroup) usage() *resourceUsage {
+	if cg == nil {
+		return nil
+	}
+	u := new(resourceUsage)
+	if data, err := os.ReadFile(filepath.Join(cg.dir, "memory.peak")); err == nil {
+		if n, err := strconv.ParseInt(string(bytes.TrimSpace(data)), 10, 64); err == nil {
+			u.peakMemory = zbstorerpc.NonNull(n)
+		}
+	}
+	if n, ok := readCgroupKey(filepath.Join(cg.dir, "cpu.stat"), "usage_usec"); ok {
+		u.cpuTime = zbstorerpc.NonNull(time.Duration(n) * time.Microsecond)
+	}
+	if n, ok := readCgroupKey(filepath.Join(cg.dir, "memory.events"), "oom_kill"); ok {
+		u.oomKilled = n > 0
+	}
+	return u
+}
+
+// close kills any processes remaining in the cgroup and removes it.
+func (cg *buildCgroup) close() error {
+	if cg == nil {
+		return nil
+	}
+	defer cg.fd.Close()
+	cg.escalateMu.Lock()
+	if cg.escalate != nil {
+		cg.escalate.Stop()
+	}
+	cg.escalateMu.Unlock()
+	if err := cg.kill(); err != nil {
+		return fmt.Errorf("clean up cgroup %s: %v", cg.dir, err)
+	}
+	// Removing the cgroup fails with EBUSY
+	// until the killed processes have exited.
+	const timeout = 10 * time.Second
+	delay := 1 * time.Millisecond
+	for start := time.Now(); ; {
+		err := unix.Rmdir(cg.dir)
+		if err == nil || errors.Is(err, unix.ENOENT) {
+			return nil
+		}
+		if !errors.Is(err, unix.EBUSY) || time.Since(start) > timeout {
+			return fmt.Errorf("clean up cgroup: %w", &os.PathError{Op: "rmdir", Path: cg.dir, Err: err})
+		}
+		time.Sleep(delay)
+		delay = min(delay*2, 100*time.Millisecond)
+	}
+}
+
+// writeCgroupFile writes value to an existing cgroup interface file.
+// Unlike [os.WriteFile], it does not try to create the file,
+// so writing to the file of a disabled controller reports [os.ErrNotExist].
+func writeCgroupFile(path string, value string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteString(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// readCgroupKey reads the value for key in a flat-keyed cgroup file
+// (e.g. cpu.stat or memory.events).
+func readCgroupKey(path string, key string) (int64, bool) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, false
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		k, v, ok := strings.Cut(s.Text(), " ")
+		if !ok || k != key {
+			continue
+		}
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
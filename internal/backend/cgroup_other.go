@@ -0,0 +1,37 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+//go:build !linux
+
+package backend
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// FindCgroupDirectory returns the path to the cgroup v2 directory
+// that the current process belongs to.
+func FindCgroupDirectory() (string, error) {
+	return "", fmt.Errorf("find cgroup: cgroups not supported on %s", runtime.GOOS)
+}
+
+// PrepareCgroupDirectory prepares a cgroup v2 directory
+// for use as [Options.CgroupDirectory].
+func PrepareCgroupDirectory(dir string) error {
+	return fmt.Errorf("prepare cgroup %s: cgroups not supported on %s", dir, runtime.GOOS)
+}
+
+// buildCgroup is a cgroup that contains a single builder's processes.
+// Methods on a nil *buildCgroup are no-ops.
+type buildCgroup struct{}
+
+func newBuildCgroup(parent string, name string, limits *ResourceLimits) (*buildCgroup, error) {
+	return nil, fmt.Errorf("create cgroup: cgroups not supported on %s", runtime.GOOS)
+}
+
+func (cg *buildCgroup) attach(c *exec.Cmd)    {}
+func (cg *buildCgroup) kill() error           { return nil }
+func (cg *buildCgroup) usage() *resourceUsage { return nil }
+func (cg *buildCgroup) close() error          { return nil }
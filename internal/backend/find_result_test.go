@@ -0,0 +1,202 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"runtime"
+	"strings"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestFindBuildResult(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses sh")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	goodDrvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+		Name:    "good.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", "echo good ; echo > $out"},
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	badDrvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+		Name:    "bad.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", "echo bad ; exit 1"},
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing has been built yet.
+	findResponse := new(zbstorerpc.FindBuildResultResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.FindBuildResultMethod, findResponse, &zbstorerpc.FindBuildResultRequest{
+		Failed: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if findResponse.BuildID != "" || findResponse.Result != nil {
+		t.Errorf("before builds, %s(failed=true) = %+v; want empty", zbstorerpc.FindBuildResultMethod, findResponse)
+	}
+
+	goodRealizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, goodRealizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{goodDrvPath},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	goodBuild, err := backendtest.WaitForSuccessfulBuild(ctx, client, goodRealizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(goodBuild.Results) != 1 || len(goodBuild.Results[0].Outputs) != 1 || !goodBuild.Results[0].Outputs[0].Path.Valid {
+		t.Fatalf("build results = %+v; want single output", goodBuild.Results)
+	}
+	goodOutputPath := goodBuild.Results[0].Outputs[0].Path.X
+
+	badRealizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, badRealizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{badDrvPath},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := backendtest.WaitForBuild(ctx, client, badRealizeResponse.BuildID); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		req         *zbstorerpc.FindBuildResultRequest
+		wantBuildID string
+		wantDrvPath zbstore.Path
+		wantStatus  zbstorerpc.BuildStatus
+		// wantLog is the expected beginning of the log.
+		// Logs of failed builds end with a footer describing the failure.
+		wantLog string
+	}{
+		{
+			name:        "LastFailed",
+			req:         &zbstorerpc.FindBuildResultRequest{Failed: true},
+			wantBuildID: badRealizeResponse.BuildID,
+			wantDrvPath: badDrvPath,
+			wantStatus:  zbstorerpc.BuildFail,
+			wantLog:     "bad\n",
+		},
+		{
+			name:        "DrvPath",
+			req:         &zbstorerpc.FindBuildResultRequest{DrvPath: goodDrvPath},
+			wantBuildID: goodRealizeResponse.BuildID,
+			wantDrvPath: goodDrvPath,
+			wantStatus:  zbstorerpc.BuildSuccess,
+			wantLog:     "good\n",
+		},
+		{
+			name:        "OutputPath",
+			req:         &zbstorerpc.FindBuildResultRequest{OutputPath: goodOutputPath},
+			wantBuildID: goodRealizeResponse.BuildID,
+			wantDrvPath: goodDrvPath,
+			wantStatus:  zbstorerpc.BuildSuccess,
+			wantLog:     "good\n",
+		},
+		{
+			name: "NoMatch",
+			req:  &zbstorerpc.FindBuildResultRequest{DrvPath: goodDrvPath, Failed: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := new(zbstorerpc.FindBuildResultResponse)
+			err := jsonrpc.Do(ctx, client, zbstorerpc.FindBuildResultMethod, got, test.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.BuildID != test.wantBuildID {
+				t.Errorf("build ID = %q; want %q", got.BuildID, test.wantBuildID)
+			}
+			if test.wantDrvPath == "" {
+				if got.Result != nil {
+					t.Errorf("result = %+v; want <nil>", got.Result)
+				}
+				return
+			}
+			if got.Result == nil {
+				t.Fatal("result = <nil>")
+			}
+			if got.Result.DrvPath != test.wantDrvPath {
+				t.Errorf("result.drvPath = %s; want %s", got.Result.DrvPath, test.wantDrvPath)
+			}
+			if got.Result.Status != test.wantStatus {
+				t.Errorf("result.status = %v; want %v", got.Result.Status, test.wantStatus)
+			}
+			gotLog, err := backendtest.ReadLog(ctx, client, got.BuildID, got.Result.DrvPath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(string(gotLog), test.wantLog) {
+				t.Errorf("log = %q; want to start with %q", gotLog, test.wantLog)
+			}
+			if footer := "*** Build failed"; test.wantStatus == zbstorerpc.BuildFail && !bytes.Contains(gotLog, []byte(footer)) {
+				t.Errorf("log = %q; want to contain %q", gotLog, footer)
+			}
+			if got.Result.LogSize != int64(len(gotLog)) {
+				t.Errorf("result.logSize = %d; want %d", got.Result.LogSize, len(gotLog))
+			}
+		})
+	}
+}
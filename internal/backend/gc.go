@@ -0,0 +1,475 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+/*
+Garbage collection roots come from three places:
+
+  - Indirect roots: symlinks outside the store directory registered with [zbstorerpc.AddRootMethod].
+  - Temporary roots: paths in use by in-flight builds and imports.
+    These are held in memory by a [rootSet].
+  - Recent builds: derivations and outputs of builds that are still recorded in the database.
+    These are deleted along with the build logs (see [Options.BuildLogRetention]).
+
+Every store object in the closure of a root is live.
+All other store objects are garbage.
+*/
+
+// A rootSet is a multiset of store paths
+// that are protected from garbage collection.
+// The zero value is an empty set.
+// Methods on rootSet are safe to call concurrently from multiple goroutines.
+type rootSet struct {
+	mu sync.Mutex
+	m  map[zbstore.Path]int
+}
+
+func (rs *rootSet) add(path zbstore.Path) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if rs.m == nil {
+		rs.m = make(map[zbstore.Path]int)
+	}
+	rs.m[path]++
+}
+
+func (rs *rootSet) remove(path zbstore.Path) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	switch n := rs.m[path]; {
+	case n > 1:
+		rs.m[path] = n - 1
+	case n == 1:
+		delete(rs.m, path)
+	default:
+		panic("rootSet.remove called on path not in set")
+	}
+}
+
+// has reports whether path is in rs.
+func (rs *rootSet) has(path zbstore.Path) bool {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	return rs.m[path] > 0
+}
+
+// addTo adds all the paths in rs to dst.
+func (rs *rootSet) addTo(dst sets.Set[zbstore.Path]) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	for path := range rs.m {
+		dst.Add(path)
+	}
+}
+
+// tempRoots is the set of paths that a single operation has added to a [rootSet].
+// Methods on tempRoots are safe to call concurrently from multiple goroutines.
+type tempRoots struct {
+	set *rootSet
+
+	mu    sync.Mutex
+	paths sets.Set[zbstore.Path]
+}
+
+// newTempRoots returns a new empty set of temporary roots for the server.
+// Callers are responsible for calling [*tempRoots.release]
+// once the paths no longer need to be protected.
+func (s *Server) newTempRoots() *tempRoots {
+	return &tempRoots{
+		set:   &s.tempRoots,
+		paths: make(sets.Set[zbstore.Path]),
+	}
+}
+
+// add protects the given paths from garbage collection
+// until tr.release is called.
+func (tr *tempRoots) add(paths ...zbstore.Path) {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for _, path := range paths {
+		if !tr.paths.Has(path) {
+			tr.paths.Add(path)
+			tr.set.add(path)
+		}
+	}
+}
+
+// release removes all of tr's paths from the server's temporary roots.
+func (tr *tempRoots) release() {
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	for path := range tr.paths.All() {
+		tr.set.remove(path)
+	}
+	clear(tr.paths)
+}
+
+func (s *Server) addRoot(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.AddRootRequest
+	if err := json.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	if !filepath.IsAbs(args.Link) {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("root %s is not an absolute path", args.Link))
+	}
+	link := filepath.Clean(args.Link)
+	path, err := s.readRootLink(link)
+	if err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "gc/add_indirect_root.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":link": link},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("add root %s: %v", link, err)
+	}
+	log.Infof(ctx, "Added root %s -> %s", link, path)
+	return nil, nil
+}
+
+// readRootLink returns the store object that the given symlink points to.
+func (s *Server) readRootLink(link string) (zbstore.Path, error) {
+	target, err := os.Readlink(link)
+	if err != nil {
+		return "", err
+	}
+	if !filepath.IsAbs(target) {
+		target = filepath.Join(filepath.Dir(link), target)
+	}
+	path, _, err := s.dir.ParsePath(target)
+	if err != nil {
+		return "", fmt.Errorf("root %s: %v", link, err)
+	}
+	return path, nil
+}
+
+func (s *Server) gc(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.GCRequest
+	if err := json.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	if args.MaxFreed < 0 {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("negative maxFreed"))
+	}
+	resp, err := s.collectGarbage(ctx, &args)
+	if err != nil {
+		return nil, err
+	}
+	return marshalResponse(resp)
+}
+
+// A garbageObject is a store object that is not reachable from any root.
+type garbageObject struct {
+	path       zbstore.Path
+	narSize    int64
+	references sets.Sorted[zbstore.Path]
+}
+
+func (s *Server) collectGarbage(ctx context.Context, opts *zbstorerpc.GCRequest) (_ *zbstorerpc.GCResponse, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("collect garbage: %v", err)
+		}
+	}()
+
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	// Find candidates without holding any locks
+	// so that we don't block builds while walking the store.
+	log.Debugf(ctx, "Searching for garbage...")
+	candidates, _, err := s.findGarbage(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxFreed > 0 {
+		candidates = limitGarbage(candidates, opts.MaxFreed)
+	}
+	if opts.DryRun || len(candidates) == 0 {
+		return gcResponse(candidates), nil
+	}
+
+	// Acquire write locks on the paths we're about to delete.
+	// Imports hold the write lock while they insert objects into the database,
+	// so we must acquire these before starting a transaction.
+	unlocks := make([]func(), 0, len(candidates))
+	defer func() {
+		for _, unlock := range unlocks {
+			unlock()
+		}
+	}()
+	for _, obj := range candidates {
+		unlock, err := s.writing.lock(ctx, obj.path)
+		if err != nil {
+			return nil, err
+		}
+		unlocks = append(unlocks, unlock)
+	}
+
+	var deleted []*garbageObject
+	err = func() (err error) {
+		endFn, err := sqlitex.ImmediateTransaction(conn)
+		if err != nil {
+			return err
+		}
+		defer endFn(&err)
+
+		// Roots may have been added since we found the candidates.
+		garbage, staleLinks, err := s.findGarbage(ctx, conn)
+		if err != nil {
+			return err
+		}
+		for _, link := range staleLinks {
+			log.Infof(ctx, "Removing root %s (no longer points to store)", link)
+			err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "gc/delete_indirect_root.sql", &sqlitex.ExecOptions{
+				Named: map[string]any{":link": link},
+			})
+			if err != nil {
+				return fmt.Errorf("remove root %s: %v", link, err)
+			}
+		}
+		deleted = intersectGarbage(garbage, candidates)
+
+		deleteStmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "delete/delete.sql")
+		if err != nil {
+			return err
+		}
+		defer deleteStmt.Finalize()
+		deleteSelfRefStmt, err := sqlitex.PrepareTransientFS(conn, sqlFiles(), "delete/delete_self_ref.sql")
+		if err != nil {
+			return err
+		}
+		defer deleteSelfRefStmt.Finalize()
+		for _, obj := range deleted {
+			deleteSelfRefStmt.SetText(":path", string(obj.path))
+			if _, err := deleteSelfRefStmt.Step(); err != nil {
+				return fmt.Errorf("%s: %v", obj.path, err)
+			}
+			if err := deleteSelfRefStmt.Reset(); err != nil {
+				return fmt.Errorf("%s: %v", obj.path, err)
+			}
+
+			deleteStmt.SetText(":path", string(obj.path))
+			if _, err := deleteStmt.Step(); err != nil {
+				return fmt.Errorf("%s: %v", obj.path, err)
+			}
+			if err := deleteStmt.Reset(); err != nil {
+				return fmt.Errorf("%s: %v", obj.path, err)
+			}
+		}
+
+		// We end the transaction before removing the files
+		// because we don't want a partial failure in removing files
+		// to abort the transaction.
+		return nil
+	}()
+	if err != nil {
+		return nil, err
+	}
+
+	ok := true
+	for _, obj := range deleted {
+		log.Debugf(ctx, "Deleting store object %s...", obj.path)
+		if err := os.RemoveAll(s.realPath(obj.path)); err != nil {
+			log.Errorf(ctx, "Failed to delete %s: %v", obj.path, err)
+			ok = false
+		}
+	}
+	resp := gcResponse(deleted)
+	log.Infof(ctx, "Garbage collection deleted %d store objects (%d bytes)", len(resp.Paths), resp.BytesFreed)
+	if !ok {
+		return resp, fmt.Errorf("one or more store paths could not be deleted")
+	}
+	return resp, nil
+}
+
+// findGarbage returns the store objects that are not reachable from any root
+// in an order where referrers appear before their references.
+// findGarbage also returns the list of registered indirect roots
+// that no longer exist.
+func (s *Server) findGarbage(ctx context.Context, conn *sqlite.Conn) (garbage []*garbageObject, staleLinks []string, err error) {
+	defer sqlitex.Save(conn)(&err)
+
+	roots := make(sets.Set[zbstore.Path])
+	s.tempRoots.addTo(roots)
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "gc/build_roots.sql", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			path, err := zbstore.ParsePath(stmt.GetText("path"))
+			if err != nil {
+				return err
+			}
+			roots.Add(path)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("find build roots: %v", err)
+	}
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "gc/indirect_roots.sql", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			link := stmt.GetText("link")
+			path, err := s.readRootLink(link)
+			if errors.Is(err, os.ErrNotExist) {
+				staleLinks = append(staleLinks, link)
+				return nil
+			}
+			if err != nil {
+				// Keep the registration in case the link is restored.
+				log.Warnf(ctx, "Ignoring root: %v", err)
+				return nil
+			}
+			roots.Add(path)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("find indirect roots: %v", err)
+	}
+	log.Debugf(ctx, "Found %d garbage collection roots", roots.Len())
+
+	live := make(sets.Set[zbstore.Path])
+	for root := range roots.All() {
+		if live.Has(root) {
+			continue
+		}
+		err := closurePaths(conn, pathAndEquivalenceClass{path: root}, func(pe pathAndEquivalenceClass) bool {
+			live.Add(pe.path)
+			return true
+		})
+		if err != nil && !errors.Is(err, errObjectNotExist) {
+			return nil, nil, err
+		}
+	}
+
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "gc/objects.sql", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			path, err := zbstore.ParsePath(stmt.GetText("path"))
+			if err != nil {
+				return err
+			}
+			if !live.Has(path) {
+				garbage = append(garbage, &garbageObject{
+					path:    path,
+					narSize: stmt.GetInt64("nar_size"),
+				})
+			}
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, nil, fmt.Errorf("list store objects: %v", err)
+	}
+	for _, obj := range garbage {
+		err := sqlitex.ExecuteTransientFS(conn, sqlFiles(), "references.sql", &sqlitex.ExecOptions{
+			Named: map[string]any{":path": string(obj.path)},
+			ResultFunc: func(stmt *sqlite.Stmt) error {
+				ref, err := zbstore.ParsePath(stmt.GetText("path"))
+				if err != nil {
+					return err
+				}
+				obj.references.Add(ref)
+				return nil
+			},
+		})
+		if err != nil {
+			return nil, nil, fmt.Errorf("references of %s: %v", obj.path, err)
+		}
+	}
+
+	err = sortByReferences(
+		garbage,
+		func(obj *garbageObject) zbstore.Path { return obj.path },
+		func(obj *garbageObject) sets.Sorted[zbstore.Path] { return obj.references },
+		false,
+	)
+	if err != nil {
+		return nil, nil, err
+	}
+	slices.Reverse(garbage)
+	return garbage, staleLinks, nil
+}
+
+// limitGarbage returns the shortest prefix of garbage
+// whose total size is at least maxFreed bytes.
+// Because garbage is ordered with referrers before their references,
+// every prefix of garbage can be deleted without leaving dangling references.
+func limitGarbage(garbage []*garbageObject, maxFreed int64) []*garbageObject {
+	var freed int64
+	for i, obj := range garbage {
+		if freed >= maxFreed {
+			return garbage[:i]
+		}
+		freed += obj.narSize
+	}
+	return garbage
+}
+
+// intersectGarbage returns the elements of garbage that are also in candidates
+// and whose referrers are all being deleted.
+// Both arguments must be ordered with referrers before their references
+// (as returned by [*Server.findGarbage]).
+func intersectGarbage(garbage, candidates []*garbageObject) []*garbageObject {
+	candidatePaths := make(sets.Set[zbstore.Path])
+	for _, obj := range candidates {
+		candidatePaths.Add(obj.path)
+	}
+	// Since the set of live objects is closed under references,
+	// all referrers of a garbage object are garbage.
+	// If we are skipping a referrer, then we must skip its references.
+	skipped := make(sets.Set[zbstore.Path])
+	var result []*garbageObject
+	for _, obj := range garbage {
+		if !candidatePaths.Has(obj.path) || skipped.Has(obj.path) {
+			skipped.Add(obj.path)
+			for ref := range obj.references.Values() {
+				skipped.Add(ref)
+			}
+			continue
+		}
+		result = append(result, obj)
+	}
+	return result
+}
+
+func gcResponse(objects []*garbageObject) *zbstorerpc.GCResponse {
+	resp := &zbstorerpc.GCResponse{
+		Paths: make([]zbstore.Path, 0, len(objects)),
+	}
+	for _, obj := range objects {
+		resp.Paths = append(resp.Paths, obj.path)
+		resp.BytesFreed += obj.narSize
+	}
+	return resp
+}
@@ -0,0 +1,206 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestGC(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	depPath, _, err := storetest.ExportText(exporter, dir, "dep.txt", []byte("dependency\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keepPath, _, err := storetest.ExportText(exporter, dir, "keep.txt", []byte(depPath+"\n"), sets.NewSorted(depPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbagePath, _, err := storetest.ExportText(exporter, dir, "garbage.txt", []byte("garbage\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	garbageReferrerPath, _, err := storetest.ExportText(exporter, dir, "garbage-referrer.txt", []byte(depPath+"\n"), sets.NewSorted(depPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Import with a separate receiver so that the client connection
+	// does not hold temporary roots for the imported objects.
+	receiver := srv.NewNARReceiver(ctx, bytebuffer.BufferCreator{})
+	err = zbstore.ReceiveExport(receiver, exportBuffer)
+	receiver.Cleanup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	link := filepath.Join(t.TempDir(), "result")
+	if err := os.Symlink(string(keepPath), link); err != nil {
+		t.Fatal(err)
+	}
+	err = jsonrpc.Do(ctx, client, zbstorerpc.AddRootMethod, nil, &zbstorerpc.AddRootRequest{
+		Link: link,
+	})
+	if err != nil {
+		t.Fatal("add root:", err)
+	}
+
+	sortPaths := cmpopts.SortSlices(func(a, b zbstore.Path) bool { return a < b })
+	checkExists := func(t *testing.T, want map[zbstore.Path]bool) {
+		t.Helper()
+		for _, path := range slices.Sorted(func(yield func(zbstore.Path) bool) {
+			for path := range want {
+				if !yield(path) {
+					return
+				}
+			}
+		}) {
+			_, err := os.Lstat(string(path))
+			if got := err == nil; got != want[path] {
+				t.Errorf("%s exists = %t; want %t", path, got, want[path])
+			}
+		}
+	}
+
+	t.Run("DryRun", func(t *testing.T) {
+		got := new(zbstorerpc.GCResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.GCMethod, got, &zbstorerpc.GCRequest{
+			DryRun: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []zbstore.Path{garbagePath, garbageReferrerPath}
+		if diff := cmp.Diff(want, got.Paths, sortPaths); diff != "" {
+			t.Errorf("paths (-want +got):\n%s", diff)
+		}
+		if got.BytesFreed <= 0 {
+			t.Errorf("bytesFreed = %d; want >0", got.BytesFreed)
+		}
+		checkExists(t, map[zbstore.Path]bool{
+			depPath:             true,
+			keepPath:            true,
+			garbagePath:         true,
+			garbageReferrerPath: true,
+		})
+	})
+
+	t.Run("Root", func(t *testing.T) {
+		got := new(zbstorerpc.GCResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.GCMethod, got, &zbstorerpc.GCRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []zbstore.Path{garbagePath, garbageReferrerPath}
+		if diff := cmp.Diff(want, got.Paths, sortPaths); diff != "" {
+			t.Errorf("paths (-want +got):\n%s", diff)
+		}
+		checkExists(t, map[zbstore.Path]bool{
+			depPath:             true,
+			keepPath:            true,
+			garbagePath:         false,
+			garbageReferrerPath: false,
+		})
+	})
+
+	t.Run("RemovedRoot", func(t *testing.T) {
+		if err := os.Remove(link); err != nil {
+			t.Fatal(err)
+		}
+		got := new(zbstorerpc.GCResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.GCMethod, got, &zbstorerpc.GCRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []zbstore.Path{depPath, keepPath}
+		if diff := cmp.Diff(want, got.Paths, sortPaths); diff != "" {
+			t.Errorf("paths (-want +got):\n%s", diff)
+		}
+		checkExists(t, map[zbstore.Path]bool{
+			depPath:  false,
+			keepPath: false,
+		})
+	})
+}
+
+func TestGCMaxFreed(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	depPath, _, err := storetest.ExportText(exporter, dir, "dep.txt", []byte("dependency\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrerPath, _, err := storetest.ExportText(exporter, dir, "referrer.txt", []byte(depPath+"\n"), sets.NewSorted(depPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	srv, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receiver := srv.NewNARReceiver(ctx, bytebuffer.BufferCreator{})
+	err = zbstore.ReceiveExport(receiver, exportBuffer)
+	receiver.Cleanup(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Freeing a single byte should only delete the referrer,
+	// since the dependency can't be deleted before it.
+	got := new(zbstorerpc.GCResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.GCMethod, got, &zbstorerpc.GCRequest{
+		MaxFreed: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []zbstore.Path{referrerPath}; !slices.Equal(got.Paths, want) {
+		t.Errorf("paths = %v; want %v", got.Paths, want)
+	}
+	if _, err := os.Lstat(string(depPath)); err != nil {
+		t.Error(err)
+	}
+	if _, err := os.Lstat(string(referrerPath)); err == nil {
+		t.Errorf("%s still exists", referrerPath)
+	}
+}
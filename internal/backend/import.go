@@ -8,12 +8,12 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"io/fs"
 	"os"
 	"path/filepath"
 	"time"
 
 	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/narutil"
 	"zb.256lights.llc/pkg/internal/osutil"
 	"zb.256lights.llc/pkg/sets"
 	"zb.256lights.llc/pkg/zbstore"
@@ -31,6 +31,8 @@ type NARReceiver struct {
 	realDir string
 	dbPool  *sqlitemigration.Pool
 	writing *mutexMap[zbstore.Path]
+	roots   *tempRoots
+	role    Role
 
 	tmpFileCreator bytebuffer.Creator
 	tmpFile        bytebuffer.ReadWriteSeekCloser
@@ -57,11 +59,24 @@ func (s *Server) NewNARReceiver(ctx context.Context, bufCreator bytebuffer.Creat
 		realDir:        s.realDir,
 		dbPool:         s.db,
 		writing:        &s.writing,
+		roots:          s.newTempRoots(),
+		role:           s.role(ctx),
 		tmpFileCreator: bufCreator,
 		hasher:         *nix.NewHasher(nix.SHA256),
 	}
 }
 
+// newInternalNARReceiver returns a new [NARReceiver] for store objects
+// that the server fetches on its own behalf,
+// like downloads from substituters or outputs from remote builders.
+// Unlike [Server.NewNARReceiver], the receiver does not check the role of the peer in ctx.
+// Callers are responsible for calling [NARReceiver.Cleanup] after the receiver is no longer in use.
+func (s *Server) newInternalNARReceiver(ctx context.Context, bufCreator bytebuffer.Creator) *NARReceiver {
+	r := s.NewNARReceiver(ctx, bufCreator)
+	r.role = RoleTrusted
+	return r
+}
+
 func (r *NARReceiver) Write(p []byte) (n int, err error) {
 	if r.tmpFile == nil {
 		r.tmpFile, err = r.tmpFileCreator.CreateBuffer(-1)
@@ -83,7 +98,7 @@ func (r *NARReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
 	}
 	if _, err := r.tmpFile.Seek(0, io.SeekStart); err != nil {
 		log.Errorf(ctx, "Unable to seek in store temp file: %v", err)
-		r.Cleanup(ctx)
+		r.closeTempFile(ctx)
 		return
 	}
 	defer func() {
@@ -92,13 +107,17 @@ func (r *NARReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
 		}
 		if _, err := r.tmpFile.Seek(0, io.SeekStart); err != nil {
 			log.Errorf(ctx, "Unable to seek in store temp file: %v", err)
-			r.Cleanup(ctx)
+			r.closeTempFile(ctx)
 			return
 		}
 		r.hasher.Reset()
 		r.size = 0
 	}()
 
+	if r.role < RoleBuild {
+		log.Warnf(ctx, "Rejecting %s (%v not permitted to import)", trailer.StorePath, peerFromContext(ctx))
+		return
+	}
 	if trailer.StorePath.Dir() != r.dir {
 		log.Warnf(ctx, "Rejecting %s (not in %s)", trailer.StorePath, r.dir)
 		return
@@ -119,6 +138,9 @@ func (r *NARReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
 		return
 	}
 	defer unlock()
+	// Clients typically import objects before using them in a build,
+	// so keep them around for the rest of the connection.
+	r.roots.add(trailer.StorePath)
 
 	realPath := filepath.Join(r.realDir, trailer.StorePath.Base())
 	if _, err := os.Lstat(realPath); err == nil {
@@ -130,7 +152,7 @@ func (r *NARReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
 	}
 
 	log.Debugf(ctx, "Extracting %s.nar to %s...", trailer.StorePath, realPath)
-	if err := extractNAR(realPath, io.LimitReader(r.tmpFile, r.size)); err != nil {
+	if err := narutil.Extract(realPath, io.LimitReader(r.tmpFile, r.size)); err != nil {
 		log.Warnf(ctx, "Import of %s failed: %v", trailer.StorePath, err)
 		if err := os.RemoveAll(realPath); err != nil {
 			log.Errorf(ctx, "Failed to clean up partial import of %s: %v", trailer.StorePath, err)
@@ -254,52 +276,13 @@ func verifyContentAddress(ctx context.Context, path zbstore.Path, narContent io.
 	return computed, nil
 }
 
-// extractNAR extracts a NAR file to the local filesystem at the given path.
-func extractNAR(dst string, r io.Reader) error {
-	nr := nar.NewReader(r)
-	for {
-		hdr, err := nr.Next()
-		if err == io.EOF {
-			return nil
-		}
-		if err != nil {
-			return err
-		}
-		p := filepath.Join(dst, filepath.FromSlash(hdr.Path))
-		switch typ := hdr.Mode.Type(); typ {
-		case 0:
-			perm := os.FileMode(0o644)
-			if hdr.Mode&0o111 != 0 {
-				perm = 0o755
-			}
-			f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
-			if err != nil {
-				return err
-			}
-			_, err = io.Copy(f, nr)
-			err2 := f.Close()
-			if err != nil {
-				return err
-			}
-			if err2 != nil {
-				return err2
-			}
-		case fs.ModeDir:
-			if err := os.Mkdir(p, 0o755); err != nil {
-				return err
-			}
-		case fs.ModeSymlink:
-			if err := os.Symlink(hdr.LinkTarget, p); err != nil {
-				return err
-			}
-		default:
-			return fmt.Errorf("unhandled type %v", typ)
-		}
-	}
-}
-
 // Cleanup releases any resources associated with the receiver.
 func (r *NARReceiver) Cleanup(ctx context.Context) {
+	r.roots.release()
+	r.closeTempFile(ctx)
+}
+
+func (r *NARReceiver) closeTempFile(ctx context.Context) {
 	if r.tmpFile == nil {
 		return
 	}
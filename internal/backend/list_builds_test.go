@@ -0,0 +1,175 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"runtime"
+	"slices"
+	"testing"
+	"time"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestListBuilds(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses sh")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	var drvPaths []zbstore.Path
+	for _, drv := range []struct {
+		name   string
+		script string
+	}{
+		{"first.txt", "echo > $out"},
+		{"second.txt", "exit 1"},
+		{"third.txt", "echo > $out"},
+	} {
+		drvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+			Name:    drv.name,
+			Dir:     dir,
+			System:  system.Current().String(),
+			Builder: shPath,
+			Args:    []string{"-c", drv.script},
+			Env: map[string]string{
+				"out": zbstore.HashPlaceholder("out"),
+			},
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		drvPaths = append(drvPaths, drvPath)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	startTime := time.Now().Add(-1 * time.Second)
+	var buildIDs []string
+	for _, drvPath := range drvPaths {
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths: []zbstore.Path{drvPath},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID); err != nil {
+			t.Fatal(err)
+		}
+		buildIDs = append(buildIDs, realizeResponse.BuildID)
+		// Ensure builds have distinct times.
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name string
+		req  *zbstorerpc.ListBuildsRequest
+		want []string
+	}{
+		{
+			name: "All",
+			req:  &zbstorerpc.ListBuildsRequest{},
+			want: []string{buildIDs[2], buildIDs[1], buildIDs[0]},
+		},
+		{
+			name: "Status",
+			req:  &zbstorerpc.ListBuildsRequest{Status: zbstorerpc.BuildFail},
+			want: []string{buildIDs[1]},
+		},
+		{
+			name: "DrvName",
+			req:  &zbstorerpc.ListBuildsRequest{DrvName: "first.txt"},
+			want: []string{buildIDs[0]},
+		},
+		{
+			name: "StartedAfter",
+			req:  &zbstorerpc.ListBuildsRequest{StartedAfter: zbstorerpc.NonNull(time.Now().Add(time.Hour))},
+			want: []string{},
+		},
+		{
+			name: "StartedBefore",
+			req:  &zbstorerpc.ListBuildsRequest{StartedBefore: zbstorerpc.NonNull(startTime)},
+			want: []string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp := new(zbstorerpc.ListBuildsResponse)
+			if err := jsonrpc.Do(ctx, client, zbstorerpc.ListBuildsMethod, resp, test.req); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(resp.Builds))
+			for _, b := range resp.Builds {
+				got = append(got, b.ID)
+				if !b.Status.IsFinished() {
+					t.Errorf("build %s status = %q; want finished", b.ID, b.Status)
+				}
+			}
+			if !slices.Equal(got, test.want) {
+				t.Errorf("builds = %q; want %q", got, test.want)
+			}
+			if resp.NextPageToken != "" {
+				t.Errorf("nextPageToken = %q; want \"\"", resp.NextPageToken)
+			}
+		})
+	}
+
+	t.Run("Pagination", func(t *testing.T) {
+		var got []string
+		req := &zbstorerpc.ListBuildsRequest{Limit: 2}
+		for range len(buildIDs) + 1 {
+			resp := new(zbstorerpc.ListBuildsResponse)
+			if err := jsonrpc.Do(ctx, client, zbstorerpc.ListBuildsMethod, resp, req); err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.Builds) > req.Limit {
+				t.Errorf("page has %d builds; want <=%d", len(resp.Builds), req.Limit)
+			}
+			for _, b := range resp.Builds {
+				got = append(got, b.ID)
+			}
+			if resp.NextPageToken == "" {
+				break
+			}
+			req.PageToken = resp.NextPageToken
+		}
+		want := []string{buildIDs[2], buildIDs[1], buildIDs[0]}
+		if !slices.Equal(got, want) {
+			t.Errorf("builds = %q; want %q", got, want)
+		}
+	})
+}
@@ -13,12 +13,14 @@ import (
 	"io"
 	"iter"
 	"maps"
+	"math"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"slices"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -49,6 +51,8 @@ import (
 const (
 	buildSystemDepsVar = "__buildSystemDeps"
 	networkVar         = "__network"
+	timeoutVar         = "__timeout"
+	maxSilentTimeVar   = "__maxSilentTime"
 )
 
 func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.Response, err error) {
@@ -60,6 +64,9 @@ func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.
 	if len(args.DrvPaths) == 0 {
 		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("no derivation paths given"))
 	}
+	if args.KeepFailed && s.role(ctx) < RoleTrusted {
+		return nil, permissionDenied(ctx, req.Method+" with keepFailed")
+	}
 	var drvPaths []zbstore.Path
 	for _, arg := range args.DrvPaths {
 		drvPath, subPath, err := s.dir.ParsePath(string(arg))
@@ -81,6 +88,14 @@ func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.
 	drvPathList := joinStrings(drvPaths, ", ")
 	log.Infof(ctx, "New build %v: %s", buildID, drvPathList)
 
+	// Protect the derivations from garbage collection until the build finishes.
+	roots := s.newTempRoots()
+	roots.add(drvPaths...)
+	defer func() {
+		if roots != nil {
+			roots.release()
+		}
+	}()
 	drvCache, err := s.readDerivationClosure(ctx, drvPaths)
 	if err != nil {
 		return nil, fmt.Errorf("build %s: %v", drvPathList, err)
@@ -97,9 +112,16 @@ func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.
 		return nil, fmt.Errorf("build %s: %v", drvPathList, err)
 	}
 
+	derivationCount := len(drvCache)
+	b := s.newBuilder(buildID, drvCache, roots)
+	roots = nil // Now owned by builder.
+	if args.Check {
+		b.check = sets.New(drvPaths...)
+	}
 	s.background.Add(1)
 	go func() {
 		defer func() {
+			b.roots.release()
 			cancelBuild()
 			s.background.Done()
 		}()
@@ -113,7 +135,6 @@ func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.
 				})
 			}
 		}
-		b := s.newBuilder(buildID, drvCache)
 		realizeError := b.realize(buildCtx, wantOutputs, args.KeepFailed)
 
 		recordCtx, cancel := xcontext.KeepAlive(buildCtx, 30*time.Second)
@@ -130,7 +151,8 @@ func (s *Server) realize(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.
 	}()
 
 	return marshalResponse(&zbstorerpc.RealizeResponse{
-		BuildID: buildID.String(),
+		BuildID:     buildID.String(),
+		Derivations: derivationCount,
 	})
 }
 
@@ -165,6 +187,14 @@ func (s *Server) expand(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.R
 		return nil, fmt.Errorf("store cannot build derivations (unsandboxed and storage directory does not match store)")
 	}
 
+	// Protect the derivation from garbage collection until the build finishes.
+	roots := s.newTempRoots()
+	roots.add(drvPath)
+	defer func() {
+		if roots != nil {
+			roots.release()
+		}
+	}()
 	drvCache, err := s.readDerivationClosure(ctx, []zbstore.Path{drvPath})
 	if err != nil {
 		return nil, fmt.Errorf("expand %s: %v", drvPath, err)
@@ -185,9 +215,12 @@ func (s *Server) expand(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.R
 		return nil, fmt.Errorf("expand %s: %v", drvPath, err)
 	}
 
+	b := s.newBuilder(buildID, drvCache, roots)
+	roots = nil // Now owned by builder.
 	s.background.Add(1)
 	go func() {
 		defer func() {
+			b.roots.release()
 			endBuild()
 			s.background.Done()
 		}()
@@ -195,7 +228,6 @@ func (s *Server) expand(ctx context.Context, req *jsonrpc.Request) (_ *jsonrpc.R
 		drv := drvCache[drvPath]
 		inputs := sets.Collect(drv.InputDerivationOutputs())
 
-		b := s.newBuilder(buildID, drvCache)
 		realizeError := b.realize(buildCtx, inputs, false)
 
 		recordCtx, cancel := xcontext.KeepAlive(buildCtx, 30*time.Second)
@@ -275,6 +307,7 @@ func (s *Server) registerBuildID(parent context.Context, conn *sqlite.Conn, buil
 		s.activeBuildsMu.Lock()
 		delete(s.activeBuilds, buildID)
 		s.activeBuildsMu.Unlock()
+		s.notifyBuildUpdate(buildID)
 		cancel()
 	}, nil
 }
@@ -283,7 +316,20 @@ type builder struct {
 	id     uuid.UUID
 	server *Server
 
-	derivations  map[zbstore.Path]*zbstore.Derivation
+	derivations map[zbstore.Path]*zbstore.Derivation
+	// roots is the set of store objects that the build is using.
+	// The caller of [*Server.newBuilder] is responsible for releasing them
+	// after the build finishes.
+	roots *tempRoots
+	// check is the set of derivations to build again
+	// even if they have existing realizations.
+	// See [zbstorerpc.RealizeRequest] for details.
+	check sets.Set[zbstore.Path]
+
+	// mu protects drvHashes and realizations.
+	// If a goroutine needs to hold both mu and a database write transaction,
+	// mu must be acquired first.
+	mu           sync.Mutex
 	drvHashes    map[zbstore.Path]nix.Hash
 	realizations map[equivalenceClass]cachedRealization
 }
@@ -298,11 +344,12 @@ type cachedRealization struct {
 	closure map[zbstore.Path]sets.Set[equivalenceClass]
 }
 
-func (s *Server) newBuilder(id uuid.UUID, derivations map[zbstore.Path]*zbstore.Derivation) *builder {
+func (s *Server) newBuilder(id uuid.UUID, derivations map[zbstore.Path]*zbstore.Derivation, roots *tempRoots) *builder {
 	return &builder{
 		server:      s,
 		id:          id,
 		derivations: derivations,
+		roots:       roots,
 
 		drvHashes:    make(map[zbstore.Path]nix.Hash),
 		realizations: make(map[equivalenceClass]cachedRealization),
@@ -325,6 +372,9 @@ func (b *builder) makeBuildResult(drvPath zbstore.Path) *zbstorerpc.BuildResult
 	return result
 }
 
+// toEquivalenceClass returns the equivalence class for the given output
+// if the builder has hashed its derivation.
+// The caller must hold b.mu.
 func (b *builder) toEquivalenceClass(ref zbstore.OutputReference) (_ equivalenceClass, ok bool) {
 	if ref.OutputName == "" {
 		return equivalenceClass{}, false
@@ -337,7 +387,10 @@ func (b *builder) toEquivalenceClass(ref zbstore.OutputReference) (_ equivalence
 }
 
 // lookup returns the realized path for the given output if the builder realized one.
+// lookup is safe to call concurrently from multiple goroutines.
 func (b *builder) lookup(ref zbstore.OutputReference) (_ zbstore.Path, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	eqClassRef, ok := b.toEquivalenceClass(ref)
 	if !ok {
 		return "", false
@@ -348,6 +401,16 @@ func (b *builder) lookup(ref zbstore.OutputReference) (_ zbstore.Path, ok bool)
 
 var errUnfinishedRealization = errors.New("realization did not complete")
 
+// A realizeNode is a derivation in the graph walked by [builder.realize].
+type realizeNode struct {
+	// outputs is the set of outputs of the derivation that must be realized.
+	outputs sets.Set[string]
+	// dependents is the set of derivations that use outputs of this derivation.
+	dependents sets.Set[zbstore.Path]
+	// unmet is the number of input derivations that have not been realized yet.
+	unmet int
+}
+
 func (b *builder) realize(ctx context.Context, want sets.Set[zbstore.OutputReference], keepFailed bool) error {
 	log.Debugf(ctx, "Will realize %v...", want)
 
@@ -365,80 +428,180 @@ func (b *builder) realize(ctx context.Context, want sets.Set[zbstore.OutputRefer
 	}
 	log.Debugf(ctx, "Found multi-outputs for %v: %v", want, multiOutputs)
 
+	graph, err := b.plan(want)
+	if err != nil {
+		return err
+	}
+	for drvPath, node := range graph {
+		node.outputs.AddSeq(multiOutputs[drvPath].All())
+	}
+
 	// TODO(soon): Find realizations we can use without requiring all build dependencies.
 
 	log.Debugf(ctx, "Realizing %v...", want)
-	drvLocks := make(map[zbstore.Path]func())
-	defer func() {
-		for _, unlock := range drvLocks {
-			unlock()
+	var ready []zbstore.Path
+	for _, drvPath := range xmaps.SortedKeys(graph) {
+		if graph[drvPath].unmet == 0 {
+			ready = append(ready, drvPath)
 		}
-	}()
+	}
+	type result struct {
+		drvPath zbstore.Path
+		err     error
+	}
+	// Cancel derivations in progress once one fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	results := make(chan result)
+	running := 0
+	finished := 0
+	var firstError error
+	for running > 0 || (firstError == nil && len(ready) > 0) {
+		// Start as many derivations as we're permitted to.
+		// Once a derivation fails, we stop starting new ones,
+		// cancel the ones in progress, and wait for them to finish.
+		for firstError == nil && len(ready) > 0 && running < b.server.maxJobs {
+			drvPath := ready[0]
+			ready = ready[1:]
+			outputs := graph[drvPath].outputs
+			running++
+			go func() {
+				err := b.realizeDerivation(ctx, drvPath, outputs, keepFailed)
+				results <- result{drvPath, err}
+			}()
+		}
+
+		r := <-results
+		running--
+		if r.err != nil {
+			if firstError == nil {
+				firstError = r.err
+				cancel()
+			}
+			continue
+		}
+		finished++
+		for _, dependent := range xmaps.SortedKeys(graph[r.drvPath].dependents) {
+			node := graph[dependent]
+			node.unmet--
+			if node.unmet == 0 {
+				log.Debugf(ctx, "Enqueuing %s (all dependencies realized)", dependent)
+				ready = append(ready, dependent)
+			}
+		}
+	}
+	if firstError != nil {
+		return firstError
+	}
+	if finished < len(graph) {
+		return fmt.Errorf("realize %v: dependency cycle", want)
+	}
+	return nil
+}
+
+// plan computes the graph of derivations that must be realized
+// to realize the want set.
+func (b *builder) plan(want sets.Set[zbstore.OutputReference]) (map[zbstore.Path]*realizeNode, error) {
+	graph := make(map[zbstore.Path]*realizeNode)
 	stack := slices.Collect(want.All())
 	for len(stack) > 0 {
 		curr := xslices.Last(stack)
 		stack = xslices.Pop(stack, 1)
 
-		if _, realized := b.lookup(curr); realized {
-			continue
-		}
 		drv := b.derivations[curr.DrvPath]
 		if drv == nil {
-			return fmt.Errorf("realize %v: unknown derivation", curr)
+			return nil, fmt.Errorf("realize %v: unknown derivation", curr)
 		}
 		if !xmaps.HasKey(drv.Outputs, curr.OutputName) {
-			return fmt.Errorf("realize %v: unknown output %q", curr, curr.OutputName)
+			return nil, fmt.Errorf("realize %v: unknown output %q", curr, curr.OutputName)
 		}
-		drvHash := b.drvHashes[curr.DrvPath]
-		if !drvHash.IsZero() {
-			log.Debugf(ctx, "Resuming %s", curr.DrvPath)
-		} else {
-			// First visit to derivation.
-			log.Debugf(ctx, "Reached %v", curr)
-			unmetDependencies := false
-			for ref := range drv.InputDerivationOutputs() {
-				if _, realized := b.lookup(ref); !realized {
-					log.Debugf(ctx, "Enqueuing %s (dependency of %s)", ref, curr.DrvPath)
-					if !unmetDependencies {
-						stack = append(stack, curr)
-						unmetDependencies = true
-					}
-					stack = append(stack, ref)
-				}
-			}
-			if unmetDependencies {
-				log.Debugf(ctx, "Pausing %s to build dependencies", curr.DrvPath)
-				continue
-			}
-			drvHash, err := hashDrv(drv, b.lookup)
-			if err != nil {
-				return fmt.Errorf("realize %s: %v", curr, err)
-			}
-			log.Debugf(ctx, "Hashed %s to %v", curr.DrvPath, drvHash)
-			b.drvHashes[curr.DrvPath] = drvHash
+		node := graph[curr.DrvPath]
+		if node != nil {
+			node.outputs.Add(curr.OutputName)
+			continue
 		}
 
-		log.Debugf(ctx, "Waiting for build lock on %s...", curr.DrvPath)
-		unlock, err := b.server.building.lock(ctx, curr.DrvPath)
-		if err != nil {
-			return err
+		// First visit to derivation.
+		node = &realizeNode{
+			outputs:    sets.New(curr.OutputName),
+			dependents: make(sets.Set[zbstore.Path]),
 		}
-		drvLocks[curr.DrvPath] = unlock
-		log.Debugf(ctx, "Acquired build lock on %s", curr.DrvPath)
-		outputs := sets.New(curr.OutputName)
-		outputs.AddSeq(multiOutputs[curr.DrvPath].All())
-		if err := b.do(ctx, curr.DrvPath, outputs, keepFailed); err != nil {
-			// b.do already records the build failure,
-			// so we don't need to report the same error at the build level.
-			if !isBuilderFailure(err) {
-				log.Errorf(ctx, "%v", err)
-			}
-			return errUnfinishedRealization
+		graph[curr.DrvPath] = node
+		for ref := range drv.InputDerivationOutputs() {
+			stack = append(stack, ref)
 		}
-		drvLocks[curr.DrvPath]()
-		delete(drvLocks, curr.DrvPath)
 	}
 
+	for drvPath := range graph {
+		inputDrvs := make(sets.Set[zbstore.Path])
+		for ref := range b.derivations[drvPath].InputDerivationOutputs() {
+			inputDrvs.Add(ref.DrvPath)
+		}
+		for inputDrvPath := range inputDrvs.All() {
+			graph[inputDrvPath].dependents.Add(drvPath)
+		}
+		graph[drvPath].unmet = inputDrvs.Len()
+	}
+	return graph, nil
+}
+
+// realizeDerivation realizes the given outputs of a single derivation.
+// All of the derivation's inputs must have been realized
+// before calling realizeDerivation.
+// realizeDerivation is safe to call concurrently from multiple goroutines.
+func (b *builder) realizeDerivation(ctx context.Context, drvPath zbstore.Path, outputNames sets.Set[string], keepFailed bool) error {
+	log.Debugf(ctx, "Reached %s", drvPath)
+	drvHash, err := hashDrv(b.derivations[drvPath], b.lookup)
+	if err != nil {
+		return fmt.Errorf("realize %s: %v", drvPath, err)
+	}
+	log.Debugf(ctx, "Hashed %s to %v", drvPath, drvHash)
+	b.mu.Lock()
+	b.drvHashes[drvPath] = drvHash
+	b.mu.Unlock()
+
+	log.Debugf(ctx, "Waiting for build lock on %s...", drvPath)
+	unlock, err := b.server.building.lock(ctx, drvPath)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+	log.Debugf(ctx, "Acquired build lock on %s", drvPath)
+
+	unrealized := make(sets.Set[string])
+	for outputName := range outputNames.All() {
+		ref := zbstore.OutputReference{
+			DrvPath:    drvPath,
+			OutputName: outputName,
+		}
+		if _, realized := b.lookup(ref); !realized {
+			unrealized.Add(outputName)
+		}
+	}
+	if b.check.Has(drvPath) {
+		unrealized = outputNames
+	}
+	if unrealized.Len() == 0 {
+		log.Debugf(ctx, "All requested outputs of %s already realized", drvPath)
+		return nil
+	}
+
+	// The job slot must be acquired after the build lock
+	// so that a goroutine holding a slot never waits on another build.
+	log.Debugf(ctx, "Waiting for job slot for %s...", drvPath)
+	if err := b.server.jobs.Acquire(ctx, 1); err != nil {
+		return err
+	}
+	defer b.server.jobs.Release(1)
+
+	if err := b.do(ctx, drvPath, unrealized, keepFailed); err != nil {
+		// b.do already records the build failure,
+		// so we don't need to report the same error at the build level.
+		if !isBuilderFailure(err) {
+			log.Errorf(ctx, "%v", err)
+		}
+		return errUnfinishedRealization
+	}
 	return nil
 }
 
@@ -482,6 +645,7 @@ func (b *builder) expand(drvPath zbstore.Path, drv *zbstore.Derivation, temporar
 // because selecting a realization may imply selecting realizations from its closure.
 // fetchRealization will only add realizations to b.realizations
 // if it does not return an error.
+// The caller must hold b.mu.
 func (b *builder) fetchRealization(ctx context.Context, conn *sqlite.Conn, eqClass equivalenceClass, mustExist bool) (absentRealizations sets.Set[equivalenceClass], err error) {
 	if _, exists := b.realizations[eqClass]; exists {
 		return nil, nil
@@ -530,7 +694,10 @@ func (b *builder) fetchRealization(ctx context.Context, conn *sqlite.Conn, eqCla
 	// Now that we selected our realization, fill out the closures.
 	log.Debugf(ctx, "Using sole viable candidate %s for %v", r.path, eqClass)
 	b.realizations[eqClass] = r
-	if !present {
+	if present {
+		// Protecting the object also protects its closure.
+		b.roots.add(r.path)
+	} else {
 		absentRealizations = sets.New(eqClass)
 	}
 	for refPath, eqClasses := range r.closure {
@@ -589,6 +756,7 @@ func (b *builder) fetchRealization(ctx context.Context, conn *sqlite.Conn, eqCla
 // because selecting a realization may imply selecting realizations from its closure.
 // fetchRealizationSet will only add realizations to b.realizations
 // if it does not return an error.
+// The caller must hold b.mu.
 func (b *builder) fetchRealizationSet(ctx context.Context, conn *sqlite.Conn, eqClasses sets.Set[equivalenceClass]) (err error) {
 	defer sqlitex.Save(conn)(&err)
 
@@ -607,6 +775,9 @@ func (b *builder) fetchRealizationSet(ctx context.Context, conn *sqlite.Conn, eq
 	return nil
 }
 
+// pickRealizationFromSet selects the sole path in existing
+// that is compatible with the builder's realizations.
+// The caller must hold b.mu.
 func (b *builder) pickRealizationFromSet(ctx context.Context, conn *sqlite.Conn, eqClass equivalenceClass, existing sets.Set[zbstore.Path]) (zbstore.Path, map[zbstore.Path]sets.Set[equivalenceClass], error) {
 	var selectedPath zbstore.Path
 	closure := make(map[zbstore.Path]sets.Set[equivalenceClass])
@@ -676,6 +847,7 @@ func (b *builder) pickRealizationFromSet(ctx context.Context, conn *sqlite.Conn,
 // isCompatible reports whether the given path can be used
 // for the given (potentially zero) equivalence class
 // with respect to the rest of the builder's realizations.
+// The caller must hold b.mu.
 func (b *builder) isCompatible(pe pathAndEquivalenceClass) bool {
 	if pe.equivalenceClass.isZero() {
 		// Sources can't conflict.
@@ -690,13 +862,17 @@ func (b *builder) isCompatible(pe pathAndEquivalenceClass) bool {
 // b.drvHashes must have a non-zero value for drvPath before calling do
 // (which implies the caller realized all of the derivation's inputs)
 // or else do returns an error.
+// do is safe to call concurrently from multiple goroutines
+// for different derivations.
 func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets.Set[string], keepFailed bool) (err error) {
 	startTime := time.Now()
 	drv := b.derivations[drvPath]
 	if drv == nil {
 		return fmt.Errorf("build %s: unknown derivation", drvPath)
 	}
+	b.mu.Lock()
 	drvHash := b.drvHashes[drvPath]
+	b.mu.Unlock()
 	if drvHash.IsZero() {
 		return fmt.Errorf("build %s: missing hash", drvPath)
 	}
@@ -710,6 +886,12 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		}
 	}
 
+	// Fixed outputs are verified against their content address,
+	// so there's nothing to gain from building them again.
+	_, fixedOutputError := drv.OutputPath(zbstore.DefaultDerivationOutputName)
+	checking := b.check.Has(drvPath) && fixedOutputError != nil
+	var priorRealizations map[string]sets.Set[zbstore.Path]
+
 	conn, err := b.server.db.Get(ctx)
 	if err != nil {
 		return err
@@ -719,6 +901,8 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 	var buildResultID int64
 	hasExisting := false
 	err = func() (err error) {
+		b.mu.Lock()
+		defer b.mu.Unlock()
 		endFn, err := sqlitex.ImmediateTransaction(conn)
 		if err != nil {
 			return err
@@ -739,6 +923,12 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 			}
 		})
 		reuseError := b.fetchRealizationSet(ctx, conn, wantEqClasses)
+		if checking && reuseError == nil {
+			priorRealizations, err = findAllRealizations(ctx, conn, drvHash, outputNames)
+			if err != nil {
+				return fmt.Errorf("build %s: %v", drvPath, err)
+			}
+		}
 
 		// Regardless of whether the realization search succeeded or not,
 		// we want to set outputs for this build results during this transaction.
@@ -763,6 +953,11 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 			return err
 		}
 
+		if checking && (reuseError == nil || errors.Is(reuseError, errRealizationNotFound)) {
+			// Build again to compare against the realizations we found.
+			// If there aren't any, the check fails below.
+			return nil
+		}
 		if !errors.Is(reuseError, errRealizationNotFound) {
 			// If there was a realization or the search had an abnormal failure,
 			// we can finalize the result inside this transaction.
@@ -782,6 +977,7 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 
 		return nil
 	}()
+	b.server.notifyBuildUpdate(b.id)
 	if err != nil {
 		return fmt.Errorf("build %s: %v", drvPath, err)
 	}
@@ -789,6 +985,12 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		return nil
 	}
 	defer func() {
+		// Record the result even if the build was canceled.
+		recordCtx, cancel := xcontext.KeepAlive(ctx, 30*time.Second)
+		defer cancel()
+		oldDone := conn.SetInterrupt(recordCtx.Done())
+		defer conn.SetInterrupt(oldDone)
+
 		endFn, txError := sqlitex.ImmediateTransaction(conn)
 		if txError != nil {
 			log.Warnf(ctx, "For build %s: %v", drvPath, txError)
@@ -805,11 +1007,24 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		if finalizeError != nil {
 			log.Warnf(ctx, "For build %s: %v", drvPath, finalizeError)
 		}
+		b.server.notifyBuildUpdate(b.id)
 	}()
 
+	if checking && priorRealizations == nil {
+		return fmt.Errorf("build %s: %w", drvPath, builderFailure{
+			fmt.Errorf("no realization in store to check against (realize without checking first)"),
+		})
+	}
+
 	// If fixed output, acquire write lock on output path.
 	var unlockFixedOutput func()
 	if outputPath, err := drv.OutputPath(zbstore.DefaultDerivationOutputName); err == nil {
+		// Substitution imports through the same write lock,
+		// so it must happen before we acquire the lock.
+		if err := b.server.substitute(ctx, b.roots, outputPath); err != nil && !errors.Is(err, errNotSubstitutable) {
+			log.Warnf(ctx, "For build %s: %v", drvPath, err)
+		}
+
 		log.Debugf(ctx, "%s has fixed output %s. Waiting for lock to check for reuse...", drvPath, outputPath)
 		unlock, err := b.server.writing.lock(ctx, outputPath)
 		if err != nil {
@@ -826,6 +1041,7 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		_, err = os.Lstat(b.server.realPath(outputPath))
 		log.Debugf(ctx, "%s exists=%t (output of %s)", outputPath, err == nil, drvPath)
 		if err == nil {
+			b.roots.add(outputPath)
 			outputs := map[string]realizationOutput{
 				zbstore.DefaultDerivationOutputName: {
 					path: outputPath,
@@ -840,22 +1056,21 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		if !errors.Is(err, os.ErrNotExist) {
 			return fmt.Errorf("build %s: %v", drvPath, err)
 		}
+	} else if !checking {
+		err := b.substituteOutputs(ctx, conn, drvPath, drvHash, outputNames, buildResultID)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, errNotSubstitutable) {
+			log.Warnf(ctx, "For build %s: %v", drvPath, err)
+		}
 	}
 
-	// Verify that builder can run.
-	if !canBuildLocally(drv) {
-		return fmt.Errorf("build %s: a %s system is required, but host is a %v system",
-			drvPath, drv.System, system.Current())
-	}
+	// Verify that inputs are present.
 	buildSystemDeps := drv.Env[buildSystemDepsVar]
 	if hasPlaceholders(drv, buildSystemDeps) {
 		return fmt.Errorf("build %s: %s contains placeholders", drvPath, buildSystemDeps)
 	}
-	for dep := range strings.FieldsSeq(buildSystemDeps) {
-		if !xmaps.HasKey(b.server.sandboxPaths, dep) {
-			return fmt.Errorf("build %s: system dependency %s not allowed", drvPath, buildSystemDeps)
-		}
-	}
 	for _, input := range drv.InputSources.All() {
 		log.Debugf(ctx, "Waiting for lock on %s (input to %s)...", input, drvPath)
 		unlockInput, err := b.server.writing.lock(ctx, input)
@@ -866,8 +1081,33 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		unlockInput()
 		log.Debugf(ctx, "%s exists=%t (input to %s)", input, err == nil, drvPath)
 		if err != nil {
-			// TODO(someday): Import from substituter if not found.
-			return fmt.Errorf("build %s: input %s not present (%v)", drvPath, input, err)
+			if subError := b.server.substitute(ctx, b.roots, input); subError != nil {
+				if !errors.Is(subError, errNotSubstitutable) {
+					log.Warnf(ctx, "For build %s: %v", drvPath, subError)
+				}
+				return fmt.Errorf("build %s: input %s not present (%v)", drvPath, input, err)
+			}
+		}
+	}
+
+	// Checks are always built locally
+	// so that the outputs can be compared with the local store's realizations.
+	if b.server.remoteBuilders != nil && drv.System != builtinSystem && !checking {
+		err := b.buildRemote(ctx, conn, drvPath, drvHash, buildResultID, keepFailed, unlockFixedOutput)
+		if !errors.Is(err, errNoRemoteBuilder) {
+			return err
+		}
+		log.Debugf(ctx, "Building %s locally: %v", drvPath, err)
+	}
+
+	// Verify that builder can run.
+	if !canBuildLocally(drv) {
+		return fmt.Errorf("build %s: a %s system is required, but host is a %v system",
+			drvPath, drv.System, system.Current())
+	}
+	for dep := range strings.FieldsSeq(buildSystemDeps) {
+		if !xmaps.HasKey(b.server.sandboxPaths, dep) {
+			return fmt.Errorf("build %s: system dependency %s not allowed", drvPath, buildSystemDeps)
 		}
 	}
 	buildUser, err := b.server.users.acquire(ctx)
@@ -914,6 +1154,7 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 	}
 	inputPaths := sets.CollectSorted(maps.Keys(inputs))
 	outputs := make(map[string]realizationOutput)
+	checkPaths := make(map[string]zbstore.Path)
 	for outputName, tempOutputPath := range tempOutPaths {
 		ref := zbstore.OutputReference{
 			DrvPath:    drvPath,
@@ -924,8 +1165,14 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 			return fmt.Errorf("build %s: %v", drvPath, err)
 		}
 		delete(tempOutPaths, outputName) // No longer needs cleanup if we fail.
+		if checking {
+			checkPaths[outputName] = info.StorePath
+			continue
+		}
 
+		b.mu.Lock()
 		prev, previouslyRealized := b.realizations[newEquivalenceClass(drvHash, outputName)]
+		b.mu.Unlock()
 		if previouslyRealized && info.StorePath != prev.path {
 			// This should have been prevented at a higher level,
 			// but we do a safety check here anyway.
@@ -947,6 +1194,10 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 		}
 	}
 
+	if checking {
+		return b.recordCheck(ctx, conn, drvPath, drvHash, buildResultID, priorRealizations, checkPaths)
+	}
+
 	// Record realizations.
 	if err := b.recordRealizations(ctx, conn, drvHash, buildResultID, outputs); err != nil {
 		return fmt.Errorf("build %s: %v", drvPath, err)
@@ -962,6 +1213,66 @@ func (b *builder) do(ctx context.Context, drvPath zbstore.Path, outputNames sets
 	return nil
 }
 
+// findAllRealizations returns the paths of all the realizations
+// of the given derivation outputs recorded in the store,
+// regardless of whether they are present in the store.
+func findAllRealizations(ctx context.Context, conn *sqlite.Conn, drvHash nix.Hash, outputNames sets.Set[string]) (map[string]sets.Set[zbstore.Path], error) {
+	result := make(map[string]sets.Set[zbstore.Path])
+	for outputName := range outputNames.All() {
+		presentInStore, absentFromStore, err := findPossibleRealizations(ctx, conn, newEquivalenceClass(drvHash, outputName))
+		if err != nil {
+			return nil, err
+		}
+		presentInStore.AddSeq(absentFromStore.All())
+		result[outputName] = presentInStore
+	}
+	return result, nil
+}
+
+// recordCheck records the outputs produced by building a derivation again in check mode
+// and returns a [builderFailure] if any of the outputs
+// do not match one of the derivation's prior realizations.
+func (b *builder) recordCheck(ctx context.Context, conn *sqlite.Conn, drvPath zbstore.Path, drvHash nix.Hash, buildResultID int64, priorRealizations map[string]sets.Set[zbstore.Path], checkPaths map[string]zbstore.Path) error {
+	err := func() (err error) {
+		endFn, err := sqlitex.ImmediateTransaction(conn)
+		if err != nil {
+			return err
+		}
+		defer b.server.notifyBuildUpdate(b.id)
+		defer endFn(&err)
+		for outputName, checkPath := range checkPaths {
+			if err := setBuildResultCheckOutput(conn, buildResultID, outputName, checkPath); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		return fmt.Errorf("build %s: %v", drvPath, err)
+	}
+
+	var mismatches []string
+	for _, outputName := range xmaps.SortedKeys(checkPaths) {
+		checkPath := checkPaths[outputName]
+		prior, requested := priorRealizations[outputName]
+		if !requested || prior.Has(checkPath) {
+			continue
+		}
+		prev, _ := b.lookup(zbstore.OutputReference{
+			DrvPath:    drvPath,
+			OutputName: outputName,
+		})
+		mismatches = append(mismatches, fmt.Sprintf("output %s is %s (previously %s)", outputName, checkPath, prev))
+	}
+	if len(mismatches) > 0 {
+		return fmt.Errorf("build %s: %w", drvPath, builderFailure{
+			fmt.Errorf("not deterministic: %s", strings.Join(mismatches, ", ")),
+		})
+	}
+	log.Infof(ctx, "Checked %s: outputs match previous realizations of %v", drvPath, drvHash)
+	return nil
+}
+
 // inputs computes the closure of all inputs used by the derivation at drvPath.
 func (b *builder) inputs(conn *sqlite.Conn, drvPath zbstore.Path) (map[zbstore.Path]sets.Set[equivalenceClass], error) {
 	drv := b.derivations[drvPath]
@@ -969,13 +1280,16 @@ func (b *builder) inputs(conn *sqlite.Conn, drvPath zbstore.Path) (map[zbstore.P
 		return nil, fmt.Errorf("input closure for %s: unknown derivation", drvPath)
 	}
 	result := make(map[zbstore.Path]sets.Set[equivalenceClass])
+	b.mu.Lock()
 	for input := range drv.InputDerivationOutputs() {
 		eqClass, ok := b.toEquivalenceClass(input)
 		if !ok {
+			b.mu.Unlock()
 			return nil, fmt.Errorf("input closure for %s: missing derivation hash for %v", drvPath, input)
 		}
 		out, ok := b.realizations[eqClass]
 		if !ok {
+			b.mu.Unlock()
 			return nil, fmt.Errorf("input closure for %s: missing realization for %v", drvPath, input)
 		}
 		for refPath, refClasses := range out.closure {
@@ -987,6 +1301,7 @@ func (b *builder) inputs(conn *sqlite.Conn, drvPath zbstore.Path) (map[zbstore.P
 			dst.AddSeq(refClasses.All())
 		}
 	}
+	b.mu.Unlock()
 
 	rollback, err := readonlySavepoint(conn)
 	if err != nil {
@@ -1056,6 +1371,19 @@ type builderInvocation struct {
 	// to paths on the host machine.
 	// For sandboxed runners, these paths will be made available inside the sandbox.
 	sandboxPaths map[string]string
+	// cgroup is the cgroup that the builder should be started in.
+	// If nil, then the builder is not run in a separate cgroup.
+	cgroup *buildCgroup
+}
+
+// resourceUsage is the set of resources consumed by a builder.
+type resourceUsage struct {
+	// peakMemory is the maximum number of bytes of memory used at once.
+	peakMemory zbstorerpc.Nullable[int64]
+	// cpuTime is the total CPU time used across all of the builder's processes.
+	cpuTime zbstorerpc.Nullable[time.Duration]
+	// oomKilled is true if a process was killed for exceeding the memory limit.
+	oomKilled bool
 }
 
 // builderLogInterval is the maximum time between flushes of the builder log.
@@ -1075,6 +1403,10 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 	if err != nil {
 		return nil, fmt.Errorf("build %s: %v", drvPath, err)
 	}
+	// The builder creates its outputs in the store directory
+	// before they are recorded in the database,
+	// so mark them as in use to keep store verification from treating them as orphans.
+	b.roots.add(slices.Collect(maps.Values(outPaths))...)
 	if log.IsEnabled(log.Debug) {
 		log.Debugf(ctx, "Output map for %s: %s", drvPath, formatOutputPaths(outPaths))
 	}
@@ -1126,12 +1458,45 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 	))
 	expandedDrv := expandDerivationPlaceholders(r, drv)
 
+	timeout, maxSilentTime, err := builderTimeLimits(drv.Env, b.server.timeout, b.server.maxSilentTime)
+	if err != nil {
+		if _, err := fmt.Fprintf(logFile, "*** Build failed: %v\n", err); err != nil {
+			log.Debugf(ctx, "While writing build failure: %v", err)
+		}
+		return nil, fmt.Errorf("build %s: %w", drvPath, builderFailure{err})
+	}
+	runCtx, cancelRun := context.WithCancelCause(ctx)
+	defer cancelRun(nil)
+	if timeout > 0 {
+		var cancelTimeout context.CancelFunc
+		runCtx, cancelTimeout = context.WithTimeoutCause(
+			runCtx,
+			timeout,
+			builderFailure{fmt.Errorf("%w after %v", errBuilderTimedOut, timeout)},
+		)
+		defer cancelTimeout()
+	}
+
+	var cgroup *buildCgroup
+	if b.server.cgroupDir != "" {
+		cgroup, err = newBuildCgroup(b.server.cgroupDir, drvName, &b.server.limits)
+		if err != nil {
+			return nil, fmt.Errorf("build %s: %v", drvPath, err)
+		}
+	}
+
 	log.Debugf(ctx, "Starting builder for %s...", drvPath)
 	if err := recordBuilderStart(conn, buildResultID, time.Now()); err != nil {
 		log.Warnf(ctx, "For %s: %v", drvPath, err)
 	}
 	startedRun = true
-	builderError := f(ctx, &builderInvocation{
+	stopWatchingLog := func() {}
+	if maxSilentTime > 0 {
+		stopWatchingLog = watchBuilderLog(logFile, maxSilentTime, func() {
+			cancelRun(builderFailure{fmt.Errorf("%w: no output for %v", errBuilderTimedOut, maxSilentTime)})
+		})
+	}
+	builderError := f(runCtx, &builderInvocation{
 		derivation:     expandedDrv,
 		derivationPath: drvPath,
 		outputPaths:    outPaths,
@@ -1142,6 +1507,7 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 		user:         buildUser,
 		sandboxPaths: filterSandboxPaths(b.server.sandboxPaths, drv.Env[buildSystemDepsVar]),
 		cores:        b.server.coresPerBuild,
+		cgroup:       cgroup,
 
 		lookup: b.lookup,
 		closure: func(path zbstore.Path, yield func(zbstore.Path) bool) error {
@@ -1152,6 +1518,21 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 		},
 	})
 	builderEndTime := time.Now()
+	stopWatchingLog()
+	if builderError != nil && ctx.Err() == nil {
+		// If the builder was stopped because of a time limit,
+		// report that instead of the error from the stopped process.
+		if cause := context.Cause(runCtx); isBuilderTimeout(cause) {
+			builderError = cause
+		}
+	}
+	usage := cgroup.usage()
+	if err := cgroup.close(); err != nil {
+		log.Warnf(ctx, "For %s: %v", drvPath, err)
+	}
+	if builderError != nil && isBuilderFailure(builderError) && usage != nil && usage.oomKilled {
+		builderError = builderFailure{fmt.Errorf("%v (exceeded memory limit)", builderError)}
+	}
 
 	if builderError == nil {
 		// Verify that builder produced all outputs.
@@ -1187,7 +1568,7 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 		}
 	}
 
-	if err := recordBuilderEnd(conn, buildResultID, builderEndTime); err != nil {
+	if err := recordBuilderEnd(conn, buildResultID, builderEndTime, usage); err != nil {
 		log.Warnf(ctx, "For %s: %v", drvPath, err)
 	}
 	if builderError != nil {
@@ -1207,6 +1588,89 @@ func (b *builder) runBuilder(ctx context.Context, conn *sqlite.Conn, drvPath zbs
 	return outPaths, nil
 }
 
+// errBuilderTimedOut is wrapped by the errors returned from [*builder.runBuilder]
+// when the builder exceeds one of its time limits.
+var errBuilderTimedOut = errors.New("timed out")
+
+func isBuilderTimeout(err error) bool {
+	return errors.Is(err, errBuilderTimedOut)
+}
+
+// builderTimeLimits returns the time limits for a derivation's builder.
+// The derivation's __timeout and __maxSilentTime environment variables
+// override the given defaults.
+func builderTimeLimits(env map[string]string, defaultTimeout, defaultMaxSilentTime time.Duration) (timeout, maxSilentTime time.Duration, err error) {
+	timeout, err = parseSecondsVar(env, timeoutVar, defaultTimeout)
+	if err != nil {
+		return 0, 0, err
+	}
+	maxSilentTime, err = parseSecondsVar(env, maxSilentTimeVar, defaultMaxSilentTime)
+	if err != nil {
+		return 0, 0, err
+	}
+	return timeout, maxSilentTime, nil
+}
+
+// parseSecondsVar parses the environment variable with the given name
+// as a non-negative number of seconds.
+// If the variable is not set, parseSecondsVar returns defaultValue.
+func parseSecondsVar(env map[string]string, name string, defaultValue time.Duration) (time.Duration, error) {
+	s, ok := env[name]
+	if !ok {
+		return defaultValue, nil
+	}
+	n, err := strconv.ParseFloat(s, 64)
+	if err != nil || !(n >= 0 && n <= math.MaxInt64/float64(time.Second)) {
+		return 0, fmt.Errorf("%s: %q is not a valid number of seconds", name, s)
+	}
+	return time.Duration(n * float64(time.Second)), nil
+}
+
+// builderLogCheckInterval is the maximum time between checks of the builder log's size
+// for enforcing the max silent time.
+const builderLogCheckInterval = 1 * time.Second
+
+// watchBuilderLog starts a goroutine that calls stop
+// if f does not grow in size for longer than maxSilentTime.
+// The returned function stops the goroutine and waits for it to exit.
+func watchBuilderLog(f *os.File, maxSilentTime time.Duration, stop func()) (cancel func()) {
+	done := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		ticker := time.NewTicker(min(maxSilentTime, builderLogCheckInterval))
+		defer ticker.Stop()
+		var lastSize int64 = -1
+		if info, err := f.Stat(); err == nil {
+			lastSize = info.Size()
+		}
+		lastChange := time.Now()
+		for {
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+			info, err := f.Stat()
+			if err != nil {
+				continue
+			}
+			now := time.Now()
+			if size := info.Size(); size != lastSize {
+				lastSize = size
+				lastChange = now
+			} else if now.Sub(lastChange) >= maxSilentTime {
+				stop()
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-exited
+	}
+}
+
 // runSubprocess runs a builder by running a subprocess.
 // It satisfies the [runnerFunc] signature.
 func runSubprocess(ctx context.Context, invocation *builderInvocation) error {
@@ -1225,6 +1689,7 @@ func runSubprocess(ctx context.Context, invocation *builderInvocation) error {
 	c.Stdout = invocation.logWriter
 	c.Stderr = invocation.logWriter
 	c.SysProcAttr = sysProcAttrForUser(invocation.user)
+	invocation.cgroup.attach(c)
 
 	if err := c.Run(); err != nil {
 		return builderFailure{err}
@@ -1372,6 +1837,7 @@ func (b *builder) postprocess(ctx context.Context, conn *sqlite.Conn, output zbs
 // and verifies that it matches the content address.
 func (b *builder) postprocessFixedOutput(ctx context.Context, conn *sqlite.Conn, outputPath zbstore.Path, ca zbstore.ContentAddress) (info *ObjectInfo, err error) {
 	log.Debugf(ctx, "Verifying fixed output %s...", outputPath)
+	b.roots.add(outputPath)
 
 	realOutputPath := b.server.realPath(outputPath)
 	wc := new(xio.WriteCounter)
@@ -1437,6 +1903,7 @@ func (b *builder) postprocessFloatingOutput(ctx context.Context, conn *sqlite.Co
 	}
 	defer unlock()
 	log.Debugf(ctx, "Acquired lock on %s", finalPath)
+	b.roots.add(finalPath)
 
 	info := &ObjectInfo{
 		StorePath:  finalPath,
@@ -1653,10 +2120,13 @@ func rewriteAtPath(path string, baseOffset int64, newDigest string, rewriters []
 // and on success, saves the realizations into b.realizations.
 // The outputs must exist in the store.
 func (b *builder) recordRealizations(ctx context.Context, conn *sqlite.Conn, drvHash nix.Hash, buildResultID int64, outputs map[string]realizationOutput) (err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
 	endFn, err := sqlitex.ImmediateTransaction(conn)
 	if err != nil {
 		return fmt.Errorf("record realizations for %v: %v", drvHash, err)
 	}
+	defer b.server.notifyBuildUpdate(b.id)
 	defer endFn(&err)
 
 	if err := recordRealizations(ctx, conn, drvHash, outputs); err != nil {
@@ -6,6 +6,7 @@ package backend
 import (
 	"context"
 	"fmt"
+	"runtime"
 )
 
 func defaultSystemCertFile() (string, error) {
@@ -15,3 +16,7 @@ func defaultSystemCertFile() (string, error) {
 func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 	return fmt.Errorf("TODO(someday)")
 }
+
+func checkSandbox() error {
+	return fmt.Errorf("sandboxing not supported on %s", runtime.GOOS)
+}
@@ -6,14 +6,14 @@ package backend
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"iter"
 	"maps"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"syscall"
+	"slices"
 
 	"golang.org/x/sys/unix"
 	"zb.256lights.llc/pkg/internal/osutil"
@@ -74,10 +74,18 @@ func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 	if err := os.Mkdir(chrootDir, 0o755); err != nil {
 		return err
 	}
+	rootless := os.Geteuid() != 0
 	defer func() {
-		// The chroot is expected to contain bind mounts,
-		// so we carefully unmount as we remove the directory.
-		if err := osutil.UnmountAndRemoveAll(chrootDir); err != nil {
+		var err error
+		if rootless {
+			// Mounts are only created inside the sandbox's mount namespace.
+			err = os.RemoveAll(chrootDir)
+		} else {
+			// The chroot is expected to contain bind mounts,
+			// so we carefully unmount as we remove the directory.
+			err = osutil.UnmountAndRemoveAll(chrootDir)
+		}
+		if err != nil {
 			log.Errorf(ctx, "Failed to clean up: %v", err)
 		}
 	}()
@@ -104,25 +112,42 @@ func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 		opts.builderUID = invocation.user.UID
 		opts.builderGID = invocation.user.GID
 	}
-	if err := setupSandboxFilesystem(ctx, chrootDir, opts); err != nil {
+	cfg := &sandboxInitConfig{
+		Root:          chrootDir,
+		WorkDir:       workDir,
+		UID:           opts.builderUID,
+		GID:           opts.builderGID,
+		Loopback:      !opts.network,
+		UserNamespace: rootless,
+		Builder:       invocation.derivation.Builder,
+		Args:          invocation.derivation.Args,
+	}
+	if rootless {
+		// Without root, we can only create mounts from inside a user namespace,
+		// so the sandbox's init process sets up the filesystem.
+		opts.rootless = true
+		opts.builderUID = rootlessBuilderUID
+		opts.builderGID = rootlessBuilderGID
+		cfg.UID = rootlessBuilderUID
+		cfg.GID = rootlessBuilderGID
+		cfg.Filesystem = opts
+	} else if err := setupSandboxFilesystem(ctx, chrootDir, opts); err != nil {
 		return err
 	}
 
-	c := exec.CommandContext(ctx, invocation.derivation.Builder, invocation.derivation.Args...)
+	c, err := sandboxCommand(ctx, cfg, opts.network)
+	if err != nil {
+		return err
+	}
 	setCancelFunc(c)
 	env := maps.Clone(invocation.derivation.Env)
 	fillBaseEnv(env, invocation.derivation.Dir, workDir, invocation.cores)
 	for k, v := range xmaps.Sorted(env) {
 		c.Env = append(c.Env, k+"="+v)
 	}
-	c.Dir = workDir
 	c.Stdout = invocation.logWriter
 	c.Stderr = invocation.logWriter
-	c.SysProcAttr = sysProcAttrForUser(invocation.user)
-	if c.SysProcAttr == nil {
-		c.SysProcAttr = new(syscall.SysProcAttr)
-	}
-	c.SysProcAttr.Chroot = chrootDir
+	invocation.cgroup.attach(c)
 
 	if err := c.Run(); err != nil {
 		return builderFailure{err}
@@ -165,6 +190,67 @@ type linuxSandboxOptions struct {
 	network bool
 	caFile  string
 	shmSize string
+
+	// rootless is true if the sandbox is being created inside a user namespace
+	// by a store server that is not running as root.
+	rootless bool
+}
+
+type jsonLinuxSandboxOptions struct {
+	StoreDir     zbstore.Directory `json:"storeDir"`
+	RealStoreDir string            `json:"realStoreDir"`
+	Inputs       []zbstore.Path    `json:"inputs"`
+	WorkDir      string            `json:"workDir"`
+	RealWorkDir  string            `json:"realWorkDir"`
+	Extra        map[string]string `json:"extra,omitempty"`
+	BuilderUID   int               `json:"builderUID"`
+	BuilderGID   int               `json:"builderGID"`
+	Network      bool              `json:"network"`
+	CAFile       string            `json:"caFile,omitempty"`
+	ShmSize      string            `json:"shmSize,omitempty"`
+	Rootless     bool              `json:"rootless"`
+}
+
+// MarshalJSON encodes the options as a JSON object
+// so that they can be sent to the sandbox's init process.
+func (opts *linuxSandboxOptions) MarshalJSON() ([]byte, error) {
+	return json.Marshal(&jsonLinuxSandboxOptions{
+		StoreDir:     opts.storeDir,
+		RealStoreDir: opts.realStoreDir,
+		Inputs:       slices.Sorted(opts.inputs.All()),
+		WorkDir:      opts.workDir,
+		RealWorkDir:  opts.realWorkDir,
+		Extra:        opts.extra,
+		BuilderUID:   opts.builderUID,
+		BuilderGID:   opts.builderGID,
+		Network:      opts.network,
+		CAFile:       opts.caFile,
+		ShmSize:      opts.shmSize,
+		Rootless:     opts.rootless,
+	})
+}
+
+// UnmarshalJSON decodes options encoded by [*linuxSandboxOptions.MarshalJSON].
+func (opts *linuxSandboxOptions) UnmarshalJSON(data []byte) error {
+	var parsed jsonLinuxSandboxOptions
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return err
+	}
+	*opts = linuxSandboxOptions{
+		storeDir:     parsed.StoreDir,
+		realStoreDir: parsed.RealStoreDir,
+		inputs:       sets.New(parsed.Inputs...),
+		workDir:      parsed.WorkDir,
+		realWorkDir:  parsed.RealWorkDir,
+		extra:        parsed.Extra,
+		builderUID:   parsed.BuilderUID,
+		builderGID:   parsed.BuilderGID,
+		network:      parsed.Network,
+		caFile:       parsed.CAFile,
+		shmSize:      parsed.ShmSize,
+		rootless:     parsed.Rootless,
+	}
+	return nil
 }
 
 // setupSandboxFilesystem creates a sandbox filesystem inside an existing directory.
@@ -219,14 +305,29 @@ func setupSandboxFilesystem(ctx context.Context, dir string, opts *linuxSandboxO
 			}
 		}
 	}
-	if err := os.Chmod(etcDir, 0o555); err != nil {
-		return err
+	if !opts.rootless {
+		// In a user namespace, the builder owns /etc (so it could undo this anyway)
+		// and the server would not be able to clean up the sandbox.
+		if err := os.Chmod(etcDir, 0o555); err != nil {
+			return err
+		}
 	}
 
+	// Use a private tmpfs for /dev so that the builder cannot create device nodes
+	// on the store's filesystem and only sees the devices we bind-mount.
 	devDir := filepath.Join(dir, "dev")
 	if err := osutil.MkdirPerm(devDir, 0o755); err != nil {
 		return err
 	}
+	const devMountOpts = "mode=0755"
+	log.Debugf(ctx, "mount -t tmpfs -o %s none %s", devMountOpts, devDir)
+	if err := unix.Mount("none", devDir, "tmpfs", unix.MS_NOSUID|unix.MS_NOEXEC, devMountOpts); err != nil {
+		return &os.PathError{
+			Op:   "mount tmpfs",
+			Path: devDir,
+			Err:  err,
+		}
+	}
 	if exists("/dev/shm") {
 		shmDir := filepath.Join(devDir, "shm")
 		if err := osutil.MkdirPerm(shmDir, 0o755); err != nil {
@@ -295,17 +396,11 @@ func setupSandboxFilesystem(ctx context.Context, dir string, opts *linuxSandboxO
 		}
 	}
 
-	procDir := filepath.Join(dir, "proc")
-	if err := osutil.MkdirPerm(procDir, 0o755); err != nil {
+	// /proc is mounted by the sandbox's init process
+	// once it is inside the builder's PID namespace.
+	if err := osutil.MkdirPerm(filepath.Join(dir, "proc"), 0o755); err != nil {
 		return err
 	}
-	if err := unix.Mount("none", procDir, "proc", 0, ""); err != nil {
-		return &os.PathError{
-			Op:   "mount proc",
-			Path: procDir,
-			Err:  err,
-		}
-	}
 
 	// Create writable store directory.
 	storeDir := filepath.Join(dir, string(opts.storeDir))
@@ -315,16 +410,23 @@ func setupSandboxFilesystem(ctx context.Context, dir string, opts *linuxSandboxO
 	if err := osutil.MkdirPerm(storeDir, 0o775|os.ModeSticky); err != nil {
 		return err
 	}
-	if err := os.Chown(storeDir, opts.builderUID, opts.builderGID); err != nil {
-		return err
+	if !opts.rootless {
+		// In a user namespace, the directory is already owned by the builder
+		// and the builder's UID is not mapped.
+		if err := os.Chown(storeDir, opts.builderUID, opts.builderGID); err != nil {
+			return err
+		}
 	}
 	// Bind-mount input paths.
+	// Store objects belong to the server's user,
+	// so the mounts are read-only to prevent builders
+	// running as the same user from modifying them.
 	for input := range opts.inputs {
 		if inputDir := input.Dir(); inputDir != opts.storeDir {
 			return fmt.Errorf("input %s is not inside %s", input, opts.storeDir)
 		}
 		dst := filepath.Join(dir, string(input))
-		if err := bindMount(ctx, filepath.Join(opts.realStoreDir, input.Base()), dst); err != nil {
+		if err := bindMountReadOnly(ctx, filepath.Join(opts.realStoreDir, input.Base()), dst); err != nil {
 			return err
 		}
 	}
@@ -428,6 +530,36 @@ func bindMount(ctx context.Context, oldname, newname string) (err error) {
 	return nil
 }
 
+// bindMountReadOnly creates a read-only bind mount of oldname at newname.
+// Its behavior is otherwise the same as [bindMount].
+func bindMountReadOnly(ctx context.Context, oldname, newname string) error {
+	if err := bindMount(ctx, oldname, newname); err != nil {
+		return err
+	}
+	info, err := os.Lstat(oldname)
+	if err != nil {
+		return err
+	}
+	if info.Mode().Type() == os.ModeSymlink {
+		// bindMount created a symlink instead of a mount.
+		return nil
+	}
+
+	// Remounting requires preserving flags of the original mount
+	// that are locked inside a user namespace.
+	var st unix.Statfs_t
+	if err := unix.Statfs(newname, &st); err != nil {
+		return &os.PathError{Op: "statfs", Path: newname, Err: err}
+	}
+	const preservedFlags = unix.MS_NOSUID | unix.MS_NODEV | unix.MS_NOEXEC | unix.MS_NOATIME | unix.MS_NODIRATIME | unix.MS_RELATIME
+	flags := uintptr(st.Flags)&preservedFlags | unix.MS_REMOUNT | unix.MS_BIND | unix.MS_RDONLY
+	log.Debugf(ctx, "mount -o remount,bind,ro %s", newname)
+	if err := unix.Mount("", newname, "", flags, ""); err != nil {
+		return &os.PathError{Op: "remount read-only", Path: newname, Err: err}
+	}
+	return nil
+}
+
 func linuxNetworkBindMounts(etcDir string, opts *linuxSandboxOptions) iter.Seq2[string, string] {
 	return func(yield func(string, string) bool) {
 		if !yield(filepath.Join(etcDir, "resolv.conf"), "/etc/resolv.conf") {
@@ -6,9 +6,11 @@ package backend_test
 import (
 	"bytes"
 	"fmt"
+	"maps"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"reflect"
 	"runtime"
 	"strings"
@@ -216,6 +218,178 @@ func TestRealizeReuse(t *testing.T) {
 	checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
 }
 
+func TestRealizeCheck(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses /bin/sh")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	deterministicDrvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+		Name:    "deterministic.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", "echo 'Hello, World!' > $out"},
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The non-deterministic builder appends a line to a file outside the store
+	// (builds in tests are not sandboxed)
+	// and copies the file to its output using only shell builtins,
+	// so each build produces a different output.
+	counterPath := filepath.Join(t.TempDir(), "counter")
+	nondeterministicDrvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+		Name:    "nondeterministic.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", `echo x >> "$counter" && while read -r line; do echo "$line"; done < "$counter" > "$out"`},
+		Env: map[string]string{
+			"counter": counterPath,
+			"out":     zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	unbuiltDrvPath, _, err := storetest.ExportDerivation(exporter, &zbstore.Derivation{
+		Name:    "unbuilt.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", "echo 'Goodbye, World!' > $out"},
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realize := func(t *testing.T, drvPath zbstore.Path, check bool) *zbstorerpc.BuildResult {
+		t.Helper()
+		realizeResponse := new(zbstorerpc.RealizeResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+			DrvPaths: []zbstore.Path{drvPath},
+			Check:    check,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		build, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := build.ResultForPath(drvPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(result.Outputs) != 1 {
+			t.Fatalf("build result has %d outputs; want 1", len(result.Outputs))
+		}
+		return result
+	}
+
+	t.Run("Deterministic", func(t *testing.T) {
+		first := realize(t, deterministicDrvPath, false)
+		if first.Status != zbstorerpc.BuildSuccess {
+			t.Fatalf("first build status = %v; want %v", first.Status, zbstorerpc.BuildSuccess)
+		}
+		got := realize(t, deterministicDrvPath, true)
+		if got.Status != zbstorerpc.BuildSuccess {
+			t.Errorf("check status = %v (error = %q); want %v", got.Status, got.Error, zbstorerpc.BuildSuccess)
+		}
+		want := []*zbstorerpc.RealizeOutput{{
+			Name:      zbstore.DefaultDerivationOutputName,
+			Path:      first.Outputs[0].Path,
+			CheckPath: first.Outputs[0].Path,
+		}}
+		if diff := cmp.Diff(want, got.Outputs); diff != "" {
+			t.Errorf("outputs (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Nondeterministic", func(t *testing.T) {
+		first := realize(t, nondeterministicDrvPath, false)
+		if first.Status != zbstorerpc.BuildSuccess {
+			t.Fatalf("first build status = %v; want %v", first.Status, zbstorerpc.BuildSuccess)
+		}
+		got := realize(t, nondeterministicDrvPath, true)
+		if got.Status != zbstorerpc.BuildFail {
+			t.Errorf("check status = %v; want %v", got.Status, zbstorerpc.BuildFail)
+		}
+		if want := "not deterministic"; !strings.Contains(got.Error, want) {
+			t.Errorf("check error = %q; want to contain %q", got.Error, want)
+		}
+		output := got.Outputs[0]
+		if output.Path != first.Outputs[0].Path {
+			t.Errorf("after check, path = %v; want %v", output.Path, first.Outputs[0].Path)
+		}
+		if !output.CheckPath.Valid || output.CheckPath == output.Path {
+			t.Fatalf("after check, checkPath = %v; want a path different from %v", output.CheckPath, output.Path)
+		}
+		const wantCheckContent = "x\nx\n"
+		if gotCheckContent, err := os.ReadFile(string(output.CheckPath.X)); err != nil {
+			t.Error(err)
+		} else if string(gotCheckContent) != wantCheckContent {
+			t.Errorf("%s content = %q; want %q", output.CheckPath.X, gotCheckContent, wantCheckContent)
+		}
+
+		// The divergent output must not replace the original realization.
+		again := realize(t, nondeterministicDrvPath, false)
+		if again.Status != zbstorerpc.BuildSuccess || again.Outputs[0].Path != first.Outputs[0].Path {
+			t.Errorf("after check, build = %v %v; want %v %v",
+				again.Status, again.Outputs[0].Path, zbstorerpc.BuildSuccess, first.Outputs[0].Path)
+		}
+	})
+
+	t.Run("NotBuilt", func(t *testing.T) {
+		got := realize(t, unbuiltDrvPath, true)
+		if got.Status != zbstorerpc.BuildFail {
+			t.Errorf("check status = %v; want %v", got.Status, zbstorerpc.BuildFail)
+		}
+		if got.Outputs[0].CheckPath.Valid {
+			t.Errorf("checkPath = %v; want null", got.Outputs[0].CheckPath)
+		}
+	})
+}
+
 func TestRealizeMultiStep(t *testing.T) {
 	ctx, cancel := testcontext.New(t)
 	defer cancel()
@@ -317,6 +491,183 @@ func TestRealizeMultiStep(t *testing.T) {
 	checkSingleFileOutput(t, drv2Path, wantOutputPath, []byte(wantOutputContent), got)
 }
 
+func TestRealizeParallel(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses sh")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	// Each derivation waits for the other to start,
+	// so the build can only succeed if they run concurrently.
+	rendezvousDir := t.TempDir()
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	names := []string{"left.txt", "right.txt"}
+	var drvPaths []zbstore.Path
+	for i, name := range names {
+		drvContent := &zbstore.Derivation{
+			Name:    name,
+			Dir:     dir,
+			System:  system.Current().String(),
+			Builder: shPath,
+			Args: []string{"-c", `: > "$rendezvous/$name" && ` +
+				`i=0 && ` +
+				`while [ ! -e "$rendezvous/$other" ]; do ` +
+				`i=$((i+1)); if [ $i -gt 300 ]; then echo "timed out waiting for $other" >&2; exit 1; fi; /bin/sleep 0.1; ` +
+				`done && ` +
+				`echo "$name" > "$out"`},
+			Env: map[string]string{
+				"name":       name,
+				"other":      names[1-i],
+				"rendezvous": rendezvousDir,
+				"out":        zbstore.HashPlaceholder("out"),
+			},
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		}
+		drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drvPaths = append(drvPaths, drvPath)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			MaxJobs: 2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: drvPaths,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		gotLog1, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPaths[0])
+		gotLog2, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPaths[1])
+		t.Fatalf("build failed: %v\n%s log:\n%s\n%s log:\n%s", err, names[0], gotLog1, names[1], gotLog2)
+	}
+	for i, name := range names {
+		wantOutputContent := name + "\n"
+		wantOutputPath, err := singleFileOutputPath(dir, name, []byte(wantOutputContent), zbstore.References{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkSingleFileOutput(t, drvPaths[i], wantOutputPath, []byte(wantOutputContent), got)
+	}
+}
+
+func TestRealizeParallelFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses sh")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	// One derivation fails immediately while the other would run for a long time.
+	// The failure should cancel the long-running derivation.
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	derivations := []struct {
+		name   string
+		script string
+	}{
+		{"fail.txt", "exit 1"},
+		{"slow.txt", `/bin/sleep 300 && echo done > "$out"`},
+	}
+	var drvPaths []zbstore.Path
+	for _, d := range derivations {
+		drvContent := &zbstore.Derivation{
+			Name:    d.name,
+			Dir:     dir,
+			System:  system.Current().String(),
+			Builder: shPath,
+			Args:    []string{"-c", d.script},
+			Env: map[string]string{
+				"out": zbstore.HashPlaceholder("out"),
+			},
+			Outputs: map[string]*zbstore.DerivationOutputType{
+				zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+			},
+		}
+		drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drvPaths = append(drvPaths, drvPath)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			MaxJobs: 2,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: drvPaths,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Minute {
+		t.Errorf("build took %v; want less than 2m (running derivations not canceled)", elapsed)
+	}
+	if got.Status != zbstorerpc.BuildFail {
+		t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+	}
+	if result, err := got.ResultForPath(drvPaths[0]); err != nil {
+		t.Error(err)
+	} else if result.Status != zbstorerpc.BuildFail {
+		t.Errorf("%s status = %q; want %q", drvPaths[0], result.Status, zbstorerpc.BuildFail)
+	}
+}
+
 func TestRealizeReferenceToDep(t *testing.T) {
 	ctx, cancel := testcontext.New(t)
 	defer cancel()
@@ -767,6 +1118,14 @@ func TestRealizeFailure(t *testing.T) {
 	if !got.EndedAt.Valid {
 		t.Error("build.endedAt = null")
 	}
+	if len(got.Results) > 0 {
+		if got.Results[0].Error == "" {
+			t.Error("build.results[0].error is empty")
+		}
+		if !got.Results[0].EndedAt.Valid {
+			t.Error("build.results[0].endedAt = null")
+		}
+	}
 	// Ensure that the build didn't leave files in the store.
 	storeListing, err := os.ReadDir(string(dir))
 	if err != nil {
@@ -904,6 +1263,137 @@ func TestRealizeNoOutput(t *testing.T) {
 	}
 }
 
+func TestRealizeTimeout(t *testing.T) {
+	tests := []struct {
+		name             string
+		env              map[string]string
+		script           string
+		powershellScript string
+		opts             Options
+
+		wantStatus zbstorerpc.BuildStatus
+		wantLog    string
+	}{
+		{
+			name:             "ServerTimeout",
+			script:           "echo > $out ; /bin/sleep 60",
+			powershellScript: "New-Item ${env:out} -type file ; Start-Sleep -Seconds 60",
+			opts:             Options{BuilderTimeout: 1 * time.Second},
+
+			wantStatus: zbstorerpc.BuildTimedOut,
+			wantLog:    "timed out after 1s",
+		},
+		{
+			name:             "DerivationTimeout",
+			env:              map[string]string{"__timeout": "1"},
+			script:           "echo > $out ; /bin/sleep 60",
+			powershellScript: "New-Item ${env:out} -type file ; Start-Sleep -Seconds 60",
+			opts:             Options{BuilderTimeout: 1 * time.Hour},
+
+			wantStatus: zbstorerpc.BuildTimedOut,
+			wantLog:    "timed out after 1s",
+		},
+		{
+			name:             "MaxSilentTime",
+			env:              map[string]string{"__maxSilentTime": "1"},
+			script:           "echo hello ; /bin/sleep 60",
+			powershellScript: "Write-Output hello ; Start-Sleep -Seconds 60",
+
+			wantStatus: zbstorerpc.BuildTimedOut,
+			wantLog:    "timed out: no output for 1s",
+		},
+		{
+			name:             "InvalidTimeout",
+			env:              map[string]string{"__timeout": "forever"},
+			script:           "echo > $out",
+			powershellScript: "New-Item ${env:out} -type file",
+
+			wantStatus: zbstorerpc.BuildFail,
+			wantLog:    "__timeout",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := testcontext.New(t)
+			defer cancel()
+			dir := backendtest.NewStoreDirectory(t)
+
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExporter(exportBuffer)
+			drvContent := &zbstore.Derivation{
+				Name:   "hello.txt",
+				Dir:    dir,
+				System: system.Current().String(),
+				Env: map[string]string{
+					"out": zbstore.HashPlaceholder("out"),
+				},
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+				},
+			}
+			if runtime.GOOS == "windows" {
+				drvContent.Builder = powershellPath
+				drvContent.Args = []string{"-Command", test.powershellScript}
+			} else {
+				drvContent.Builder = shPath
+				drvContent.Args = []string{"-c", test.script}
+			}
+			maps.Copy(drvContent.Env, test.env)
+			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+				Options: test.opts,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("build drv:", err)
+			}
+			got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				t.Fatal("build drv:", err)
+			}
+			if got.Status != test.wantStatus {
+				t.Errorf("build status = %q; want %q", got.Status, test.wantStatus)
+			}
+			if result, err := got.ResultForPath(drvPath); err != nil {
+				t.Error(err)
+			} else if result.Status != test.wantStatus {
+				t.Errorf("build result status = %q; want %q", result.Status, test.wantStatus)
+			}
+
+			if gotLog, err := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath); err != nil {
+				t.Error(err)
+			} else if !bytes.Contains(gotLog, []byte(test.wantLog)) {
+				t.Errorf("Log does not contain phrase %q. Full output:\n%s", test.wantLog, gotLog)
+			}
+		})
+	}
+}
+
 func TestRealizeCores(t *testing.T) {
 	tests := []int{1, 2}
 	for _, n := range tests {
@@ -1063,7 +1553,10 @@ var buildResultOption = cmp.Options{
 			return false
 		}
 		name := p.Last().(cmp.StructField).Name()
-		return name == "LogSize"
+		return name == "LogSize" ||
+			name == "StartedAt" ||
+			name == "EndedAt" ||
+			name == "Error"
 	}, cmp.Ignore()),
 }
 
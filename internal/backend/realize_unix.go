@@ -9,6 +9,7 @@ import (
 	"os/exec"
 	"strconv"
 	"syscall"
+	"time"
 
 	"golang.org/x/sys/unix"
 	"zb.256lights.llc/pkg/internal/xmaps"
@@ -41,8 +42,13 @@ func sysProcAttrForUser(user *BuildUser) *syscall.SysProcAttr {
 	}
 }
 
+// builderKillDelay is the length of time to wait for a builder to exit
+// after sending it SIGTERM before killing it.
+const builderKillDelay = 10 * time.Second
+
 func setCancelFunc(c *exec.Cmd) {
 	c.Cancel = func() error {
 		return c.Process.Signal(unix.SIGTERM)
 	}
+	c.WaitDelay = builderKillDelay
 }
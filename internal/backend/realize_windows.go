@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"os/exec"
+	"runtime"
 	"strconv"
 	"syscall"
 
@@ -40,3 +41,7 @@ func defaultSystemCertFile() (string, error) {
 func runSandboxed(ctx context.Context, invocation *builderInvocation) error {
 	return fmt.Errorf("TODO(someday)")
 }
+
+func checkSandbox() error {
+	return fmt.Errorf("sandboxing not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,691 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+	"zombiezen.com/go/nix/nar"
+	"zombiezen.com/go/sqlite"
+)
+
+// A RemoteBuilder is another zb store server
+// that the server can send derivations to build.
+// The remote store must use the same store directory as the server.
+type RemoteBuilder struct {
+	// Name identifies the builder in logs and error messages.
+	Name string
+	// Dial opens a new connection to the builder's store server.
+	Dial func(ctx context.Context) (io.ReadWriteCloser, error)
+	// Systems is the list of systems (e.g. "aarch64-unknown-linux")
+	// that the builder can build derivations for.
+	Systems []string
+	// MaxJobs is the maximum number of derivations
+	// that the server will send to the builder concurrently.
+	// If non-positive, then derivations are sent one at a time.
+	MaxJobs int
+	// SpeedFactor is the builder's speed relative to other builders.
+	// When more than one builder can build a derivation,
+	// the server picks the builder with the lowest load relative to its speed.
+	// If non-positive, then 1 is used.
+	SpeedFactor float64
+}
+
+// errNoRemoteBuilder is returned by [*builder.buildRemote]
+// when no remote builder could be reached to build a derivation.
+var errNoRemoteBuilder = errors.New("no remote builder available")
+
+const (
+	// remoteBuilderRetryDelay is the length of time that a remote builder is skipped
+	// after it fails in a way that the builder itself did not report.
+	remoteBuilderRetryDelay = 1 * time.Minute
+	// remoteBuildPollInterval is how often the status of a remote build is checked.
+	remoteBuildPollInterval = 500 * time.Millisecond
+)
+
+type remoteBuilder struct {
+	name        string
+	dial        func(ctx context.Context) (io.ReadWriteCloser, error)
+	systems     sets.Set[string] // normalized with normalizeSystem
+	maxJobs     int
+	speedFactor float64
+
+	// jobs and unavailableUntil are protected by remoteBuilderSet.mu.
+	jobs             int
+	unavailableUntil time.Time
+}
+
+func (rb *remoteBuilder) String() string {
+	return rb.name
+}
+
+// remoteBuilderSet assigns derivations to remote builders.
+type remoteBuilderSet struct {
+	mu       sync.Mutex
+	builders []*remoteBuilder
+	// released is closed and replaced whenever a job slot is released.
+	released chan struct{}
+}
+
+func newRemoteBuilderSet(builders []*RemoteBuilder) *remoteBuilderSet {
+	set := &remoteBuilderSet{released: make(chan struct{})}
+	for _, rb := range builders {
+		b := &remoteBuilder{
+			name:        rb.Name,
+			dial:        rb.Dial,
+			systems:     make(sets.Set[string]),
+			maxJobs:     max(1, rb.MaxJobs),
+			speedFactor: rb.SpeedFactor,
+		}
+		if b.speedFactor <= 0 {
+			b.speedFactor = 1
+		}
+		for _, sys := range rb.Systems {
+			b.systems.Add(normalizeSystem(sys))
+		}
+		set.builders = append(set.builders, b)
+	}
+	return set
+}
+
+// acquire reserves a job slot on the least loaded builder
+// that supports the given system and is not in skip.
+// Builders that recently failed are not considered.
+// If every such builder is busy and wait is true,
+// then acquire blocks until a slot is released.
+// Otherwise, acquire returns an error that wraps [errNoRemoteBuilder].
+// The caller is responsible for calling [*remoteBuilderSet.release]
+// with the returned builder.
+func (set *remoteBuilderSet) acquire(ctx context.Context, sys string, skip sets.Set[*remoteBuilder], wait bool) (*remoteBuilder, error) {
+	sys = normalizeSystem(sys)
+	for {
+		set.mu.Lock()
+		now := time.Now()
+		var best *remoteBuilder
+		busy := false
+		for _, rb := range set.builders {
+			if !rb.systems.Has(sys) || skip.Has(rb) || now.Before(rb.unavailableUntil) {
+				continue
+			}
+			if rb.jobs >= rb.maxJobs {
+				busy = true
+				continue
+			}
+			if best == nil || rb.load() < best.load() {
+				best = rb
+			}
+		}
+		if best != nil {
+			best.jobs++
+			set.mu.Unlock()
+			return best, nil
+		}
+		released := set.released
+		set.mu.Unlock()
+
+		if !busy || !wait {
+			return nil, fmt.Errorf("%w for %s", errNoRemoteBuilder, sys)
+		}
+		select {
+		case <-released:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// load returns the builder's load if it was given another job.
+// The caller must hold remoteBuilderSet.mu.
+func (rb *remoteBuilder) load() float64 {
+	return float64(rb.jobs+1) / rb.speedFactor
+}
+
+// release releases a job slot acquired with [*remoteBuilderSet.acquire].
+// If failed is true, then the builder will not be used
+// for the next [remoteBuilderRetryDelay].
+func (set *remoteBuilderSet) release(rb *remoteBuilder, failed bool) {
+	set.mu.Lock()
+	defer set.mu.Unlock()
+	rb.jobs--
+	if failed {
+		rb.unavailableUntil = time.Now().Add(remoteBuilderRetryDelay)
+	}
+	close(set.released)
+	set.released = make(chan struct{})
+}
+
+// normalizeSystem returns the canonical form of a system string
+// so that equivalent spellings (e.g. "x86_64-linux" and "x86_64-unknown-linux") compare equal.
+func normalizeSystem(s string) string {
+	sys, err := system.Parse(s)
+	if err != nil {
+		return s
+	}
+	return sys.String()
+}
+
+// buildRemote builds a derivation on one of the server's remote builders
+// and records its outputs as realizations of the derivation.
+// If a builder fails for a reason other than its builder failing,
+// then buildRemote tries the next available remote builder.
+// If no remote builder could be reached,
+// then buildRemote returns an error
+// for which errors.Is(err, errNoRemoteBuilder) reports true
+// and the caller may build the derivation locally.
+func (b *builder) buildRemote(ctx context.Context, conn *sqlite.Conn, drvPath zbstore.Path, drvHash nix.Hash, buildResultID int64, keepFailed bool, unlockFixedOutput func()) error {
+	drv := b.derivations[drvPath]
+	if drv == nil {
+		return fmt.Errorf("build %s: unknown derivation", drvPath)
+	}
+	resolved, err := b.resolveDerivation(drv)
+	if err != nil {
+		return fmt.Errorf("build %s: %v", drvPath, err)
+	}
+	inputs, err := b.inputs(conn, drvPath)
+	if err != nil {
+		return err
+	}
+
+	var logFile *os.File
+	defer func() {
+		if logFile == nil {
+			return
+		}
+		if err := logFile.Close(); err != nil {
+			log.Warnf(ctx, "Closing build log for %s: %v", drvPath, err)
+		}
+	}()
+	openLog := func() (io.Writer, error) {
+		if logFile == nil {
+			var err error
+			logFile, err = createBuilderLog(b.server.logDir, b.id, drvPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+		return logFile, nil
+	}
+
+	tried := make(sets.Set[*remoteBuilder])
+	var outputPaths map[string]zbstore.Path
+	var lastError error
+	for {
+		rb, err := b.server.remoteBuilders.acquire(ctx, drv.System, tried, !canBuildLocally(drv))
+		if errors.Is(err, errNoRemoteBuilder) && logFile != nil {
+			// We already started writing the log, so we can't fall back to a local build.
+			return fmt.Errorf("build %s: remote builders failed (last error: %v)", drvPath, lastError)
+		}
+		if err != nil {
+			return fmt.Errorf("build %s: %w", drvPath, err)
+		}
+		tried.Add(rb)
+
+		log.Infof(ctx, "Building %s on %v...", drvPath, rb)
+		outputPaths, err = b.runRemoteBuilder(ctx, conn, rb, &remoteBuildRequest{
+			drvPath:           drvPath,
+			drv:               resolved,
+			buildResultID:     buildResultID,
+			inputs:            sets.CollectSorted(maps.Keys(inputs)),
+			keepFailed:        keepFailed,
+			openLog:           openLog,
+			unlockFixedOutput: unlockFixedOutput,
+		})
+		failed := err != nil && !isBuilderFailure(err) && ctx.Err() == nil
+		b.server.remoteBuilders.release(rb, failed)
+		if err == nil {
+			break
+		}
+		if !failed {
+			return fmt.Errorf("build %s: %w", drvPath, err)
+		}
+		log.Warnf(ctx, "Remote builder %v failed to build %s: %v", rb, drvPath, err)
+		lastError = err
+		if logFile != nil {
+			if _, err := fmt.Fprintf(logFile, "*** Remote builder %v failed: %v\n", rb, err); err != nil {
+				log.Debugf(ctx, "While writing remote builder failure: %v", err)
+			}
+		}
+	}
+
+	outputPathSet := sets.Collect(maps.Values(outputPaths))
+	outputs := make(map[string]realizationOutput)
+	for outputName, outputPath := range outputPaths {
+		info, err := pathInfo(conn, outputPath)
+		if err != nil {
+			return fmt.Errorf("build %s: %v", drvPath, err)
+		}
+		b.mu.Lock()
+		prev, previouslyRealized := b.realizations[newEquivalenceClass(drvHash, outputName)]
+		b.mu.Unlock()
+		if previouslyRealized && outputPath != prev.path {
+			return fmt.Errorf("build %s: output %s: new path %s conflicts with existing %s",
+				drvPath, outputName, outputPath, prev.path)
+		}
+
+		references := make(map[zbstore.Path]sets.Set[equivalenceClass])
+		for ref := range info.References.Values() {
+			if outputPathSet.Has(ref) {
+				continue
+			}
+			eqClasses, ok := inputs[ref]
+			if !ok {
+				// The output can only refer to objects that the builder had access to.
+				return fmt.Errorf("build %s: output %s: %s references %s, which is not an input",
+					drvPath, outputName, outputPath, ref)
+			}
+			references[ref] = eqClasses
+		}
+		outputs[outputName] = realizationOutput{
+			path:       outputPath,
+			references: references,
+		}
+	}
+	if err := b.recordRealizations(ctx, conn, drvHash, buildResultID, outputs); err != nil {
+		return fmt.Errorf("build %s: %v", drvPath, err)
+	}
+
+	log.Infof(ctx, "Built %s: %s", drvPath, formatOutputPaths(outputPaths))
+	return nil
+}
+
+// resolveDerivation returns a copy of drv
+// with its input derivations' outputs replaced by their realizations.
+// The resolved derivation has no input derivations,
+// so it can be built by a store that has not realized them.
+func (b *builder) resolveDerivation(drv *zbstore.Derivation) (*zbstore.Derivation, error) {
+	inputRewrites, err := derivationInputRewrites(drv, b.lookup)
+	if err != nil {
+		return nil, err
+	}
+	resolved := expandDerivationPlaceholders(newReplacer(maps.All(inputRewrites)), drv)
+	resolved.InputDerivations = nil
+	for _, p := range inputRewrites {
+		resolved.InputSources.Add(p)
+	}
+	return resolved, nil
+}
+
+// remoteBuildRequest is the set of parameters to [*builder.runRemoteBuilder].
+type remoteBuildRequest struct {
+	// drvPath is the path of the derivation being realized in this store.
+	drvPath zbstore.Path
+	// drv is the resolved derivation (see [*builder.resolveDerivation]).
+	drv           *zbstore.Derivation
+	buildResultID int64
+	// inputs is the closure of the derivation's inputs.
+	inputs     *sets.Sorted[zbstore.Path]
+	keepFailed bool
+
+	// openLog returns the writer for the derivation's builder log.
+	// It is called once the remote builder has been reached.
+	openLog func() (io.Writer, error)
+	// unlockFixedOutput is called before importing outputs if not nil.
+	unlockFixedOutput func()
+}
+
+// runRemoteBuilder copies a derivation and its inputs to rb,
+// waits for rb to build it,
+// and imports the outputs into the store.
+// Failures reported by the remote builder are returned as a [builderFailure].
+func (b *builder) runRemoteBuilder(ctx context.Context, conn *sqlite.Conn, rb *remoteBuilder, req *remoteBuildRequest) (outputPaths map[string]zbstore.Path, err error) {
+	drvNAR, drvTrailer, err := req.drv.Export(nix.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	remoteDrvPath := drvTrailer.StorePath
+
+	rwc, err := rb.dial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("connect: %v", err)
+	}
+	receiver := b.server.newInternalNARReceiver(ctx, bytebuffer.TempFileCreator{
+		Dir:     b.server.buildDir,
+		Pattern: "zb-remote-*.nar",
+	})
+	defer receiver.Cleanup(ctx)
+	codec := zbstorerpc.NewCodec(rwc, &zbstorerpc.CodecOptions{
+		NARReceiver: receiver,
+	})
+	// The client would otherwise reconnect and wait indefinitely after a lost connection,
+	// so fail any calls in progress instead.
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	opened := false
+	client := jsonrpc.NewClient(func(context.Context) (jsonrpc.ClientCodec, error) {
+		if opened {
+			err := fmt.Errorf("connection to %v lost", rb)
+			cancel(err)
+			return nil, err
+		}
+		opened = true
+		return codec, nil
+	})
+	defer client.Close()
+	defer func() {
+		if err != nil && context.Cause(ctx) != nil && !errors.Is(context.Cause(ctx), context.Canceled) {
+			err = context.Cause(ctx)
+		}
+	}()
+
+	// Find which inputs the remote store is missing.
+	// This also verifies that the remote store is reachable before we start writing the log.
+	var missing []zbstore.Path
+	for p := range req.inputs.Values() {
+		var exists bool
+		err := jsonrpc.Do(ctx, client, zbstorerpc.ExistsMethod, &exists, &zbstorerpc.ExistsRequest{
+			Path: string(p),
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			missing = append(missing, p)
+		}
+	}
+	logWriter, err := req.openLog()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := b.exportToRemote(ctx, conn, client, missing, drvNAR, drvTrailer); err != nil {
+		return nil, err
+	}
+	// Exports don't send a response, so this introduces a sync point.
+	var exists bool
+	err = jsonrpc.Do(ctx, client, zbstorerpc.ExistsMethod, &exists, &zbstorerpc.ExistsRequest{
+		Path: string(remoteDrvPath),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, fmt.Errorf("%s not imported (does %v use %s?)", remoteDrvPath, rb, remoteDrvPath.Dir())
+	}
+
+	if err := recordBuilderStart(conn, req.buildResultID, time.Now()); err != nil {
+		log.Warnf(ctx, "For %s: %v", req.drvPath, err)
+	}
+	result, err := b.waitForRemoteBuild(ctx, client, rb, remoteDrvPath, req.keepFailed, logWriter)
+	if err := recordBuilderEnd(conn, req.buildResultID, time.Now(), nil); err != nil {
+		log.Warnf(ctx, "For %s: %v", req.drvPath, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	switch result.Status {
+	case zbstorerpc.BuildSuccess:
+	case zbstorerpc.BuildFail:
+		err := builderFailure{fmt.Errorf("failed on %v", rb)}
+		if result.Error != "" {
+			err = builderFailure{fmt.Errorf("failed on %v: %s", rb, result.Error)}
+		}
+		writeBuildFailure(ctx, logWriter, err)
+		return nil, err
+	case zbstorerpc.BuildTimedOut:
+		err := builderFailure{fmt.Errorf("%w on %v", errBuilderTimedOut, rb)}
+		writeBuildFailure(ctx, logWriter, err)
+		return nil, err
+	default:
+		if result.Error != "" {
+			return nil, fmt.Errorf("remote build %s: %s", result.Status, result.Error)
+		}
+		return nil, fmt.Errorf("remote build %s", result.Status)
+	}
+
+	// Import outputs.
+	outputPaths = make(map[string]zbstore.Path)
+	for _, output := range result.Outputs {
+		if !output.Path.Valid {
+			return nil, fmt.Errorf("%v did not realize output %s", rb, output.Name)
+		}
+		if output.Path.X.Dir() != b.server.dir {
+			return nil, fmt.Errorf("output %s: %s is not in %s", output.Name, output.Path.X, b.server.dir)
+		}
+		outputPaths[output.Name] = output.Path.X
+	}
+	for outputName := range req.drv.Outputs {
+		if _, ok := outputPaths[outputName]; !ok {
+			return nil, fmt.Errorf("%v did not realize output %s", rb, outputName)
+		}
+	}
+	if req.unlockFixedOutput != nil {
+		// Importing acquires the write lock on the output path.
+		req.unlockFixedOutput()
+	}
+	var toImport []zbstore.Path
+	for _, outputPath := range slices.Sorted(maps.Values(outputPaths)) {
+		b.roots.add(outputPath)
+		if _, err := os.Lstat(b.server.realPath(outputPath)); err == nil {
+			continue
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		toImport = append(toImport, outputPath)
+	}
+	if len(toImport) == 0 {
+		return outputPaths, nil
+	}
+	// The export message is sent before the RPC response,
+	// so once we receive the response, the import is complete.
+	err = jsonrpc.Do(ctx, client, zbstorerpc.ExportMethod, nil, &zbstorerpc.ExportRequest{
+		Paths:             toImport,
+		ExcludeReferences: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, outputPath := range toImport {
+		if _, err := os.Lstat(b.server.realPath(outputPath)); err != nil {
+			return nil, fmt.Errorf("import %s: failed", outputPath)
+		}
+	}
+	return outputPaths, nil
+}
+
+// exportToRemote sends the given store objects from the store
+// followed by a derivation
+// to a remote store.
+func (b *builder) exportToRemote(ctx context.Context, conn *sqlite.Conn, client *jsonrpc.Client, paths []zbstore.Path, drvNAR []byte, drvTrailer *zbstore.ExportTrailer) error {
+	trailers := make([]*zbstore.ExportTrailer, 0, len(paths))
+	err := func() error {
+		rollback, err := readonlySavepoint(conn)
+		if err != nil {
+			return err
+		}
+		defer rollback()
+		for _, p := range paths {
+			info, err := pathInfo(conn, p)
+			if err != nil {
+				return err
+			}
+			trailers = append(trailers, info.ToExportTrailer())
+		}
+		return nil
+	}()
+	if err != nil {
+		return fmt.Errorf("export inputs: %v", err)
+	}
+	err = sortByReferences(
+		trailers,
+		func(t *zbstore.ExportTrailer) zbstore.Path { return t.StorePath },
+		func(t *zbstore.ExportTrailer) sets.Sorted[zbstore.Path] { return t.References },
+		true,
+	)
+	if err != nil {
+		return fmt.Errorf("export inputs: %v", err)
+	}
+
+	generic, release, err := client.Codec(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+	codec, ok := generic.(*zbstorerpc.Codec)
+	if !ok {
+		return fmt.Errorf("export inputs: connection is %T", generic)
+	}
+
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		e := zbstore.NewExporter(pw)
+		pw.CloseWithError(func() error {
+			for _, t := range trailers {
+				if err := nar.DumpPath(e, b.server.realPath(t.StorePath)); err != nil {
+					return fmt.Errorf("export %s: %v", t.StorePath, err)
+				}
+				if err := e.Trailer(t); err != nil {
+					return fmt.Errorf("export %s: %v", t.StorePath, err)
+				}
+			}
+			if _, err := e.Write(drvNAR); err != nil {
+				return fmt.Errorf("export %s: %v", drvTrailer.StorePath, err)
+			}
+			if err := e.Trailer(drvTrailer); err != nil {
+				return fmt.Errorf("export %s: %v", drvTrailer.StorePath, err)
+			}
+			return e.Close()
+		}())
+	}()
+	err = codec.Export(nil, pr)
+	pr.Close()
+	<-done
+	if err != nil {
+		return fmt.Errorf("export inputs: %v", err)
+	}
+	return nil
+}
+
+// waitForRemoteBuild realizes a derivation on a remote store,
+// copying its builder log to logWriter as the build progresses.
+// waitForRemoteBuild returns the derivation's build result once the build finishes.
+func (b *builder) waitForRemoteBuild(ctx context.Context, client *jsonrpc.Client, rb *remoteBuilder, drvPath zbstore.Path, keepFailed bool, logWriter io.Writer) (_ *zbstorerpc.BuildResult, err error) {
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths:   []zbstore.Path{drvPath},
+		KeepFailed: keepFailed,
+	})
+	if err != nil {
+		return nil, err
+	}
+	buildID := realizeResponse.BuildID
+	log.Debugf(ctx, "Building %s as build %s on %v", drvPath, buildID, rb)
+	defer func() {
+		if err != nil && ctx.Err() != nil {
+			cancelCtx, cleanupCtx := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+			defer cleanupCtx()
+			cancelError := jsonrpc.Notify(cancelCtx, client, zbstorerpc.CancelBuildMethod, &zbstorerpc.CancelBuildNotification{
+				BuildID: buildID,
+			})
+			if cancelError != nil {
+				log.Warnf(ctx, "Failed to cancel build %s on %v: %v", buildID, rb, cancelError)
+			}
+		}
+	}()
+
+	var logOffset int64
+	ticker := time.NewTicker(remoteBuildPollInterval)
+	defer ticker.Stop()
+	for {
+		build := new(zbstorerpc.Build)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.GetBuildMethod, build, &zbstorerpc.GetBuildRequest{
+			BuildID: buildID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		var result *zbstorerpc.BuildResult
+		for _, r := range build.Results {
+			if r.DrvPath == drvPath {
+				result = r
+				break
+			}
+		}
+		if build.Status != zbstorerpc.BuildActive && build.Status != zbstorerpc.BuildUnknown {
+			if result == nil {
+				if build.Error != "" {
+					return nil, fmt.Errorf("build %s on %v: %s", buildID, rb, build.Error)
+				}
+				return nil, fmt.Errorf("build %s on %v finished with status %q without building %s",
+					buildID, rb, build.Status, drvPath)
+			}
+			// Copy the rest of the log.
+			if _, err := copyRemoteLog(ctx, logWriter, client, buildID, drvPath, logOffset, -1); err != nil {
+				log.Warnf(ctx, "Copying log for %s from %v: %v", drvPath, rb, err)
+			}
+			return result, nil
+		}
+		if result != nil && result.LogSize > logOffset {
+			logOffset, err = copyRemoteLog(ctx, logWriter, client, buildID, drvPath, logOffset, result.LogSize)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// copyRemoteLog copies a builder log from a remote store to dst
+// starting at the given offset.
+// If end is non-negative, then copyRemoteLog stops at that offset.
+// Otherwise, copyRemoteLog copies until the end of the log.
+// copyRemoteLog returns the offset after the last byte copied.
+func copyRemoteLog(ctx context.Context, dst io.Writer, client *jsonrpc.Client, buildID string, drvPath zbstore.Path, offset, end int64) (int64, error) {
+	for end < 0 || offset < end {
+		req := &zbstorerpc.ReadLogRequest{
+			BuildID:    buildID,
+			DrvPath:    drvPath,
+			RangeStart: offset,
+		}
+		if end >= 0 {
+			req.RangeEnd = zbstorerpc.NonNull(end)
+		}
+		resp := new(zbstorerpc.ReadLogResponse)
+		if err := jsonrpc.Do(ctx, client, zbstorerpc.ReadLogMethod, resp, req); err != nil {
+			return offset, err
+		}
+		payload, err := resp.Payload()
+		if err != nil {
+			return offset, err
+		}
+		n, err := dst.Write(payload)
+		offset += int64(n)
+		if err != nil {
+			return offset, err
+		}
+		if resp.EOF || len(payload) == 0 {
+			break
+		}
+	}
+	return offset, nil
+}
+
+// writeBuildFailure appends a build failure message to a builder log.
+func writeBuildFailure(ctx context.Context, logWriter io.Writer, err error) {
+	if _, err := fmt.Fprintf(logWriter, "*** Build failed: %v\n", err); err != nil {
+		log.Debugf(ctx, "While writing build failure: %v", err)
+	}
+}
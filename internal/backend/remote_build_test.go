@@ -0,0 +1,230 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"zb.256lights.llc/pkg/bytebuffer"
+	. "zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestRemoteBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses Unix sockets")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	const inputContent = "Hello, World!\n"
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	inputFilePath, _, err := storetest.ExportSourceFile(exporter, []byte(inputContent), storetest.SourceExportOptions{
+		Name:      "hello.txt",
+		Directory: dir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv1Content := &zbstore.Derivation{
+		Name:   "hello2.txt",
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in":  string(inputFilePath),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputSources: *sets.NewSorted(
+			inputFilePath,
+		),
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drv1Content.Builder, drv1Content.Args = catcatBuilder()
+	drv1Path, _, err := storetest.ExportDerivation(exporter, drv1Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const wantOutputName = "hello4.txt"
+	drv2Content := &zbstore.Derivation{
+		Name:   wantOutputName,
+		Dir:    dir,
+		System: system.Current().String(),
+		Env: map[string]string{
+			"in": zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
+				DrvPath:    drv1Path,
+				OutputName: zbstore.DefaultDerivationOutputName,
+			}),
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		InputDerivations: map[zbstore.Path]*sets.Sorted[string]{
+			drv1Path: sets.NewSorted(zbstore.DefaultDerivationOutputName),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drv2Content.Builder, drv2Content.Args = catcatBuilder()
+	drv2Path, _, err := storetest.ExportDerivation(exporter, drv2Content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The remote server stores its files in the real store directory,
+	// so it is the only server that can run builders.
+	remoteServer, _, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	socketPath := listenForTest(ctx, t, remoteServer)
+	localRealDir := t.TempDir()
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			RealStoreDirectory: localRealDir,
+			RemoteBuilders: []*RemoteBuilder{
+				{
+					Name:    "down",
+					Dial:    dialUnixFunc(filepath.Join(t.TempDir(), "nonexistent.sock")),
+					Systems: []string{system.Current().String()},
+				},
+				{
+					Name:    "remote",
+					Dial:    dialUnixFunc(socketPath),
+					Systems: []string{system.Current().String()},
+				},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drv2Path},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+	gotLog1, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv1Path)
+	if err != nil {
+		gotLog2, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drv2Path)
+		t.Fatalf("build failed: %v\ndrv1 log:\n%s\ndrv2 log:\n%s", err, gotLog1, gotLog2)
+	}
+
+	wantOutputContent := strings.Repeat(inputContent, 4)
+	wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkSingleFileOutput(t, drv2Path, wantOutputPath, []byte(wantOutputContent), got)
+	if !bytes.Contains(gotLog1, []byte("catcat")) {
+		t.Errorf("drv1 log does not include builder output:\n%s", gotLog1)
+	}
+
+	// Outputs should have been copied back to the local store.
+	gotContent, err := os.ReadFile(filepath.Join(localRealDir, wantOutputPath.Base()))
+	if err != nil {
+		t.Error(err)
+	} else if string(gotContent) != wantOutputContent {
+		t.Errorf("local %s content = %q; want %q", wantOutputPath, gotContent, wantOutputContent)
+	}
+}
+
+// listenForTest serves srv on a new Unix socket for the duration of the test
+// and returns the socket's path.
+func listenForTest(ctx context.Context, tb testing.TB, srv *Server) string {
+	tb.Helper()
+
+	// Unix socket paths have a short length limit,
+	// so avoid the potentially long test temporary directory.
+	socketDir, err := os.MkdirTemp("", "zb")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	tb.Cleanup(func() {
+		if err := os.RemoveAll(socketDir); err != nil {
+			tb.Log(err)
+		}
+	})
+	socketPath := filepath.Join(socketDir, "zb.sock")
+	l, err := net.Listen("unix", socketPath)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	serveCtx, stopServe := context.WithCancel(context.WithoutCancel(ctx))
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				receiver := srv.NewNARReceiver(serveCtx, bytebuffer.BufferCreator{})
+				defer receiver.Cleanup(serveCtx)
+				codec := zbstorerpc.NewCodec(conn, &zbstorerpc.CodecOptions{
+					NARReceiver: receiver,
+				})
+				stopClose := context.AfterFunc(serveCtx, func() { codec.Close() })
+				defer stopClose()
+				jsonrpc.Serve(WithExporter(serveCtx, codec), codec, srv)
+				codec.Close()
+			}()
+		}
+	}()
+	tb.Cleanup(func() {
+		l.Close()
+		stopServe()
+		wg.Wait()
+	})
+	return socketPath
+}
+
+func dialUnixFunc(path string) func(ctx context.Context) (io.ReadWriteCloser, error) {
+	return func(ctx context.Context) (io.ReadWriteCloser, error) {
+		return (&net.Dialer{}).DialContext(ctx, "unix", path)
+	}
+}
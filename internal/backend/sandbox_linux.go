@@ -0,0 +1,332 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Values of argv[0] that signal that the process should run
+// [sandboxInit] or [sandboxCheck], respectively,
+// instead of the program's main function.
+const (
+	sandboxInitCommand  = "zb-sandbox-init"
+	sandboxCheckCommand = "zb-sandbox-check"
+)
+
+// User and group IDs that builders run as
+// when the store server is not running as root.
+// See [sandboxInitConfig.UserNamespace].
+const (
+	rootlessBuilderUID = 1000
+	rootlessBuilderGID = 100
+)
+
+// Deterministic hostname and domain name used inside the sandbox.
+const (
+	sandboxHostname   = "localhost"
+	sandboxDomainname = "(none)"
+)
+
+// sandboxInitConfig is the configuration passed to [sandboxInit] in argv[1].
+type sandboxInitConfig struct {
+	// Root is the absolute path to the directory to use as the builder's root directory.
+	Root string `json:"root"`
+	// WorkDir is the builder's working directory (relative to Root).
+	WorkDir string `json:"workDir"`
+	// UID is the user ID to run the builder as.
+	UID int `json:"uid"`
+	// GID is the group ID to run the builder as.
+	GID int `json:"gid"`
+	// Loopback is true if the loopback interface needs to be brought up.
+	// This is required in a fresh network namespace.
+	Loopback bool `json:"loopback"`
+	// UserNamespace is true if the sandbox runs in a user namespace
+	// because the store server is not running as root.
+	// The init process is mapped to root inside the user namespace
+	// and the builder runs in a nested user namespace
+	// that maps UID and GID to the init process's user.
+	UserNamespace bool `json:"userNamespace"`
+	// Filesystem is the sandbox filesystem to create inside Root
+	// from within the sandbox's mount namespace.
+	// If nil, then the filesystem has already been set up
+	// (see [setupSandboxFilesystem]).
+	Filesystem *linuxSandboxOptions `json:"filesystem,omitempty"`
+	// Builder is the path to the builder program.
+	Builder string `json:"builder"`
+	// Args is the list of arguments passed to the builder program.
+	Args []string `json:"args"`
+}
+
+func init() {
+	// zb re-executes itself in fresh namespaces to set up the sandbox
+	// before running the builder.
+	// See sandboxCommand.
+	switch {
+	case len(os.Args) == 2 && os.Args[0] == sandboxInitCommand:
+		os.Exit(sandboxInit(os.Args[1]))
+	case len(os.Args) == 1 && os.Args[0] == sandboxCheckCommand:
+		os.Exit(sandboxCheck())
+	}
+}
+
+// checkSandbox returns an error if sandboxing cannot be used.
+// Sandboxing can always be used by root.
+// Other users require unprivileged user namespaces.
+func checkSandbox() error {
+	if os.Geteuid() == 0 {
+		return nil
+	}
+	return checkUserNamespaces()
+}
+
+var checkUserNamespaces = sync.OnceValue(func() error {
+	sysctls := []struct {
+		name string
+		path string
+	}{
+		{"kernel.unprivileged_userns_clone", "/proc/sys/kernel/unprivileged_userns_clone"},
+		{"user.max_user_namespaces", "/proc/sys/user/max_user_namespaces"},
+	}
+	for _, sysctl := range sysctls {
+		data, err := os.ReadFile(sysctl.path)
+		if err == nil && strings.TrimSpace(string(data)) == "0" {
+			return fmt.Errorf("not running as root and user namespaces are disabled (sysctl %s = 0)", sysctl.name)
+		}
+	}
+
+	// The only reliable way to know whether we can create a sandbox
+	// is to try it.
+	c := exec.Command("/proc/self/exe")
+	c.Args = []string{sandboxCheckCommand}
+	c.SysProcAttr = sandboxSysProcAttr(true, false)
+	stderr := new(bytes.Buffer)
+	c.Stderr = stderr
+	if err := c.Run(); err != nil {
+		msg := strings.TrimSpace(stderr.String())
+		if msg == "" {
+			msg = err.Error()
+		}
+		return fmt.Errorf("not running as root and unable to create user namespace: %s", msg)
+	}
+	return nil
+})
+
+// sandboxCheck is the entry point for the process started by [checkUserNamespaces].
+// It performs the privileged operations that [sandboxInit] requires
+// and reports whether they succeeded.
+func sandboxCheck() (exitCode int) {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "make private mount: %v\n", err)
+		return 1
+	}
+	if err := unix.Mount("none", "/proc", "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		fmt.Fprintf(os.Stderr, "mount proc: %v\n", err)
+		return 1
+	}
+	if err := unix.Sethostname([]byte(sandboxHostname)); err != nil {
+		fmt.Fprintf(os.Stderr, "set hostname: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// sandboxCommand returns a command that runs the builder described by cfg
+// in new mount, PID, IPC, UTS, and (unless network is true) network namespaces.
+// The returned command runs the current executable as an init process
+// that finishes setting up the sandbox from inside the namespaces.
+// The command's environment is passed through to the builder.
+func sandboxCommand(ctx context.Context, cfg *sandboxInitConfig, network bool) (*exec.Cmd, error) {
+	cfgJSON, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, err
+	}
+	c := exec.CommandContext(ctx, "/proc/self/exe")
+	c.Args = []string{sandboxInitCommand, string(cfgJSON)}
+	c.SysProcAttr = sandboxSysProcAttr(cfg.UserNamespace, network)
+	return c, nil
+}
+
+func sandboxSysProcAttr(userNamespace bool, network bool) *syscall.SysProcAttr {
+	attr := &syscall.SysProcAttr{
+		Cloneflags: unix.CLONE_NEWNS | unix.CLONE_NEWPID | unix.CLONE_NEWIPC | unix.CLONE_NEWUTS,
+		Pdeathsig:  unix.SIGKILL,
+	}
+	if !network {
+		attr.Cloneflags |= unix.CLONE_NEWNET
+	}
+	if userNamespace {
+		attr.Cloneflags |= unix.CLONE_NEWUSER
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 0, HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+	return attr
+}
+
+// sandboxInit is the entry point for the sandbox's init process.
+// It is run as PID 1 of a fresh PID namespace (see [sandboxCommand]).
+// It finishes setting up the sandbox, runs the builder,
+// and then exits with the builder's exit code.
+// When sandboxInit exits, the kernel kills any processes left in the namespace.
+func sandboxInit(cfgJSON string) (exitCode int) {
+	cfg := new(sandboxInitConfig)
+	if err := json.Unmarshal([]byte(cfgJSON), cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", sandboxInitCommand, err)
+		return 1
+	}
+	if err := setupSandboxNamespaces(cfg); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", sandboxInitCommand, err)
+		return 1
+	}
+
+	c := exec.Command(cfg.Builder, cfg.Args...)
+	c.Dir = cfg.WorkDir
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	if cfg.UserNamespace {
+		// Only the init process's user is mapped into the user namespace,
+		// so we can't switch to another user.
+		// Instead, create a nested user namespace
+		// so that the builder does not run as root.
+		c.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:                 unix.CLONE_NEWUSER,
+			UidMappings:                []syscall.SysProcIDMap{{ContainerID: cfg.UID, HostID: 0, Size: 1}},
+			GidMappings:                []syscall.SysProcIDMap{{ContainerID: cfg.GID, HostID: 0, Size: 1}},
+			GidMappingsEnableSetgroups: false,
+		}
+	} else {
+		c.SysProcAttr = &syscall.SysProcAttr{
+			Credential: &syscall.Credential{
+				Uid: uint32(cfg.UID),
+				Gid: uint32(cfg.GID),
+			},
+		}
+	}
+	// As PID 1, we don't receive signals from outside the namespace
+	// unless we install a handler, so forward them to the builder.
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, unix.SIGTERM, unix.SIGINT, unix.SIGHUP, unix.SIGQUIT)
+	if err := c.Start(); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", sandboxInitCommand, err)
+		return 1
+	}
+	go func() {
+		for sig := range signals {
+			c.Process.Signal(sig)
+		}
+	}()
+	err := c.Wait()
+	signal.Stop(signals)
+	if exitError := (*exec.ExitError)(nil); errors.As(err, &exitError) {
+		if status, ok := exitError.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return exitError.ExitCode()
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", sandboxInitCommand, err)
+		return 1
+	}
+	return 0
+}
+
+// setupSandboxNamespaces performs the parts of the sandbox setup
+// that must happen inside the builder's namespaces
+// and then changes the root mount to cfg.Root.
+func setupSandboxNamespaces(cfg *sandboxInitConfig) error {
+	// Prevent mounts inside the sandbox from propagating to the host.
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return &os.PathError{Op: "make private mount", Path: "/", Err: err}
+	}
+	if cfg.Filesystem != nil {
+		// Log messages are sent to the builder's log,
+		// but setupSandboxFilesystem only logs debug messages,
+		// which the default logger discards.
+		if err := setupSandboxFilesystem(context.Background(), cfg.Root, cfg.Filesystem); err != nil {
+			return err
+		}
+	}
+	// /proc must be mounted from inside the PID namespace
+	// so that it only shows processes in the sandbox.
+	procDir := filepath.Join(cfg.Root, "proc")
+	if err := unix.Mount("none", procDir, "proc", unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, ""); err != nil {
+		return &os.PathError{Op: "mount proc", Path: procDir, Err: err}
+	}
+
+	if err := unix.Sethostname([]byte(sandboxHostname)); err != nil {
+		return fmt.Errorf("set hostname: %v", err)
+	}
+	if err := unix.Setdomainname([]byte(sandboxDomainname)); err != nil {
+		return fmt.Errorf("set domain name: %v", err)
+	}
+	if cfg.Loopback {
+		if err := bringUpLoopback(); err != nil {
+			return err
+		}
+	}
+
+	return pivotRoot(cfg.Root)
+}
+
+// pivotRoot changes the root mount of the current mount namespace to dir
+// and detaches the old root.
+// Unlike chroot(2), this leaves no path back to the host filesystem
+// and permits creating nested user namespaces.
+func pivotRoot(dir string) error {
+	// pivot_root(2) requires the new root to be a mount point.
+	if err := unix.Mount(dir, dir, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return &os.PathError{Op: "bind mount", Path: dir, Err: err}
+	}
+	if err := os.Chdir(dir); err != nil {
+		return err
+	}
+	// Stack the old root on top of the new root
+	// so that we don't need a directory to put it in.
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return &os.PathError{Op: "pivot_root", Path: dir, Err: err}
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return &os.PathError{Op: "unmount old root", Path: dir, Err: err}
+	}
+	if err := os.Chdir("/"); err != nil {
+		return err
+	}
+	return nil
+}
+
+// bringUpLoopback sets the "up" flag on the loopback network interface.
+// The loopback interface starts down in a fresh network namespace.
+func bringUpLoopback() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("bring up loopback interface: %v", err)
+	}
+	defer unix.Close(fd)
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return fmt.Errorf("bring up loopback interface: %v", err)
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("bring up loopback interface: %v", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	if err := unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr); err != nil {
+		return fmt.Errorf("bring up loopback interface: %v", err)
+	}
+	return nil
+}
@@ -1,5 +1,6 @@
 update "build_results"
 set
   "status" = :status,
-  "ended_at" = :timestamp_millis
+  "ended_at" = :timestamp_millis,
+  "error" = :error
 where "id" = :id;
@@ -0,0 +1,29 @@
+select
+  uuidhex("builds"."uuid") as "build_id",
+  "drv_path"."path" as "drv_path"
+from
+  "build_results"
+  join "builds" on "builds"."id" = "build_results"."build_id"
+  join "paths" as "drv_path" on "drv_path"."id" = "build_results"."drv_path"
+where
+  (:drv_path is null or :drv_path = '' or "drv_path"."path" = :drv_path) and
+  (:output_path is null or :output_path = '' or exists(
+    select 1
+    from
+      "build_outputs" as "outputs"
+      join "paths" as "output_path" on "output_path"."id" = "outputs"."output_path"
+    where
+      "outputs"."result_id" = "build_results"."id" and
+      "output_path"."path" = :output_path
+  )) and
+  (not :failed or "build_results"."status" in ('fail', 'error', 'timedOut'))
+order by
+  -- When searching by output, prefer results where the builder ran
+  -- over results that reused an existing realization.
+  case
+    when :output_path is null or :output_path = '' then 0
+    else "build_results"."builder_started_at" is null
+  end,
+  coalesce("build_results"."ended_at", "build_results"."started_at") desc,
+  "build_results"."id" desc
+limit 1;
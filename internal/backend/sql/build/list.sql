@@ -0,0 +1,27 @@
+select
+  uuidhex("uuid") as "build_id",
+  "build_statuses"."status" as "status",
+  "started_at" as "started_at",
+  "ended_at" as "ended_at",
+  "internal_error" as "internal_error",
+  coalesce("ended_at", "started_at") as "sort_time"
+from
+  "builds"
+  join "build_statuses" on "build_statuses"."build_id" = "builds"."id"
+where
+  (:status is null or "build_statuses"."status" = :status) and
+  (:started_after is null or "started_at" >= :started_after) and
+  (:started_before is null or "started_at" < :started_before) and
+  (:drv_suffix is null or exists(
+    select 1
+    from
+      "build_results"
+      join "paths" on "paths"."id" = "build_results"."drv_path"
+    where
+      "build_results"."build_id" = "builds"."id" and
+      substr("paths"."path", -length(:drv_suffix)) = :drv_suffix
+  )) and
+  (:cursor_time is null or
+    (coalesce("ended_at", "started_at"), "uuid") < (:cursor_time, uuid(:cursor_id)))
+order by coalesce("ended_at", "started_at") desc, "uuid" desc
+limit :n;
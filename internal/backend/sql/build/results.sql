@@ -5,14 +5,19 @@ select
   "build_results"."ended_at" as "ended_at",
   "build_results"."builder_started_at" as "builder_started_at",
   "build_results"."builder_ended_at" as "builder_ended_at",
+  "build_results"."peak_memory" as "peak_memory",
+  "build_results"."cpu_time" as "cpu_time",
+  "build_results"."error" as "error",
   "outputs"."output_name" as "output_name",
-  "output_path"."path" as "output_path"
+  "output_path"."path" as "output_path",
+  "check_path"."path" as "check_path"
 from
   "build_results"
   join "builds" on "builds"."id" = "build_results"."build_id"
   join "paths" as "drv_path" on "drv_path"."id" = "build_results"."drv_path"
   left join "build_outputs" as "outputs" on "outputs"."result_id" = "build_results"."id"
   left join "paths" as "output_path" on "output_path"."id" = "outputs"."output_path"
+  left join "paths" as "check_path" on "check_path"."id" = "outputs"."check_path"
 where
   "builds"."uuid" = uuid(:build_id) and
   (:drv_path is null or :drv_path = '' or "drv_path"."path" = :drv_path)
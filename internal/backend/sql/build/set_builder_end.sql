@@ -1,3 +1,6 @@
 update "build_results"
-set "builder_ended_at" = :timestamp_millis
+set
+  "builder_ended_at" = :timestamp_millis,
+  "peak_memory" = :peak_memory,
+  "cpu_time" = :cpu_time_micros
 where "id" = :id;
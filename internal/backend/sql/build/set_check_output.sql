@@ -0,0 +1,5 @@
+update "build_outputs"
+set "check_path" = (select "id" from "paths" where "path" = :check_path)
+where
+  "result_id" = :id and
+  "output_name" = :output_name;
@@ -0,0 +1,3 @@
+insert into "gc_roots" ("link")
+values (:link)
+on conflict ("link") do nothing;
@@ -0,0 +1,16 @@
+-- Derivations and outputs of builds that have not been cleaned up yet.
+select "paths"."path" as "path"
+from
+  "build_results"
+  join "paths" on "build_results"."drv_path" = "paths"."id"
+union
+select "paths"."path" as "path"
+from
+  "build_outputs"
+  join "paths" on "build_outputs"."output_path" = "paths"."id"
+union
+select "paths"."path" as "path"
+from
+  "build_outputs"
+  join "paths" on "build_outputs"."check_path" = "paths"."id"
+order by 1;
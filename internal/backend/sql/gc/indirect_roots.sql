@@ -0,0 +1 @@
+select "link" from "gc_roots" order by 1;
@@ -0,0 +1,7 @@
+select
+  "paths"."path" as "path",
+  "objects"."nar_size" as "nar_size"
+from
+  "objects"
+  join "paths" using ("id")
+order by 1;
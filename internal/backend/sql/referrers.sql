@@ -0,0 +1,10 @@
+select
+  "referrer"."path" as "path"
+from
+  "references"
+  join "paths" as "referrer" on ("references"."referrer" = "referrer"."id")
+  join "paths" as "reference" on ("references"."reference" = "reference"."id")
+where
+  "reference"."path" = :path and
+  "references"."referrer" <> "references"."reference"
+order by 1;
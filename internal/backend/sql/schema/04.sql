@@ -0,0 +1,7 @@
+-- Copyright 2025 The zb Authors
+-- SPDX-License-Identifier: MIT
+
+-- Symlinks outside the store directory that act as garbage collection roots.
+create table "gc_roots" (
+  "link" text primary key not null
+) without rowid;
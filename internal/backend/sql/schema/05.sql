@@ -0,0 +1,6 @@
+-- Copyright 2025 The zb Authors
+-- SPDX-License-Identifier: MIT
+
+-- Resource usage of builders run in a cgroup.
+alter table "build_results" add column "peak_memory" integer; -- Bytes
+alter table "build_results" add column "cpu_time" integer;    -- Microseconds
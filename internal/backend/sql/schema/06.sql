@@ -0,0 +1,5 @@
+-- Copyright 2025 The zb Authors
+-- SPDX-License-Identifier: MIT
+
+-- Human-readable reason that a derivation was not realized successfully.
+alter table "build_results" add column "error" text;
@@ -0,0 +1,5 @@
+-- Copyright 2025 The zb Authors
+-- SPDX-License-Identifier: MIT
+
+-- Path produced by rebuilding a derivation in check mode.
+alter table "build_outputs" add column "check_path" integer references "paths";
@@ -0,0 +1,30 @@
+-- Copyright 2025 The zb Authors
+-- SPDX-License-Identifier: MIT
+
+-- Overall status of each build, derived from its results.
+create view "build_statuses" as
+select
+  "builds"."id" as "build_id",
+  case
+    when "ended_at" is null then 'active'
+    when "internal_error" is not null or exists(
+      select 1 from "build_results"
+      where
+        "build_results"."build_id" = "builds"."id" and
+        "build_results"."status" = 'error'
+    ) then 'error'
+    when exists(
+      select 1 from "build_results"
+      where
+        "build_results"."build_id" = "builds"."id" and
+        "build_results"."status" = 'fail'
+    ) then 'fail'
+    when exists(
+      select 1 from "build_results"
+      where
+        "build_results"."build_id" = "builds"."id" and
+        "build_results"."status" = 'timedOut'
+    ) then 'timedOut'
+    else 'success'
+  end as "status"
+from "builds";
@@ -0,0 +1,398 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"compress/bzip2"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"zb.256lights.llc/pkg/bytebuffer"
+	"zb.256lights.llc/pkg/internal/remotestore"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+	"zombiezen.com/go/sqlite"
+)
+
+// errNotSubstitutable is returned by substitution functions
+// when no substituter has the requested store object or realization.
+var errNotSubstitutable = errors.New("not found in substituters")
+
+// maxSubstituterMetadataSize is the maximum size in bytes
+// of a .narinfo or realization file that will be read from a substituter.
+const maxSubstituterMetadataSize = 1 << 20 // 1 MiB
+
+// substituter is a binary cache that store objects can be downloaded from.
+type substituter struct {
+	// base is the URL of the binary cache's root.
+	// Its path always ends in a slash.
+	base *url.URL
+}
+
+func newSubstituter(u *url.URL) *substituter {
+	base := new(url.URL)
+	*base = *u
+	if base.Path == "" || base.Path[len(base.Path)-1] != '/' {
+		base.Path += "/"
+		base.RawPath = ""
+	}
+	return &substituter{base: base}
+}
+
+func (sub *substituter) String() string {
+	return sub.base.Redacted()
+}
+
+// resolve returns the URL of the given slash-separated path
+// relative to the binary cache's root.
+func (sub *substituter) resolve(path string) (*url.URL, error) {
+	ref, err := url.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	return sub.base.ResolveReference(ref), nil
+}
+
+// open opens the file at the given slash-separated path in the binary cache.
+// If the file does not exist, open returns an error
+// for which errors.Is(err, errNotSubstitutable) reports true.
+func (sub *substituter) open(ctx context.Context, path string) (io.ReadCloser, error) {
+	u, err := sub.resolve(path)
+	if err != nil {
+		return nil, fmt.Errorf("open %s from %v: %v", path, sub, err)
+	}
+	switch u.Scheme {
+	case "file":
+		f, err := os.Open(fileURLPath(u))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("open %s from %v: %w", path, sub, errNotSubstitutable)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("open %s from %v: %v", path, sub, err)
+		}
+		return f, nil
+	case "http", "https":
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return nil, fmt.Errorf("open %s from %v: %v", path, sub, err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return nil, fmt.Errorf("open %s from %v: %v", path, sub, err)
+		}
+		switch {
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone || resp.StatusCode == http.StatusForbidden:
+			resp.Body.Close()
+			return nil, fmt.Errorf("open %s from %v: %w", path, sub, errNotSubstitutable)
+		case resp.StatusCode != http.StatusOK:
+			resp.Body.Close()
+			return nil, fmt.Errorf("open %s from %v: http %s", path, sub, resp.Status)
+		}
+		return resp.Body, nil
+	default:
+		return nil, fmt.Errorf("open %s from %v: unsupported scheme %q", path, sub, u.Scheme)
+	}
+}
+
+// fileURLPath converts a "file" URL to a local filesystem path.
+func fileURLPath(u *url.URL) string {
+	p := u.Path
+	if runtime.GOOS == "windows" && len(p) >= len("/C:") && p[0] == '/' && p[2] == ':' {
+		// Drive letter paths (e.g. "/C:/foo").
+		p = p[1:]
+	}
+	return filepath.FromSlash(p)
+}
+
+// readFile reads a small metadata file from the binary cache.
+func (sub *substituter) readFile(ctx context.Context, path string) ([]byte, error) {
+	rc, err := sub.open(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	data, err := io.ReadAll(io.LimitReader(rc, maxSubstituterMetadataSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("read %s from %v: %v", path, sub, err)
+	}
+	if len(data) > maxSubstituterMetadataSize {
+		return nil, fmt.Errorf("read %s from %v: file too large", path, sub)
+	}
+	return data, nil
+}
+
+// narInfo downloads and verifies the .narinfo file for the given store path.
+func (sub *substituter) narInfo(ctx context.Context, path zbstore.Path, trusted []*nix.PublicKey) (*remotestore.NARInfo, error) {
+	data, err := sub.readFile(ctx, path.Digest()+remotestore.NARInfoExtension)
+	if err != nil {
+		return nil, err
+	}
+	info := new(remotestore.NARInfo)
+	if err := info.UnmarshalText(data); err != nil {
+		return nil, fmt.Errorf("%s from %v: %v", path, sub, err)
+	}
+	if info.StorePath != path {
+		return nil, fmt.Errorf("%s from %v: narinfo is for %s", path, sub, info.StorePath)
+	}
+	if err := remotestore.VerifyAnyNARInfo(trusted, info); err != nil {
+		return nil, fmt.Errorf("%s from %v: %v", path, sub, err)
+	}
+	return info, nil
+}
+
+// realization downloads and verifies the realization for the given derivation output.
+func (sub *substituter) realization(ctx context.Context, dir zbstore.Directory, drvHash nix.Hash, outputName string, trusted []*nix.PublicKey) (zbstore.Path, error) {
+	data, err := sub.readFile(ctx, remotestore.RealizationPath(drvHash, outputName))
+	if err != nil {
+		return "", err
+	}
+	r := new(remotestore.Realization)
+	if err := json.Unmarshal(data, r); err != nil {
+		return "", fmt.Errorf("%v from %v: %v", newEquivalenceClass(drvHash, outputName), sub, err)
+	}
+	if !r.DerivationHash.Equal(drvHash) || r.OutputName != outputName {
+		return "", fmt.Errorf("%v from %v: realization is for %s", newEquivalenceClass(drvHash, outputName), sub, r.ID())
+	}
+	if r.OutputPath.Dir() != dir {
+		return "", fmt.Errorf("%v from %v: %s is not in %s", newEquivalenceClass(drvHash, outputName), sub, r.OutputPath, dir)
+	}
+	if err := remotestore.VerifyAnyRealization(trusted, r); err != nil {
+		return "", fmt.Errorf("%v from %v: %v", newEquivalenceClass(drvHash, outputName), sub, err)
+	}
+	return r.OutputPath, nil
+}
+
+// querySubstituters finds the first substituter that has a valid .narinfo file for path.
+func (s *Server) querySubstituters(ctx context.Context, path zbstore.Path) (*substituter, *remotestore.NARInfo, error) {
+	for _, sub := range s.substituters {
+		info, err := sub.narInfo(ctx, path, s.trustedKeys)
+		if errors.Is(err, errNotSubstitutable) {
+			log.Debugf(ctx, "%s not found in %v", path, sub)
+			continue
+		}
+		if err != nil {
+			log.Warnf(ctx, "Querying substituter: %v", err)
+			continue
+		}
+		return sub, info, nil
+	}
+	return nil, nil, fmt.Errorf("substitute %s: %w", path, errNotSubstitutable)
+}
+
+// substitute ensures that the given store object and its closure are present in the store,
+// downloading any missing objects from the server's substituters.
+// The store object is added to roots,
+// which protects its closure from garbage collection.
+func (s *Server) substitute(ctx context.Context, roots *tempRoots, path zbstore.Path) error {
+	// Protect the path from garbage collection before checking for existence
+	// so that a concurrent collection doesn't remove it out from under us.
+	roots.add(path)
+	if _, err := os.Lstat(s.realPath(path)); err == nil {
+		return nil
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("substitute %s: %v", path, err)
+	}
+	if len(s.substituters) == 0 {
+		return fmt.Errorf("substitute %s: %w", path, errNotSubstitutable)
+	}
+
+	sub, info, err := s.querySubstituters(ctx, path)
+	if err != nil {
+		return err
+	}
+	for _, ref := range info.References.All() {
+		if ref == path {
+			continue
+		}
+		if err := s.substitute(ctx, roots, ref); err != nil {
+			return fmt.Errorf("substitute %s: %w", path, err)
+		}
+	}
+	if err := s.downloadNAR(ctx, roots, sub, info); err != nil {
+		return err
+	}
+	return nil
+}
+
+// downloadNAR downloads the store object described by info from sub
+// and imports it into the store.
+func (s *Server) downloadNAR(ctx context.Context, roots *tempRoots, sub *substituter, info *remotestore.NARInfo) error {
+	log.Infof(ctx, "Downloading %s from %v...", info.StorePath, sub)
+	receiver := s.newInternalNARReceiver(ctx, bytebuffer.TempFileCreator{
+		Dir:     s.buildDir,
+		Pattern: "zb-substitute-*.nar",
+	})
+	defer receiver.Cleanup(ctx)
+	if err := fetchNAR(ctx, receiver, sub, info); err != nil {
+		return err
+	}
+
+	receiver.ReceiveNAR(&zbstore.ExportTrailer{
+		StorePath:      info.StorePath,
+		References:     info.References,
+		ContentAddress: info.CA,
+	})
+	// ReceiveNAR logs rather than returning errors,
+	// so check whether the import succeeded.
+	if _, err := os.Lstat(s.realPath(info.StorePath)); err != nil {
+		return fmt.Errorf("substitute %s: import failed", info.StorePath)
+	}
+	return nil
+}
+
+// fetchNAR downloads the NAR file described by info from sub,
+// decompresses it, and writes it to dst.
+// fetchNAR returns an error if the NAR file does not match the hashes in info.
+func fetchNAR(ctx context.Context, dst io.Writer, sub *substituter, info *remotestore.NARInfo) error {
+	rc, err := sub.open(ctx, info.URL)
+	if err != nil {
+		return fmt.Errorf("substitute %s: %v", info.StorePath, err)
+	}
+	defer rc.Close()
+
+	var fileHasher *nix.Hasher
+	var compressed io.Reader = rc
+	if !info.FileHash.IsZero() {
+		fileHasher = nix.NewHasher(info.FileHash.Type())
+		compressed = io.TeeReader(rc, fileHasher)
+	}
+	nr, err := decompress(info.Compression, compressed)
+	if err != nil {
+		return fmt.Errorf("substitute %s: %v", info.StorePath, err)
+	}
+	defer nr.Close()
+
+	narHasher := nix.NewHasher(info.NARHash.Type())
+	n, err := io.Copy(io.MultiWriter(dst, narHasher), io.LimitReader(nr, info.NARSize+1))
+	if err != nil {
+		return fmt.Errorf("substitute %s: %v", info.StorePath, err)
+	}
+	if n != info.NARSize {
+		return fmt.Errorf("substitute %s: nar is %d bytes (expected %d)", info.StorePath, n, info.NARSize)
+	}
+	if got := narHasher.SumHash(); !got.Equal(info.NARHash) {
+		return fmt.Errorf("substitute %s: nar hash %v does not match %v", info.StorePath, got, info.NARHash)
+	}
+	if fileHasher != nil {
+		// Drain the rest of the file so that the file hash covers all of it.
+		if _, err := io.Copy(io.Discard, compressed); err != nil {
+			return fmt.Errorf("substitute %s: %v", info.StorePath, err)
+		}
+		if got := fileHasher.SumHash(); !got.Equal(info.FileHash) {
+			return fmt.Errorf("substitute %s: file hash %v does not match %v", info.StorePath, got, info.FileHash)
+		}
+	}
+	return nil
+}
+
+// decompress returns a reader that decompresses r
+// using the given compression algorithm.
+func decompress(ct remotestore.CompressionType, r io.Reader) (io.ReadCloser, error) {
+	switch ct {
+	case remotestore.NoCompression:
+		return io.NopCloser(r), nil
+	case remotestore.Bzip2, "":
+		return io.NopCloser(bzip2.NewReader(r)), nil
+	case remotestore.Gzip:
+		return gzip.NewReader(r)
+	case remotestore.XZ:
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return io.NopCloser(xr), nil
+	case remotestore.Zstandard:
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		return zr.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported compression %q", ct)
+	}
+}
+
+// substituteOutputs attempts to download the given outputs of a derivation
+// from the server's substituters
+// and records them as realizations of the derivation.
+// If any of the outputs are not available,
+// then substituteOutputs returns an error
+// for which errors.Is(err, errNotSubstitutable) reports true.
+func (b *builder) substituteOutputs(ctx context.Context, conn *sqlite.Conn, drvPath zbstore.Path, drvHash nix.Hash, outputNames sets.Set[string], buildResultID int64) error {
+	if len(b.server.substituters) == 0 {
+		return fmt.Errorf("substitute %s: %w", drvPath, errNotSubstitutable)
+	}
+
+	outputPaths := make(map[string]zbstore.Path)
+	for outputName := range outputNames.All() {
+		eqClass := newEquivalenceClass(drvHash, outputName)
+		for _, sub := range b.server.substituters {
+			outputPath, err := sub.realization(ctx, b.server.dir, drvHash, outputName, b.server.trustedKeys)
+			if errors.Is(err, errNotSubstitutable) {
+				log.Debugf(ctx, "%v not found in %v", eqClass, sub)
+				continue
+			}
+			if err != nil {
+				log.Warnf(ctx, "Querying substituter: %v", err)
+				continue
+			}
+			outputPaths[outputName] = outputPath
+			break
+		}
+		if outputPaths[outputName] == "" {
+			return fmt.Errorf("substitute %s: %v: %w", drvPath, eqClass, errNotSubstitutable)
+		}
+	}
+
+	inputs, err := b.inputs(conn, drvPath)
+	if err != nil {
+		return err
+	}
+	outputs := make(map[string]realizationOutput)
+	for outputName, outputPath := range outputPaths {
+		if err := b.server.substitute(ctx, b.roots, outputPath); err != nil {
+			return fmt.Errorf("substitute %s: %w", drvPath, err)
+		}
+		info, err := pathInfo(conn, outputPath)
+		if err != nil {
+			return fmt.Errorf("substitute %s: %v", drvPath, err)
+		}
+		references := make(map[zbstore.Path]sets.Set[equivalenceClass])
+		for _, ref := range info.References.All() {
+			if ref == outputPath {
+				continue
+			}
+			eqClasses, ok := inputs[ref]
+			if !ok {
+				// The output can only refer to objects that the builder had access to.
+				return fmt.Errorf("substitute %s: output %s: %s references %s, which is not an input",
+					drvPath, outputName, outputPath, ref)
+			}
+			references[ref] = eqClasses
+		}
+		outputs[outputName] = realizationOutput{
+			path:       outputPath,
+			references: references,
+		}
+	}
+	if err := b.recordRealizations(ctx, conn, drvHash, buildResultID, outputs); err != nil {
+		return fmt.Errorf("substitute %s: %v", drvPath, err)
+	}
+
+	log.Infof(ctx, "Substituted %s: %s", drvPath, formatOutputPaths(outputPaths))
+	return nil
+}
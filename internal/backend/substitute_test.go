@@ -0,0 +1,353 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	. "zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/remotestore"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestRealizeSubstituteFixed(t *testing.T) {
+	for _, compression := range []remotestore.CompressionType{remotestore.NoCompression, remotestore.XZ, remotestore.Zstandard} {
+		t.Run(string(compression), func(t *testing.T) {
+			ctx, cancel := testcontext.New(t)
+			defer cancel()
+			dir := backendtest.NewStoreDirectory(t)
+
+			const wantOutputName = "hello.txt"
+			const wantOutputContent = "Hello, World!\n"
+			wantOutputCA := nix.FlatFileContentAddress(mustParseHash(t, "sha256:c98c24b677eff44860afea6f493bbaec5bb1c4cbb209c6fc2bbb47f66ff2ad31"))
+			wantOutputPath, err := zbstore.FixedCAOutputPath(dir, wantOutputName, wantOutputCA, zbstore.References{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			pub, pk, err := nix.GenerateKey("example.com-1", rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cacheDir := t.TempDir()
+			writeCacheObject(t, cacheDir, pk, wantOutputPath, []byte(wantOutputContent), wantOutputCA, compression)
+			cacheServer := httptest.NewServer(http.FileServer(http.Dir(cacheDir)))
+			t.Cleanup(cacheServer.Close)
+
+			// The builder always fails, so the build can only succeed by substitution.
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExporter(exportBuffer)
+			drvContent := &zbstore.Derivation{
+				Name:   wantOutputName,
+				Dir:    dir,
+				System: system.Current().String(),
+				Env: map[string]string{
+					"out": zbstore.HashPlaceholder("out"),
+				},
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: zbstore.FixedCAOutput(wantOutputCA),
+				},
+			}
+			drvContent.Builder, drvContent.Args = failingBuilder()
+			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+				Options: Options{
+					Substituters:      []*url.URL{mustParseURL(t, cacheServer.URL)},
+					TrustedPublicKeys: []*nix.PublicKey{pub},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("RPC error:", err)
+			}
+			got, err := backendtest.WaitForSuccessfulBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+				t.Fatalf("%v\nlog:\n%s", err, gotLog)
+			}
+			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+		})
+	}
+}
+
+func TestRealizeSubstituteFloating(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted bool
+	}{
+		{name: "Trusted", trusted: true},
+		{name: "Untrusted", trusted: false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := testcontext.New(t)
+			defer cancel()
+			dir := backendtest.NewStoreDirectory(t)
+
+			const wantOutputName = "hello.txt"
+			const wantOutputContent = "Hello, World!\n"
+			drvContent := &zbstore.Derivation{
+				Name:   wantOutputName,
+				Dir:    dir,
+				System: system.Current().String(),
+				Env: map[string]string{
+					"out": zbstore.HashPlaceholder("out"),
+				},
+				Outputs: map[string]*zbstore.DerivationOutputType{
+					zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+				},
+			}
+			// The builder always fails, so the build can only succeed by substitution.
+			drvContent.Builder, drvContent.Args = failingBuilder()
+			exportBuffer := new(bytes.Buffer)
+			exporter := zbstore.NewExporter(exportBuffer)
+			drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := exporter.Close(); err != nil {
+				t.Fatal(err)
+			}
+			wantOutputPath, err := singleFileOutputPath(dir, wantOutputName, []byte(wantOutputContent), zbstore.References{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// For a derivation without inputs,
+			// the derivation hash is computed directly from its contents.
+			drvText, err := drvContent.MarshalText()
+			if err != nil {
+				t.Fatal(err)
+			}
+			drvHasher := nix.NewHasher(nix.SHA256)
+			drvHasher.WriteString("floating:" + drvContent.Name + ":")
+			drvHasher.Write(drvText)
+			drvHash := drvHasher.SumHash()
+
+			pub, pk, err := nix.GenerateKey("example.com-1", rand.Reader)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !test.trusted {
+				// Sign with a different key of the same name.
+				_, pk, err = nix.GenerateKey("example.com-1", rand.Reader)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			cacheDir := t.TempDir()
+			writeCacheObject(t, cacheDir, pk, wantOutputPath, []byte(wantOutputContent), zbstore.ContentAddress{}, remotestore.XZ)
+			r := &remotestore.Realization{
+				DerivationHash: drvHash,
+				OutputName:     zbstore.DefaultDerivationOutputName,
+				OutputPath:     wantOutputPath,
+			}
+			sig, err := remotestore.SignRealization(pk, r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			r.Sig = append(r.Sig, sig)
+			realizationData, err := json.Marshal(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			realizationPath := filepath.Join(cacheDir, filepath.FromSlash(remotestore.RealizationPath(drvHash, zbstore.DefaultDerivationOutputName)))
+			if err := os.MkdirAll(filepath.Dir(realizationPath), 0o777); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(realizationPath, realizationData, 0o666); err != nil {
+				t.Fatal(err)
+			}
+			cacheServer := httptest.NewServer(http.FileServer(http.Dir(cacheDir)))
+			t.Cleanup(cacheServer.Close)
+
+			_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+				TempDir: t.TempDir(),
+				Options: Options{
+					Substituters:      []*url.URL{mustParseURL(t, cacheServer.URL)},
+					TrustedPublicKeys: []*nix.PublicKey{pub},
+				},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			codec, releaseCodec, err := storeCodec(ctx, client)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = codec.Export(nil, exportBuffer)
+			releaseCodec()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			realizeResponse := new(zbstorerpc.RealizeResponse)
+			err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+				DrvPaths: []zbstore.Path{drvPath},
+			})
+			if err != nil {
+				t.Fatal("RPC error:", err)
+			}
+			got, err := backendtest.WaitForBuild(ctx, client, realizeResponse.BuildID)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !test.trusted {
+				if got.Status != zbstorerpc.BuildFail {
+					t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildFail)
+				}
+				if _, err := os.Lstat(string(wantOutputPath)); err == nil {
+					t.Errorf("%s exists", wantOutputPath)
+				}
+				return
+			}
+			if got.Status != zbstorerpc.BuildSuccess {
+				gotLog, _ := backendtest.ReadLog(ctx, client, realizeResponse.BuildID, drvPath)
+				t.Fatalf("build status = %q; want %q\nlog:\n%s", got.Status, zbstorerpc.BuildSuccess, gotLog)
+			}
+			checkSingleFileOutput(t, drvPath, wantOutputPath, []byte(wantOutputContent), got)
+		})
+	}
+}
+
+// writeCacheObject writes a single-file store object
+// and its signed .narinfo file to a binary cache directory.
+func writeCacheObject(tb testing.TB, cacheDir string, pk *nix.PrivateKey, path zbstore.Path, content []byte, ca zbstore.ContentAddress, compression remotestore.CompressionType) {
+	tb.Helper()
+
+	narBuffer := new(bytes.Buffer)
+	if err := storetest.SingleFileNAR(narBuffer, content); err != nil {
+		tb.Fatal(err)
+	}
+	narHasher := nix.NewHasher(nix.SHA256)
+	narHasher.Write(narBuffer.Bytes())
+	info := &remotestore.NARInfo{
+		StorePath:   path,
+		Compression: compression,
+		NARHash:     narHasher.SumHash(),
+		NARSize:     int64(narBuffer.Len()),
+		CA:          ca,
+	}
+
+	fileBuffer := new(bytes.Buffer)
+	var w io.WriteCloser
+	var ext string
+	switch compression {
+	case remotestore.NoCompression:
+		w = nopWriteCloser{fileBuffer}
+	case remotestore.XZ:
+		var err error
+		w, err = xz.NewWriter(fileBuffer)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		ext = ".xz"
+	case remotestore.Zstandard:
+		var err error
+		w, err = zstd.NewWriter(fileBuffer)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		ext = ".zst"
+	default:
+		tb.Fatalf("unsupported compression %q", compression)
+	}
+	if _, err := w.Write(narBuffer.Bytes()); err != nil {
+		tb.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		tb.Fatal(err)
+	}
+	fileHasher := nix.NewHasher(nix.SHA256)
+	fileHasher.Write(fileBuffer.Bytes())
+	info.FileHash = fileHasher.SumHash()
+	info.FileSize = int64(fileBuffer.Len())
+	info.URL = "nar/" + info.FileHash.RawBase32() + ".nar" + ext
+
+	sig, err := remotestore.SignNARInfo(pk, info)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	info.AddSignatures(sig)
+	infoData, err := info.MarshalText()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(cacheDir, "nar"), 0o777); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, filepath.FromSlash(info.URL)), fileBuffer.Bytes(), 0o666); err != nil {
+		tb.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(cacheDir, path.Digest()+remotestore.NARInfoExtension), infoData, 0o666); err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func failingBuilder() (builder string, args []string) {
+	if runtime.GOOS == "windows" {
+		return powershellPath, []string{"-Command", "exit 1"}
+	}
+	return shPath, []string{"-c", "exit 1"}
+}
+
+func mustParseURL(tb testing.TB, s string) *url.URL {
+	tb.Helper()
+	u, err := url.Parse(s)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return u
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
@@ -0,0 +1,456 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/narutil"
+	"zb.256lights.llc/pkg/internal/xio"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+	"zombiezen.com/go/nix"
+	"zombiezen.com/go/nix/nar"
+	"zombiezen.com/go/sqlite"
+	"zombiezen.com/go/sqlite/sqlitex"
+)
+
+func (s *Server) verify(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.VerifyRequest
+	if err := json.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	resp, err := s.verifyStore(ctx, &args)
+	if err != nil {
+		return nil, err
+	}
+	return marshalResponse(resp)
+}
+
+func (s *Server) verifyStore(ctx context.Context, opts *zbstorerpc.VerifyRequest) (_ *zbstorerpc.VerifyResponse, err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("verify store: %v", err)
+		}
+	}()
+
+	// Garbage collection removes store objects,
+	// which would otherwise show up as missing.
+	s.gcMu.Lock()
+	defer s.gcMu.Unlock()
+
+	conn, err := s.db.Get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer s.db.Put(conn)
+
+	var paths []zbstore.Path
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "gc/objects.sql", &sqlitex.ExecOptions{
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			path, err := zbstore.ParsePath(stmt.GetText("path"))
+			if err != nil {
+				return err
+			}
+			paths = append(paths, path)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("list store objects: %v", err)
+	}
+
+	log.Infof(ctx, "Verifying %d store objects...", len(paths))
+	resp := &zbstorerpc.VerifyResponse{
+		Problems: []*zbstorerpc.VerifyProblem{},
+	}
+	for _, path := range paths {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		problem, err := s.verifyObject(ctx, conn, path, opts.CheckContents)
+		if err != nil {
+			return nil, err
+		}
+		resp.Checked++
+		if problem == nil {
+			continue
+		}
+		log.Warnf(ctx, "%s", problem.Message)
+		if opts.Repair {
+			s.repairObject(ctx, conn, problem)
+		}
+		resp.Problems = append(resp.Problems, problem)
+	}
+
+	orphans, err := s.verifyOrphans(ctx, conn, sets.New(paths...), opts.Repair)
+	if err != nil {
+		return nil, err
+	}
+	resp.Problems = append(resp.Problems, orphans...)
+
+	log.Infof(ctx, "Verified %d store objects (%d problems found)", resp.Checked, len(resp.Problems))
+	return resp, nil
+}
+
+// verifyObject checks that the store object at path is present in the store directory.
+// If checkContents is true, then verifyObject also checks
+// that the store object's contents match its database record.
+// verifyObject returns a nil problem if the store object is intact.
+func (s *Server) verifyObject(ctx context.Context, conn *sqlite.Conn, path zbstore.Path, checkContents bool) (*zbstorerpc.VerifyProblem, error) {
+	log.Debugf(ctx, "Verifying %s...", path)
+	realPath := s.realPath(path)
+	unlock, err := s.writing.lock(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	_, statError := os.Lstat(realPath)
+	unlock()
+	if errors.Is(statError, os.ErrNotExist) {
+		return &zbstorerpc.VerifyProblem{
+			Path:    path,
+			Kind:    zbstorerpc.MissingObject,
+			Message: fmt.Sprintf("%s is missing from %s", path, s.realDir),
+		}, nil
+	}
+	if statError != nil {
+		return &zbstorerpc.VerifyProblem{
+			Path:    path,
+			Kind:    zbstorerpc.CorruptObject,
+			Message: statError.Error(),
+		}, nil
+	}
+	if !checkContents {
+		return nil, nil
+	}
+
+	// Store objects are not modified once they are present,
+	// so we can read the object without holding its lock.
+	info, err := pathInfo(conn, path)
+	if errors.Is(err, errObjectNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := s.checkObjectContents(ctx, info); err != nil {
+		return &zbstorerpc.VerifyProblem{
+			Path:    path,
+			Kind:    zbstorerpc.CorruptObject,
+			Message: err.Error(),
+		}, nil
+	}
+	return nil, nil
+}
+
+// checkObjectContents serializes the store object described by info
+// and returns an error if the result does not match info.
+func (s *Server) checkObjectContents(ctx context.Context, info *ObjectInfo) error {
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	wc := new(xio.WriteCounter)
+	hasher := nix.NewHasher(info.NARHash.Type())
+	go func() {
+		err := nar.DumpPath(io.MultiWriter(wc, hasher, pw), s.realPath(info.StorePath))
+		pw.CloseWithError(err)
+		close(done)
+	}()
+	_, err := verifyContentAddress(ctx, info.StorePath, pr, &info.References, info.CA, s.caCreateTemp)
+	if err == nil {
+		// Make sure the hash covers the whole NAR.
+		if _, err = io.Copy(io.Discard, pr); err != nil {
+			err = fmt.Errorf("verify %s: %v", info.StorePath, err)
+		}
+	}
+	pr.Close()
+	<-done
+	if err != nil {
+		return err
+	}
+
+	if got := int64(*wc); got != info.NARSize {
+		return fmt.Errorf("verify %s: nar is %d bytes (expected %d)", info.StorePath, got, info.NARSize)
+	}
+	if got := hasher.SumHash(); !got.Equal(info.NARHash) {
+		return fmt.Errorf("verify %s: nar hash %v does not match %v", info.StorePath, got, info.NARHash)
+	}
+	return nil
+}
+
+// repairObject attempts to fix a missing or corrupt store object
+// and records the outcome in problem.
+// repairObject first tries to download the store object from the server's substituters.
+// If that fails, then repairObject forgets the store object
+// so that the next build that needs it will build it again.
+func (s *Server) repairObject(ctx context.Context, conn *sqlite.Conn, problem *zbstorerpc.VerifyProblem) {
+	info, err := pathInfo(conn, problem.Path)
+	if err != nil {
+		problem.RepairError = err.Error()
+		return
+	}
+
+	err = s.resubstitute(ctx, info)
+	if err == nil {
+		log.Infof(ctx, "Repaired %s from substituter", problem.Path)
+		problem.Repair = zbstorerpc.Substituted
+		return
+	}
+	if !errors.Is(err, errNotSubstitutable) {
+		log.Warnf(ctx, "%v", err)
+	}
+
+	if err := s.forget(ctx, conn, problem.Path); err != nil {
+		log.Errorf(ctx, "Unable to repair: %v", err)
+		problem.RepairError = err.Error()
+		return
+	}
+	log.Infof(ctx, "Removed %s from store database", problem.Path)
+	problem.Repair = zbstorerpc.Forgotten
+}
+
+// resubstitute downloads the store object described by info
+// from the server's substituters
+// and replaces any existing copy in the store directory,
+// moving it to the quarantine directory.
+// The downloaded object must have the NAR hash recorded in info.
+func (s *Server) resubstitute(ctx context.Context, info *ObjectInfo) error {
+	path := info.StorePath
+	if len(s.substituters) == 0 {
+		return fmt.Errorf("substitute %s: %w", path, errNotSubstitutable)
+	}
+	sub, narInfo, err := s.querySubstituters(ctx, path)
+	if err != nil {
+		return err
+	}
+	if !narInfo.NARHash.Equal(info.NARHash) {
+		return fmt.Errorf("substitute %s: %v has nar hash %v (expected %v)", path, sub, narInfo.NARHash, info.NARHash)
+	}
+
+	// Extract to a temporary location in the store directory first
+	// so that the final move is a cheap rename.
+	log.Infof(ctx, "Downloading %s from %v...", path, sub)
+	tempDir, err := os.MkdirTemp(s.realDir, ".zb-repair-*")
+	if err != nil {
+		return fmt.Errorf("substitute %s: %v", path, err)
+	}
+	defer func() {
+		if err := os.RemoveAll(tempDir); err != nil {
+			log.Warnf(ctx, "Cleanup failure: %v", err)
+		}
+	}()
+	newPath := filepath.Join(tempDir, path.Base())
+	pr, pw := io.Pipe()
+	fetchError := make(chan error, 1)
+	go func() {
+		err := fetchNAR(ctx, pw, sub, narInfo)
+		pw.CloseWithError(err)
+		fetchError <- err
+	}()
+	// Errors from fetchNAR are passed along to narutil.Extract through the pipe.
+	err = narutil.Extract(newPath, pr)
+	if err == nil {
+		_, err = io.Copy(io.Discard, pr)
+	}
+	pr.Close()
+	if err != nil {
+		<-fetchError
+		return fmt.Errorf("substitute %s: %v", path, err)
+	}
+	if err := <-fetchError; err != nil {
+		return err
+	}
+
+	unlock, err := s.writing.lock(ctx, path)
+	if err != nil {
+		return fmt.Errorf("substitute %s: %v", path, err)
+	}
+	defer unlock()
+	realPath := s.realPath(path)
+	if _, err := os.Lstat(realPath); err == nil {
+		if err := s.quarantine(ctx, path); err != nil {
+			return fmt.Errorf("substitute %s: %v", path, err)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("substitute %s: %v", path, err)
+	}
+	if err := os.Rename(newPath, realPath); err != nil {
+		return fmt.Errorf("substitute %s: %v", path, err)
+	}
+	freeze(ctx, realPath)
+	return nil
+}
+
+// forget removes the store object at path from the database
+// and moves any file at its location in the store directory to the quarantine directory.
+// Realizations of the store object are kept,
+// so the next build that needs the object will rebuild or substitute it.
+// forget returns an error if other store objects refer to path.
+func (s *Server) forget(ctx context.Context, conn *sqlite.Conn, path zbstore.Path) (err error) {
+	defer func() {
+		if err != nil {
+			err = fmt.Errorf("forget %s: %v", path, err)
+		}
+	}()
+
+	unlock, err := s.writing.lock(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	endFn, err := sqlitex.ImmediateTransaction(conn)
+	if err != nil {
+		return err
+	}
+	defer endFn(&err)
+
+	var referrers []string
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "referrers.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":path": string(path)},
+		ResultFunc: func(stmt *sqlite.Stmt) error {
+			referrers = append(referrers, stmt.GetText("path"))
+			return nil
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if len(referrers) > 0 {
+		return fmt.Errorf("referenced by %s", strings.Join(referrers, ", "))
+	}
+
+	if _, err := os.Lstat(s.realPath(path)); err == nil {
+		if err := s.quarantine(ctx, path); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "delete/delete_self_ref.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":path": string(path)},
+	})
+	if err != nil {
+		return err
+	}
+	err = sqlitex.ExecuteTransientFS(conn, sqlFiles(), "delete/delete.sql", &sqlitex.ExecOptions{
+		Named: map[string]any{":path": string(path)},
+	})
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// verifyOrphans returns a problem for each store object in the store directory
+// that does not have a database record.
+// known is the set of store objects that are known to have a record.
+// If repair is true, then verifyOrphans moves the orphans to the quarantine directory.
+func (s *Server) verifyOrphans(ctx context.Context, conn *sqlite.Conn, known sets.Set[zbstore.Path], repair bool) ([]*zbstorerpc.VerifyProblem, error) {
+	entries, err := os.ReadDir(s.realDir)
+	if err != nil {
+		return nil, err
+	}
+	var problems []*zbstorerpc.VerifyProblem
+	for _, ent := range entries {
+		path, err := s.dir.Object(ent.Name())
+		if err != nil {
+			log.Debugf(ctx, "Ignoring %s in store directory (not a store object)", ent.Name())
+			continue
+		}
+		if known.Has(path) || s.tempRoots.has(path) {
+			continue
+		}
+		problem, err := s.checkOrphan(ctx, conn, path, repair)
+		if err != nil {
+			return nil, err
+		}
+		if problem != nil {
+			problems = append(problems, problem)
+		}
+	}
+	return problems, nil
+}
+
+// checkOrphan checks whether the store object at path is an orphan
+// while holding its write lock.
+// Imports and builds may have added the object since the store directory was read.
+func (s *Server) checkOrphan(ctx context.Context, conn *sqlite.Conn, path zbstore.Path, repair bool) (*zbstorerpc.VerifyProblem, error) {
+	unlock, err := s.writing.lock(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	if s.tempRoots.has(path) {
+		return nil, nil
+	}
+	if exists, err := objectExists(conn, path); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, nil
+	}
+	if _, err := os.Lstat(s.realPath(path)); errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+
+	problem := &zbstorerpc.VerifyProblem{
+		Path:    path,
+		Kind:    zbstorerpc.OrphanObject,
+		Message: fmt.Sprintf("%s is not recorded in the store database", path),
+	}
+	log.Warnf(ctx, "%s", problem.Message)
+	if repair {
+		if err := s.quarantine(ctx, path); err != nil {
+			log.Errorf(ctx, "Unable to repair: %v", err)
+			problem.RepairError = err.Error()
+		} else {
+			problem.Repair = zbstorerpc.Quarantined
+		}
+	}
+	return problem, nil
+}
+
+// quarantine moves the file for the store object at path
+// to a new directory inside the server's quarantine directory.
+// The caller must hold the write lock for path.
+func (s *Server) quarantine(ctx context.Context, path zbstore.Path) error {
+	realPath := s.realPath(path)
+	info, err := os.Lstat(realPath)
+	if err != nil {
+		return fmt.Errorf("quarantine %s: %v", path, err)
+	}
+	if err := os.MkdirAll(s.quarantineDir, 0o755); err != nil {
+		return fmt.Errorf("quarantine %s: %v", path, err)
+	}
+	dir, err := os.MkdirTemp(s.quarantineDir, path.Base()+"-*")
+	if err != nil {
+		return fmt.Errorf("quarantine %s: %v", path, err)
+	}
+	if info.IsDir() {
+		// Moving a directory to a new parent requires write permission on it
+		// to update its ".." entry.
+		if err := os.Chmod(realPath, info.Mode().Perm()|0o200); err != nil {
+			os.Remove(dir)
+			return fmt.Errorf("quarantine %s: %v", path, err)
+		}
+	}
+	dst := filepath.Join(dir, path.Base())
+	if err := os.Rename(realPath, dst); err != nil {
+		os.Remove(dir)
+		return fmt.Errorf("quarantine %s: %v", path, err)
+	}
+	log.Infof(ctx, "Moved %s to %s", path, dst)
+	return nil
+}
@@ -0,0 +1,241 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	. "zb.256lights.llc/pkg/internal/backend"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/remotestore"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestVerify(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	intactPath, _, err := storetest.ExportText(exporter, dir, "intact.txt", []byte("intact\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	missingPath, _, err := storetest.ExportText(exporter, dir, "missing.txt", []byte("missing\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corruptPath, _, err := storetest.ExportText(exporter, dir, "corrupt.txt", []byte("corrupt\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrerPath, _, err := storetest.ExportText(exporter, dir, "referrer.txt", []byte(corruptPath+"\n"), sets.NewSorted(corruptPath))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const cachedContent = "cached\n"
+	cachedPath, cachedCA, err := storetest.ExportText(exporter, dir, "cached.txt", []byte(cachedContent), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	orphanPath, _, err := storetest.ExportText(zbstore.NewExporter(io.Discard), dir, "orphan.txt", []byte("orphan\n"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	pub, pk, err := nix.GenerateKey("example.com-1", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cacheDir := t.TempDir()
+	writeCacheObject(t, cacheDir, pk, cachedPath, []byte(cachedContent), cachedCA, remotestore.NoCompression)
+	cacheServer := httptest.NewServer(http.FileServer(http.Dir(cacheDir)))
+	t.Cleanup(cacheServer.Close)
+
+	quarantineDir := t.TempDir()
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: Options{
+			QuarantineDirectory: quarantineDir,
+			Substituters:        []*url.URL{mustParseURL(t, cacheServer.URL)},
+			TrustedPublicKeys:   []*nix.PublicKey{pub},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Exports don't send a response, so this introduces a sync point
+	// before we modify the store.
+	for _, path := range []zbstore.Path{missingPath, corruptPath, cachedPath} {
+		var exists bool
+		err = jsonrpc.Do(ctx, client, zbstorerpc.ExistsMethod, &exists, &zbstorerpc.ExistsRequest{
+			Path: string(path),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !exists {
+			t.Fatalf("store reports exists=false for %s", path)
+		}
+	}
+
+	// Damage the store.
+	if err := os.Remove(string(missingPath)); err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []zbstore.Path{corruptPath, cachedPath} {
+		if err := os.Chmod(string(path), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(string(path), []byte("oops\n"), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(string(orphanPath), []byte("orphan\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// summarize returns a map of store paths to problem kind and repair.
+	summarize := func(resp *zbstorerpc.VerifyResponse) map[zbstore.Path]string {
+		m := make(map[zbstore.Path]string)
+		for _, problem := range resp.Problems {
+			s := string(problem.Kind)
+			switch {
+			case problem.Repair != "":
+				s += " " + string(problem.Repair)
+			case problem.RepairError != "":
+				s += " error"
+			}
+			m[problem.Path] = s
+		}
+		return m
+	}
+	const wantChecked = 5
+
+	t.Run("Existence", func(t *testing.T) {
+		got := new(zbstorerpc.VerifyResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.VerifyMethod, got, &zbstorerpc.VerifyRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Checked != wantChecked {
+			t.Errorf("checked = %d; want %d", got.Checked, wantChecked)
+		}
+		want := map[zbstore.Path]string{
+			missingPath: "missing",
+			orphanPath:  "orphan",
+		}
+		if diff := cmp.Diff(want, summarize(got)); diff != "" {
+			t.Errorf("problems (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("CheckContents", func(t *testing.T) {
+		got := new(zbstorerpc.VerifyResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.VerifyMethod, got, &zbstorerpc.VerifyRequest{
+			CheckContents: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[zbstore.Path]string{
+			missingPath: "missing",
+			corruptPath: "corrupt",
+			cachedPath:  "corrupt",
+			orphanPath:  "orphan",
+		}
+		if diff := cmp.Diff(want, summarize(got)); diff != "" {
+			t.Errorf("problems (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("Repair", func(t *testing.T) {
+		got := new(zbstorerpc.VerifyResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.VerifyMethod, got, &zbstorerpc.VerifyRequest{
+			CheckContents: true,
+			Repair:        true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := map[zbstore.Path]string{
+			missingPath: "missing forgotten",
+			// Other store objects refer to corruptPath,
+			// so it can't be forgotten.
+			corruptPath: "corrupt error",
+			cachedPath:  "corrupt substituted",
+			orphanPath:  "orphan quarantined",
+		}
+		if diff := cmp.Diff(want, summarize(got)); diff != "" {
+			t.Errorf("problems (-want +got):\n%s", diff)
+		}
+
+		if got, err := os.ReadFile(string(cachedPath)); err != nil {
+			t.Error(err)
+		} else if string(got) != cachedContent {
+			t.Errorf("after repair, %s content = %q; want %q", cachedPath, got, cachedContent)
+		}
+		if _, err := os.Lstat(string(orphanPath)); !os.IsNotExist(err) {
+			t.Errorf("after repair, %s exists (error = %v)", orphanPath, err)
+		}
+		if _, err := os.Lstat(string(intactPath)); err != nil {
+			t.Error(err)
+		}
+		if _, err := os.Lstat(string(referrerPath)); err != nil {
+			t.Error(err)
+		}
+		if entries, err := os.ReadDir(quarantineDir); err != nil {
+			t.Error(err)
+		} else if len(entries) != 2 {
+			// Expect the orphan and the damaged copy of cachedPath.
+			t.Errorf("quarantine directory has %d entries; want 2", len(entries))
+		}
+	})
+
+	t.Run("AfterRepair", func(t *testing.T) {
+		got := new(zbstorerpc.VerifyResponse)
+		err := jsonrpc.Do(ctx, client, zbstorerpc.VerifyMethod, got, &zbstorerpc.VerifyRequest{
+			CheckContents: true,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got.Checked != wantChecked-1 {
+			t.Errorf("checked = %d; want %d", got.Checked, wantChecked-1)
+		}
+		want := map[zbstore.Path]string{
+			corruptPath: "corrupt",
+		}
+		if diff := cmp.Diff(want, summarize(got)); diff != "" {
+			t.Errorf("problems (-want +got):\n%s", diff)
+		}
+	})
+}
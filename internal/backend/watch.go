@@ -0,0 +1,304 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+// maxLogEventSize is the maximum number of bytes of log sent in a single build event.
+const maxLogEventSize = 64 * 1024
+
+// buildUpdateWaiters is a channel that is closed
+// the next time a build changes state.
+type buildUpdateWaiters struct {
+	c chan struct{}
+	// n is the number of callers of [*Server.buildUpdateChan]
+	// that have not released the channel.
+	n int
+}
+
+// buildUpdateChan returns a channel that is closed
+// the next time the build with the given ID changes state.
+// Callers should obtain the channel before reading the build's state
+// to avoid missing updates.
+// Callers must call the returned release function
+// once they are no longer waiting on the channel.
+func (s *Server) buildUpdateChan(buildID uuid.UUID) (_ <-chan struct{}, release func()) {
+	s.buildUpdatesMu.Lock()
+	defer s.buildUpdatesMu.Unlock()
+	w := s.buildUpdates[buildID]
+	if w == nil {
+		w = &buildUpdateWaiters{c: make(chan struct{})}
+		s.buildUpdates[buildID] = w
+	}
+	w.n++
+	return w.c, func() {
+		s.buildUpdatesMu.Lock()
+		defer s.buildUpdatesMu.Unlock()
+		w.n--
+		// If the channel was already closed,
+		// then notifyBuildUpdate has removed it from the map.
+		if w.n == 0 && s.buildUpdates[buildID] == w {
+			delete(s.buildUpdates, buildID)
+		}
+	}
+}
+
+// notifyBuildUpdate wakes any callers waiting on [*Server.buildUpdateChan]
+// for the build with the given ID.
+// It should be called after changes to the build have been committed to the database.
+func (s *Server) notifyBuildUpdate(buildID uuid.UUID) {
+	s.buildUpdatesMu.Lock()
+	defer s.buildUpdatesMu.Unlock()
+	if w := s.buildUpdates[buildID]; w != nil {
+		close(w.c)
+		delete(s.buildUpdates, buildID)
+	}
+}
+
+func (s *Server) watchBuild(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+	var args zbstorerpc.WatchBuildRequest
+	if err := json.Unmarshal(req.Params, &args); err != nil {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, err)
+	}
+	if args.LogStart < 0 {
+		return nil, jsonrpc.Error(jsonrpc.InvalidParams, fmt.Errorf("log start must be non-negative"))
+	}
+	notifier := jsonrpc.NotificationHandlerFromContext(ctx)
+	if notifier == nil {
+		return nil, fmt.Errorf("watch build %s: notifications not supported", args.BuildID)
+	}
+	buildID, ok := parseBuildID(args.BuildID)
+	if !ok {
+		return marshalResponse(&zbstorerpc.Build{
+			ID:      args.BuildID,
+			Status:  zbstorerpc.BuildUnknown,
+			Results: []*zbstorerpc.BuildResult{},
+		})
+	}
+
+	w := &buildWatcher{
+		ctx:        ctx,
+		notifier:   notifier,
+		logDir:     s.logDir,
+		buildID:    buildID,
+		args:       &args,
+		logOffsets: make(map[zbstore.Path]int64),
+		done:       make(sets.Set[zbstore.Path]),
+		outputs:    make(sets.Set[zbstore.OutputReference]),
+	}
+	var ticker *time.Ticker
+	var tick <-chan time.Time
+	if args.Logs {
+		ticker = time.NewTicker(builderLogInterval)
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+	for {
+		build, err := s.watchBuildStep(w, tick)
+		if err != nil {
+			return nil, fmt.Errorf("watch build %v: %w", buildID, err)
+		}
+		if build != nil {
+			build.ID = args.BuildID
+			return marshalResponse(build)
+		}
+	}
+}
+
+// watchBuildStep sends events for the current state of the build
+// and then waits for the build to change state.
+// If the watch is complete, watchBuildStep returns the build.
+// Otherwise, watchBuildStep returns nil after the build changes state.
+func (s *Server) watchBuildStep(w *buildWatcher, tick <-chan time.Time) (*zbstorerpc.Build, error) {
+	updated, release := s.buildUpdateChan(w.buildID)
+	defer release()
+	build, err := s.findBuild(w.ctx, w.buildID)
+	if err != nil {
+		return nil, err
+	}
+	if err := w.update(build.Results); err != nil {
+		return nil, err
+	}
+	if build.Status != zbstorerpc.BuildActive ||
+		w.args.DrvPath != "" && w.done.Has(w.args.DrvPath) {
+		return build, nil
+	}
+
+	for {
+		select {
+		case <-updated:
+			return nil, nil
+		case <-tick:
+			if err := w.sendActiveLogs(); err != nil {
+				return nil, err
+			}
+		case <-w.ctx.Done():
+			return nil, w.ctx.Err()
+		}
+	}
+}
+
+// buildWatcher sends [zbstorerpc.BuildEvent] notifications
+// for changes in a build's results.
+type buildWatcher struct {
+	ctx      context.Context
+	notifier jsonrpc.Handler
+	logDir   string
+	buildID  uuid.UUID
+	args     *zbstorerpc.WatchBuildRequest
+
+	// logOffsets is the set of derivations that have been started
+	// mapped to the number of bytes of log sent.
+	logOffsets map[zbstore.Path]int64
+	// done is the set of derivations that have finished.
+	done sets.Set[zbstore.Path]
+	// outputs is the set of outputs that have been realized.
+	outputs sets.Set[zbstore.OutputReference]
+}
+
+// update sends events for any changes since the last call to update.
+func (w *buildWatcher) update(results []*zbstorerpc.BuildResult) error {
+	for _, result := range results {
+		if w.args.DrvPath != "" && result.DrvPath != w.args.DrvPath {
+			continue
+		}
+		if w.done.Has(result.DrvPath) {
+			continue
+		}
+		if _, started := w.logOffsets[result.DrvPath]; !started {
+			if w.args.DrvPath != "" {
+				w.logOffsets[result.DrvPath] = w.args.LogStart
+			} else {
+				w.logOffsets[result.DrvPath] = 0
+			}
+			err := w.send(&zbstorerpc.BuildEvent{
+				Kind:    zbstorerpc.DerivationStartedEvent,
+				DrvPath: result.DrvPath,
+				Result:  result,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		finished := result.Status.IsFinished()
+		if finished {
+			// The log is complete once the result is finished.
+			if err := w.sendLog(result.DrvPath); err != nil {
+				return err
+			}
+		}
+		for _, output := range result.Outputs {
+			ref := zbstore.OutputReference{
+				DrvPath:    result.DrvPath,
+				OutputName: output.Name,
+			}
+			if !output.Path.Valid || w.outputs.Has(ref) {
+				continue
+			}
+			w.outputs.Add(ref)
+			err := w.send(&zbstorerpc.BuildEvent{
+				Kind:    zbstorerpc.OutputRealizedEvent,
+				DrvPath: result.DrvPath,
+				Output:  output,
+			})
+			if err != nil {
+				return err
+			}
+		}
+		if finished {
+			w.done.Add(result.DrvPath)
+			kind := zbstorerpc.DerivationFinishedEvent
+			if result.Status != zbstorerpc.BuildSuccess {
+				kind = zbstorerpc.DerivationFailedEvent
+			}
+			err := w.send(&zbstorerpc.BuildEvent{
+				Kind:    kind,
+				DrvPath: result.DrvPath,
+				Result:  result,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sendActiveLogs sends any new log content for derivations that have not finished.
+func (w *buildWatcher) sendActiveLogs() error {
+	for drvPath := range w.logOffsets {
+		if !w.done.Has(drvPath) {
+			if err := w.sendLog(drvPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// sendLog sends any new log content for the given derivation.
+func (w *buildWatcher) sendLog(drvPath zbstore.Path) error {
+	if !w.args.Logs {
+		return nil
+	}
+	f, err := os.Open(builderLogPath(w.logDir, w.buildID, drvPath))
+	if errors.Is(err, os.ErrNotExist) {
+		// Builder has not started yet.
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	off := w.logOffsets[drvPath]
+	if info, err := f.Stat(); err == nil && info.Size() <= off {
+		return nil
+	}
+	buf := make([]byte, maxLogEventSize)
+	for {
+		n, err := f.ReadAt(buf, off)
+		if n > 0 {
+			chunk := &zbstorerpc.LogChunk{Offset: off}
+			chunk.SetPayload(buf[:n])
+			off += int64(n)
+			w.logOffsets[drvPath] = off
+			sendError := w.send(&zbstorerpc.BuildEvent{
+				Kind:    zbstorerpc.BuildLogEvent,
+				DrvPath: drvPath,
+				Log:     chunk,
+			})
+			if sendError != nil {
+				return sendError
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read log for %s: %v", drvPath, err)
+		}
+	}
+}
+
+func (w *buildWatcher) send(event *zbstorerpc.BuildEvent) error {
+	event.BuildID = w.args.BuildID
+	event.WatchID = w.args.WatchID
+	return jsonrpc.Notify(w.ctx, w.notifier, zbstorerpc.BuildEventMethod, event)
+}
@@ -0,0 +1,183 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package backend_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"runtime"
+	"sync"
+	"testing"
+
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+	"zb.256lights.llc/pkg/internal/storetest"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/internal/zbstorerpc"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestWatchBuild(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses sh")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+
+	exportBuffer := new(bytes.Buffer)
+	exporter := zbstore.NewExporter(exportBuffer)
+	const wantLog = "Hello, World!\n"
+	drvContent := &zbstore.Derivation{
+		Name:    "hello.txt",
+		Dir:     dir,
+		System:  system.Current().String(),
+		Builder: shPath,
+		Args:    []string{"-c", "echo 'Hello, World!' ; echo > $out"},
+		Env: map[string]string{
+			"out": zbstore.HashPlaceholder("out"),
+		},
+		Outputs: map[string]*zbstore.DerivationOutputType{
+			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		},
+	}
+	drvPath, _, err := storetest.ExportDerivation(exporter, drvContent)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := exporter.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	codec, releaseCodec, err := storeCodec(ctx, client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = codec.Export(nil, exportBuffer)
+	releaseCodec()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	realizeResponse := new(zbstorerpc.RealizeResponse)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.RealizeMethod, realizeResponse, &zbstorerpc.RealizeRequest{
+		DrvPaths: []zbstore.Path{drvPath},
+	})
+	if err != nil {
+		t.Fatal("build drv:", err)
+	}
+
+	var mu sync.Mutex
+	var events []*zbstorerpc.BuildEvent
+	watchCtx := jsonrpc.WithNotificationHandler(ctx, jsonrpc.HandlerFunc(func(ctx context.Context, req *jsonrpc.Request) (*jsonrpc.Response, error) {
+		if req.Method != zbstorerpc.BuildEventMethod {
+			t.Errorf("Received unexpected %s notification", req.Method)
+			return nil, nil
+		}
+		event := new(zbstorerpc.BuildEvent)
+		if err := json.Unmarshal(req.Params, event); err != nil {
+			t.Error(err)
+			return nil, nil
+		}
+		mu.Lock()
+		events = append(events, event)
+		mu.Unlock()
+		return nil, nil
+	}))
+	got := new(zbstorerpc.Build)
+	err = jsonrpc.Do(watchCtx, client, zbstorerpc.WatchBuildMethod, got, &zbstorerpc.WatchBuildRequest{
+		BuildID: realizeResponse.BuildID,
+		Logs:    true,
+		WatchID: "test-watch",
+	})
+	if err != nil {
+		t.Fatal("watch build:", err)
+	}
+	if got.Status != zbstorerpc.BuildSuccess {
+		t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildSuccess)
+	}
+	result, err := got.ResultForPath(drvPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := result.OutputForName(zbstore.DefaultDerivationOutputName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) == 0 {
+		t.Fatal("no events received")
+	}
+	if first := events[0]; first.Kind != zbstorerpc.DerivationStartedEvent || first.DrvPath != drvPath {
+		t.Errorf("first event = %s %s; want %s %s", first.Kind, first.DrvPath, zbstorerpc.DerivationStartedEvent, drvPath)
+	}
+	if last := events[len(events)-1]; last.Kind != zbstorerpc.DerivationFinishedEvent || last.DrvPath != drvPath {
+		t.Errorf("last event = %s %s; want %s %s", last.Kind, last.DrvPath, zbstorerpc.DerivationFinishedEvent, drvPath)
+	}
+	gotLog := new(bytes.Buffer)
+	var gotOutputPaths []zbstore.Path
+	for _, event := range events {
+		if event.BuildID != realizeResponse.BuildID {
+			t.Errorf("%s event build ID = %q; want %q", event.Kind, event.BuildID, realizeResponse.BuildID)
+		}
+		if want := "test-watch"; event.WatchID != want {
+			t.Errorf("%s event watch ID = %q; want %q", event.Kind, event.WatchID, want)
+		}
+		switch event.Kind {
+		case zbstorerpc.BuildLogEvent:
+			if event.Log.Offset != int64(gotLog.Len()) {
+				t.Errorf("log event offset = %d; want %d", event.Log.Offset, gotLog.Len())
+			}
+			payload, err := event.Log.Payload()
+			if err != nil {
+				t.Error(err)
+			}
+			gotLog.Write(payload)
+		case zbstorerpc.OutputRealizedEvent:
+			if event.Output.Path.Valid {
+				gotOutputPaths = append(gotOutputPaths, event.Output.Path.X)
+			}
+		}
+	}
+	if gotLog.String() != wantLog {
+		t.Errorf("log = %q; want %q", gotLog, wantLog)
+	}
+	if len(gotOutputPaths) != 1 || !output.Path.Valid || gotOutputPaths[0] != output.Path.X {
+		t.Errorf("realized outputs = %v; want [%v]", gotOutputPaths, output.Path)
+	}
+}
+
+func TestWatchUnknownBuild(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	dir := backendtest.NewStoreDirectory(t)
+	_, client, err := backendtest.NewServer(ctx, t, dir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := new(zbstorerpc.Build)
+	err = jsonrpc.Do(ctx, client, zbstorerpc.WatchBuildMethod, got, &zbstorerpc.WatchBuildRequest{
+		BuildID: "0190f1b1-2c04-7e57-a63a-9a0e4f1ad1cb",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Status != zbstorerpc.BuildUnknown {
+		t.Errorf("build status = %q; want %q", got.Status, zbstorerpc.BuildUnknown)
+	}
+}
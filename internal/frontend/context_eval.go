@@ -0,0 +1,213 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func hasContextFunction(ctx context.Context, l *lua.State) (int, error) {
+	if _, err := lua.CheckString(l, 1); err != nil {
+		return 0, err
+	}
+	l.PushBoolean(len(l.StringContext(1)) > 0)
+	return 1, nil
+}
+
+func unsafeDiscardContextFunction(ctx context.Context, l *lua.State) (int, error) {
+	s, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	l.PushString(s)
+	return 1, nil
+}
+
+// getContextFunction returns a table that describes the dependency information of a string.
+// Each key in the table is a store path.
+// Each value is a table with a “path” field set to true
+// if the string depends on the store object,
+// and an “outputs” field with the sorted list of output names
+// if the store path is a derivation
+// and the string depends on some of the derivation's outputs.
+func getContextFunction(ctx context.Context, l *lua.State) (int, error) {
+	if _, err := lua.CheckString(l, 1); err != nil {
+		return 0, err
+	}
+
+	paths := make(sets.Set[zbstore.Path])
+	outputs := make(map[zbstore.Path][]string)
+	for dep := range l.StringContext(1) {
+		c, err := parseContextString(dep)
+		if err != nil {
+			return 0, fmt.Errorf("internal error: %v", err)
+		}
+		if c.path != "" {
+			paths.Add(c.path)
+		} else {
+			ref := c.outputReference
+			outputs[ref.DrvPath] = append(outputs[ref.DrvPath], ref.OutputName)
+		}
+	}
+
+	l.CreateTable(0, paths.Len()+len(outputs))
+	for _, p := range slices.Sorted(maps.Keys(outputs)) {
+		l.CreateTable(0, 2)
+		if paths.Has(p) {
+			l.PushBoolean(true)
+			if err := l.RawSetField(-2, "path"); err != nil {
+				return 0, err
+			}
+			paths.Delete(p)
+		}
+		outputNames := outputs[p]
+		slices.Sort(outputNames)
+		l.CreateTable(len(outputNames), 0)
+		for i, name := range outputNames {
+			l.PushString(name)
+			if err := l.RawSetIndex(-2, int64(i)+1); err != nil {
+				return 0, err
+			}
+		}
+		if err := l.RawSetField(-2, "outputs"); err != nil {
+			return 0, err
+		}
+		if err := l.RawSetField(-2, string(p)); err != nil {
+			return 0, err
+		}
+	}
+	for p := range paths.All() {
+		l.CreateTable(0, 1)
+		l.PushBoolean(true)
+		if err := l.RawSetField(-2, "path"); err != nil {
+			return 0, err
+		}
+		if err := l.RawSetField(-2, string(p)); err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
+// appendContextFunction returns its first argument
+// with the dependency information of the rest of its arguments added.
+// Each of the rest of the arguments is either a string
+// (whose dependency information is added)
+// or a table in the format returned by [getContextFunction].
+func (eval *Eval) appendContextFunction(ctx context.Context, l *lua.State) (int, error) {
+	s, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	scontext := l.StringContext(1).Clone()
+	if scontext == nil {
+		scontext = make(sets.Set[string])
+	}
+	for arg := 2; arg <= l.Top(); arg++ {
+		switch typ := l.Type(arg); typ {
+		case lua.TypeString:
+			scontext.AddSeq(l.StringContext(arg).All())
+		case lua.TypeTable:
+			if err := eval.appendContextTable(ctx, l, arg, scontext); err != nil {
+				return 0, err
+			}
+		default:
+			return 0, lua.NewTypeError(l, arg, "string or table")
+		}
+	}
+	l.PushStringContext(s, scontext)
+	return 1, nil
+}
+
+// appendContextTable adds the dependency information
+// described by the table at the given function argument
+// (in the format returned by [getContextFunction])
+// to scontext.
+func (eval *Eval) appendContextTable(ctx context.Context, l *lua.State, arg int, scontext sets.Set[string]) error {
+	l.PushNil()
+	for l.Next(arg) {
+		if l.Type(-2) != lua.TypeString {
+			l.Pop(2)
+			return lua.NewArgError(l, arg, "keys must be store paths")
+		}
+		rawPath, _ := l.ToString(-2)
+		p, sub, err := eval.storeDir.ParsePath(rawPath)
+		if err != nil || sub != "" {
+			l.Pop(2)
+			return lua.NewArgError(l, arg, fmt.Sprintf("%s is not a store path in %s",
+				lualex.Quote(rawPath), lualex.Quote(string(eval.storeDir))))
+		}
+		if !l.IsTable(-1) {
+			l.Pop(2)
+			return lua.NewArgError(l, arg, fmt.Sprintf("[%s] must be a table", lualex.Quote(rawPath)))
+		}
+
+		var deps []contextValue
+		l.PushNil()
+		for l.Next(-2) {
+			var k string
+			if l.Type(-2) == lua.TypeString {
+				k, _ = l.ToString(-2)
+			}
+			if k != "path" && k != "outputs" {
+				l.Pop(4)
+				return lua.NewArgError(l, arg, fmt.Sprintf("[%s] has unknown field", lualex.Quote(rawPath)))
+			}
+			switch {
+			case k == "path":
+				if l.ToBoolean(-1) {
+					deps = append(deps, contextValue{path: p})
+				}
+			case !p.IsDerivation():
+				l.Pop(4)
+				return lua.NewArgError(l, arg, fmt.Sprintf("[%s].outputs given for a non-derivation", lualex.Quote(rawPath)))
+			default:
+				err := ipairs(ctx, l, -1, func(i int64) error {
+					if l.Type(-1) != lua.TypeString {
+						return fmt.Errorf("[%s].outputs[%d] is not a string", lualex.Quote(rawPath), i)
+					}
+					name, _ := l.ToString(-1)
+					if !zbstore.IsValidOutputName(name) {
+						return fmt.Errorf("[%s].outputs[%d] is not a valid output name", lualex.Quote(rawPath), i)
+					}
+					deps = append(deps, contextValue{outputReference: zbstore.OutputReference{
+						DrvPath:    p,
+						OutputName: name,
+					}})
+					return nil
+				})
+				if err != nil {
+					l.Pop(4)
+					return lua.NewArgError(l, arg, err.Error())
+				}
+			}
+			l.Pop(1)
+		}
+
+		if len(deps) > 0 {
+			exists, err := eval.store.Exists(ctx, string(p))
+			if err != nil {
+				l.Pop(2)
+				return fmt.Errorf("%sappendContext: %v", lua.Where(l, 1), err)
+			}
+			if !exists {
+				l.Pop(2)
+				return fmt.Errorf("%sappendContext: %s does not exist", lua.Where(l, 1), p)
+			}
+		}
+		for _, c := range deps {
+			scontext.Add(c.String())
+		}
+		l.Pop(1)
+	}
+	return nil
+}
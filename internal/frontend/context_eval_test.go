@@ -0,0 +1,126 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestStringContext(t *testing.T) {
+	const drvExpr = `derivation { name = "hello"; system = "x86_64-unknown-linux"; builder = "/bin/sh"; outputs = { "out", "dev" } }`
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{
+			expr: `hasContext("foo")`,
+			want: false,
+		},
+		{
+			expr: `hasContext(path("testdata/hello.txt"))`,
+			want: true,
+		},
+		{
+			expr: `hasContext(unsafeDiscardContext(path("testdata/hello.txt")))`,
+			want: false,
+		},
+		{
+			expr: `unsafeDiscardContext(path("testdata/hello.txt")) == path("testdata/hello.txt")`,
+			want: true,
+		},
+		{
+			expr: `getContext("foo")`,
+			want: map[string]any{},
+		},
+		{
+			expr: `(function()
+				local p = path("testdata/hello.txt")
+				local c = getContext("file: " .. p)
+				return c[p]
+			end)()`,
+			want: map[string]any{"path": true},
+		},
+		{
+			expr: `(function()
+				local drv = ` + drvExpr + `
+				return getContext(drv.out .. drv.dev)[drv.drvPath]
+			end)()`,
+			want: map[string]any{"outputs": []any{"dev", "out"}},
+		},
+		{
+			expr: `(function()
+				local p = path("testdata/hello.txt")
+				return getContext(appendContext("foo", p))[p]
+			end)()`,
+			want: map[string]any{"path": true},
+		},
+		{
+			expr: `(function()
+				local drv = ` + drvExpr + `
+				local s = appendContext("foo", { [drv.drvPath] = { outputs = { "dev" } } })
+				return getContext(s)[drv.drvPath]
+			end)()`,
+			want: map[string]any{"outputs": []any{"dev"}},
+		},
+		{
+			expr: `(function()
+				local p = path("testdata/hello.txt")
+				local s = appendContext(unsafeDiscardContext(p), getContext(p))
+				return s == p and hasContext(s)
+			end)()`,
+			want: true,
+		},
+	}
+
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	for _, test := range tests {
+		got, err := eval.Expression(ctx, test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+		}
+	}
+
+	badExprs := []string{
+		`appendContext("foo", 42)`,
+		`appendContext("foo", { [storeDir .. "/00000000000000000000000000000000-bork"] = { path = true } })`,
+		`appendContext("foo", { ["/not/a/store/path"] = { path = true } })`,
+		`appendContext("foo", { [path("testdata/hello.txt")] = { outputs = { "out" } } })`,
+		`appendContext("foo", { [path("testdata/hello.txt")] = { bork = true } })`,
+	}
+	for _, expr := range badExprs {
+		if _, err := eval.Expression(ctx, expr); err == nil {
+			t.Errorf("%s did not return an error", expr)
+		}
+	}
+}
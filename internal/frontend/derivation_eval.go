@@ -6,6 +6,7 @@ package frontend
 import (
 	"context"
 	"fmt"
+	"slices"
 	"strings"
 
 	"zb.256lights.llc/pkg/internal/lua"
@@ -21,6 +22,21 @@ const derivationTypeName = "derivation"
 type Derivation struct {
 	*zbstore.Derivation
 	Path zbstore.Path
+
+	// defaultOutput is the name of the output
+	// used when the derivation is converted to a string.
+	defaultOutput string
+}
+
+// DefaultOutput returns the name of the output
+// used when the derivation is converted to a string.
+// This is the first output listed in the derivation's outputs field,
+// or "out" if the field was not given.
+func (drv *Derivation) DefaultOutput() string {
+	if drv.defaultOutput == "" {
+		return zbstore.DefaultDerivationOutputName
+	}
+	return drv.defaultOutput
 }
 
 func (drv *Derivation) Freeze() error { return nil }
@@ -53,6 +69,11 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 	}
 
 	// Configure outputs.
+	outputNames, err := derivationOutputNames(ctx, l)
+	if err != nil {
+		return 0, err
+	}
+	drv.defaultOutput = outputNames[0]
 	var h nix.Hash
 	switch typ := l.RawField(1, "outputHash"); typ {
 	case lua.TypeNil:
@@ -94,10 +115,13 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 	l.Pop(1)
 
 	if h.IsZero() {
-		// TODO(someday): Multiple outputs.
-		drv.Outputs = map[string]*zbstore.DerivationOutputType{
-			zbstore.DefaultDerivationOutputName: zbstore.RecursiveFileFloatingCAOutput(nix.SHA256),
+		drv.Outputs = make(map[string]*zbstore.DerivationOutputType, len(outputNames))
+		for _, name := range outputNames {
+			drv.Outputs[name] = zbstore.RecursiveFileFloatingCAOutput(nix.SHA256)
 		}
+	} else if len(outputNames) != 1 || outputNames[0] != zbstore.DefaultDerivationOutputName {
+		return 0, fmt.Errorf("outputs argument: fixed-output derivations must have a single %q output",
+			zbstore.DefaultDerivationOutputName)
 	}
 
 	// Start a copy of the table.
@@ -185,7 +209,6 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 			panic(outputName + " has an unhandled output type")
 		}
 	}
-	var err error
 	drv.Path, err = writeDerivation(ctx, eval.store, drv.Derivation)
 	if err != nil {
 		return 0, fmt.Errorf("derivation: %v", err)
@@ -201,7 +224,7 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 		case outType.IsFloating():
 			placeholder = zbstore.UnknownCAOutputPlaceholder(zbstore.OutputReference{
 				DrvPath:    drv.Path,
-				OutputName: zbstore.DefaultDerivationOutputName,
+				OutputName: outputName,
 			})
 		case outType.IsFixed():
 			// TODO(someday): We already computed this earlier.
@@ -234,6 +257,55 @@ func (eval *Eval) derivationFunction(ctx context.Context, l *lua.State) (int, er
 	return 1, nil
 }
 
+// derivationOutputNames returns the output names
+// listed in the outputs field of the table at index 1,
+// in the order given.
+func derivationOutputNames(ctx context.Context, l *lua.State) ([]string, error) {
+	defer l.Pop(1)
+	switch typ := l.RawField(1, "outputs"); typ {
+	case lua.TypeNil:
+		return []string{zbstore.DefaultDerivationOutputName}, nil
+	case lua.TypeTable:
+		var names []string
+		err := ipairs(ctx, l, -1, func(i int64) error {
+			if typ := l.Type(-1); typ != lua.TypeString {
+				return fmt.Errorf("#%d: %v expected, got %v", i, lua.TypeString, typ)
+			}
+			name, _ := l.ToString(-1)
+			switch {
+			case !zbstore.IsValidOutputName(name):
+				return fmt.Errorf("#%d: %s is not a valid output name", i, lualex.Quote(name))
+			case isSpecialDerivationField(name):
+				return fmt.Errorf("#%d: %s is a reserved field name", i, lualex.Quote(name))
+			case slices.Contains(names, name):
+				return fmt.Errorf("#%d: duplicate output %s", i, lualex.Quote(name))
+			}
+			names = append(names, name)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("outputs argument: %v", err)
+		}
+		if len(names) == 0 {
+			return nil, fmt.Errorf("outputs argument: empty")
+		}
+		return names, nil
+	default:
+		return nil, fmt.Errorf("outputs argument: %v expected, got %v", lua.TypeTable, typ)
+	}
+}
+
+// isSpecialDerivationField reports whether name is a field of a derivation object
+// that cannot be used as an output name.
+func isSpecialDerivationField(name string) bool {
+	switch name {
+	case "name", "system", "builder", "args", "outputs", "outputHash", "outputHashMode", "drvPath":
+		return true
+	default:
+		return false
+	}
+}
+
 func toEnvVar(ctx context.Context, l *lua.State, drv *zbstore.Derivation, idx int, allowLists bool) (string, error) {
 	idx = l.AbsIndex(idx)
 	switch typ := l.Type(idx); typ {
@@ -408,11 +480,12 @@ func derivationPairNext(ctx context.Context, l *lua.State) (int, error) {
 
 // derivationToString handles the __tostring metamethod on derivations.
 func derivationToString(ctx context.Context, l *lua.State) (int, error) {
-	if _, err := toDerivation(l); err != nil {
+	drv, err := toDerivation(l)
+	if err != nil {
 		return 0, err
 	}
 	l.UserValue(1, 1) // Push derivation argument table.
-	if _, err := l.Field(ctx, -1, "out"); err != nil {
+	if _, err := l.Field(ctx, -1, drv.DefaultOutput()); err != nil {
 		return 0, err
 	}
 	return 1, nil
@@ -421,9 +494,9 @@ func derivationToString(ctx context.Context, l *lua.State) (int, error) {
 // concatDerivation handles the __concat metamethod on derivations.
 func concatDerivation(ctx context.Context, l *lua.State) (int, error) {
 	l.SetTop(2)
-	if testDerivation(l, 1) != nil {
+	if drv := testDerivation(l, 1); drv != nil {
 		l.UserValue(1, 1) // Push derivation argument table.
-		if _, err := l.Field(ctx, -1, "out"); err != nil {
+		if _, err := l.Field(ctx, -1, drv.DefaultOutput()); err != nil {
 			return 0, err
 		}
 		if err := l.Replace(1); err != nil {
@@ -431,9 +504,9 @@ func concatDerivation(ctx context.Context, l *lua.State) (int, error) {
 		}
 		l.Pop(1)
 	}
-	if testDerivation(l, 2) != nil {
+	if drv := testDerivation(l, 2); drv != nil {
 		l.UserValue(2, 1) // Push derivation argument table.
-		if _, err := l.Field(ctx, -1, "out"); err != nil {
+		if _, err := l.Field(ctx, -1, drv.DefaultOutput()); err != nil {
 			return 0, err
 		}
 		if err := l.Replace(2); err != nil {
@@ -47,6 +47,13 @@ type Options struct {
 	// CacheDBPath is the path to a database file used to speed up store imports.
 	// If empty, an in-memory cache will be used.
 	CacheDBPath string
+	// StoreCacheDirectory is the path to a directory used to hold copies of store objects
+	// that the evaluator needs to read
+	// but are not present on the local filesystem
+	// (e.g. because the store is on another machine).
+	// If empty, a temporary directory will be created as needed
+	// and removed when the [Eval] is closed.
+	StoreCacheDirectory string
 	// LookupEnv is called for the Lua os.getenv function.
 	// If nil, os.getenv will always return nil.
 	LookupEnv func(ctx context.Context, key string) (string, bool)
@@ -68,10 +75,14 @@ type Options struct {
 // Realize starts a build for the given derivation paths,
 // waits for the build to finish,
 // then returns the results of the build.
+//
+// Export writes the NAR serialization of the given store object to w.
+// It is used to read store objects that are not present on the local filesystem.
 type Store interface {
 	Exists(ctx context.Context, path string) (bool, error)
 	Import(ctx context.Context, r io.Reader) error
 	Realize(ctx context.Context, want sets.Set[zbstore.OutputReference]) ([]*zbstorerpc.BuildResult, error)
+	Export(ctx context.Context, w io.Writer, path zbstore.Path) error
 }
 
 type Eval struct {
@@ -82,6 +93,10 @@ type Eval struct {
 	httpClient   *http.Client
 	downloadTemp bytebuffer.Creator
 
+	storeCacheMutex  sync.Mutex
+	storeCacheDir    string
+	storeCacheIsTemp bool
+
 	baseImportContext context.Context
 	cancelImports     context.CancelFunc
 	importGroup       sync.WaitGroup
@@ -103,6 +118,8 @@ func NewEval(opts *Options) (_ *Eval, err error) {
 		lookupEnv:    opts.LookupEnv,
 		httpClient:   opts.HTTPClient,
 		downloadTemp: opts.DownloadBufferCreator,
+
+		storeCacheDir: opts.StoreCacheDirectory,
 	}
 	if eval.lookupEnv == nil {
 		eval.lookupEnv = func(ctx context.Context, key string) (string, bool) {
@@ -179,12 +196,21 @@ func (eval *Eval) initZygote() error {
 
 	// Set other built-ins.
 	extraBaseFunctions := map[string]lua.Function{
-		"await":      awaitFunction,
-		"derivation": eval.derivationFunction,
-		"import":     eval.importFunction,
-		"toFile":     eval.toFileFunction,
-		"path":       eval.pathFunction,
-		"storePath":  eval.storePathFunction,
+		"appendContext":        eval.appendContextFunction,
+		"await":                awaitFunction,
+		"derivation":           eval.derivationFunction,
+		"getContext":           getContextFunction,
+		"hasContext":           hasContextFunction,
+		"hash":                 hashFunction,
+		"hashFile":             eval.hashFileFunction,
+		"import":               eval.importFunction,
+		"toFile":               eval.toFileFunction,
+		"path":                 eval.pathFunction,
+		"pathExists":           eval.pathExistsFunction,
+		"readDir":              eval.readDirFunction,
+		"readFile":             eval.readFileFunction,
+		"storePath":            eval.storePathFunction,
+		"unsafeDiscardContext": unsafeDiscardContextFunction,
 	}
 	if err := lua.SetPureFunctions(ctx, l, 0, extraBaseFunctions); err != nil {
 		return err
@@ -193,6 +219,14 @@ func (eval *Eval) initZygote() error {
 	if err := l.SetField(ctx, -2, "storeDir"); err != nil {
 		return err
 	}
+	openJSON(l)
+	if err := l.RawSetField(-2, "json"); err != nil {
+		return err
+	}
+	openTOML(l)
+	if err := l.RawSetField(-2, "toml"); err != nil {
+		return err
+	}
 
 	// Wrap load function.
 	if tp := l.RawField(-1, "load"); tp != lua.TypeFunction {
@@ -212,6 +246,10 @@ func (eval *Eval) initZygote() error {
 	l.Pop(1)
 
 	// Load other standard libraries.
+	if err := lua.Require(ctx, l, lua.CoroutineLibraryName, true, lua.OpenCoroutine); err != nil {
+		return err
+	}
+	l.Pop(1)
 	if err := lua.Require(ctx, l, lua.MathLibraryName, true, lua.NewOpenMath(nil)); err != nil {
 		return err
 	}
@@ -454,7 +492,9 @@ func prepareCache(conn *sqlite.Conn) error {
 func (eval *Eval) Close() error {
 	eval.cancelImports()
 	eval.importGroup.Wait()
-	return eval.cachePool.Close()
+	err1 := eval.cachePool.Close()
+	err2 := eval.removeStoreCache()
+	return errors.Join(err1, err2)
 }
 
 // Expression evaluates a single Lua expression and returns the result.
@@ -608,11 +648,19 @@ func luaTableToGoSlice(ctx context.Context, l *lua.State) ([]any, error) {
 }
 
 func loadFile(l *lua.State, path string) error {
+	return loadFileFrom(l, path, path)
+}
+
+// loadFileFrom loads the Lua file at localPath
+// using path as the chunk's source name.
+// path and localPath differ when path is a store path
+// that is read from the store cache directory.
+func loadFileFrom(l *lua.State, path, localPath string) error {
 	path, err := filepath.Abs(path)
 	if err != nil {
 		return fmt.Errorf("load file: %w", err)
 	}
-	f, err := os.Open(path)
+	f, err := os.Open(localPath)
 	if err != nil {
 		return fmt.Errorf("load file: %w", err)
 	}
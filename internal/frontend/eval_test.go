@@ -7,6 +7,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
@@ -16,6 +17,7 @@ import (
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
+	"zb.256lights.llc/pkg/internal/backend"
 	"zb.256lights.llc/pkg/internal/backendtest"
 	"zb.256lights.llc/pkg/internal/jsonrpc"
 	"zb.256lights.llc/pkg/internal/lua"
@@ -226,6 +228,100 @@ func TestStringMethod(t *testing.T) {
 	}
 }
 
+func TestDerivationOutputs(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const drvExpr = `derivation { name = "hello"; system = "x86_64-unknown-linux"; builder = "/bin/sh"; outputs = { "lib", "dev" } }`
+	got, err := eval.Expression(ctx, drvExpr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	drv, ok := got.(*Derivation)
+	if !ok {
+		t.Fatalf("%s = %#v; want derivation", drvExpr, got)
+	}
+	if got, want := slices.Sorted(maps.Keys(drv.Outputs)), []string{"dev", "lib"}; !slices.Equal(got, want) {
+		t.Errorf("outputs = %q; want %q", got, want)
+	}
+	for name, outType := range drv.Outputs {
+		if !outType.IsFloating() {
+			t.Errorf("output %s is not floating", name)
+		}
+		if got, want := drv.Env[name], zbstore.HashPlaceholder(name); got != want {
+			t.Errorf("env[%q] = %q; want %q", name, got, want)
+		}
+	}
+	if got, want := drv.Env["outputs"], "lib dev"; got != want {
+		t.Errorf("env[\"outputs\"] = %q; want %q", got, want)
+	}
+	if got, want := drv.DefaultOutput(), "lib"; got != want {
+		t.Errorf("DefaultOutput() = %q; want %q", got, want)
+	}
+
+	for _, expr := range []string{"(" + drvExpr + ").dev", "tostring(" + drvExpr + ")"} {
+		got, err := eval.Expression(ctx, expr)
+		if err != nil {
+			t.Errorf("%s: %v", expr, err)
+			continue
+		}
+		ref := zbstore.OutputReference{DrvPath: drv.Path, OutputName: "dev"}
+		if strings.HasPrefix(expr, "tostring") {
+			ref.OutputName = "lib"
+		}
+		want := zbstore.UnknownCAOutputPlaceholder(ref)
+		if diff := cmp.Diff(any(want), got); diff != "" {
+			t.Errorf("%s (-want +got):\n%s", expr, diff)
+		}
+	}
+
+	// URLs can select a single output with a suffix.
+	pkgFile := filepath.Join(t.TempDir(), "pkg.lua")
+	if err := os.WriteFile(pkgFile, []byte("return { pkg = "+drvExpr+" }\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+	results, err := eval.URLs(ctx, []string{pkgFile + "#pkg.dev"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantRef := zbstore.OutputReference{DrvPath: drv.Path, OutputName: "dev"}
+	if diff := cmp.Diff([]any{wantRef}, results); diff != "" {
+		t.Errorf("URLs(%q) (-want +got):\n%s", pkgFile+"#pkg.dev", diff)
+	}
+
+	badExprs := []string{
+		`derivation { name = "hello"; system = "x86_64-unknown-linux"; builder = "/bin/sh"; outputs = {} }`,
+		`derivation { name = "hello"; system = "x86_64-unknown-linux"; builder = "/bin/sh"; outputs = { "out", "out" } }`,
+		`derivation { name = "hello"; system = "x86_64-unknown-linux"; builder = "/bin/sh"; outputs = { "drvPath" } }`,
+		`derivation { name = "hello"; system = "x86_64-unknown-linux"; builder = "/bin/sh"; outputs = { "out", "dev" }; outputHash = "sha256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=" }`,
+	}
+	for _, expr := range badExprs {
+		if _, err := eval.Expression(ctx, expr); err == nil {
+			t.Errorf("%s did not return an error", expr)
+		}
+	}
+}
+
 func TestImportFromDerivation(t *testing.T) {
 	ctx, cancel := testcontext.New(t)
 	defer cancel()
@@ -265,6 +361,64 @@ func TestImportFromDerivation(t *testing.T) {
 	}
 }
 
+func TestImportNonLocalStore(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	// Place the store objects somewhere other than storeDir
+	// to simulate a store on another machine.
+	exports := new(testExportReceiver)
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+		Options: backend.Options{
+			RealStoreDirectory: t.TempDir(),
+		},
+		ClientOptions: zbstorerpc.CodecOptions{
+			NARReceiver: exports,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	testStore := newTestRPCStore(store)
+	testStore.exports = exports
+	storeCacheDir := t.TempDir()
+	eval, err := NewEval(&Options{
+		Store:               testStore,
+		StoreDirectory:      storeDir,
+		StoreCacheDirectory: storeCacheDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const expr = `local f = toFile("f.lua", "return 'Hello, World!'"); return await(import(f))`
+	got, err := eval.Expression(ctx, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello, World!"; got != want {
+		t.Errorf("result = %#v; want %#v", got, want)
+	}
+
+	imports := testStore.readImports()
+	if len(imports) != 1 {
+		t.Fatalf("imported %v; want 1 path", imports)
+	}
+	if _, err := os.Stat(string(imports[0])); err == nil {
+		t.Errorf("%s exists on local filesystem", imports[0])
+	}
+	if _, err := os.Stat(filepath.Join(storeCacheDir, imports[0].Base())); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestImportExitStore(t *testing.T) {
 	ctx, cancel := testcontext.New(t)
 	defer cancel()
@@ -568,8 +722,11 @@ func BenchmarkNewState(b *testing.B) {
 // that communicates to a real backend using JSON-RPC.
 // Imported paths are tracked.
 // Realization logs are ignored.
+// Export is only supported if exports is set
+// and the client was created with exports as its NAR receiver.
 type testRPCStore struct {
-	client *jsonrpc.Client
+	client  *jsonrpc.Client
+	exports *testExportReceiver
 
 	mu      sync.Mutex
 	imports []zbstore.Path
@@ -638,6 +795,68 @@ func (store *testRPCStore) Realize(ctx context.Context, want sets.Set[zbstore.Ou
 	return build.Results, nil
 }
 
+func (store *testRPCStore) Export(ctx context.Context, w io.Writer, path zbstore.Path) error {
+	if store.exports == nil {
+		return fmt.Errorf("export %s: not supported by test store", path)
+	}
+	// Exports share a connection, so only one may be in flight at a time.
+	store.exports.exportMu.Lock()
+	defer store.exports.exportMu.Unlock()
+	store.exports.start(w)
+	err := jsonrpc.Do(ctx, store.client, zbstorerpc.ExportMethod, nil, &zbstorerpc.ExportRequest{
+		Paths:             []zbstore.Path{path},
+		ExcludeReferences: true,
+	})
+	n := store.exports.finish()
+	if err != nil {
+		return err
+	}
+	if n != 1 {
+		return fmt.Errorf("export %s: received %d store objects", path, n)
+	}
+	return nil
+}
+
+// testExportReceiver is a [zbstore.NARReceiver]
+// that forwards the NAR files it receives
+// to the writer passed to [*testRPCStore.Export].
+type testExportReceiver struct {
+	exportMu sync.Mutex
+
+	mu sync.Mutex
+	w  io.Writer
+	n  int
+}
+
+func (r *testExportReceiver) start(w io.Writer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w = w
+	r.n = 0
+}
+
+func (r *testExportReceiver) finish() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.w = nil
+	return r.n
+}
+
+func (r *testExportReceiver) Write(p []byte) (n int, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.w == nil || r.n > 0 {
+		return len(p), nil
+	}
+	return r.w.Write(p)
+}
+
+func (r *testExportReceiver) ReceiveNAR(trailer *zbstore.ExportTrailer) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.n++
+}
+
 type exportSpy struct {
 	store *testRPCStore
 }
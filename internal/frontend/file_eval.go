@@ -0,0 +1,127 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"zb.256lights.llc/pkg/internal/lua"
+)
+
+func (eval *Eval) readFileFunction(ctx context.Context, l *lua.State) (int, error) {
+	p, localPath, err := eval.resolveReadPath(ctx, l, 1)
+	if err != nil {
+		return 0, fmt.Errorf("%sreadFile: %v", lua.Where(l, 1), err)
+	}
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("%sreadFile %s: %v", lua.Where(l, 1), p, unwrapPathError(err))
+	}
+	l.PushStringContext(string(data), l.StringContext(1))
+	return 1, nil
+}
+
+func (eval *Eval) readDirFunction(ctx context.Context, l *lua.State) (int, error) {
+	p, localPath, err := eval.resolveReadPath(ctx, l, 1)
+	if err != nil {
+		return 0, fmt.Errorf("%sreadDir: %v", lua.Where(l, 1), err)
+	}
+	entries, err := os.ReadDir(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("%sreadDir %s: %v", lua.Where(l, 1), p, unwrapPathError(err))
+	}
+	l.CreateTable(0, len(entries))
+	for _, ent := range entries {
+		switch ent.Type() {
+		case 0:
+			l.PushString("regular")
+		case fs.ModeDir:
+			l.PushString("directory")
+		case fs.ModeSymlink:
+			l.PushString("symlink")
+		default:
+			l.PushString("unknown")
+		}
+		if err := l.RawSetField(-2, ent.Name()); err != nil {
+			return 0, err
+		}
+	}
+	return 1, nil
+}
+
+func (eval *Eval) pathExistsFunction(ctx context.Context, l *lua.State) (int, error) {
+	p, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	pcontext := l.StringContext(1)
+	p, err = eval.realizePlaceholders(ctx, p, pcontext)
+	if err != nil {
+		return 0, fmt.Errorf("%spathExists: %v", lua.Where(l, 1), err)
+	}
+	p, err = absSourcePath(l, eval.storeDir, p, pcontext)
+	if err != nil {
+		return 0, fmt.Errorf("%spathExists: %v", lua.Where(l, 1), err)
+	}
+	if storePath, _, err := eval.storeDir.ParsePath(p); err == nil {
+		// Avoid fetching store objects that don't exist.
+		exists, err := eval.store.Exists(ctx, string(storePath))
+		if err != nil {
+			return 0, fmt.Errorf("%spathExists: %v", lua.Where(l, 1), err)
+		}
+		if !exists {
+			l.PushBoolean(false)
+			return 1, nil
+		}
+	}
+	localPath, err := eval.localPath(ctx, p)
+	if err != nil {
+		return 0, fmt.Errorf("%spathExists: %v", lua.Where(l, 1), err)
+	}
+	_, err = os.Stat(localPath)
+	l.PushBoolean(err == nil)
+	return 1, nil
+}
+
+// resolveReadPath resolves the path given as the function argument arg
+// of the current [lua.Function]
+// in the same way as the path function,
+// realizing any derivation outputs it refers to.
+// resolveReadPath returns the resolved absolute path
+// along with a path on the local filesystem with the same content
+// (see [*Eval.localPath]).
+func (eval *Eval) resolveReadPath(ctx context.Context, l *lua.State, arg int) (path, localPath string, err error) {
+	path, err = lua.CheckString(l, arg)
+	if err != nil {
+		return "", "", err
+	}
+	pcontext := l.StringContext(arg)
+	path, err = eval.realizePlaceholders(ctx, path, pcontext)
+	if err != nil {
+		return "", "", err
+	}
+	path, err = absSourcePath(l, eval.storeDir, path, pcontext)
+	if err != nil {
+		return "", "", err
+	}
+	localPath, err = eval.localPath(ctx, path)
+	if err != nil {
+		return "", "", err
+	}
+	return path, localPath, nil
+}
+
+// unwrapPathError returns the underlying error of an [*fs.PathError]
+// so that error messages can refer to the path as written in Lua.
+func unwrapPathError(err error) error {
+	var pathError *fs.PathError
+	if errors.As(err, &pathError) {
+		return pathError.Err
+	}
+	return err
+}
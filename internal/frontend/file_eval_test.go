@@ -0,0 +1,189 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/lualex"
+	"zb.256lights.llc/pkg/internal/system"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestReadFile(t *testing.T) {
+	wantContent, err := os.ReadFile(filepath.Join("testdata", "hello.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{
+			expr: `readFile("testdata/hello.txt")`,
+			want: string(wantContent),
+		},
+		{
+			expr: `readFile(toFile("greeting.txt", "Hello"))`,
+			want: "Hello",
+		},
+		{
+			expr: `readFile(path("testdata/dir") .. "/a.txt")`,
+			want: "AAA\n",
+		},
+		{
+			expr: `readDir("testdata/dir")`,
+			want: map[string]any{
+				"a.txt": "regular",
+				"b.txt": "regular",
+				"c":     "directory",
+			},
+		},
+		{
+			expr: `pathExists("testdata/hello.txt")`,
+			want: true,
+		},
+		{
+			expr: `pathExists("testdata/dir")`,
+			want: true,
+		},
+		{
+			expr: `pathExists("testdata/bork.txt")`,
+			want: false,
+		},
+		{
+			expr: `pathExists(toFile("greeting.txt", "Hello"))`,
+			want: true,
+		},
+	}
+
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	for _, test := range tests {
+		got, err := eval.Expression(ctx, test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+		}
+	}
+
+	badExprs := []string{
+		`readFile("testdata/bork.txt")`,
+		`readFile("testdata/dir")`,
+		`readDir("testdata/hello.txt")`,
+	}
+	for _, expr := range badExprs {
+		if _, err := eval.Expression(ctx, expr); err == nil {
+			t.Errorf("%s did not return an error", expr)
+		}
+	}
+
+}
+
+func TestReadFileExitStore(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	secretPath := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(secretPath, []byte("secret\n"), 0o666); err != nil {
+		t.Fatal(err)
+	}
+
+	fContent := `return readFile(` + lualex.Quote(secretPath) + `)`
+	expr := `local f = toFile("f.lua", ` + lualex.Quote(fContent) + `); return await(import(f))`
+	if got, err := eval.Expression(ctx, expr); err == nil {
+		t.Errorf("reading %s from store returned %#v", secretPath, got)
+	}
+}
+
+func TestReadFileFromDerivation(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("Test uses /bin/sh")
+	}
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	expr := `readFile(derivation {
+		name = "hello.txt";
+		builder = "/bin/sh";
+		args = { "-c", [[echo "Hello, World!" > "$out"]] };
+		system = ` + lualex.Quote(system.Current().String()) + `;
+	}.out)`
+	got, err := eval.Expression(ctx, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "Hello, World!\n"; got != want {
+		t.Errorf("result = %#v; want %#v", got, want)
+	}
+}
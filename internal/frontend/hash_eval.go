@@ -0,0 +1,105 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zombiezen.com/go/nix"
+)
+
+func hashFunction(ctx context.Context, l *lua.State) (int, error) {
+	typ, err := checkHashType(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	s, err := lua.CheckString(l, 2)
+	if err != nil {
+		return 0, err
+	}
+	format, err := checkHashFormat(l, 3)
+	if err != nil {
+		return 0, err
+	}
+	h := nix.NewHasher(typ)
+	h.WriteString(s)
+	l.PushString(formatHash(h.SumHash(), format))
+	return 1, nil
+}
+
+func (eval *Eval) hashFileFunction(ctx context.Context, l *lua.State) (int, error) {
+	typ, err := checkHashType(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	format, err := checkHashFormat(l, 3)
+	if err != nil {
+		return 0, err
+	}
+	p, localPath, err := eval.resolveReadPath(ctx, l, 2)
+	if err != nil {
+		return 0, fmt.Errorf("%shashFile: %v", lua.Where(l, 1), err)
+	}
+	f, err := os.Open(localPath)
+	if err != nil {
+		return 0, fmt.Errorf("%shashFile %s: %v", lua.Where(l, 1), p, unwrapPathError(err))
+	}
+	defer f.Close()
+	h := nix.NewHasher(typ)
+	if _, err := io.Copy(h, f); err != nil {
+		return 0, fmt.Errorf("%shashFile %s: %v", lua.Where(l, 1), p, unwrapPathError(err))
+	}
+	l.PushString(formatHash(h.SumHash(), format))
+	return 1, nil
+}
+
+// checkHashType returns the hash algorithm named by the given function argument.
+func checkHashType(l *lua.State, arg int) (nix.HashType, error) {
+	name, err := lua.CheckString(l, arg)
+	if err != nil {
+		return 0, err
+	}
+	typ, err := nix.ParseHashType(name)
+	if err != nil {
+		return 0, lua.NewArgError(l, arg, fmt.Sprintf("unsupported hash algorithm %q", name))
+	}
+	return typ, nil
+}
+
+// checkHashFormat returns the hash string format named by the given optional function argument.
+// The format is one of "sri" (the default), "base16", "base32", or "base64".
+func checkHashFormat(l *lua.State, arg int) (string, error) {
+	if l.IsNoneOrNil(arg) {
+		return "sri", nil
+	}
+	format, err := lua.CheckString(l, arg)
+	if err != nil {
+		return "", err
+	}
+	switch format {
+	case "sri", "base16", "base32", "base64":
+		return format, nil
+	default:
+		return "", lua.NewArgError(l, arg, fmt.Sprintf("invalid hash format %q", format))
+	}
+}
+
+// formatHash returns h as a hash string in the given format.
+// format must have been returned by [checkHashFormat].
+func formatHash(h nix.Hash, format string) string {
+	switch format {
+	case "base16":
+		return h.Base16()
+	case "base32":
+		return h.Base32()
+	case "base64":
+		return h.Base64()
+	default:
+		return h.SRI()
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+)
+
+func TestHash(t *testing.T) {
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{
+			expr: `hash("sha256", "")`,
+			want: "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		},
+		{
+			expr: `hash("sha256", "", "sri")`,
+			want: "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		},
+		{
+			expr: `hash("sha256", "", "base16")`,
+			want: "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		},
+		{
+			expr: `hash("sha256", "", "base32")`,
+			want: "sha256:0mdqa9w1p6cmli6976v4wi0sw9r4p5prkj7lzfd1877wk11c9c73",
+		},
+		{
+			expr: `hash("sha256", "", "base64")`,
+			want: "sha256:47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=",
+		},
+		{
+			expr: `hash("md5", "abc", "base16")`,
+			want: "md5:900150983cd24fb0d6963f7d28e17f72",
+		},
+		{
+			expr: `hashFile("sha256", "testdata/dir/a.txt", "base16") == hash("sha256", "AAA\n", "base16")`,
+			want: true,
+		},
+		{
+			expr: `hashFile("sha1", toFile("empty.txt", "")) == hash("sha1", "")`,
+			want: true,
+		},
+	}
+
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	for _, test := range tests {
+		got, err := eval.Expression(ctx, test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+		}
+	}
+
+	badExprs := []string{
+		`hash("sha3", "")`,
+		`hash("sha256", "", "hex")`,
+		`hashFile("sha256", "testdata/bork.txt")`,
+		`hashFile("sha256", "testdata/dir")`,
+	}
+	for _, expr := range badExprs {
+		if _, err := eval.Expression(ctx, expr); err == nil {
+			t.Errorf("%s did not return an error", expr)
+		}
+	}
+}
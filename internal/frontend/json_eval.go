@@ -0,0 +1,314 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/jsonstring"
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/toml"
+	"zb.256lights.llc/pkg/sets"
+)
+
+// maxEncodeDepth is the maximum nesting depth of tables passed to json.encode.
+const maxEncodeDepth = 1000
+
+// maxSparseArrayLen is the maximum length of an array encoded by json.encode
+// that may have more nils than elements.
+const maxSparseArrayLen = 10
+
+func openJSON(l *lua.State) {
+	lua.NewPureLib(l, map[string]lua.Function{
+		"decode": jsonDecodeFunction,
+		"encode": jsonEncodeFunction,
+	})
+}
+
+func jsonDecodeFunction(ctx context.Context, l *lua.State) (int, error) {
+	s, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.UseNumber()
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return 0, fmt.Errorf("%sjson.decode: %v", lua.Where(l, 1), err)
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return 0, fmt.Errorf("%sjson.decode: unexpected data after top-level value", lua.Where(l, 1))
+	}
+	if err := pushDecodedValue(l, v, l.StringContext(1)); err != nil {
+		return 0, fmt.Errorf("%sjson.decode: %v", lua.Where(l, 1), err)
+	}
+	return 1, nil
+}
+
+// pushDecodedValue pushes a value produced by [encoding/json] or [toml.Parse]
+// onto l's stack.
+// Every string in the value is given the dependency context of the source document.
+func pushDecodedValue(l *lua.State, v any, context sets.Set[string]) error {
+	if !l.CheckStack(2) {
+		return errors.New("nesting too deep")
+	}
+	switch v := v.(type) {
+	case nil:
+		l.PushNil()
+	case bool:
+		l.PushBoolean(v)
+	case string:
+		l.PushStringContext(v, context)
+	case toml.Datetime:
+		l.PushStringContext(string(v), context)
+	case int64:
+		l.PushInteger(v)
+	case float64:
+		l.PushNumber(v)
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			l.PushInteger(i)
+		} else if f, err := v.Float64(); err == nil {
+			l.PushNumber(f)
+		} else {
+			return fmt.Errorf("number %s out of range", v)
+		}
+	case []any:
+		l.CreateTable(len(v), 0)
+		for i, elem := range v {
+			if err := pushDecodedValue(l, elem, context); err != nil {
+				l.Pop(1)
+				return err
+			}
+			if err := l.RawSetIndex(-2, int64(i)+1); err != nil {
+				l.Pop(1)
+				return err
+			}
+		}
+	case map[string]any:
+		l.CreateTable(0, len(v))
+		for k, elem := range v {
+			if err := pushDecodedValue(l, elem, context); err != nil {
+				l.Pop(1)
+				return err
+			}
+			if err := l.RawSetField(-2, k); err != nil {
+				l.Pop(1)
+				return err
+			}
+		}
+	default:
+		return fmt.Errorf("internal error: unhandled %T", v)
+	}
+	return nil
+}
+
+func jsonEncodeFunction(ctx context.Context, l *lua.State) (int, error) {
+	if l.IsNone(1) {
+		return 0, lua.NewArgError(l, 1, "value expected")
+	}
+	var indent string
+	if !l.IsNoneOrNil(2) {
+		var err error
+		indent, err = lua.CheckString(l, 2)
+		if err != nil {
+			return 0, err
+		}
+	}
+	l.SetTop(1)
+
+	enc := &jsonEncoder{
+		context: make(sets.Set[string]),
+		visited: make(map[uint64]struct{}),
+	}
+	if err := enc.encode(ctx, l); err != nil {
+		return 0, fmt.Errorf("%sjson.encode: %v", lua.Where(l, 1), err)
+	}
+	buf := enc.buf
+	if indent != "" {
+		indented := new(bytes.Buffer)
+		if err := json.Indent(indented, buf, "", indent); err != nil {
+			return 0, fmt.Errorf("%sjson.encode: %v", lua.Where(l, 1), err)
+		}
+		buf = indented.Bytes()
+	}
+	l.PushStringContext(string(buf), enc.context)
+	return 1, nil
+}
+
+// jsonEncoder converts Lua values to JSON.
+type jsonEncoder struct {
+	buf []byte
+	// context is the union of the dependency context
+	// of all strings encountered.
+	context sets.Set[string]
+	// visited is the set of tables currently being encoded,
+	// used to detect cycles.
+	visited map[uint64]struct{}
+}
+
+// encode appends the JSON encoding of the value on the top of l's stack
+// to enc.buf.
+// The value may be replaced, but is left on the stack.
+func (enc *jsonEncoder) encode(ctx context.Context, l *lua.State) error {
+	for {
+		mod := testModule(l, -1)
+		if mod == nil {
+			break
+		}
+		l.Pop(1)
+		if err := waitForModule(ctx, l, mod); err != nil {
+			return err
+		}
+	}
+
+	switch typ := l.Type(-1); typ {
+	case lua.TypeNil:
+		enc.buf = append(enc.buf, "null"...)
+	case lua.TypeBoolean:
+		enc.buf = strconv.AppendBool(enc.buf, l.ToBoolean(-1))
+	case lua.TypeNumber:
+		if i, ok := l.ToInteger(-1); ok && l.IsInteger(-1) {
+			enc.buf = strconv.AppendInt(enc.buf, i, 10)
+			return nil
+		}
+		f, _ := l.ToNumber(-1)
+		if math.IsInf(f, 0) || math.IsNaN(f) {
+			return fmt.Errorf("cannot encode %v", f)
+		}
+		b, err := json.Marshal(f)
+		if err != nil {
+			return err
+		}
+		enc.buf = append(enc.buf, b...)
+	case lua.TypeString:
+		s, _ := l.ToString(-1)
+		enc.appendString(s, l.StringContext(-1))
+	case lua.TypeTable:
+		return enc.encodeTable(ctx, l)
+	default:
+		if testDerivation(l, -1) == nil {
+			return fmt.Errorf("cannot encode %v", typ)
+		}
+		s, context, err := lua.ToString(ctx, l, -1)
+		if err != nil {
+			return err
+		}
+		enc.appendString(s, context)
+	}
+	return nil
+}
+
+func (enc *jsonEncoder) appendString(s string, context sets.Set[string]) {
+	enc.buf = jsonstring.Append(enc.buf, s)
+	enc.context.AddSeq(context.All())
+}
+
+// encodeTable appends the JSON encoding of the table on the top of l's stack
+// to enc.buf.
+// Tables are encoded as arrays using the same rules as [luaToGo].
+// Other tables are encoded as objects with their keys sorted.
+func (enc *jsonEncoder) encodeTable(ctx context.Context, l *lua.State) error {
+	id := l.ID(-1)
+	if _, cycle := enc.visited[id]; cycle {
+		return errors.New("cannot encode table with cycle")
+	}
+	if len(enc.visited) >= maxEncodeDepth || !l.CheckStack(3) {
+		return errors.New("nesting too deep")
+	}
+	enc.visited[id] = struct{}{}
+	defer delete(enc.visited, id)
+
+	var keys []string
+	arrayLen := int64(0)
+	numIntegerKeys := int64(0)
+	isArray := true
+	n := int64(-1)
+	l.PushNil()
+	for l.Next(-2) {
+		if l.Type(-2) == lua.TypeNumber {
+			i, ok := l.ToInteger(-2)
+			if !ok || i < 1 {
+				l.Pop(2)
+				return errors.New("cannot encode table with non-string, non-positive-integer key")
+			}
+			arrayLen = max(arrayLen, i)
+			numIntegerKeys++
+			l.Pop(1)
+			continue
+		}
+		if l.Type(-2) != lua.TypeString {
+			typ := l.Type(-2)
+			l.Pop(2)
+			return fmt.Errorf("cannot encode table with %v key", typ)
+		}
+		k, _ := l.ToString(-2)
+		enc.context.AddSeq(l.StringContext(-2).All())
+		if k == "n" {
+			if i, ok := l.ToInteger(-1); ok && i >= 0 {
+				n = i
+			} else {
+				isArray = false
+			}
+		} else {
+			isArray = false
+		}
+		keys = append(keys, k)
+		l.Pop(1)
+	}
+	if n >= 0 && isArray {
+		arrayLen = max(arrayLen, n)
+	}
+	isArray = isArray && (arrayLen > 0 || n >= 0)
+	if !isArray && arrayLen > 0 {
+		return errors.New("cannot encode table with both string and integer keys")
+	}
+	if isArray && n < 0 && arrayLen > maxSparseArrayLen && arrayLen/2 > numIntegerKeys {
+		return errors.New("cannot encode excessively sparse array")
+	}
+
+	if isArray {
+		enc.buf = append(enc.buf, '[')
+		for i := int64(1); i <= arrayLen; i++ {
+			if i > 1 {
+				enc.buf = append(enc.buf, ',')
+			}
+			l.RawIndex(-1, i)
+			err := enc.encode(ctx, l)
+			l.Pop(1)
+			if err != nil {
+				return fmt.Errorf("[%d]: %w", i, err)
+			}
+		}
+		enc.buf = append(enc.buf, ']')
+		return nil
+	}
+
+	slices.Sort(keys)
+	enc.buf = append(enc.buf, '{')
+	for i, k := range keys {
+		if i > 0 {
+			enc.buf = append(enc.buf, ',')
+		}
+		enc.buf = jsonstring.Append(enc.buf, k)
+		enc.buf = append(enc.buf, ':')
+		l.RawField(-1, k)
+		err := enc.encode(ctx, l)
+		l.Pop(1)
+		if err != nil {
+			return fmt.Errorf("[%q]: %w", k, err)
+		}
+	}
+	enc.buf = append(enc.buf, '}')
+	return nil
+}
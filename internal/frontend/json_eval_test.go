@@ -0,0 +1,200 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"zb.256lights.llc/pkg/internal/backendtest"
+	"zb.256lights.llc/pkg/internal/testcontext"
+	"zb.256lights.llc/pkg/zbstore"
+)
+
+func TestJSON(t *testing.T) {
+	tests := []struct {
+		expr string
+		want any
+	}{
+		{
+			expr: `json.encode({b = 1, a = {1, 2, nil, 3}, c = "x\n\"<>", d = 1.5, e = true})`,
+			want: `{"a":[1,2,null,3],"b":1,"c":"x\n\"<>","d":1.5,"e":true}`,
+		},
+		{
+			expr: `json.encode({})`,
+			want: `{}`,
+		},
+		{
+			expr: `json.encode({n = 0})`,
+			want: `[]`,
+		},
+		{
+			expr: `json.encode({z = {y = 1}, a = false}, "  ")`,
+			want: "{\n  \"a\": false,\n  \"z\": {\n    \"y\": 1\n  }\n}",
+		},
+		{
+			expr: `json.decode('{"a": [1, 2.5, null, "x"], "b": {"c": true}}')`,
+			want: map[string]any{
+				"a": []any{int64(1), 2.5, nil, "x"},
+				"b": map[string]any{"c": true},
+			},
+		},
+		{
+			expr: `json.decode("1e2")`,
+			want: 100.0,
+		},
+		{
+			expr: `json.decode(json.encode({a = {"b", {c = "d"}}}))`,
+			want: map[string]any{
+				"a": []any{"b", map[string]any{"c": "d"}},
+			},
+		},
+	}
+
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	for _, test := range tests {
+		got, err := eval.Expression(ctx, test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got, cmpopts.EquateEmpty()); diff != "" {
+			t.Errorf("%s (-want +got):\n%s", test.expr, diff)
+		}
+	}
+
+	t.Run("Context", func(t *testing.T) {
+		const drvExpr = `derivation { name = "hello"; system = "x86_64-unknown-linux"; builder = "/bin/sh"; outputs = { "out", "dev" } }`
+		got, err := eval.Expression(ctx, drvExpr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		drv, ok := got.(*Derivation)
+		if !ok {
+			t.Fatalf("%s = %#v; want derivation", drvExpr, got)
+		}
+
+		expr := `json.decode(json.encode({x = (` + drvExpr + `).dev})).x`
+		got, err = eval.Expression(ctx, expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := zbstore.OutputReference{DrvPath: drv.Path, OutputName: "dev"}
+		if diff := cmp.Diff(any(want), got); diff != "" {
+			t.Errorf("%s (-want +got):\n%s", expr, diff)
+		}
+
+		expr = `(function() local drv = ` + drvExpr + `; return json.encode({drv}) == '["' .. tostring(drv) .. '"]' end)()`
+		got, err = eval.Expression(ctx, expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != true {
+			t.Errorf("%s = %v; want true", expr, got)
+		}
+	})
+
+	badExprs := []string{
+		`json.encode(1/0)`,
+		`json.encode(function() end)`,
+		`json.encode({1, x = 2})`,
+		`json.encode({[100] = 1})`,
+		`(function() local t = {}; t.t = t; return json.encode(t) end)()`,
+		`json.decode("{")`,
+		`json.decode("[1] 2")`,
+	}
+	for _, expr := range badExprs {
+		if _, err := eval.Expression(ctx, expr); err == nil {
+			t.Errorf("%s did not return an error", expr)
+		}
+	}
+}
+
+func TestTOML(t *testing.T) {
+	ctx, cancel := testcontext.New(t)
+	defer cancel()
+	storeDir := backendtest.NewStoreDirectory(t)
+
+	_, store, err := backendtest.NewServer(ctx, t, storeDir, &backendtest.Options{
+		TempDir: t.TempDir(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	eval, err := NewEval(&Options{
+		Store:          newTestRPCStore(store),
+		StoreDirectory: storeDir,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := eval.Close(); err != nil {
+			t.Error("eval.Close:", err)
+		}
+	}()
+
+	const expr = `toml.decode([[
+version = 3
+
+[[package]]
+name = "zb"
+version = "0.1.0"
+dependencies = ["lua"]
+released = 2025-01-02
+
+[[package]]
+name = "lua"
+version = "5.4.7"
+]])`
+	got, err := eval.Expression(ctx, expr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]any{
+		"version": int64(3),
+		"package": []any{
+			map[string]any{
+				"name":         "zb",
+				"version":      "0.1.0",
+				"dependencies": []any{"lua"},
+				"released":     "2025-01-02",
+			},
+			map[string]any{
+				"name":    "lua",
+				"version": "5.4.7",
+			},
+		},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("%s (-want +got):\n%s", expr, diff)
+	}
+
+	if _, err := eval.Expression(ctx, `toml.decode("a = 1\na = 2")`); err == nil {
+		t.Error("toml.decode with duplicate key did not return an error")
+	}
+}
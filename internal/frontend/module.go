@@ -73,52 +73,11 @@ func (eval *Eval) importFunction(ctx context.Context, l *lua.State) (int, error)
 		return 0, err
 	}
 	filenameContext := l.StringContext(1)
-
-	// TODO(someday): If we have dependencies and we're using a non-local store,
-	// export the store object and read it.
-	toRealize := make(sets.Set[zbstore.OutputReference])
-	placeholders := make(map[string]zbstore.OutputReference)
-	for dep := range filenameContext {
-		c, err := parseContextString(dep)
-		if err != nil {
-			l.PushNil()
-			l.PushString(fmt.Sprintf("internal error: %v", err))
-			return 2, nil
-		}
-		if c.outputReference.IsZero() {
-			continue
-		}
-		placeholder := zbstore.UnknownCAOutputPlaceholder(c.outputReference)
-		if !strings.Contains(filename, placeholder) {
-			continue
-		}
-		toRealize.Add(c.outputReference)
-		placeholders[placeholder] = c.outputReference
-	}
-	if toRealize.Len() > 0 {
-		results, err := eval.store.Realize(ctx, toRealize)
-		if err != nil {
-			l.PushNil()
-			l.PushString(err.Error())
-			return 2, nil
-		}
-
-		var rewrites []string
-		for placeholder, outputReference := range placeholders {
-			outputPath, err := zbstorerpc.FindRealizeOutput(slices.Values(results), outputReference)
-			if err != nil {
-				l.PushNil()
-				l.PushString(err.Error())
-				return 2, nil
-			}
-			if !outputPath.Valid || outputPath.X == "" {
-				l.PushNil()
-				l.PushString(fmt.Sprintf("realize %v: build failed", outputReference))
-				return 2, nil
-			}
-			rewrites = append(rewrites, placeholder, string(outputPath.X))
-		}
-		filename = strings.NewReplacer(rewrites...).Replace(filename)
+	filename, err = eval.realizePlaceholders(ctx, filename, filenameContext)
+	if err != nil {
+		l.PushNil()
+		l.PushString(err.Error())
+		return 2, nil
 	}
 
 	filename, err = absSourcePath(l, eval.storeDir, filename, filenameContext)
@@ -210,9 +169,60 @@ func (eval *Eval) importFunction(ctx context.Context, l *lua.State) (int, error)
 	return 1, nil
 }
 
+// realizePlaceholders builds the derivation outputs
+// whose placeholders appear in the path string s
+// (as determined by the string's context)
+// and returns s with the placeholders replaced by the realized store paths.
+func (eval *Eval) realizePlaceholders(ctx context.Context, s string, context sets.Set[string]) (string, error) {
+	toRealize := make(sets.Set[zbstore.OutputReference])
+	placeholders := make(map[string]zbstore.OutputReference)
+	for dep := range context {
+		c, err := parseContextString(dep)
+		if err != nil {
+			return "", fmt.Errorf("internal error: %v", err)
+		}
+		if c.outputReference.IsZero() {
+			continue
+		}
+		placeholder := zbstore.UnknownCAOutputPlaceholder(c.outputReference)
+		if !strings.Contains(s, placeholder) {
+			continue
+		}
+		toRealize.Add(c.outputReference)
+		placeholders[placeholder] = c.outputReference
+	}
+	if toRealize.Len() == 0 {
+		return s, nil
+	}
+
+	results, err := eval.store.Realize(ctx, toRealize)
+	if err != nil {
+		return "", err
+	}
+	var rewrites []string
+	for placeholder, outputReference := range placeholders {
+		outputPath, err := zbstorerpc.FindRealizeOutput(slices.Values(results), outputReference)
+		if err != nil {
+			return "", err
+		}
+		if !outputPath.Valid || outputPath.X == "" {
+			return "", fmt.Errorf("realize %v: build failed", outputReference)
+		}
+		rewrites = append(rewrites, placeholder, string(outputPath.X))
+	}
+	return strings.NewReplacer(rewrites...).Replace(s), nil
+}
+
 func (eval *Eval) resolveModule(ctx context.Context, l *lua.State, filename string) error {
 	l.SetTop(0)
-	if err := loadFile(l, filename); err != nil {
+	// The store may not be on the local filesystem.
+	// Read from a local copy, but keep the store path as the chunk name
+	// so that the module resolves relative paths inside the store.
+	localFilename, err := eval.localPath(ctx, filename)
+	if err != nil {
+		return err
+	}
+	if err := loadFileFrom(l, filename, localFilename); err != nil {
 		return err
 	}
 	l.PushClosure(0, messageHandler)
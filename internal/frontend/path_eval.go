@@ -74,6 +74,13 @@ func (eval *Eval) pathFunction(ctx context.Context, l *lua.State) (nResults int,
 	if name == "" {
 		name = filepath.Base(p)
 	}
+	// Store objects may not be present on the local filesystem.
+	// The name is derived from the path as written,
+	// but the files are read from a local copy.
+	p, err = eval.localPath(ctx, p)
+	if err != nil {
+		return 0, fmt.Errorf("path: %v", err)
+	}
 
 	cache, err := eval.cachePool.Get(ctx)
 	if err != nil {
@@ -0,0 +1,146 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"zb.256lights.llc/pkg/internal/narutil"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/log"
+)
+
+// localPath returns a path on the local filesystem
+// that has the same content as the given absolute path.
+// Paths outside the store directory are returned unchanged,
+// as are paths whose store object is present on the local filesystem.
+// Otherwise, the store object is exported from the store
+// into the store cache directory
+// and localPath returns the corresponding path inside the cached copy.
+//
+// Callers should continue to use the original path when reporting errors
+// or naming Lua chunks so that relative paths resolve inside the store.
+func (eval *Eval) localPath(ctx context.Context, path string) (string, error) {
+	if !pathInStore(path, eval.storeDir) {
+		return path, nil
+	}
+	storePath, sub, err := eval.storeDir.ParsePath(path)
+	if err != nil {
+		// Not a store object (e.g. the store directory itself).
+		return path, nil
+	}
+	if _, err := os.Lstat(string(storePath)); err == nil {
+		return path, nil
+	}
+	cachedPath, err := eval.fetchStoreObject(ctx, storePath)
+	if err != nil {
+		return "", err
+	}
+	if sub == "" {
+		return cachedPath, nil
+	}
+	return filepath.Join(cachedPath, filepath.FromSlash(sub)), nil
+}
+
+// fetchStoreObject returns the path to a copy of the given store object
+// in the store cache directory,
+// exporting it from the store if it has not been cached yet.
+// Store objects are immutable,
+// so a cached copy is never refreshed.
+func (eval *Eval) fetchStoreObject(ctx context.Context, storePath zbstore.Path) (string, error) {
+	cacheDir, err := eval.storeCacheDirectory()
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %v", storePath, err)
+	}
+	dst := filepath.Join(cacheDir, storePath.Base())
+	if _, err := os.Lstat(dst); err == nil {
+		return dst, nil
+	}
+
+	log.Debugf(ctx, "%s not present locally. Exporting from store...", storePath)
+	tempDir, err := os.MkdirTemp(cacheDir, ".tmp-*")
+	if err != nil {
+		return "", fmt.Errorf("fetch %s: %v", storePath, err)
+	}
+	defer func() {
+		if rmErr := os.RemoveAll(tempDir); rmErr != nil {
+			log.Warnf(ctx, "Clean up fetch of %s: %v", storePath, rmErr)
+		}
+	}()
+	tempPath := filepath.Join(tempDir, storePath.Base())
+
+	pr, pw := io.Pipe()
+	extractDone := make(chan error)
+	go func() {
+		err := narutil.Extract(tempPath, pr)
+		if err == nil {
+			// Drain any trailing bytes so that the exporter doesn't block.
+			_, err = io.Copy(io.Discard, pr)
+		}
+		pr.CloseWithError(err)
+		extractDone <- err
+		close(extractDone)
+	}()
+	exportErr := eval.store.Export(ctx, pw, storePath)
+	pw.CloseWithError(exportErr)
+	extractErr := <-extractDone
+	if exportErr != nil {
+		return "", fmt.Errorf("fetch %s: %v", storePath, exportErr)
+	}
+	if extractErr != nil {
+		return "", fmt.Errorf("fetch %s: %v", storePath, extractErr)
+	}
+
+	if err := os.Rename(tempPath, dst); err != nil {
+		// Another evaluation may have fetched the same object concurrently.
+		if _, statErr := os.Lstat(dst); statErr == nil {
+			return dst, nil
+		}
+		return "", fmt.Errorf("fetch %s: %v", storePath, err)
+	}
+	return dst, nil
+}
+
+// storeCacheDirectory returns the directory used to cache store objects,
+// creating it if necessary.
+func (eval *Eval) storeCacheDirectory() (string, error) {
+	eval.storeCacheMutex.Lock()
+	defer eval.storeCacheMutex.Unlock()
+	if eval.storeCacheDir != "" {
+		if err := os.MkdirAll(eval.storeCacheDir, 0o777); err != nil {
+			return "", err
+		}
+		return eval.storeCacheDir, nil
+	}
+	dir, err := os.MkdirTemp("", "zb-store-cache-*")
+	if err != nil {
+		return "", err
+	}
+	eval.storeCacheDir = dir
+	eval.storeCacheIsTemp = true
+	return dir, nil
+}
+
+// removeStoreCache removes the store cache directory
+// if it was created by [*Eval.storeCacheDirectory].
+func (eval *Eval) removeStoreCache() error {
+	eval.storeCacheMutex.Lock()
+	defer eval.storeCacheMutex.Unlock()
+	if !eval.storeCacheIsTemp {
+		return nil
+	}
+	err := os.RemoveAll(eval.storeCacheDir)
+	eval.storeCacheDir = ""
+	eval.storeCacheIsTemp = false
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
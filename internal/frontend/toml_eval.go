@@ -0,0 +1,33 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package frontend
+
+import (
+	"context"
+	"fmt"
+
+	"zb.256lights.llc/pkg/internal/lua"
+	"zb.256lights.llc/pkg/internal/toml"
+)
+
+func openTOML(l *lua.State) {
+	lua.NewPureLib(l, map[string]lua.Function{
+		"decode": tomlDecodeFunction,
+	})
+}
+
+func tomlDecodeFunction(ctx context.Context, l *lua.State) (int, error) {
+	s, err := lua.CheckString(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	doc, err := toml.Parse([]byte(s))
+	if err != nil {
+		return 0, fmt.Errorf("%s%v", lua.Where(l, 1), err)
+	}
+	if err := pushDecodedValue(l, doc, l.StringContext(1)); err != nil {
+		return 0, fmt.Errorf("%stoml.decode: %v", lua.Where(l, 1), err)
+	}
+	return 1, nil
+}
@@ -155,12 +155,32 @@ func (eval *Eval) URLs(ctx context.Context, urls []string) ([]any, error) {
 	for i, u := range parsedURLs {
 		l.RawIndex(tableStackIndex, int64(i+1))
 		_, fieldPath, _ := parseFragment(u.Fragment)
+		var outputRef zbstore.OutputReference
 		if fieldPath == "" {
 			l.PushValue(-1)
 		} else {
 			if err := searchKeyPaths(ctx, l, fieldPath, []string{sysTriple}, -2); err != nil {
 				return nil, fmt.Errorf("%s: %v", urls[i], err)
 			}
+			if l.IsNil(-1) {
+				// Not found. Check whether it's an output selector like "pkg.dev".
+				if drvPath, outputName, ok := cutOutputSuffix(fieldPath); ok {
+					l.Pop(1)
+					if err := searchKeyPaths(ctx, l, drvPath, []string{sysTriple}, -2); err != nil {
+						return nil, fmt.Errorf("%s: %v", urls[i], err)
+					}
+					var err error
+					outputRef, err = selectDerivationOutput(l, outputName)
+					if err != nil {
+						return nil, fmt.Errorf("%s: %s: %v", urls[i], drvPath, err)
+					}
+				}
+			}
+		}
+		if !outputRef.IsZero() {
+			result[i] = outputRef
+			l.Pop(2)
+			continue
 		}
 		val, err := luaToGo(ctx, l)
 		if err != nil {
@@ -431,6 +451,41 @@ func parseFragment(s string) (archivePath, keyPath string, err error) {
 	return archivePath, keyPath, nil
 }
 
+// cutOutputSuffix splits a key path of the form "pkg.out"
+// into the key path of a derivation and an output name.
+func cutOutputSuffix(keyPath string) (drvKeyPath, outputName string, ok bool) {
+	i := strings.LastIndexByte(keyPath, '.')
+	if i < 0 || strings.LastIndexByte(keyPath, '/') > i {
+		return keyPath, "", false
+	}
+	drvKeyPath, outputName = keyPath[:i], keyPath[i+1:]
+	if drvKeyPath == "" || strings.HasSuffix(drvKeyPath, "/") || !zbstore.IsValidOutputName(outputName) {
+		return keyPath, "", false
+	}
+	return drvKeyPath, outputName, true
+}
+
+// selectDerivationOutput returns a reference to the output with the given name
+// of the derivation on the top of the stack.
+// If the value on the top of the stack is nil,
+// selectDerivationOutput returns the zero value.
+func selectDerivationOutput(l *lua.State, outputName string) (zbstore.OutputReference, error) {
+	if l.IsNil(-1) {
+		return zbstore.OutputReference{}, nil
+	}
+	drv := testDerivation(l, -1)
+	if drv == nil {
+		return zbstore.OutputReference{}, fmt.Errorf("%v is not a derivation", l.Type(-1))
+	}
+	if _, ok := drv.Outputs[outputName]; !ok {
+		return zbstore.OutputReference{}, fmt.Errorf("derivation %s has no output %s", drv.Path, lualex.Quote(outputName))
+	}
+	return zbstore.OutputReference{
+		DrvPath:    drv.Path,
+		OutputName: outputName,
+	}, nil
+}
+
 // splitKeyPath splits a slash-separated path into its components.
 // A run of slashes is treated the same as a single slash.
 // An empty string yields no elements.
@@ -295,6 +295,33 @@ func TestSplitKeyPath(t *testing.T) {
 	}
 }
 
+func TestCutOutputSuffix(t *testing.T) {
+	tests := []struct {
+		keyPath        string
+		wantDrvKeyPath string
+		wantOutputName string
+		wantOK         bool
+	}{
+		{keyPath: "foo"},
+		{keyPath: "foo/bar"},
+		{keyPath: "foo.dev", wantDrvKeyPath: "foo", wantOutputName: "dev", wantOK: true},
+		{keyPath: "foo/bar.lib", wantDrvKeyPath: "foo/bar", wantOutputName: "lib", wantOK: true},
+		{keyPath: "foo.bar.dev", wantDrvKeyPath: "foo.bar", wantOutputName: "dev", wantOK: true},
+		{keyPath: "foo.bar/baz"},
+		{keyPath: ".dev"},
+		{keyPath: "foo/.dev"},
+		{keyPath: "foo."},
+	}
+
+	for _, test := range tests {
+		drvKeyPath, outputName, ok := cutOutputSuffix(test.keyPath)
+		if ok != test.wantOK || (ok && (drvKeyPath != test.wantDrvKeyPath || outputName != test.wantOutputName)) {
+			t.Errorf("cutOutputSuffix(%q) = %q, %q, %t; want %q, %q, %t",
+				test.keyPath, drvKeyPath, outputName, ok, test.wantDrvKeyPath, test.wantOutputName, test.wantOK)
+		}
+	}
+}
+
 func userinfoEqual(u1, u2 *url.Userinfo) bool {
 	user1 := u1.Username()
 	pass1, hasPass1 := u1.Password()
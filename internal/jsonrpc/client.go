@@ -88,6 +88,15 @@ func (c *Client) Close() error {
 }
 
 // JSONRPC sends a request to the server.
+// If ctx has a notification handler (see [WithNotificationHandler]),
+// then notifications that the server sends while the request is in flight
+// are passed to the handler.
+// JSON-RPC notifications do not identify the request they are associated with,
+// so every notification is passed to the handlers of all requests in flight on the connection.
+// Handlers should use the notification's parameters
+// to ignore notifications intended for other requests.
+// Notification handlers are called from the goroutine that reads from the connection,
+// so they should return quickly.
 func (c *Client) JSONRPC(ctx context.Context, req *Request) (*Response, error) {
 	if !isValidParamStruct(req.Params) {
 		return nil, Error(InvalidRequest, fmt.Errorf("call json rpc %s: params must be an object or an array", req.Method))
@@ -335,6 +344,11 @@ func dispatchResponse(ctx context.Context, msg json.RawMessage, inflight map[int
 		return
 	}
 	for _, resp := range batch {
+		if resp.isNotification() {
+			dispatchNotification(ctx, resp, inflight)
+			continue
+		}
+
 		// We specifically don't check anything beyond ID here
 		// because we want most errors to be returned to the application.
 		id, err := resp.id()
@@ -362,6 +376,34 @@ func dispatchResponse(ctx context.Context, msg json.RawMessage, inflight map[int
 	}
 }
 
+// dispatchNotification sends a notification from the server
+// to the notification handlers of all the in-flight requests.
+func dispatchNotification(ctx context.Context, msg rawResponse, inflight map[int64]inflightRequestState) {
+	if err := msg.checkVersion(); err != nil {
+		log.Warnf(ctx, "JSON-RPC server sent invalid notification: %v", err)
+		return
+	}
+	req := &Request{
+		Params:       msg.msg["params"],
+		Notification: true,
+		Extra:        inverseFilterMap(msg.msg, isReservedRequestField),
+	}
+	if err := json.Unmarshal(msg.msg["method"], &req.Method); err != nil {
+		log.Warnf(ctx, "JSON-RPC server sent invalid notification: method: %v", err)
+		return
+	}
+	log.Debugf(ctx, "Received %s JSON-RPC notification", req.Method)
+	for _, state := range inflight {
+		h := NotificationHandlerFromContext(state.context)
+		if h == nil {
+			continue
+		}
+		if _, err := h.JSONRPC(state.context, req); err != nil {
+			log.Debugf(ctx, "Notification handler for %s: %v", req.Method, err)
+		}
+	}
+}
+
 // Codec obtains the client's currently active codec,
 // waiting for a connection to be established if necessary.
 // The caller is responsible for calling the release function
@@ -483,6 +525,13 @@ func (resp rawResponse) checkVersion() error {
 	return nil
 }
 
+// isNotification reports whether the message is a request without an ID.
+func (resp rawResponse) isNotification() bool {
+	_, hasMethod := resp.msg["method"]
+	_, hasID := resp.msg["id"]
+	return resp.error == nil && hasMethod && !hasID
+}
+
 func (resp rawResponse) id() (RequestID, error) {
 	raw := resp.msg["id"]
 	if len(raw) == 0 {
@@ -306,6 +306,62 @@ func TestClientCancel(t *testing.T) {
 	}
 }
 
+func TestClientServerNotification(t *testing.T) {
+	ctx := context.Background()
+	if d, ok := t.Deadline(); ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, d)
+		defer cancel()
+	}
+
+	codec := newTestClientCodec(t, []clientTestWireInteraction{
+		{
+			wantRequests: []any{
+				map[string]any{
+					"jsonrpc": "2.0",
+					"method":  "watch",
+					"id":      "1",
+				},
+			},
+			responses: []json.RawMessage{
+				json.RawMessage(`{"jsonrpc": "2.0", "method": "progress", "params": [1]}`),
+				json.RawMessage(`{"jsonrpc": "2.0", "method": "progress", "params": [2]}`),
+				json.RawMessage(`{"jsonrpc": "2.0", "result": 123, "id": "1"}`),
+			},
+		},
+	})
+	client := NewClient(func(ctx context.Context) (ClientCodec, error) {
+		return codec, nil
+	})
+	defer func() {
+		if err := client.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+
+	var got []string
+	callCtx := WithNotificationHandler(ctx, HandlerFunc(func(ctx context.Context, req *Request) (*Response, error) {
+		if !req.Notification {
+			t.Errorf("notification handler called with non-notification request %s", req.Method)
+		}
+		got = append(got, req.Method+string(req.Params))
+		return nil, nil
+	}))
+	resp, err := client.JSONRPC(callCtx, &Request{
+		Method: "watch",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(&Response{Result: json.RawMessage(`123`)}, resp, parseRawJSON()); diff != "" {
+		t.Errorf("response (-want +got):\n%s", diff)
+	}
+	want := []string{"progress[1]", "progress[2]"}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("notifications (-want +got):\n%s", diff)
+	}
+}
+
 func TestClientCodec(t *testing.T) {
 	ctx := context.Background()
 	openCount := 0
@@ -83,6 +83,30 @@ func Notify(ctx context.Context, h Handler, method string, params any) error {
 	return err
 }
 
+type notificationHandlerContextKey struct{}
+
+// WithNotificationHandler returns a copy of parent
+// in which [NotificationHandlerFromContext] returns h.
+//
+// When the returned context is passed to [*Client.JSONRPC],
+// any notifications that the server sends while the request is in flight
+// are delivered to h,
+// including notifications sent on behalf of other concurrent requests.
+// [Serve] passes contexts to its [Handler]
+// whose notification handler sends notifications to the client.
+func WithNotificationHandler(parent context.Context, h Handler) context.Context {
+	return context.WithValue(parent, notificationHandlerContextKey{}, h)
+}
+
+// NotificationHandlerFromContext returns the [Handler]
+// that receives notifications associated with a request
+// or nil if the context does not have one.
+// See [WithNotificationHandler] for details.
+func NotificationHandlerFromContext(ctx context.Context) Handler {
+	h, _ := ctx.Value(notificationHandlerContextKey{}).(Handler)
+	return h
+}
+
 // ErrorCode is a number that indicates the type of error
 // that occurred during a JSON-RPC.
 type ErrorCode int
@@ -60,6 +60,11 @@ type server struct {
 // Serve serves JSON-RPC requests for a connection.
 // Serve will read requests from the codec until ReadRequest returns an error,
 // which Serve will return once all requests have completed.
+//
+// Handlers can send notifications to the client
+// with the handler returned by [NotificationHandlerFromContext].
+// Notifications sent before a handler returns
+// are written to the connection before the handler's response.
 func Serve(ctx context.Context, codec ServerCodec, handler Handler) error {
 	srv := &server{
 		codec:     codec,
@@ -107,9 +112,9 @@ func (srv *server) single(ctx context.Context, handler Handler, req *serverReque
 	case cancelMethod:
 		resp, err = srv.cancel(&req.Request)
 	default:
-		handlerCtx := ctx
+		handlerCtx := WithNotificationHandler(ctx, serverNotifier{srv})
 		if !notification {
-			handlerCtx = withRequestID(ctx, req.id)
+			handlerCtx = withRequestID(handlerCtx, req.id)
 		}
 		resp, err = handler.JSONRPC(handlerCtx, &req.Request)
 	}
@@ -183,6 +188,37 @@ func (srv *server) writeError(err error) {
 	srv.codec.WriteResponse(json.RawMessage(buf.Bytes()))
 }
 
+// serverNotifier is a [Handler] that sends notifications to the client.
+type serverNotifier struct {
+	srv *server
+}
+
+// JSONRPC writes a notification to the client.
+// The returned response is always nil.
+func (n serverNotifier) JSONRPC(ctx context.Context, req *Request) (*Response, error) {
+	if !req.Notification {
+		return nil, Error(InvalidRequest, fmt.Errorf("call json rpc %s: server can only send notifications to client", req.Method))
+	}
+	if !isValidParamStruct(req.Params) {
+		return nil, Error(InvalidRequest, fmt.Errorf("call json rpc %s: params must be an object or an array", req.Method))
+	}
+	buf := new(bytes.Buffer)
+	buf.WriteString(`{"jsonrpc":"2.0","method":`)
+	buf.Write(jsonstring.Append(nil, req.Method))
+	if len(req.Params) > 0 {
+		buf.WriteString(`,"params":`)
+		buf.Write(req.Params)
+	}
+	buf.WriteString("}")
+
+	n.srv.writeLock.Lock()
+	defer n.srv.writeLock.Unlock()
+	if err := n.srv.codec.WriteResponse(json.RawMessage(buf.Bytes())); err != nil {
+		return nil, fmt.Errorf("call json rpc %s: %w", req.Method, err)
+	}
+	return nil, nil
+}
+
 // ServeMux is a mapping of method names to JSON-RPC handlers.
 type ServeMux map[string]Handler
 
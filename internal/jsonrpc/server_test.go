@@ -79,6 +79,26 @@ func TestServe(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "ServerNotification",
+			requests: []json.RawMessage{
+				json.RawMessage(`{"jsonrpc": "2.0", "method": "notifyBack", "params": {"x": 42}, "id": 1}`),
+			},
+			responses: []any{
+				map[string]any{
+					"jsonrpc": "2.0",
+					"result":  nil,
+					"id":      json.Number("1"),
+				},
+				map[string]any{
+					"jsonrpc": "2.0",
+					"method":  "progress",
+					"params": map[string]any{
+						"x": json.Number("42"),
+					},
+				},
+			},
+		},
 		{
 			name: "NonExistentNotification",
 			requests: []json.RawMessage{
@@ -142,6 +162,17 @@ func TestServe(t *testing.T) {
 		case "hang":
 			<-ctx.Done()
 			return nil, nil
+		case "notifyBack":
+			h := NotificationHandlerFromContext(ctx)
+			if h == nil {
+				return nil, fmt.Errorf("no notification handler")
+			}
+			_, err := h.JSONRPC(ctx, &Request{
+				Method:       "progress",
+				Params:       req.Params,
+				Notification: true,
+			})
+			return nil, err
 		default:
 			return nil, Error(MethodNotFound, fmt.Errorf("unknown method %q", req.Method))
 		}
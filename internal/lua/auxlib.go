@@ -477,6 +477,7 @@ func OpenLibraries(ctx context.Context, l *State) error {
 		openf Function
 	}{
 		{GName, NewOpenBase(nil)},
+		{CoroutineLibraryName, OpenCoroutine},
 		{TableLibraryName, OpenTable},
 		{StringLibraryName, OpenString},
 		{MathLibraryName, NewOpenMath(nil)},
@@ -57,7 +57,6 @@ func NewOpenBase(opts *BaseOptions) Function {
 			"load":         baseLoad,
 			"next":         baseNext,
 			"pairs":        basePairs,
-			"pcall":        basePCall,
 			"rawequal":     baseRawEqual,
 			"rawget":       baseRawGet,
 			"rawlen":       baseRawLen,
@@ -67,7 +66,11 @@ func NewOpenBase(opts *BaseOptions) Function {
 			"tonumber":     baseToNumber,
 			"tostring":     baseToString,
 			"type":         baseType,
-			"xpcall":       baseXPCall,
+		}
+		// Protected calls can be yielded across.
+		yieldableFuncs := map[string]Function{
+			"pcall":  basePCall,
+			"xpcall": baseXPCall,
 		}
 		impureFuncs := map[string]Function{
 			"print": newBasePrint(opts.Output),
@@ -90,6 +93,12 @@ func NewOpenBase(opts *BaseOptions) Function {
 		if err := SetPureFunctions(ctx, l, 0, pureFuncs); err != nil {
 			return 0, err
 		}
+		err := setFuncs(l, 0, yieldableFuncs, (*State).pushYieldableFunction, func(l *State, idx int, k string) error {
+			return l.SetField(ctx, idx, k)
+		})
+		if err != nil {
+			return 0, err
+		}
 		if err := SetFunctions(ctx, l, 0, impureFuncs); err != nil {
 			return 0, err
 		}
@@ -168,9 +177,7 @@ func baseError(ctx context.Context, l *State) (int, error) {
 			return 0, err
 		}
 	}
-	// TODO(someday): Return error object from top of stack.
-	msg, _ := l.ToString(-1)
-	return 0, errors.New(msg)
+	return 0, newErrorObject(l, l.stack[len(l.stack)-1])
 }
 
 func baseGetMetatable(ctx context.Context, l *State) (int, error) {
@@ -396,9 +403,12 @@ func basePCall(ctx context.Context, l *State) (int, error) {
 	l.Insert(1)
 
 	if err := l.PCall(ctx, l.Top()-2, MultipleReturns, 0); err != nil {
+		if l.isClosingFrame() {
+			// Coroutine is being closed: continue unwinding.
+			return 0, err
+		}
 		l.PushBoolean(false)
-		// TODO(someday): Push error object from err.
-		l.PushString(err.Error())
+		l.push(l.errorToValue(err))
 		return 2, nil
 	}
 	return l.Top(), nil
@@ -422,13 +432,19 @@ func baseXPCall(ctx context.Context, l *State) (int, error) {
 	// 2: true
 	// 3: function
 	// 4 → top: arguments
+	l.PushValue(1)
+	l.Insert(3)
+	l.Remove(1)
 	l.PushBoolean(true)
-	l.Rotate(3, 1)
+	l.Insert(2)
 
 	if err := l.PCall(ctx, numArgs, MultipleReturns, 1); err != nil {
+		if l.isClosingFrame() {
+			// Coroutine is being closed: continue unwinding.
+			return 0, err
+		}
 		l.PushBoolean(false)
-		// TODO(someday): Push error object from err.
-		l.PushString(err.Error())
+		l.push(l.errorToValue(err))
 		return 2, nil
 	}
 	return l.Top() - 1, nil
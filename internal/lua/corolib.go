@@ -0,0 +1,194 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"slices"
+)
+
+// CoroutineLibraryName is the conventional identifier for the [coroutine manipulation library].
+//
+// [coroutine manipulation library]: https://www.lua.org/manual/5.4/manual.html#6.2
+const CoroutineLibraryName = "coroutine"
+
+// OpenCoroutine is a [Function] that loads the [coroutine manipulation library].
+// This function is intended to be used as an argument to [Require].
+//
+// All functions in the coroutine library are pure (as per [*State.PushPureFunction]).
+// The functions returned by “coroutine.wrap” are not pure.
+// Each coroutine runs on its own goroutine,
+// but only one thread of a [State] runs at a time.
+// A coroutine may yield across calls to “pcall”, “xpcall”, and metamethods,
+// but not across other functions implemented in Go.
+//
+// [coroutine manipulation library]: https://www.lua.org/manual/5.4/manual.html#6.2
+func OpenCoroutine(ctx context.Context, l *State) (int, error) {
+	NewPureLib(l, map[string]Function{
+		"close":   coroutineClose,
+		"create":  coroutineCreate,
+		"resume":  coroutineResume,
+		"running": coroutineRunning,
+		"status":  coroutineStatus,
+		"wrap":    coroutineWrap,
+	})
+	yieldableFuncs := map[string]Function{
+		"isyieldable": coroutineIsYieldable,
+		"yield":       coroutineYield,
+	}
+	if err := setFuncs(l, 0, yieldableFuncs, (*State).pushYieldableFunction, (*State).RawSetField); err != nil {
+		return 0, err
+	}
+	return 1, nil
+}
+
+func coroutineCreate(ctx context.Context, l *State) (int, error) {
+	if got, want := l.Type(1), TypeFunction; got != want {
+		return 0, NewTypeError(l, 1, want.String())
+	}
+	l.push(l.newThread(l.stack[l.frame().registerStart()]))
+	return 1, nil
+}
+
+func coroutineResume(ctx context.Context, l *State) (int, error) {
+	t, err := toThread(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	results, err := resumeThread(ctx, l, t, 2)
+	if err != nil {
+		l.PushBoolean(false)
+		l.push(l.errorToValue(err))
+		return 2, nil
+	}
+	if !l.CheckStack(len(results) + 1) {
+		l.PushBoolean(false)
+		l.PushString("too many results to resume")
+		return 2, nil
+	}
+	l.PushBoolean(true)
+	l.stack = append(l.stack, results...)
+	return len(results) + 1, nil
+}
+
+func coroutineWrap(ctx context.Context, l *State) (int, error) {
+	if _, err := coroutineCreate(ctx, l); err != nil {
+		return 0, err
+	}
+	l.PushClosure(1, coroutineAuxWrap)
+	return 1, nil
+}
+
+func coroutineAuxWrap(ctx context.Context, l *State) (int, error) {
+	t, err := toThread(l, UpvalueIndex(1))
+	if err != nil {
+		return 0, err
+	}
+	results, err := resumeThread(ctx, l, t, 1)
+	if err != nil {
+		if t.state.status == threadDead {
+			// The coroutine's variables have already been closed
+			// and the error is propagated to the caller.
+			t.state.co.err = nil
+		}
+		errValue := l.errorToValue(err)
+		if s, ok := errValue.(stringValue); ok {
+			// Add extra position information.
+			s.s = Where(l, 1) + s.s
+			errValue = s
+		}
+		return 0, newErrorObject(l, errValue)
+	}
+	if !l.CheckStack(len(results)) {
+		return 0, errors.New("too many results to resume")
+	}
+	l.stack = append(l.stack, results...)
+	return len(results), nil
+}
+
+// resumeThread resumes t with the arguments of the current Go function call
+// starting with the argument at index firstArg.
+// The arguments are popped from the stack.
+func resumeThread(ctx context.Context, l *State, t *thread, firstArg int) ([]value, error) {
+	argStart := min(l.frame().registerStart()+firstArg-1, len(l.stack))
+	args := slices.Clone(l.stack[argStart:])
+	l.setTop(argStart)
+	if !t.state.CheckStack(len(args)) {
+		return nil, errors.New("too many arguments to resume")
+	}
+	return l.resume(ctx, t, args)
+}
+
+func coroutineYield(ctx context.Context, l *State) (int, error) {
+	start := l.frame().registerStart()
+	values := slices.Clone(l.stack[start:])
+	l.setTop(start)
+	results, err := l.yield(values)
+	if err != nil {
+		return 0, err
+	}
+	l.stack = append(l.stack, results...)
+	return len(results), nil
+}
+
+func coroutineStatus(ctx context.Context, l *State) (int, error) {
+	t, err := toThread(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	l.PushString(t.state.status.String())
+	return 1, nil
+}
+
+func coroutineRunning(ctx context.Context, l *State) (int, error) {
+	l.push(l.threadHandle())
+	l.PushBoolean(l.co == nil)
+	return 2, nil
+}
+
+func coroutineIsYieldable(ctx context.Context, l *State) (int, error) {
+	s := l
+	if !l.IsNone(1) {
+		t, err := toThread(l, 1)
+		if err != nil {
+			return 0, err
+		}
+		s = t.state
+	}
+	l.PushBoolean(s.co != nil && s.nny == 0)
+	return 1, nil
+}
+
+func coroutineClose(ctx context.Context, l *State) (int, error) {
+	t, err := toThread(l, 1)
+	if err != nil {
+		return 0, err
+	}
+	threadErr, err := l.closeThread(ctx, t)
+	if err != nil {
+		return 0, err
+	}
+	if threadErr != nil {
+		l.PushBoolean(false)
+		l.push(l.errorToValue(threadErr))
+		return 2, nil
+	}
+	l.PushBoolean(true)
+	return 1, nil
+}
+
+// toThread returns the thread at the given index
+// or returns an argument error if the value is not a thread.
+func toThread(l *State, idx int) (*thread, error) {
+	v, _, err := l.valueByIndex(idx)
+	if err != nil {
+		return nil, err
+	}
+	t, ok := v.(*thread)
+	if !ok {
+		return nil, NewTypeError(l, idx, "coroutine")
+	}
+	return t, nil
+}
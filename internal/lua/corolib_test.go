@@ -0,0 +1,178 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package lua
+
+import (
+	"context"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCoroutineContext(t *testing.T) {
+	type contextKey struct{}
+
+	state := new(State)
+	defer func() {
+		if err := state.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+	if err := OpenLibraries(context.Background(), state); err != nil {
+		t.Fatal(err)
+	}
+	state.PushClosure(0, func(ctx context.Context, l *State) (int, error) {
+		s, _ := ctx.Value(contextKey{}).(string)
+		l.PushString(s)
+		return 1, nil
+	})
+	if err := state.SetGlobal(context.Background(), "contextValue"); err != nil {
+		t.Fatal(err)
+	}
+	const source = "local co = coroutine.wrap(function()\n" +
+		"  while true do coroutine.yield(contextValue()) end\n" +
+		"end)\n" +
+		"return co\n"
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Call(context.Background(), 0, 1); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, want := range []string{"first", "second"} {
+		ctx := context.WithValue(context.Background(), contextKey{}, want)
+		state.PushValue(-1)
+		if err := state.Call(ctx, 0, 1); err != nil {
+			t.Fatal(err)
+		}
+		if got, _ := state.ToString(-1); got != want {
+			t.Errorf("context value = %q; want %q", got, want)
+		}
+		state.Pop(1)
+	}
+}
+
+func TestCoroutineYieldAcrossGoFunction(t *testing.T) {
+	ctx := context.Background()
+	state := new(State)
+	defer func() {
+		if err := state.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+	if err := OpenLibraries(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+	state.PushClosure(0, func(ctx context.Context, l *State) (int, error) {
+		if err := l.Call(ctx, l.Top()-1, MultipleReturns); err != nil {
+			return 0, err
+		}
+		return l.Top(), nil
+	})
+	if err := state.SetGlobal(ctx, "gocall"); err != nil {
+		t.Fatal(err)
+	}
+	const source = "return coroutine.resume(coroutine.create(function()\n" +
+		"  return gocall(coroutine.yield, 42)\n" +
+		"end))\n"
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	if err := state.Call(ctx, 0, 2); err != nil {
+		t.Fatal(err)
+	}
+	if state.ToBoolean(-2) {
+		t.Error("coroutine.resume(...) = true; want false")
+	}
+	const want = "attempt to yield across a Go-call boundary"
+	if msg, _ := state.ToString(-1); !strings.Contains(msg, want) {
+		t.Errorf("error = %q; want to contain %q", msg, want)
+	}
+}
+
+func TestAbandonedCoroutine(t *testing.T) {
+	ctx := context.Background()
+	state := new(State)
+	defer func() {
+		if err := state.Close(); err != nil {
+			t.Error("Close:", err)
+		}
+	}()
+	if err := OpenLibraries(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+	const source = "for i = 1, 100 do\n" +
+		"  local co = coroutine.wrap(function() coroutine.yield(i) end)\n" +
+		"  assert(co() == i)\n" +
+		"end\n"
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	if err := state.Call(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// Suspended coroutines that are no longer reachable
+	// should stop their goroutines once they are garbage collected.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		runtime.GC()
+		n := runtime.NumGoroutine()
+		if n <= before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running after coroutines were abandoned; want <=%d", n, before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestCloseStopsCoroutines(t *testing.T) {
+	ctx := context.Background()
+	state := new(State)
+	if err := OpenLibraries(ctx, state); err != nil {
+		t.Fatal(err)
+	}
+	const n = 100
+	const source = "coroutines = {}\n" +
+		"for i = 1, 100 do\n" +
+		"  local co = coroutine.create(function() coroutine.yield(i) end)\n" +
+		"  assert(coroutine.resume(co))\n" +
+		"  coroutines[i] = co\n" +
+		"end\n"
+	if err := state.Load(strings.NewReader(source), LiteralSource(source), "t"); err != nil {
+		t.Fatal(err)
+	}
+	before := runtime.NumGoroutine()
+	if err := state.Call(ctx, 0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if got := state.coroutines.Len(); got != n {
+		t.Errorf("before Close, %d coroutines tracked; want %d", got, n)
+	}
+
+	if err := state.Close(); err != nil {
+		t.Fatal("Close:", err)
+	}
+	if got := state.coroutines.Len(); got != 0 {
+		t.Errorf("after Close, %d coroutines tracked; want 0", got)
+	}
+	// Goroutines may take a moment to be reaped after they return,
+	// but Close should not depend on garbage collection.
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		got := runtime.NumGoroutine()
+		if got <= before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d goroutines running after Close; want <=%d", got, before)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -23,7 +23,12 @@ However, there are some differences:
   - This package does not provide to-be-closed slots to functions implemented in Go.
     It is assumed that such functions will use “defer” to handle cleanup.
     This eliminates the need to check for errors in functions like [*State.Pop].
-  - Coroutines are not implemented. Maybe one day.
+  - Each coroutine runs on its own goroutine.
+    Go functions called from a coroutine receive the coroutine's [*State].
+    A coroutine can only yield across the Go functions in this package
+    that explicitly support it (like “pcall”).
+    There is no equivalent to “lua_newthread”, “lua_resume”, or “lua_yield”:
+    use the “coroutine” library ([OpenCoroutine]) instead.
   - There is no light userdata, despite there being a [TypeLightUserdata] constant.
     Full userdata holds an “any” value, which is more flexible in Go.
   - There is no lua_topointer, but you can use [*State.ID] for similar purposes.
@@ -11,17 +11,19 @@ import (
 
 // errorToValue converts a Go error to a Lua [value].
 // If the error is an [*errorObject]
-// and it came from the same generation of *State,
+// and it came from the same generation of *State
+// (or one of its threads),
 // then errorToValue returns its value.
-// errorToValue(nil) returns nil.
+// errorToValue(nil) and errorToValue([errThreadClose]) return nil.
 func (l *State) errorToValue(err error) value {
-	if err == nil {
+	if err == nil || err == errThreadClose {
 		return nil
 	}
 	// We test for identity instead of using errors.As
 	// because any wrapping could have a different message,
 	// and thus lead to confusing results.
-	if obj, ok := err.(*errorObject); ok && obj.state == l && obj.generation == l.generation {
+	g := l.globalState()
+	if obj, ok := err.(*errorObject); ok && obj.state == g && obj.generation == g.generation {
 		return obj.value
 	}
 	// TODO(maybe): Use a userdata instead (so errors can be round-tripped)?
@@ -36,9 +38,10 @@ type errorObject struct {
 }
 
 func newErrorObject(l *State, value value) *errorObject {
+	g := l.globalState()
 	return &errorObject{
-		state:      l,
-		generation: l.generation,
+		state:      g,
+		generation: g.generation,
 		value:      value,
 	}
 }
@@ -42,6 +42,10 @@ type goFunction struct {
 	cb       Function
 	upvalues []*upvalue
 	pure     bool
+	// yieldable is true if the function's callback may yield
+	// from a coroutine (like pcall or coroutine.yield).
+	// Calls to other Go functions prevent yields.
+	yieldable bool
 }
 
 func (f goFunction) valueType() Type           { return TypeFunction }
@@ -137,6 +141,8 @@ func (l *State) closeTBCSlots(ctx context.Context, bottom int, preserveTop bool,
 // An upvalue is "open" if it refers to the stack
 // or "closed" if it has escaped the stack.
 type upvalue struct {
+	// owner is the thread whose stack holds the variable while the upvalue is open.
+	owner      *thread
 	stackIndex int
 	storage    value
 	frozen     bool
@@ -167,7 +173,7 @@ func (l *State) stackUpvalue(i int) *upvalue {
 	if uvIndex != -1 {
 		return l.pendingVariables[uvIndex]
 	}
-	uv := &upvalue{stackIndex: i}
+	uv := &upvalue{owner: l.threadHandle(), stackIndex: i}
 	l.pendingVariables = append(l.pendingVariables, uv)
 	return uv
 }
@@ -175,19 +181,20 @@ func (l *State) stackUpvalue(i int) *upvalue {
 // resolveUpvalue converts an [*upvalue] to a pointer to a [value],
 // representing the upvalue's variable.
 // If the upvalue is open, then the returned pointer is valid
-// until the stack grows.
+// until the stack of the upvalue's thread grows.
 func (l *State) resolveUpvalue(uv *upvalue) *value {
 	if uv.isOpen() {
-		return &l.stack[uv.stackIndex]
+		return &uv.owner.state.stack[uv.stackIndex]
 	}
 	return &uv.storage
 }
 
 // checkUpvalues ensures that the given set of upvalues
-// are either closed or referring to variables below the given stack index.
+// are either closed, referring to variables below the given stack index,
+// or referring to variables on another thread's stack.
 func (l *State) checkUpvalues(top int, upvalues []*upvalue) error {
 	for i, uv := range upvalues {
-		if uv.stackIndex >= top {
+		if uv.stackIndex >= top && uv.owner.state == l {
 			return fmt.Errorf("internal error: function upvalue [%d] inside current frame", i)
 		}
 	}
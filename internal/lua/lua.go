@@ -14,6 +14,7 @@ import (
 	"iter"
 	"slices"
 	"strings"
+	"sync"
 
 	"zb.256lights.llc/pkg/internal/luacode"
 	"zb.256lights.llc/pkg/internal/xslices"
@@ -97,15 +98,45 @@ type State struct {
 	typeMetatables   [9]*table
 	pendingVariables []*upvalue
 	tbc              sets.Bit
+
+	// global is the main thread's state for a coroutine
+	// or nil if this is the main thread.
+	// The registry and type metatables are only used from the main thread's state.
+	global *State
+	// thread is the handle for the main thread.
+	// It is created on first use and is always nil for coroutines.
+	thread *thread
+	// co is the coroutine state for a coroutine
+	// or nil if this is the main thread.
+	co *coroutine
+	// status is the coroutine status of the state.
+	status threadStatus
+	// nny is the number of non-yieldable calls in the call stack.
+	nny int
+
+	// coroutinesMu protects coroutines.
+	coroutinesMu sync.Mutex
+	// coroutines is the set of coroutines whose goroutines have not exited.
+	// It is only used from the main thread's state.
+	coroutines sets.Set[*State]
+}
+
+// globalState returns the state that holds
+// the registry and type metatables for l.
+func (l *State) globalState() *State {
+	if l.global != nil {
+		return l.global
+	}
+	return l
 }
 
 func (l *State) init() {
 	if cap(l.stack) < minStack {
 		l.stack = slices.Grow(l.stack, minStack*2-len(l.stack))
 	}
-	if l.registry == nil {
-		l.registry = newTable(1)
-		if err := l.registry.set(integerValue(RegistryIndexGlobals), newTable(0)); err != nil {
+	if g := l.globalState(); g.registry == nil {
+		g.registry = newTable(1)
+		if err := g.registry.set(integerValue(RegistryIndexGlobals), newTable(0)); err != nil {
 			panic(err)
 		}
 	}
@@ -126,6 +157,9 @@ func (l *State) init() {
 //   - The stack will be empty.
 //   - A new registry table will be created.
 //   - Type-wide metatables are removed.
+//   - Suspended coroutines are stopped and their goroutines exit
+//     without closing their pending to-be-closed variables.
+//     Resuming such a coroutine afterward raises an error.
 //
 // Unlike the Lua C API, calling Close is not necessary to clean up resources.
 // States and their associated values are garbage-collected like other Go values.
@@ -134,6 +168,9 @@ func (l *State) Close() error {
 	if len(l.callStack) > 1 {
 		return errors.New("close lua state: in use")
 	}
+	if l.co == nil {
+		l.stopCoroutines()
+	}
 	if len(l.stack) > 0 {
 		l.closeUpvalues(1) // Clears l.pendingVariables as well.
 		l.setTop(1)
@@ -172,7 +209,7 @@ func (l *State) stackIndex(idx int) (int, error) {
 func (l *State) valueByIndex(idx int) (v value, valid bool, err error) {
 	switch {
 	case idx == RegistryIndex:
-		return l.registry, true, nil
+		return l.globalState().registry, true, nil
 	case isUpvalueIndex(idx):
 		fv := l.stack[l.frame().functionIndex]
 		f, ok := fv.(functionValue)
@@ -398,7 +435,8 @@ func (l *State) grow(wantTop int) bool {
 // XMove exchanges values between states:
 // n values are popped from src,
 // then pushed onto the stack of l.
-// If l and src are different states
+// If l and src are independent states
+// (i.e. not threads of the same state)
 // and the top n values of src's stack are not frozen using [*State.Freeze],
 // then XMove returns an error.
 func (l *State) XMove(src *State, n int) error {
@@ -423,9 +461,11 @@ func (l *State) XMove(src *State, n int) error {
 	}
 	newTop := len(src.stack) - n
 	elems := src.stack[newTop:]
-	for _, v := range elems {
-		if !isFrozen(v) {
-			return errors.New("moving unfrozen values between independent states")
+	if src.globalState() != l.globalState() {
+		for _, v := range elems {
+			if !isFrozen(v) {
+				return errors.New("moving unfrozen values between independent states")
+			}
 		}
 	}
 	l.stack = append(l.stack, elems...)
@@ -1033,6 +1073,17 @@ func (l *State) pushGoFunction(n int, f Function, pure bool) {
 	})
 }
 
+// pushYieldableFunction pushes a pure Go function onto the stack
+// that is permitted to yield when called from a coroutine.
+// See [*State.yield] for details.
+func (l *State) pushYieldableFunction(n int, f Function) {
+	l.pushGoFunction(n, f, true)
+	top := len(l.stack) - 1
+	gf := l.stack[top].(goFunction)
+	gf.yieldable = true
+	l.stack[top] = gf
+}
+
 // Global pushes onto the stack the value of the global with the given name,
 // returning the type of that value.
 //
@@ -1041,7 +1092,7 @@ func (l *State) pushGoFunction(n int, f Function, pure bool) {
 // If there is any error, Global pushes nil, then returns [TypeNil] and the error.
 func (l *State) Global(ctx context.Context, name string) (Type, error) {
 	l.init()
-	v, err := l.index(ctx, l.registry.get(integerValue(RegistryIndexGlobals)), stringValue{s: name})
+	v, err := l.index(ctx, l.globalState().registry.get(integerValue(RegistryIndexGlobals)), stringValue{s: name})
 	if err != nil {
 		l.push(nil)
 		return TypeNil, err
@@ -1251,7 +1302,7 @@ func (l *State) metatable(v value) *table {
 	case *userdata:
 		return v.meta
 	default:
-		return l.typeMetatables[valueType(v)]
+		return l.globalState().typeMetatables[valueType(v)]
 	}
 }
 
@@ -1310,7 +1361,7 @@ func (l *State) SetGlobal(ctx context.Context, name string) error {
 	l.init()
 	v := l.stack[len(l.stack)-1]
 	l.setTop(len(l.stack) - 1)
-	if err := l.setIndex(ctx, l.registry.get(integerValue(RegistryIndexGlobals)), stringValue{s: name}, v); err != nil {
+	if err := l.setIndex(ctx, l.globalState().registry.get(integerValue(RegistryIndexGlobals)), stringValue{s: name}, v); err != nil {
 		return err
 	}
 	return nil
@@ -1534,7 +1585,7 @@ func (l *State) SetMetatable(idx int) error {
 		}
 		v.meta = mt
 	default:
-		l.typeMetatables[valueType(v)] = mt
+		l.globalState().typeMetatables[valueType(v)] = mt
 	}
 	return nil
 }
@@ -1858,11 +1909,17 @@ func (l *State) prepareCall(ctx context.Context, functionIndex int, opts callOpt
 				numResults:     opts.numResults,
 				messageHandler: nextMessageHandler,
 			})
+			if !f.yieldable {
+				l.nny++
+			}
 			n, err := f.cb(ctx, l)
+			if !f.yieldable {
+				l.nny--
+			}
 			if err != nil {
 				// Go function raised an error.
 				// Before unwinding call stack, invoke the message handler.
-				if nextMessageHandler != nil && !nextMessageHandler.called {
+				if nextMessageHandler != nil && !nextMessageHandler.called && !l.isClosingFrame() {
 					var errValue value
 					errValue, err = l.call1(ctx, nextMessageHandler.function, l.errorToValue(err))
 					nextMessageHandler.called = true
@@ -1974,7 +2031,7 @@ func (l *State) Load(r io.ByteScanner, chunkName Source, mode string) (err error
 		id:    nextID(),
 		proto: p,
 		upvalues: []*upvalue{
-			closedUpvalue(l.registry.get(integerValue(RegistryIndexGlobals))),
+			closedUpvalue(l.globalState().registry.get(integerValue(RegistryIndexGlobals))),
 		},
 	})
 	return nil
@@ -2241,6 +2298,7 @@ func (l *State) len(ctx context.Context, v value) (value, error) {
 //     Freezing a userdata prevents its user values from being set.
 //   - Functions can be frozen if all their upvalues can be frozen.
 //     Go functions can only be frozen if they were created with [*State.PushPureFunction].
+//   - Threads cannot be frozen.
 func (l *State) Freeze(idx int) error {
 	type freezeFrame struct {
 		value  value
@@ -2285,6 +2343,8 @@ func (l *State) Freeze(idx int) error {
 		visited.Add(id)
 
 		switch v := curr.value.(type) {
+		case *thread:
+			return errors.New("cannot freeze thread")
 		case *userdata:
 			if f, ok := v.x.(Freezer); !ok {
 				return fmt.Errorf("cannot freeze %T", v.x)
@@ -1785,6 +1785,7 @@ func TestRotate(t *testing.T) {
 
 func TestSuite(t *testing.T) {
 	names := []string{
+		"coroutine",
 		"math",
 		"pm",
 		"strings",
@@ -3,7 +3,8 @@
 
 print "testing coroutines"
 
-local debug = require'debug'
+-- XXX: debug library not supported.
+-- local debug = require'debug'
 
 local f
 
@@ -227,7 +228,8 @@ do
   local Y = false
   local function foo ()
     local x <close> = func2close(function (self, err)
-      Y = debug.getinfo(2)
+      -- XXX: debug library not supported.
+      -- Y = debug.getinfo(2)
       X = err
     end)
     error(43)
@@ -235,7 +237,9 @@ do
   local co = coroutine.create(function () return pcall(foo) end)
   local st1, st2, err = coroutine.resume(co)
   assert(st1 and not st2 and err == 43)
-  assert(X == 43 and Y.what == "C")
+  -- XXX: debug library not supported.
+  -- assert(X == 43 and Y.what == "C")
+  assert(X == 43)
 
   -- recovering from errors in __close metamethods
   local track = {}
@@ -336,6 +340,8 @@ end
 
 
 
+-- XXX: debug library not supported.
+--[[
 do   -- testing single trace of coroutines
   local X
   local co = coroutine.create(function ()
@@ -354,11 +360,13 @@ do   -- testing single trace of coroutines
     assert(v == correcttrace[k])
   end
 end
+--]]
 
 -- errors in coroutines
 function foo ()
-  assert(debug.getinfo(1).currentline == debug.getinfo(foo).linedefined + 1)
-  assert(debug.getinfo(2).currentline == debug.getinfo(goo).linedefined)
+  -- XXX: debug library not supported.
+  -- assert(debug.getinfo(1).currentline == debug.getinfo(foo).linedefined + 1)
+  -- assert(debug.getinfo(2).currentline == debug.getinfo(goo).linedefined)
   coroutine.yield(3)
   error(foo)
 end
@@ -398,7 +406,8 @@ assert(a == 5^4)
 
 
 -- access to locals of collected corroutines
-local C = {}; setmetatable(C, {__mode = "kv"})
+-- XXX: Weak tables not supported.
+local C = {}; -- setmetatable(C, {__mode = "kv"})
 local x = coroutine.wrap (function ()
             local a = 10
             local function f () a = a+10; return a end
@@ -413,8 +422,9 @@ C[1] = x;
 local f = x()
 assert(f() == 21 and x()() == 32 and x() == f)
 x = nil
-collectgarbage()
-assert(C[1] == undef)
+-- XXX: collectgarbage and weak tables not supported.
+-- collectgarbage()
+-- assert(C[1] == undef)
 assert(f() == 43 and f() == 53)
 
 
@@ -946,10 +956,11 @@ assert(run(function ()
 do local _ENV = _ENV
   f = function () AAA = BBB + 1; return AAA end
 end
-local g = new(10); g.k.BBB = 10;
-debug.setupvalue(f, 1, g)
-assert(run(f, {"idx", "nidx", "idx"}) == 11)
-assert(g.k.AAA == 11)
+-- XXX: debug library not supported.
+-- local g = new(10); g.k.BBB = 10;
+-- debug.setupvalue(f, 1, g)
+-- assert(run(f, {"idx", "nidx", "idx"}) == 11)
+-- assert(g.k.AAA == 11)
 
 print"+"
 
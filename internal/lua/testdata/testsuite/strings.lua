@@ -167,8 +167,8 @@ do  -- tests for '%p' format
   assert(string.format("%p", nil) == null)
   assert(string.format("%p", {}) ~= null)
   assert(string.format("%p", print) ~= null)
-  -- XXX: Not supported.
-  -- assert(string.format("%p", coroutine.running()) ~= null)
+  assert(string.format("%p", coroutine.running()) ~= null)
+  -- XXX: io library not supported.
   -- assert(string.format("%p", io.stdin) ~= null)
   -- assert(string.format("%p", io.stdin) == string.format("%p", io.stdin))
   assert(string.format("%p", print) == string.format("%p", print))
@@ -463,9 +463,8 @@ end
 do
   local f = string.gmatch("1 2 3 4 5", "%d+")
   assert(f() == "1")
-  -- XXX: Coroutines not supported.
-  -- local co = coroutine.wrap(f)
-  -- assert(co() == "2")
+  local co = coroutine.wrap(f)
+  assert(co() == "2")
 end
 
 
@@ -0,0 +1,395 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package lua
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"runtime"
+	"slices"
+	"sync"
+	"time"
+	"weak"
+
+	"zb.256lights.llc/pkg/sets"
+)
+
+// maxCoroutineDepth is the maximum number of coroutines
+// that can be resuming each other at once.
+const maxCoroutineDepth = 200
+
+// threadStatus is the state of a thread
+// as reported by the “coroutine.status” function.
+type threadStatus int8
+
+const (
+	// threadRunning is the status of the thread that is currently executing.
+	// It is the zero value so that a main thread starts as running.
+	threadRunning threadStatus = iota
+	// threadSuspended is the status of a coroutine
+	// that has yielded or has not started yet.
+	threadSuspended
+	// threadNormal is the status of a thread
+	// that has resumed another coroutine.
+	threadNormal
+	// threadDead is the status of a coroutine
+	// that has finished its body or stopped with an error.
+	threadDead
+)
+
+func (status threadStatus) String() string {
+	switch status {
+	case threadRunning:
+		return "running"
+	case threadSuspended:
+		return "suspended"
+	case threadNormal:
+		return "normal"
+	case threadDead:
+		return "dead"
+	default:
+		return "unknown"
+	}
+}
+
+// errThreadClose is the error used to unwind a coroutine's stack
+// when it is closed while suspended.
+// [*State.errorToValue] converts it to nil,
+// so “__close” metamethods observe a normal exit.
+var errThreadClose = errors.New("coroutine closed")
+
+// A thread is the Lua value for a [State].
+// Each coroutine runs on its own goroutine,
+// but only one thread of a state executes at a time:
+// a thread that resumes a coroutine waits until the coroutine yields or finishes.
+type thread struct {
+	id    uint64
+	state *State
+}
+
+func (t *thread) valueType() Type { return TypeThread }
+func (t *thread) valueID() uint64 { return t.id }
+
+func (t *thread) references(l *State) iter.Seq[referenceValue] {
+	return func(yield func(referenceValue) bool) {
+		for _, v := range t.state.stack {
+			if rv, ok := v.(referenceValue); ok && !yield(rv) {
+				return
+			}
+		}
+	}
+}
+
+// coroutine is the bookkeeping for a [State] created by [*State.newThread].
+// A coroutine does not reference its [thread]
+// so that a suspended coroutine that is no longer reachable from Lua
+// can be garbage collected.
+type coroutine struct {
+	handle weak.Pointer[thread]
+	// depth is the number of threads that are (transitively) resuming the coroutine.
+	depth int
+
+	started bool
+	// closing is true while the coroutine is unwinding its stack
+	// because it was closed or abandoned while suspended.
+	closing bool
+	// closeDepth is the length of the call stack when the coroutine started closing.
+	// Protected calls in frames below closeDepth do not stop errors
+	// so that the whole stack is unwound.
+	closeDepth int
+	// abandoned is true if the coroutine's handle was garbage collected
+	// while the coroutine was suspended.
+	abandoned bool
+	// err is the error that stopped the coroutine
+	// that has not been reported by [*State.closeThread].
+	err error
+
+	resumes chan resumeMessage
+	yields  chan yieldMessage
+	// abandonOnce guards closing resumes.
+	abandonOnce sync.Once
+	// exited is closed once the coroutine's goroutine returns.
+	exited chan struct{}
+
+	mu  sync.Mutex
+	ctx context.Context
+}
+
+// resumeMessage is sent to a suspended coroutine to continue it.
+type resumeMessage struct {
+	values []value
+	close  bool
+}
+
+// yieldMessage is sent from a coroutine to the thread that resumed it.
+type yieldMessage struct {
+	values []value
+	err    error
+	done   bool
+}
+
+// threadHandle returns the [thread] for l.
+func (l *State) threadHandle() *thread {
+	if l.co != nil {
+		return l.co.handle.Value()
+	}
+	if l.thread == nil {
+		l.thread = &thread{
+			id:    nextID(),
+			state: l,
+		}
+	}
+	return l.thread
+}
+
+// isClosingFrame reports whether the top call frame
+// is being unwound because its coroutine was closed.
+// Such frames must not stop errors or call message handlers.
+func (l *State) isClosingFrame() bool {
+	return l.co != nil && l.co.closing && len(l.callStack) <= l.co.closeDepth
+}
+
+// coroutineDepth returns the number of coroutines
+// between l and its main thread.
+func (l *State) coroutineDepth() int {
+	if l.co == nil {
+		return 0
+	}
+	return l.co.depth
+}
+
+// newThread returns a new suspended coroutine that shares l's registry
+// and calls f when first resumed.
+func (l *State) newThread(f value) *thread {
+	l.init()
+	s := &State{
+		global: l.globalState(),
+		status: threadSuspended,
+		co: &coroutine{
+			resumes: make(chan resumeMessage),
+			yields:  make(chan yieldMessage),
+			exited:  make(chan struct{}),
+		},
+	}
+	s.init()
+	s.stack = append(s.stack, f)
+	t := &thread{
+		id:    nextID(),
+		state: s,
+	}
+	s.co.handle = weak.Make(t)
+	// Once the coroutine is no longer reachable,
+	// wake its goroutine (if any) so it can exit.
+	runtime.AddCleanup(t, (*coroutine).abandon, s.co)
+	return t
+}
+
+// abandon wakes the coroutine's goroutine if it is suspended
+// so that it unwinds its stack without running any more Lua code and exits.
+// abandon must not be called while the coroutine is running.
+func (co *coroutine) abandon() {
+	co.abandonOnce.Do(func() {
+		close(co.resumes)
+	})
+}
+
+// stopCoroutines abandons all of the started coroutines of the main thread l
+// and waits for their goroutines to exit.
+// It must only be called while no thread of l is running.
+func (l *State) stopCoroutines() {
+	l.coroutinesMu.Lock()
+	coroutines := l.coroutines
+	l.coroutines = nil
+	l.coroutinesMu.Unlock()
+
+	for s := range coroutines.All() {
+		s.co.abandon()
+		<-s.co.exited
+		s.status = threadDead
+	}
+}
+
+// resume starts or continues the coroutine t from l
+// with the given arguments.
+// resume returns the values the coroutine passed to [*State.yield]
+// or the values returned from its body.
+func (l *State) resume(ctx context.Context, t *thread, args []value) ([]value, error) {
+	defer runtime.KeepAlive(t)
+
+	s := t.state
+	switch s.status {
+	case threadSuspended:
+	case threadDead:
+		return nil, errors.New("cannot resume dead coroutine")
+	default:
+		return nil, errors.New("cannot resume non-suspended coroutine")
+	}
+	if l.coroutineDepth() >= maxCoroutineDepth {
+		return nil, errors.New("too many nested coroutines")
+	}
+	reply := l.transfer(ctx, s, resumeMessage{values: args})
+	if reply.done && reply.err != nil {
+		s.co.err = reply.err
+	}
+	return reply.values, reply.err
+}
+
+// closeThread closes the coroutine t from l,
+// unwinding its stack and closing any pending to-be-closed variables.
+// threadErr is the error that stopped the coroutine, if any.
+// err is non-nil if t cannot be closed.
+func (l *State) closeThread(ctx context.Context, t *thread) (threadErr, err error) {
+	defer runtime.KeepAlive(t)
+
+	s := t.state
+	switch s.status {
+	case threadRunning:
+		return nil, errors.New("cannot close a running coroutine")
+	case threadNormal:
+		return nil, errors.New("cannot close a normal coroutine")
+	case threadDead:
+		threadErr, s.co.err = s.co.err, nil
+		return threadErr, nil
+	}
+	if !s.co.started {
+		s.status = threadDead
+		return nil, nil
+	}
+	if l.coroutineDepth() >= maxCoroutineDepth {
+		return nil, errors.New("too many nested coroutines")
+	}
+	reply := l.transfer(ctx, s, resumeMessage{close: true})
+	if reply.err == errThreadClose {
+		return nil, nil
+	}
+	return reply.err, nil
+}
+
+// transfer passes control from l to the suspended coroutine s
+// and waits for s to yield or finish.
+func (l *State) transfer(ctx context.Context, s *State, msg resumeMessage) yieldMessage {
+	co := s.co
+	co.depth = l.coroutineDepth() + 1
+	co.mu.Lock()
+	co.ctx = ctx
+	co.mu.Unlock()
+	l.status = threadNormal
+	s.status = threadRunning
+
+	if !co.started {
+		co.started = true
+		g := l.globalState()
+		g.coroutinesMu.Lock()
+		if g.coroutines == nil {
+			g.coroutines = make(sets.Set[*State])
+		}
+		g.coroutines.Add(s)
+		g.coroutinesMu.Unlock()
+		go s.run(msg.values)
+	} else {
+		co.resumes <- msg
+	}
+	reply := <-co.yields
+
+	l.status = threadRunning
+	if reply.done {
+		s.status = threadDead
+	} else {
+		s.status = threadSuspended
+	}
+	return reply
+}
+
+// run calls the coroutine's body.
+// It is the entry point for a coroutine's goroutine.
+func (l *State) run(args []value) {
+	co := l.co
+	defer func() {
+		g := l.globalState()
+		g.coroutinesMu.Lock()
+		g.coroutines.Delete(l)
+		g.coroutinesMu.Unlock()
+		close(co.exited)
+	}()
+	const functionIndex = 1
+	l.stack = append(l.stack, args...)
+	err := l.Call(threadContext{co}, len(args), MultipleReturns)
+	if co.abandoned {
+		return
+	}
+	reply := yieldMessage{
+		err:  err,
+		done: true,
+	}
+	if err == nil {
+		reply.values = slices.Clone(l.stack[functionIndex:])
+	}
+	l.setTop(functionIndex)
+	co.yields <- reply
+}
+
+// yield suspends the coroutine running in l,
+// passing the given values to the thread that resumed it.
+// yield returns the values passed to the next resume.
+//
+// A coroutine can only yield if every Go function in its call stack
+// was created by [*State.pushYieldableFunction].
+// Unlike the C Lua implementation,
+// such functions do not need continuations:
+// the coroutine's goroutine blocks until the coroutine is resumed.
+func (l *State) yield(values []value) ([]value, error) {
+	co := l.co
+	if co == nil {
+		return nil, errors.New("attempt to yield from outside a coroutine")
+	}
+	if l.nny > 0 || co.closing {
+		return nil, errors.New("attempt to yield across a Go-call boundary")
+	}
+	co.yields <- yieldMessage{values: values}
+	msg, ok := <-co.resumes
+	if !ok {
+		// The coroutine is unreachable.
+		// Unwind the stack without running any more Lua code.
+		co.abandoned = true
+		co.closing = true
+		co.closeDepth = len(l.callStack)
+		l.tbc.Clear()
+		return nil, errThreadClose
+	}
+	if msg.close {
+		co.closing = true
+		co.closeDepth = len(l.callStack)
+		return nil, errThreadClose
+	}
+	return msg.values, nil
+}
+
+// threadContext is the [context.Context] passed to functions called by a coroutine.
+// It forwards to the context of the most recent resume.
+type threadContext struct {
+	co *coroutine
+}
+
+func (ctx threadContext) current() context.Context {
+	ctx.co.mu.Lock()
+	defer ctx.co.mu.Unlock()
+	return ctx.co.ctx
+}
+
+func (ctx threadContext) Deadline() (deadline time.Time, ok bool) {
+	return ctx.current().Deadline()
+}
+
+func (ctx threadContext) Done() <-chan struct{} {
+	return ctx.current().Done()
+}
+
+func (ctx threadContext) Err() error {
+	return ctx.current().Err()
+}
+
+func (ctx threadContext) Value(key any) any {
+	return ctx.current().Value(key)
+}
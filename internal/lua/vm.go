@@ -64,7 +64,7 @@ func (l *State) exec(ctx context.Context) (err error) {
 	defer func() {
 		// Call message handler, if present.
 		if err != nil {
-			if mhState := l.frame().messageHandler; mhState != nil && !mhState.called {
+			if mhState := l.frame().messageHandler; mhState != nil && !mhState.called && !l.isClosingFrame() {
 				var errValue value
 				errValue, err = l.call1(ctx, mhState.function, l.errorToValue(err))
 				mhState.called = true
@@ -692,12 +692,12 @@ func (l *State) exec(ctx context.Context) (err error) {
 			if err != nil {
 				return err
 			}
-			result, err := l.callArithmeticMetamethod(
-				ctx,
-				prevOperator.TagMethod(),
-				*ra,
-				integerValue(luacode.SignedArg(i.ArgB())),
-			)
+			arg1, arg2 := *ra, value(integerValue(luacode.SignedArg(i.ArgB())))
+			if i.K() {
+				// Immediate was the left operand.
+				arg1, arg2 = arg2, arg1
+			}
+			result, err := l.callArithmeticMetamethod(ctx, prevOperator.TagMethod(), arg1, arg2)
 			if err != nil {
 				return err
 			}
@@ -725,12 +725,12 @@ func (l *State) exec(ctx context.Context) (err error) {
 			if err != nil {
 				return err
 			}
-			result, err := l.callArithmeticMetamethod(
-				ctx,
-				prevOperator.TagMethod(),
-				*ra,
-				importConstant(kb),
-			)
+			arg1, arg2 := *ra, importConstant(kb)
+			if i.K() {
+				// Constant was the left operand.
+				arg1, arg2 = arg2, arg1
+			}
+			result, err := l.callArithmeticMetamethod(ctx, prevOperator.TagMethod(), arg1, arg2)
 			if err != nil {
 				return err
 			}
@@ -0,0 +1,59 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package narutil provides functions for working with Nix Archive (NAR) files.
+package narutil
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"zombiezen.com/go/nix/nar"
+)
+
+// Extract extracts a NAR file to the local filesystem at the given path.
+func Extract(dst string, r io.Reader) error {
+	nr := nar.NewReader(r)
+	for {
+		hdr, err := nr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := filepath.Join(dst, filepath.FromSlash(hdr.Path))
+		switch typ := hdr.Mode.Type(); typ {
+		case 0:
+			perm := os.FileMode(0o644)
+			if hdr.Mode&0o111 != 0 {
+				perm = 0o755
+			}
+			f, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, nr)
+			err2 := f.Close()
+			if err != nil {
+				return err
+			}
+			if err2 != nil {
+				return err2
+			}
+		case fs.ModeDir:
+			if err := os.Mkdir(p, 0o755); err != nil {
+				return err
+			}
+		case fs.ModeSymlink:
+			if err := os.Symlink(hdr.LinkTarget, p); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unhandled type %v", typ)
+		}
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package remotestore
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+// RealizationDirectory is the directory in a binary cache
+// that contains realization files.
+const RealizationDirectory = "realisations"
+
+// RealizationExtension is the file extension for a realization file.
+const RealizationExtension = ".doi"
+
+// Realization is a record in a binary cache
+// that maps a derivation output to the store object it was built as.
+type Realization struct {
+	// DerivationHash is the hash of the derivation
+	// (as used in its equivalence class).
+	DerivationHash nix.Hash
+	// OutputName is the name of the derivation's output.
+	OutputName string
+	// OutputPath is the store object that the output was realized as.
+	OutputPath zbstore.Path
+	// Sig is a set of signatures for this realization.
+	Sig []*nix.Signature
+}
+
+// RealizationPath returns the slash-separated path of the realization file
+// for the given derivation output, relative to the root of a binary cache.
+func RealizationPath(drvHash nix.Hash, outputName string) string {
+	return RealizationDirectory + "/" + realizationID(drvHash, outputName) + RealizationExtension
+}
+
+// ID returns the identifier of the realization,
+// which is the base16 derivation hash and output name separated by a "!"
+// (e.g. "sha256:1b2c...!out").
+func (r *Realization) ID() string {
+	return realizationID(r.DerivationHash, r.OutputName)
+}
+
+func realizationID(drvHash nix.Hash, outputName string) string {
+	return drvHash.Base16() + "!" + outputName
+}
+
+func (r *Realization) validate() error {
+	if r.DerivationHash.IsZero() {
+		return fmt.Errorf("derivation hash not set")
+	}
+	if r.OutputName == "" {
+		return fmt.Errorf("output name empty")
+	}
+	if strings.Contains(r.OutputName, "!") {
+		return fmt.Errorf("output name %q contains '!'", r.OutputName)
+	}
+	if r.OutputPath == "" {
+		return fmt.Errorf("output path empty")
+	}
+	if _, err := zbstore.ParsePath(string(r.OutputPath)); err != nil {
+		return fmt.Errorf("output path: %v", err)
+	}
+	return nil
+}
+
+// WriteFingerprint writes the realization's "fingerprint" to the given writer.
+// The fingerprint is the string used for signing.
+func (r *Realization) WriteFingerprint(w io.Writer) error {
+	if err := r.validate(); err != nil {
+		return fmt.Errorf("compute realization fingerprint: %v", err)
+	}
+	if _, err := fmt.Fprintf(w, "1;%s;%s", r.ID(), r.OutputPath); err != nil {
+		return fmt.Errorf("compute realization fingerprint for %s: %w", r.ID(), err)
+	}
+	return nil
+}
+
+type jsonRealization struct {
+	ID         string           `json:"id"`
+	OutPath    zbstore.Path     `json:"outPath"`
+	Signatures []*nix.Signature `json:"signatures"`
+}
+
+// MarshalJSON encodes the realization as a JSON object.
+func (r *Realization) MarshalJSON() ([]byte, error) {
+	if err := r.validate(); err != nil {
+		return nil, fmt.Errorf("marshal realization: %v", err)
+	}
+	sigs := r.Sig
+	if sigs == nil {
+		sigs = []*nix.Signature{}
+	}
+	return json.Marshal(&jsonRealization{
+		ID:         r.ID(),
+		OutPath:    r.OutputPath,
+		Signatures: sigs,
+	})
+}
+
+// UnmarshalJSON decodes a realization from a JSON object.
+func (r *Realization) UnmarshalJSON(data []byte) error {
+	var parsed jsonRealization
+	if err := json.Unmarshal(data, &parsed); err != nil {
+		return fmt.Errorf("unmarshal realization: %v", err)
+	}
+	hashString, outputName, ok := strings.Cut(parsed.ID, "!")
+	if !ok {
+		return fmt.Errorf("unmarshal realization: id %q missing '!'", parsed.ID)
+	}
+	drvHash, err := nix.ParseHash(hashString)
+	if err != nil {
+		return fmt.Errorf("unmarshal realization: id: %v", err)
+	}
+	*r = Realization{
+		DerivationHash: drvHash,
+		OutputName:     outputName,
+		OutputPath:     parsed.OutPath,
+		Sig:            parsed.Signatures,
+	}
+	if err := r.validate(); err != nil {
+		return fmt.Errorf("unmarshal realization: %v", err)
+	}
+	return nil
+}
+
+// SignRealization signs the given [Realization] with the private key.
+func SignRealization(pk *nix.PrivateKey, r *Realization) (*nix.Signature, error) {
+	buf := new(bytes.Buffer)
+	if err := r.WriteFingerprint(buf); err != nil {
+		return nil, fmt.Errorf("sign %s with %s: %v", r.ID(), pk.Name(), err)
+	}
+	sig, err := sign(pk, buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("sign %s with %s: %v", r.ID(), pk.Name(), err)
+	}
+	return sig, nil
+}
+
+// VerifyAnyRealization reports whether any of the signatures in r.Sig
+// can be verified by a key in the trusted list.
+// It returns an error describing why verification failed if none can.
+func VerifyAnyRealization(trusted []*nix.PublicKey, r *Realization) error {
+	if len(r.Sig) == 0 {
+		return fmt.Errorf("verify %s: no signatures", r.ID())
+	}
+	buf := new(bytes.Buffer)
+	if err := r.WriteFingerprint(buf); err != nil {
+		return fmt.Errorf("verify %s: %v", r.ID(), err)
+	}
+	var firstError error
+	for _, sig := range r.Sig {
+		err := verify(trusted, buf.Bytes(), sig)
+		if err == nil {
+			return nil
+		}
+		if firstError == nil {
+			firstError = fmt.Errorf("verify %s: %v", r.ID(), err)
+		}
+	}
+	return firstError
+}
@@ -0,0 +1,59 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package remotestore
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"zombiezen.com/go/nix"
+)
+
+func TestRealizationPath(t *testing.T) {
+	drvHash := mustParseHash(t, "sha256:0yzhigwjl6bws649vcs2asa4lbs8hg93hyix187gc7s7a74w5h80")
+	got := RealizationPath(drvHash, "out")
+	want := "realisations/" + drvHash.Base16() + "!out.doi"
+	if got != want {
+		t.Errorf("RealizationPath(...) = %q; want %q", got, want)
+	}
+}
+
+func TestRealizationJSON(t *testing.T) {
+	pub, pk, err := nix.GenerateKey("example.com-1", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := &Realization{
+		DerivationHash: mustParseHash(t, "sha256:0yzhigwjl6bws649vcs2asa4lbs8hg93hyix187gc7s7a74w5h80"),
+		OutputName:     "out",
+		OutputPath:     "/nix/store/s66mzxpvicwk07gjbjfw9izjfa797vsw-hello-2.12.1",
+	}
+	sig, err := SignRealization(pk, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.Sig = append(r.Sig, sig)
+
+	data, err := json.Marshal(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := new(Realization)
+	if err := json.Unmarshal(data, got); err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(r, got, cmp.Comparer(compareSignatures)); diff != "" {
+		t.Errorf("round trip (-want +got):\n%s", diff)
+	}
+	if err := VerifyAnyRealization([]*nix.PublicKey{pub}, got); err != nil {
+		t.Error(err)
+	}
+
+	got.OutputPath = "/nix/store/3n58xw4373jp0ljirf06d8077j15pc4j-glibc-2.37-8"
+	if err := VerifyAnyRealization([]*nix.PublicKey{pub}, got); err == nil {
+		t.Error("VerifyAnyRealization after modification did not return an error")
+	}
+}
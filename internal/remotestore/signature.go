@@ -0,0 +1,122 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package remotestore
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"zombiezen.com/go/nix"
+)
+
+// SignNARInfo signs the given [NARInfo] with the private key.
+func SignNARInfo(pk *nix.PrivateKey, info *NARInfo) (*nix.Signature, error) {
+	buf := new(bytes.Buffer)
+	if err := info.WriteFingerprint(buf); err != nil {
+		return nil, fmt.Errorf("sign %s with %s: %v", info.StorePath, pk.Name(), err)
+	}
+	sig, err := sign(pk, buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("sign %s with %s: %v", info.StorePath, pk.Name(), err)
+	}
+	return sig, nil
+}
+
+// VerifyNARInfo verifies that a signature for a [NARInfo]
+// matches the signature of the same name in a list of trusted keys.
+// The trusted key list should not contain more than one key with the same name.
+func VerifyNARInfo(trusted []*nix.PublicKey, info *NARInfo, sig *nix.Signature) error {
+	buf := new(bytes.Buffer)
+	if err := info.WriteFingerprint(buf); err != nil {
+		return fmt.Errorf("verify %s: %v", info.StorePath, err)
+	}
+	if err := verify(trusted, buf.Bytes(), sig); err != nil {
+		return fmt.Errorf("verify %s: %v", info.StorePath, err)
+	}
+	return nil
+}
+
+// VerifyAnyNARInfo reports whether any of the signatures in info.Sig
+// can be verified by a key in the trusted list.
+// It returns an error describing why verification failed if none can.
+func VerifyAnyNARInfo(trusted []*nix.PublicKey, info *NARInfo) error {
+	if len(info.Sig) == 0 {
+		return fmt.Errorf("verify %s: no signatures", info.StorePath)
+	}
+	var firstError error
+	for _, sig := range info.Sig {
+		err := VerifyNARInfo(trusted, info, sig)
+		if err == nil {
+			return nil
+		}
+		if firstError == nil {
+			firstError = err
+		}
+	}
+	return firstError
+}
+
+func sign(pk *nix.PrivateKey, msg []byte) (*nix.Signature, error) {
+	key, err := keyMaterial(pk, ed25519.PrivateKeySize)
+	if err != nil {
+		return nil, err
+	}
+	sigData := ed25519.Sign(ed25519.PrivateKey(key), msg)
+	return nix.ParseSignature(pk.Name() + ":" + base64.StdEncoding.EncodeToString(sigData))
+}
+
+func verify(trusted []*nix.PublicKey, msg []byte, sig *nix.Signature) error {
+	var foundPub *nix.PublicKey
+	for _, pub := range trusted {
+		if pub.Name() == sig.Name() {
+			foundPub = pub
+			break
+		}
+	}
+	if foundPub == nil {
+		return fmt.Errorf("key %s unknown", sig.Name())
+	}
+
+	pubData, err := keyMaterial(foundPub, ed25519.PublicKeySize)
+	if err != nil {
+		return err
+	}
+	sigData, err := keyMaterial(sig, ed25519.SignatureSize)
+	if err != nil {
+		return err
+	}
+	if !ed25519.Verify(ed25519.PublicKey(pubData), msg, sigData) {
+		return fmt.Errorf("signature for key %s is invalid", sig.Name())
+	}
+	return nil
+}
+
+// namedKey is the common interface of
+// [nix.PublicKey], [nix.PrivateKey], and [nix.Signature].
+type namedKey interface {
+	Name() string
+	String() string
+}
+
+// keyMaterial returns the raw bytes of a Nix key or signature.
+// The nix package does not provide accessors for the key material,
+// but it documents that String returns "<name>:<base64 data>"
+// and the name is already known, so only the data needs to be decoded.
+func keyMaterial(k namedKey, size int) ([]byte, error) {
+	base64Data, ok := strings.CutPrefix(k.String(), k.Name()+":")
+	if !ok {
+		return nil, fmt.Errorf("key %s: unexpected encoding", k.Name())
+	}
+	data, err := base64.StdEncoding.DecodeString(base64Data)
+	if err != nil {
+		return nil, fmt.Errorf("key %s: %v", k.Name(), err)
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("key %s: data is %d bytes (expected %d)", k.Name(), len(data), size)
+	}
+	return data, nil
+}
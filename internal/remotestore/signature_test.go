@@ -0,0 +1,74 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package remotestore
+
+import (
+	"crypto/rand"
+	"testing"
+
+	"zb.256lights.llc/pkg/sets"
+	"zb.256lights.llc/pkg/zbstore"
+	"zombiezen.com/go/nix"
+)
+
+func TestSignNARInfo(t *testing.T) {
+	pub, pk, err := nix.GenerateKey("example.com-1", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, err := nix.GenerateKey("example.com-1", rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	info := &NARInfo{
+		StorePath:   "/nix/store/s66mzxpvicwk07gjbjfw9izjfa797vsw-hello-2.12.1",
+		URL:         "nar/1nhgq6wcggx0plpy4991h3ginj6hipsdslv4fd4zml1n707j26yq.nar.xz",
+		Compression: XZ,
+		NARHash:     mustParseHash(t, "sha256:0yzhigwjl6bws649vcs2asa4lbs8hg93hyix187gc7s7a74w5h80"),
+		NARSize:     226488,
+		References: *sets.NewSorted[zbstore.Path](
+			"/nix/store/3n58xw4373jp0ljirf06d8077j15pc4j-glibc-2.37-8",
+		),
+	}
+	if err := VerifyAnyNARInfo([]*nix.PublicKey{pub}, info); err == nil {
+		t.Error("VerifyAnyNARInfo(unsigned) did not return an error")
+	}
+
+	sig, err := SignNARInfo(pk, info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := sig.Name(), "example.com-1"; got != want {
+		t.Errorf("sig.Name() = %q; want %q", got, want)
+	}
+	info.AddSignatures(sig)
+	if err := VerifyAnyNARInfo([]*nix.PublicKey{pub}, info); err != nil {
+		t.Error(err)
+	}
+	if err := VerifyAnyNARInfo([]*nix.PublicKey{otherPub}, info); err == nil {
+		t.Error("VerifyAnyNARInfo with wrong key did not return an error")
+	}
+	if err := VerifyAnyNARInfo(nil, info); err == nil {
+		t.Error("VerifyAnyNARInfo with no trusted keys did not return an error")
+	}
+
+	// Nix's signing function should agree with ours.
+	nixInfo := &nix.NARInfo{
+		StorePath:   nix.StorePath(info.StorePath),
+		URL:         info.URL,
+		Compression: info.Compression,
+		NARHash:     info.NARHash,
+		NARSize:     info.NARSize,
+		References:  []nix.StorePath{"/nix/store/3n58xw4373jp0ljirf06d8077j15pc4j-glibc-2.37-8"},
+	}
+	if err := nix.VerifyNARInfo([]*nix.PublicKey{pub}, nixInfo, sig); err != nil {
+		t.Error(err)
+	}
+
+	info.NARSize++
+	if err := VerifyAnyNARInfo([]*nix.PublicKey{pub}, info); err == nil {
+		t.Error("VerifyAnyNARInfo after modification did not return an error")
+	}
+}
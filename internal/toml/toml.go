@@ -0,0 +1,929 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+// Package toml provides a parser for [TOML] v1.0.0 documents.
+//
+// [TOML]: https://toml.io/en/v1.0.0
+package toml
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// A Datetime is a TOML offset date-time, local date-time, local date, or local time
+// in its [RFC 3339] textual form.
+// The date and time are always separated by an uppercase “T”
+// and a UTC offset is always written as an uppercase “Z”.
+//
+// [RFC 3339]: https://datatracker.ietf.org/doc/html/rfc3339
+type Datetime string
+
+// maxDepth is the maximum nesting depth of arrays and inline tables.
+const maxDepth = 1000
+
+// Parse parses a TOML document.
+// Tables are returned as map[string]any,
+// arrays as []any,
+// strings as string,
+// integers as int64,
+// floats as float64,
+// booleans as bool,
+// and date-time values as [Datetime].
+func Parse(data []byte) (map[string]any, error) {
+	if !utf8.Valid(data) {
+		return nil, errors.New("toml: invalid UTF-8")
+	}
+	p := &parser{data: data}
+	if bytes.HasPrefix(p.data, []byte("\ufeff")) {
+		p.pos = len("\ufeff")
+	}
+	root := newTable(headerTable)
+	if err := p.document(root); err != nil {
+		return nil, err
+	}
+	return root.export(), nil
+}
+
+// tableKind describes how a [table] was created,
+// which determines how the table may be extended later in a document.
+type tableKind int8
+
+const (
+	// implicitTable is a table created as a parent of a table header.
+	// It may be defined later with its own header.
+	implicitTable tableKind = iota
+	// headerTable is a table defined by a table header.
+	headerTable
+	// dottedTable is a table created by a dotted key.
+	// Sub-tables may be defined with headers,
+	// but the table itself may not.
+	dottedTable
+	// inlineTable is a table defined by an inline table.
+	// It may not be extended.
+	inlineTable
+)
+
+type table struct {
+	kind tableKind
+	// entries maps keys to *table, *tableArray, []any, or a scalar value.
+	entries map[string]any
+}
+
+func newTable(kind tableKind) *table {
+	return &table{
+		kind:    kind,
+		entries: make(map[string]any),
+	}
+}
+
+// freeze marks t and all the tables it contains as inline tables.
+func (t *table) freeze() {
+	t.kind = inlineTable
+	for _, v := range t.entries {
+		freezeValue(v)
+	}
+}
+
+func freezeValue(v any) {
+	switch v := v.(type) {
+	case *table:
+		v.freeze()
+	case []any:
+		for _, elem := range v {
+			freezeValue(elem)
+		}
+	}
+}
+
+func (t *table) export() map[string]any {
+	m := make(map[string]any, len(t.entries))
+	for k, v := range t.entries {
+		m[k] = exportValue(v)
+	}
+	return m
+}
+
+func exportValue(v any) any {
+	switch v := v.(type) {
+	case *table:
+		return v.export()
+	case *tableArray:
+		arr := make([]any, 0, len(v.tables))
+		for _, t := range v.tables {
+			arr = append(arr, t.export())
+		}
+		return arr
+	case []any:
+		arr := make([]any, 0, len(v))
+		for _, elem := range v {
+			arr = append(arr, exportValue(elem))
+		}
+		return arr
+	default:
+		return v
+	}
+}
+
+// tableArray is an array of tables defined by “[[key]]” headers.
+type tableArray struct {
+	tables []*table
+}
+
+type parser struct {
+	data  []byte
+	pos   int
+	depth int
+}
+
+func (p *parser) document(root *table) error {
+	current := root
+	for {
+		p.skipWhitespace()
+		if p.eof() {
+			return nil
+		}
+		switch p.data[p.pos] {
+		case '#', '\n', '\r':
+			// Blank line or comment.
+		case '[':
+			var err error
+			if p.hasPrefix("[[") {
+				current, err = p.tableArrayHeader(root)
+			} else {
+				current, err = p.tableHeader(root)
+			}
+			if err != nil {
+				return err
+			}
+		default:
+			if err := p.keyValue(current); err != nil {
+				return err
+			}
+		}
+		if err := p.endOfLine(); err != nil {
+			return err
+		}
+	}
+}
+
+// tableHeader parses a “[key]” header and returns the table it defines.
+func (p *parser) tableHeader(root *table) (*table, error) {
+	p.pos++
+	p.skipWhitespace()
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skipWhitespace()
+	if !p.consume("]") {
+		return nil, p.errorf("expected ']' after table name")
+	}
+
+	parent, err := p.headerParent(root, keys)
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	switch v := parent.entries[last].(type) {
+	case nil:
+		t := newTable(headerTable)
+		parent.entries[last] = t
+		return t, nil
+	case *table:
+		if v.kind != implicitTable {
+			return nil, p.errorf("table %s already defined", formatKey(keys))
+		}
+		v.kind = headerTable
+		return v, nil
+	default:
+		return nil, p.errorf("key %s already defined", formatKey(keys))
+	}
+}
+
+// tableArrayHeader parses a “[[key]]” header
+// and returns the new table it appends to the array.
+func (p *parser) tableArrayHeader(root *table) (*table, error) {
+	p.pos += len("[[")
+	p.skipWhitespace()
+	keys, err := p.key()
+	if err != nil {
+		return nil, err
+	}
+	p.skipWhitespace()
+	if !p.consume("]]") {
+		return nil, p.errorf("expected ']]' after array of tables name")
+	}
+
+	parent, err := p.headerParent(root, keys)
+	if err != nil {
+		return nil, err
+	}
+	last := keys[len(keys)-1]
+	t := newTable(headerTable)
+	switch v := parent.entries[last].(type) {
+	case nil:
+		parent.entries[last] = &tableArray{tables: []*table{t}}
+	case *tableArray:
+		v.tables = append(v.tables, t)
+	default:
+		return nil, p.errorf("key %s already defined", formatKey(keys))
+	}
+	return t, nil
+}
+
+// headerParent returns the table that contains the table named by a header,
+// creating implicit tables as needed.
+func (p *parser) headerParent(root *table, keys []string) (*table, error) {
+	t := root
+	for i, k := range keys[:len(keys)-1] {
+		switch v := t.entries[k].(type) {
+		case nil:
+			sub := newTable(implicitTable)
+			t.entries[k] = sub
+			t = sub
+		case *table:
+			if v.kind == inlineTable {
+				return nil, p.errorf("cannot extend inline table %s", formatKey(keys[:i+1]))
+			}
+			t = v
+		case *tableArray:
+			t = v.tables[len(v.tables)-1]
+		default:
+			return nil, p.errorf("key %s already defined", formatKey(keys[:i+1]))
+		}
+	}
+	return t, nil
+}
+
+// keyValue parses a key/value pair and stores it in t.
+func (p *parser) keyValue(t *table) error {
+	start := p.pos
+	keys, err := p.key()
+	if err != nil {
+		return err
+	}
+	p.skipWhitespace()
+	if !p.consume("=") {
+		return p.errorf("expected '=' after key")
+	}
+	p.skipWhitespace()
+	v, err := p.value()
+	if err != nil {
+		return err
+	}
+
+	for i, k := range keys[:len(keys)-1] {
+		switch sub := t.entries[k].(type) {
+		case nil:
+			newSub := newTable(dottedTable)
+			t.entries[k] = newSub
+			t = newSub
+		case *table:
+			if sub.kind == headerTable || sub.kind == inlineTable {
+				return p.errorfAt(start, "cannot add keys to table %s with dotted keys", formatKey(keys[:i+1]))
+			}
+			sub.kind = dottedTable
+			t = sub
+		default:
+			return p.errorfAt(start, "key %s already defined", formatKey(keys[:i+1]))
+		}
+	}
+	last := keys[len(keys)-1]
+	if _, exists := t.entries[last]; exists {
+		return p.errorfAt(start, "key %s already defined", formatKey(keys))
+	}
+	t.entries[last] = v
+	return nil
+}
+
+// key parses a possibly dotted key.
+func (p *parser) key() ([]string, error) {
+	var keys []string
+	for {
+		k, err := p.simpleKey()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+		p.skipWhitespace()
+		if !p.consume(".") {
+			return keys, nil
+		}
+		p.skipWhitespace()
+	}
+}
+
+func (p *parser) simpleKey() (string, error) {
+	switch {
+	case p.hasPrefix(`"""`) || p.hasPrefix("'''"):
+		return "", p.errorf("multi-line strings cannot be used as keys")
+	case p.hasPrefix(`"`):
+		return p.basicString()
+	case p.hasPrefix("'"):
+		return p.literalString()
+	}
+	start := p.pos
+	for !p.eof() && isBareKeyChar(p.data[p.pos]) {
+		p.pos++
+	}
+	if p.pos == start {
+		return "", p.errorf("expected key")
+	}
+	return string(p.data[start:p.pos]), nil
+}
+
+func (p *parser) value() (any, error) {
+	if p.eof() {
+		return nil, p.errorf("expected value")
+	}
+	switch {
+	case p.hasPrefix(`"""`):
+		return p.multilineBasicString()
+	case p.hasPrefix(`"`):
+		return p.basicString()
+	case p.hasPrefix("'''"):
+		return p.multilineLiteralString()
+	case p.hasPrefix("'"):
+		return p.literalString()
+	case p.hasPrefix("["):
+		return p.array()
+	case p.hasPrefix("{"):
+		return p.inlineTable()
+	}
+
+	start := p.pos
+	p.skipToken()
+	if p.pos-start == len("0000-00-00") && p.hasPrefix(" ") &&
+		p.pos+1 < len(p.data) && isDigit(p.data[p.pos+1]) {
+		// Date and time separated by a space.
+		p.pos++
+		p.skipToken()
+	}
+	tok := string(p.data[start:p.pos])
+	switch tok {
+	case "":
+		return nil, p.errorf("expected value")
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	}
+	if isDatetimeToken(tok) {
+		dt, err := parseDatetime(tok)
+		if err != nil {
+			return nil, p.errorfAt(start, "%v", err)
+		}
+		return dt, nil
+	}
+	n, err := parseNumber(tok)
+	if err != nil {
+		return nil, p.errorfAt(start, "%v", err)
+	}
+	return n, nil
+}
+
+func (p *parser) skipToken() {
+	for !p.eof() && isTokenChar(p.data[p.pos]) {
+		p.pos++
+	}
+}
+
+func (p *parser) array() ([]any, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	p.pos++
+	arr := []any{}
+	for {
+		if err := p.skipArrayWhitespace(); err != nil {
+			return nil, err
+		}
+		if p.consume("]") {
+			return arr, nil
+		}
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		arr = append(arr, v)
+		if err := p.skipArrayWhitespace(); err != nil {
+			return nil, err
+		}
+		switch {
+		case p.consume(","):
+		case p.consume("]"):
+			return arr, nil
+		default:
+			return nil, p.errorf("expected ',' or ']' in array")
+		}
+	}
+}
+
+// skipArrayWhitespace skips whitespace, newlines, and comments.
+func (p *parser) skipArrayWhitespace() error {
+	for {
+		p.skipWhitespace()
+		switch {
+		case p.hasPrefix("#"):
+			if err := p.comment(); err != nil {
+				return err
+			}
+		case p.consumeNewline():
+		default:
+			return nil
+		}
+	}
+}
+
+func (p *parser) inlineTable() (*table, error) {
+	if err := p.enter(); err != nil {
+		return nil, err
+	}
+	defer p.leave()
+
+	p.pos++
+	t := newTable(dottedTable)
+	p.skipWhitespace()
+	if p.consume("}") {
+		t.freeze()
+		return t, nil
+	}
+	for {
+		p.skipWhitespace()
+		if err := p.keyValue(t); err != nil {
+			return nil, err
+		}
+		p.skipWhitespace()
+		switch {
+		case p.consume(","):
+		case p.consume("}"):
+			t.freeze()
+			return t, nil
+		default:
+			return nil, p.errorf("expected ',' or '}' in inline table")
+		}
+	}
+}
+
+func (p *parser) enter() error {
+	if p.depth >= maxDepth {
+		return p.errorf("nesting too deep")
+	}
+	p.depth++
+	return nil
+}
+
+func (p *parser) leave() {
+	p.depth--
+}
+
+func (p *parser) basicString() (string, error) {
+	start := p.pos
+	p.pos++
+	sb := new(strings.Builder)
+	for {
+		if p.eof() || p.data[p.pos] == '\n' || p.data[p.pos] == '\r' {
+			return "", p.errorfAt(start, "unterminated string")
+		}
+		switch c := p.data[p.pos]; {
+		case c == '"':
+			p.pos++
+			return sb.String(), nil
+		case c == '\\':
+			if err := p.escape(sb); err != nil {
+				return "", err
+			}
+		case isControl(c):
+			return "", p.errorf("control character %q in string", c)
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) multilineBasicString() (string, error) {
+	start := p.pos
+	p.pos += len(`"""`)
+	p.consumeNewline()
+	sb := new(strings.Builder)
+	for {
+		if p.eof() {
+			return "", p.errorfAt(start, "unterminated string")
+		}
+		switch c := p.data[p.pos]; {
+		case c == '"':
+			if done, err := p.closeMultiline(sb, '"'); err != nil {
+				return "", err
+			} else if done {
+				return sb.String(), nil
+			}
+		case c == '\\':
+			lineEnd := p.pos + 1
+			for lineEnd < len(p.data) && (p.data[lineEnd] == ' ' || p.data[lineEnd] == '\t') {
+				lineEnd++
+			}
+			if !bytes.HasPrefix(p.data[lineEnd:], []byte("\n")) && !bytes.HasPrefix(p.data[lineEnd:], []byte("\r\n")) {
+				if err := p.escape(sb); err != nil {
+					return "", err
+				}
+				continue
+			}
+			// Line ending backslash: trim all whitespace up to the next non-whitespace character.
+			p.pos = lineEnd
+			for {
+				p.skipWhitespace()
+				if !p.consumeNewline() {
+					break
+				}
+			}
+		case p.consumeNewline():
+			sb.WriteByte('\n')
+		case isControl(c):
+			return "", p.errorf("control character %q in string", c)
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) literalString() (string, error) {
+	start := p.pos
+	p.pos++
+	for {
+		if p.eof() || p.data[p.pos] == '\n' || p.data[p.pos] == '\r' {
+			return "", p.errorfAt(start, "unterminated string")
+		}
+		switch c := p.data[p.pos]; {
+		case c == '\'':
+			s := string(p.data[start+1 : p.pos])
+			p.pos++
+			return s, nil
+		case isControl(c):
+			return "", p.errorf("control character %q in string", c)
+		default:
+			p.pos++
+		}
+	}
+}
+
+func (p *parser) multilineLiteralString() (string, error) {
+	start := p.pos
+	p.pos += len("'''")
+	p.consumeNewline()
+	sb := new(strings.Builder)
+	for {
+		if p.eof() {
+			return "", p.errorfAt(start, "unterminated string")
+		}
+		switch c := p.data[p.pos]; {
+		case c == '\'':
+			if done, err := p.closeMultiline(sb, '\''); err != nil {
+				return "", err
+			} else if done {
+				return sb.String(), nil
+			}
+		case p.consumeNewline():
+			sb.WriteByte('\n')
+		case isControl(c):
+			return "", p.errorf("control character %q in string", c)
+		default:
+			sb.WriteByte(c)
+			p.pos++
+		}
+	}
+}
+
+// closeMultiline consumes a run of quote characters inside a multi-line string.
+// A run of three to five quotes ends the string,
+// with any quotes beyond the first three being part of the content.
+func (p *parser) closeMultiline(sb *strings.Builder, quote byte) (done bool, err error) {
+	n := 0
+	for p.pos+n < len(p.data) && p.data[p.pos+n] == quote {
+		n++
+	}
+	switch {
+	case n < 3:
+		for range n {
+			sb.WriteByte(quote)
+		}
+		p.pos += n
+		return false, nil
+	case n <= 5:
+		for range n - 3 {
+			sb.WriteByte(quote)
+		}
+		p.pos += n
+		return true, nil
+	default:
+		return false, p.errorfAt(p.pos+5, "too many quotes at end of string")
+	}
+}
+
+// escape parses an escape sequence in a basic string
+// and writes the character it represents to sb.
+func (p *parser) escape(sb *strings.Builder) error {
+	start := p.pos
+	p.pos++
+	if p.eof() {
+		return p.errorfAt(start, "invalid escape sequence")
+	}
+	c := p.data[p.pos]
+	p.pos++
+	switch c {
+	case 'b':
+		sb.WriteByte('\b')
+	case 't':
+		sb.WriteByte('\t')
+	case 'n':
+		sb.WriteByte('\n')
+	case 'f':
+		sb.WriteByte('\f')
+	case 'r':
+		sb.WriteByte('\r')
+	case '"':
+		sb.WriteByte('"')
+	case '\\':
+		sb.WriteByte('\\')
+	case 'u', 'U':
+		n := 4
+		if c == 'U' {
+			n = 8
+		}
+		if len(p.data)-p.pos < n {
+			return p.errorfAt(start, "invalid escape sequence")
+		}
+		hex := string(p.data[p.pos : p.pos+n])
+		x, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil || strings.ContainsAny(hex, "+-_") || !utf8.ValidRune(rune(x)) {
+			return p.errorfAt(start, "invalid Unicode escape \\%c%s", c, hex)
+		}
+		p.pos += n
+		sb.WriteRune(rune(x))
+	default:
+		return p.errorfAt(start, "invalid escape sequence \\%c", c)
+	}
+	return nil
+}
+
+// endOfLine consumes optional whitespace, an optional comment,
+// and a newline or the end of the document.
+func (p *parser) endOfLine() error {
+	p.skipWhitespace()
+	if p.hasPrefix("#") {
+		if err := p.comment(); err != nil {
+			return err
+		}
+	}
+	if p.eof() || p.consumeNewline() {
+		return nil
+	}
+	return p.errorf("expected newline")
+}
+
+// comment consumes a comment up to (but not including) the end of the line.
+func (p *parser) comment() error {
+	for !p.eof() && p.data[p.pos] != '\n' {
+		if p.hasPrefix("\r\n") {
+			return nil
+		}
+		if isControl(p.data[p.pos]) {
+			return p.errorf("control character %q in comment", p.data[p.pos])
+		}
+		p.pos++
+	}
+	return nil
+}
+
+func (p *parser) skipWhitespace() {
+	for !p.eof() && (p.data[p.pos] == ' ' || p.data[p.pos] == '\t') {
+		p.pos++
+	}
+}
+
+func (p *parser) consumeNewline() bool {
+	return p.consume("\n") || p.consume("\r\n")
+}
+
+func (p *parser) consume(s string) bool {
+	if !p.hasPrefix(s) {
+		return false
+	}
+	p.pos += len(s)
+	return true
+}
+
+func (p *parser) hasPrefix(s string) bool {
+	return bytes.HasPrefix(p.data[p.pos:], []byte(s))
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.data)
+}
+
+func (p *parser) errorf(format string, args ...any) error {
+	return p.errorfAt(p.pos, format, args...)
+}
+
+func (p *parser) errorfAt(pos int, format string, args ...any) error {
+	line := 1 + bytes.Count(p.data[:pos], []byte("\n"))
+	return fmt.Errorf("toml: line %d: %s", line, fmt.Sprintf(format, args...))
+}
+
+func parseNumber(tok string) (any, error) {
+	switch tok {
+	case "inf", "+inf":
+		return math.Inf(1), nil
+	case "-inf":
+		return math.Inf(-1), nil
+	case "nan", "+nan", "-nan":
+		return math.NaN(), nil
+	}
+
+	if len(tok) > 2 && tok[0] == '0' {
+		var base int
+		var isBaseDigit func(byte) bool
+		switch tok[1] {
+		case 'x':
+			base, isBaseDigit = 16, isHexDigit
+		case 'o':
+			base, isBaseDigit = 8, isOctalDigit
+		case 'b':
+			base, isBaseDigit = 2, isBinaryDigit
+		}
+		if base != 0 {
+			digits := tok[2:]
+			if !validDigits(digits, isBaseDigit) {
+				return nil, fmt.Errorf("invalid integer %s", tok)
+			}
+			i, err := strconv.ParseInt(strings.ReplaceAll(digits, "_", ""), base, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid integer %s: out of range", tok)
+			}
+			return i, nil
+		}
+	}
+
+	unsigned := strings.TrimLeft(tok, "+-")
+	if len(tok)-len(unsigned) > 1 {
+		return nil, fmt.Errorf("invalid number %s", tok)
+	}
+	mantissa, exponent, hasExponent := strings.Cut(strings.ToLower(unsigned), "e")
+	intPart, fracPart, hasFrac := strings.Cut(mantissa, ".")
+	if !validDecimalInteger(intPart) ||
+		hasFrac && !validDigits(fracPart, isDigit) ||
+		hasExponent && !validDigits(strings.TrimLeft(exponent, "+-"), isDigit) ||
+		hasExponent && len(exponent)-len(strings.TrimLeft(exponent, "+-")) > 1 {
+		return nil, fmt.Errorf("invalid number %s", tok)
+	}
+	clean := strings.ReplaceAll(tok, "_", "")
+	if !hasFrac && !hasExponent {
+		i, err := strconv.ParseInt(clean, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid integer %s: out of range", tok)
+		}
+		return i, nil
+	}
+	f, err := strconv.ParseFloat(clean, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid float %s: out of range", tok)
+	}
+	return f, nil
+}
+
+// validDecimalInteger reports whether s is a sequence of decimal digits
+// (possibly separated by single underscores)
+// without leading zeroes.
+func validDecimalInteger(s string) bool {
+	return validDigits(s, isDigit) && (s == "0" || s[0] != '0')
+}
+
+// validDigits reports whether s is a non-empty sequence of digits
+// where each underscore is surrounded by digits.
+func validDigits(s string, isBaseDigit func(byte) bool) bool {
+	if s == "" {
+		return false
+	}
+	for i := range len(s) {
+		if s[i] == '_' {
+			if i == 0 || i == len(s)-1 || s[i-1] == '_' {
+				return false
+			}
+		} else if !isBaseDigit(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	offsetDatetimePattern = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?(Z|[+-][0-9]{2}:[0-9]{2})$`)
+	localDatetimePattern  = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}T[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?$`)
+	localDatePattern      = regexp.MustCompile(`^[0-9]{4}-[0-9]{2}-[0-9]{2}$`)
+	localTimePattern      = regexp.MustCompile(`^[0-9]{2}:[0-9]{2}:[0-9]{2}(\.[0-9]+)?$`)
+)
+
+// isDatetimeToken reports whether tok starts like a date or a time
+// (as opposed to a number).
+func isDatetimeToken(tok string) bool {
+	return len(tok) >= 5 && isDigit(tok[0]) && isDigit(tok[1]) &&
+		(tok[2] == ':' || isDigit(tok[2]) && isDigit(tok[3]) && tok[4] == '-')
+}
+
+func parseDatetime(tok string) (Datetime, error) {
+	b := []byte(tok)
+	if len(b) > len("0000-00-00") && (b[10] == ' ' || b[10] == 't') {
+		b[10] = 'T'
+	}
+	if n := len(b); b[n-1] == 'z' {
+		b[n-1] = 'Z'
+	}
+	s := string(b)
+
+	var layout string
+	switch {
+	case offsetDatetimePattern.MatchString(s):
+		layout = time.RFC3339
+	case localDatetimePattern.MatchString(s):
+		layout = "2006-01-02T15:04:05"
+	case localDatePattern.MatchString(s):
+		layout = time.DateOnly
+	case localTimePattern.MatchString(s):
+		layout = time.TimeOnly
+	default:
+		return "", fmt.Errorf("invalid date-time %s", tok)
+	}
+	if _, err := time.Parse(layout, s); err != nil {
+		return "", fmt.Errorf("invalid date-time %s", tok)
+	}
+	return Datetime(s), nil
+}
+
+// formatKey returns the TOML representation of a dotted key.
+func formatKey(keys []string) string {
+	sb := new(strings.Builder)
+	for i, k := range keys {
+		if i > 0 {
+			sb.WriteByte('.')
+		}
+		if isBareKey(k) {
+			sb.WriteString(k)
+		} else {
+			sb.WriteString(strconv.Quote(k))
+		}
+	}
+	return sb.String()
+}
+
+func isBareKey(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := range len(s) {
+		if !isBareKeyChar(s[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+func isBareKeyChar(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || isDigit(c) || c == '_' || c == '-'
+}
+
+func isTokenChar(c byte) bool {
+	return isBareKeyChar(c) || c == '+' || c == '.' || c == ':'
+}
+
+// isControl reports whether c is a control character
+// that is not permitted to appear literally in strings or comments.
+func isControl(c byte) bool {
+	return c < 0x20 && c != '\t' || c == 0x7f
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isHexDigit(c byte) bool {
+	return isDigit(c) || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F'
+}
+
+func isOctalDigit(c byte) bool {
+	return '0' <= c && c <= '7'
+}
+
+func isBinaryDigit(c byte) bool {
+	return c == '0' || c == '1'
+}
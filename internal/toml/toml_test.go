@@ -0,0 +1,300 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package toml
+
+import (
+	"math"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]any
+	}{
+		{
+			name:  "Empty",
+			input: "",
+			want:  map[string]any{},
+		},
+		{
+			name:  "Comments",
+			input: "# This is a comment\r\n\n  # indented\nkey = \"value\" # trailing\n",
+			want:  map[string]any{"key": "value"},
+		},
+		{
+			name: "Keys",
+			input: "bare_key-1 = 1\n" +
+				"\"quoted key\" = 2\n" +
+				"'literal key' = 3\n" +
+				"1234 = 4\n" +
+				"a . b.\"c\" = 5\n",
+			want: map[string]any{
+				"bare_key-1":  int64(1),
+				"quoted key":  int64(2),
+				"literal key": int64(3),
+				"1234":        int64(4),
+				"a": map[string]any{
+					"b": map[string]any{"c": int64(5)},
+				},
+			},
+		},
+		{
+			name: "BasicStrings",
+			input: `a = "tab\there \"quoted\" \\ \u00E9 \U0001F600"` + "\n" +
+				`b = ""` + "\n",
+			want: map[string]any{
+				"a": "tab\there \"quoted\" \\ é 😀",
+				"b": "",
+			},
+		},
+		{
+			name: "MultilineBasicStrings",
+			input: "a = \"\"\"\nRoses are red\r\nViolets are blue\"\"\"\n" +
+				"b = \"\"\"\\\n    The quick \\\n\n    fox.\\\n    \"\"\"\n" +
+				"c = \"\"\"Here are two quotes: \"\". Three: \"\"\\\".\"\"\"\n" +
+				"d = \"\"\"\"quoted\"\"\"\"\n",
+			want: map[string]any{
+				"a": "Roses are red\nViolets are blue",
+				"b": "The quick fox.",
+				"c": `Here are two quotes: "". Three: """.`,
+				"d": `"quoted"`,
+			},
+		},
+		{
+			name: "LiteralStrings",
+			input: `a = 'C:\Users\nodejs'` + "\n" +
+				"b = '''\nfirst line\n  raw \\n text'''\n" +
+				"c = ''''That,' she said'''\n",
+			want: map[string]any{
+				"a": `C:\Users\nodejs`,
+				"b": "first line\n  raw \\n text",
+				"c": "'That,' she said",
+			},
+		},
+		{
+			name: "Integers",
+			input: "a = +99\nb = -17\nc = 0\nd = 1_000\n" +
+				"e = 0xDEAD_beef\nf = 0o755\ng = 0b1101\n" +
+				"h = 9223372036854775807\n",
+			want: map[string]any{
+				"a": int64(99),
+				"b": int64(-17),
+				"c": int64(0),
+				"d": int64(1000),
+				"e": int64(0xdeadbeef),
+				"f": int64(0o755),
+				"g": int64(0b1101),
+				"h": int64(math.MaxInt64),
+			},
+		},
+		{
+			name:  "Floats",
+			input: "a = 3.14\nb = -0.01\nc = 5e+22\nd = 1E06\ne = 6.626e-34\nf = 224_617.445_991\ng = inf\nh = -inf\n",
+			want: map[string]any{
+				"a": 3.14,
+				"b": -0.01,
+				"c": 5e22,
+				"d": 1e6,
+				"e": 6.626e-34,
+				"f": 224617.445991,
+				"g": math.Inf(1),
+				"h": math.Inf(-1),
+			},
+		},
+		{
+			name:  "Booleans",
+			input: "a = true\nb = false\n",
+			want:  map[string]any{"a": true, "b": false},
+		},
+		{
+			name: "Datetimes",
+			input: "a = 1979-05-27T07:32:00Z\n" +
+				"b = 1979-05-27T00:32:00.999999-07:00\n" +
+				"c = 1979-05-27 07:32:00z\n" +
+				"d = 1979-05-27T07:32:00\n" +
+				"e = 1979-05-27\n" +
+				"f = 00:32:00.999999\n",
+			want: map[string]any{
+				"a": Datetime("1979-05-27T07:32:00Z"),
+				"b": Datetime("1979-05-27T00:32:00.999999-07:00"),
+				"c": Datetime("1979-05-27T07:32:00Z"),
+				"d": Datetime("1979-05-27T07:32:00"),
+				"e": Datetime("1979-05-27"),
+				"f": Datetime("00:32:00.999999"),
+			},
+		},
+		{
+			name: "Arrays",
+			input: "a = [ 1, 2, 3 ]\n" +
+				"b = [ \"red\", 'yellow', ]\n" +
+				"c = [ [ 1, 2 ], [\"a\", 0.5] ]\n" +
+				"d = [\n  1, # comment\n  2\n]\n" +
+				"e = []\n",
+			want: map[string]any{
+				"a": []any{int64(1), int64(2), int64(3)},
+				"b": []any{"red", "yellow"},
+				"c": []any{[]any{int64(1), int64(2)}, []any{"a", 0.5}},
+				"d": []any{int64(1), int64(2)},
+				"e": []any{},
+			},
+		},
+		{
+			name: "InlineTables",
+			input: "name = { first = \"Tom\", last = \"Preston-Werner\" }\n" +
+				"point = {x=1, y.z=2}\n" +
+				"empty = {}\n" +
+				"points = [ { x = 1 }, { x = 2 } ]\n",
+			want: map[string]any{
+				"name":   map[string]any{"first": "Tom", "last": "Preston-Werner"},
+				"point":  map[string]any{"x": int64(1), "y": map[string]any{"z": int64(2)}},
+				"empty":  map[string]any{},
+				"points": []any{map[string]any{"x": int64(1)}, map[string]any{"x": int64(2)}},
+			},
+		},
+		{
+			name: "Tables",
+			input: "top = 1\n" +
+				"[table-1]\nkey1 = \"some string\"\n\n" +
+				"[ dog . \"tater.man\" ]\ntype.name = \"pug\"\n" +
+				"[x.y.z.w]\n" +
+				"[x]\n",
+			want: map[string]any{
+				"top":     int64(1),
+				"table-1": map[string]any{"key1": "some string"},
+				"dog": map[string]any{
+					"tater.man": map[string]any{
+						"type": map[string]any{"name": "pug"},
+					},
+				},
+				"x": map[string]any{
+					"y": map[string]any{
+						"z": map[string]any{
+							"w": map[string]any{},
+						},
+					},
+				},
+			},
+		},
+		{
+			name: "DottedKeySubTable",
+			input: "[fruit]\napple.color = \"red\"\napple.taste.sweet = true\n" +
+				"[fruit.apple.texture]\nsmooth = true\n",
+			want: map[string]any{
+				"fruit": map[string]any{
+					"apple": map[string]any{
+						"color":   "red",
+						"taste":   map[string]any{"sweet": true},
+						"texture": map[string]any{"smooth": true},
+					},
+				},
+			},
+		},
+		{
+			name: "ArrayOfTables",
+			input: "[[package]]\nname = \"a\"\n" +
+				"[[package.dependencies]]\nname = \"b\"\n" +
+				"[package.source]\nurl = \"x\"\n" +
+				"[[package]]\nname = \"c\"\n",
+			want: map[string]any{
+				"package": []any{
+					map[string]any{
+						"name":         "a",
+						"dependencies": []any{map[string]any{"name": "b"}},
+						"source":       map[string]any{"url": "x"},
+					},
+					map[string]any{"name": "c"},
+				},
+			},
+		},
+		{
+			name:  "ByteOrderMark",
+			input: "\ufeffa = 1\n",
+			want:  map[string]any{"a": int64(1)},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse([]byte(test.input))
+			if err != nil {
+				t.Fatal("Parse:", err)
+			}
+			if diff := cmp.Diff(test.want, got, cmpopts.EquateNaNs()); diff != "" {
+				t.Errorf("Parse(...) (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseNaN(t *testing.T) {
+	got, err := Parse([]byte("a = nan\nb = -nan\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, k := range []string{"a", "b"} {
+		if f, ok := got[k].(float64); !ok || !math.IsNaN(f) {
+			t.Errorf("%s = %#v; want NaN", k, got[k])
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr string
+	}{
+		{name: "MissingValue", input: "a =\n", wantErr: "line 1"},
+		{name: "MissingEquals", input: "a 1\n", wantErr: "expected '='"},
+		{name: "TwoPairsOnLine", input: "a = 1 b = 2\n", wantErr: "expected newline"},
+		{name: "DuplicateKey", input: "a = 1\na = 2\n", wantErr: "line 2: key a already defined"},
+		{name: "DuplicateTable", input: "[a]\n[a]\n", wantErr: "table a already defined"},
+		{name: "TableOverValue", input: "[fruit]\napple = 1\n[fruit.apple]\n", wantErr: "already defined"},
+		{name: "HeaderForDottedTable", input: "[fruit]\napple.color = 1\n[fruit.apple]\n", wantErr: "already defined"},
+		{name: "DottedKeyIntoHeaderTable", input: "[a.b.c]\nz = 9\n[a]\nb.c.t = 1\n", wantErr: "cannot add keys"},
+		{name: "ExtendInlineTable", input: "a = {b = 1}\na.c = 2\n", wantErr: "cannot add keys"},
+		{name: "HeaderIntoInlineTable", input: "a = {b = {}}\n[a.b.c]\n", wantErr: "cannot extend inline table"},
+		{name: "AppendToStaticArray", input: "a = []\n[[a]]\n", wantErr: "already defined"},
+		{name: "TableOverArrayOfTables", input: "[[a]]\n[a]\n", wantErr: "already defined"},
+		{name: "NewlineInInlineTable", input: "a = {b = 1,\nc = 2}\n", wantErr: "line 1"},
+		{name: "TrailingCommaInInlineTable", input: "a = {b = 1,}\n", wantErr: "expected key"},
+		{name: "UnterminatedString", input: "a = \"abc\nb = 1\n", wantErr: "unterminated string"},
+		{name: "UnterminatedMultilineString", input: "a = '''abc\n", wantErr: "unterminated string"},
+		{name: "InvalidEscape", input: `a = "\q"`, wantErr: "invalid escape"},
+		{name: "SurrogateEscape", input: `a = "\uD800"`, wantErr: "invalid Unicode escape"},
+		{name: "TooManyQuotes", input: `a = """abc""""""`, wantErr: "too many quotes"},
+		{name: "ControlCharacter", input: "a = \"\x01\"", wantErr: "control character"},
+		{name: "LeadingZero", input: "a = 012\n", wantErr: "invalid number"},
+		{name: "DoubleUnderscore", input: "a = 1__2\n", wantErr: "invalid number"},
+		{name: "TrailingUnderscore", input: "a = 1_\n", wantErr: "invalid number"},
+		{name: "SignedHex", input: "a = +0x1\n", wantErr: "invalid number"},
+		{name: "MissingFraction", input: "a = 1.\n", wantErr: "invalid number"},
+		{name: "MissingIntegerPart", input: "a = .5\n", wantErr: "invalid number"},
+		{name: "IntegerOverflow", input: "a = 9223372036854775808\n", wantErr: "out of range"},
+		{name: "BareWord", input: "a = yes\n", wantErr: "invalid number"},
+		{name: "InvalidDate", input: "a = 1979-13-27\n", wantErr: "invalid date-time"},
+		{name: "TimeWithoutSeconds", input: "a = 07:32\n", wantErr: "invalid date-time"},
+		{name: "InvalidUTF8", input: "a = \"\xff\"\n", wantErr: "invalid UTF-8"},
+		{name: "MultilineKey", input: "\"\"\"a\"\"\" = 1\n", wantErr: "multi-line strings cannot be used as keys"},
+		{name: "NestingTooDeep", input: "a = " + strings.Repeat("[", maxDepth+1), wantErr: "nesting too deep"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := Parse([]byte(test.input))
+			if err == nil {
+				t.Fatalf("Parse(%q) = %v, <nil>; want error", test.input, got)
+			}
+			if !strings.Contains(err.Error(), test.wantErr) {
+				t.Errorf("Parse(%q) error = %v; want to contain %q", test.input, err, test.wantErr)
+			}
+		})
+	}
+}
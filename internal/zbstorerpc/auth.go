@@ -0,0 +1,52 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/textproto"
+	"strings"
+
+	"zb.256lights.llc/pkg/internal/jsonrpc"
+)
+
+// authContentType is the MIME media type for a message that authenticates the client.
+const authContentType = "application/zb-store-auth"
+
+// WriteAuth writes a message to w that authenticates the client with the given bearer token.
+// The message should be the first message a client sends on a connection.
+// Servers that do not require a token ignore the message.
+func WriteAuth(w io.Writer, token string) error {
+	if token == "" || strings.ContainsAny(token, "\r\n") {
+		return fmt.Errorf("write auth message: invalid token")
+	}
+	return jsonrpc.NewWriter(w).WriteMessage(jsonrpc.Header{
+		"Content-Type":   {authContentType},
+		"Content-Length": {"0"},
+		"Authorization":  {"Bearer " + token},
+	}, strings.NewReader(""))
+}
+
+// ReadAuth reads a message written by [WriteAuth] from r
+// and returns the bearer token it contains.
+// ReadAuth does not read past the end of the message.
+func ReadAuth(r *bufio.Reader) (token string, err error) {
+	header, err := textproto.NewReader(r).ReadMIMEHeader()
+	if err != nil {
+		return "", fmt.Errorf("read auth message: %w", err)
+	}
+	if ct := header.Get("Content-Type"); ct != authContentType {
+		return "", fmt.Errorf("read auth message: unexpected Content-Type %q", ct)
+	}
+	if cl := header.Get("Content-Length"); cl != "" && cl != "0" {
+		return "", fmt.Errorf("read auth message: body not permitted")
+	}
+	scheme, token, ok := strings.Cut(header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+		return "", fmt.Errorf("read auth message: missing bearer token")
+	}
+	return token, nil
+}
@@ -0,0 +1,66 @@
+// Copyright 2025 The zb Authors
+// SPDX-License-Identifier: MIT
+
+package zbstorerpc
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestAuth(t *testing.T) {
+	const token = "xyzzy"
+	const rest = "Content-Type: application/zb-store-rpc+json\r\n"
+	buf := new(bytes.Buffer)
+	if err := WriteAuth(buf, token); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString(rest)
+
+	r := bufio.NewReader(buf)
+	got, err := ReadAuth(r)
+	if got != token || err != nil {
+		t.Errorf("ReadAuth(...) = %q, %v; want %q, <nil>", got, err, token)
+	}
+	if gotRest, _ := r.ReadString(0); gotRest != rest {
+		t.Errorf("after ReadAuth, remaining = %q; want %q", gotRest, rest)
+	}
+}
+
+func TestReadAuthErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		msg  string
+	}{
+		{
+			name: "Empty",
+			msg:  "",
+		},
+		{
+			name: "RPC",
+			msg:  "Content-Type: application/zb-store-rpc+json\r\nContent-Length: 2\r\n\r\n{}",
+		},
+		{
+			name: "NoToken",
+			msg:  "Content-Type: application/zb-store-auth\r\nContent-Length: 0\r\n\r\n",
+		},
+		{
+			name: "Basic",
+			msg:  "Content-Type: application/zb-store-auth\r\nAuthorization: Basic Zm9vOmJhcg==\r\n\r\n",
+		},
+		{
+			name: "Body",
+			msg:  "Content-Type: application/zb-store-auth\r\nAuthorization: Bearer xyzzy\r\nContent-Length: 3\r\n\r\nabc",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			token, err := ReadAuth(bufio.NewReader(strings.NewReader(test.msg)))
+			if err == nil {
+				t.Errorf("ReadAuth(...) = %q, <nil>; want error", token)
+			}
+		})
+	}
+}
@@ -15,11 +15,16 @@ import (
 	"time"
 	"unicode/utf8"
 
+	"zb.256lights.llc/pkg/internal/jsonrpc"
 	"zb.256lights.llc/pkg/internal/xiter"
 	"zb.256lights.llc/pkg/zbstore"
 	"zombiezen.com/go/nix"
 )
 
+// PermissionDenied is the error code that a store returns
+// when the client is not permitted to make a request.
+const PermissionDenied jsonrpc.ErrorCode = -32010
+
 // ExistsMethod is the name of the method that checks whether a store path exists.
 // [ExistsRequest] is used for the request and the response is a boolean.
 const ExistsMethod = "zb.exists"
@@ -71,11 +76,26 @@ type RealizeRequest struct {
 	// KeepFailed indicates that if the realization fails,
 	// the user wants the store to keep the build directory for further investigation.
 	KeepFailed bool `json:"keepFailed"`
+	// Check indicates that the store should build the requested derivations again
+	// even though they have already been realized
+	// and compare the new outputs to the existing realizations.
+	// The new outputs are reported in [RealizeOutput.CheckPath].
+	// Dependencies of the requested derivations are realized as usual.
+	// If the new outputs differ from all of the existing realizations,
+	// the derivation's build result has the status [BuildFail].
+	// Derivations with fixed outputs are not built again,
+	// since their outputs are already verified against their content address.
+	Check bool `json:"check,omitempty"`
 }
 
 // RealizeResponse is the result for [RealizeMethod].
 type RealizeResponse struct {
 	BuildID string `json:"buildID"`
+	// Derivations is the number of derivations
+	// that the build may need to realize,
+	// including the requested derivations and their dependencies.
+	// Stores that do not compute this leave it zero.
+	Derivations int `json:"derivations,omitempty"`
 }
 
 // ExpandMethod is the name of the method that performs placeholder expansion
@@ -129,13 +149,18 @@ const (
 	BuildFail BuildStatus = "fail"
 	// BuildError is the status used for a build that encountered an internal error.
 	BuildError BuildStatus = "error"
+	// BuildTimedOut is the status used for a build that has one or more derivations
+	// whose builder was stopped for running too long or for not producing output.
+	// A build that has both failed and timed out derivations has the status [BuildFail].
+	BuildTimedOut BuildStatus = "timedOut"
 )
 
 // IsFinished reports whether the status indicates that the build has finished.
 func (status BuildStatus) IsFinished() bool {
 	return status == BuildSuccess ||
 		status == BuildFail ||
-		status == BuildError
+		status == BuildError ||
+		status == BuildTimedOut
 }
 
 // Build is the result for [GetBuildMethod].
@@ -146,6 +171,9 @@ type Build struct {
 	EndedAt   Nullable[time.Time] `json:"endedAt"`
 	Results   []*BuildResult      `json:"results"`
 	Expand    *ExpandResult       `json:"expand,omitempty"`
+
+	// Error is a description of the internal error that stopped the build, if any.
+	Error string `json:"error,omitempty"`
 }
 
 // Duration returns the length of the build.
@@ -190,6 +218,48 @@ func (resp *Build) FindRealizeOutput(ref zbstore.OutputReference) (Nullable[zbst
 	return FindRealizeOutput(slices.Values(results), ref)
 }
 
+// ListBuildsMethod is the name of the method
+// that lists the builds known to the store,
+// most recently started or finished first.
+// [ListBuildsRequest] is used for the request
+// and [ListBuildsResponse] is used for the response.
+const ListBuildsMethod = "zb.listBuilds"
+
+// ListBuildsRequest is the set of parameters for [ListBuildsMethod].
+// Empty fields do not restrict the search.
+type ListBuildsRequest struct {
+	// Status restricts the list to builds with the given status.
+	Status BuildStatus `json:"status,omitempty"`
+	// StartedAfter restricts the list to builds started at or after the given time.
+	StartedAfter Nullable[time.Time] `json:"startedAfter"`
+	// StartedBefore restricts the list to builds started before the given time.
+	StartedBefore Nullable[time.Time] `json:"startedBefore"`
+	// DrvName restricts the list to builds that realized a derivation
+	// with the given name (e.g. "hello" for "/opt/zb/store/ib4dfwymfd8n7d2kr2kqn9qbmg8r6hgl-hello.drv").
+	DrvName string `json:"drvName,omitempty"`
+
+	// Limit is the maximum number of builds to return.
+	// If zero, then the store picks a default.
+	// The store may return fewer builds than the limit
+	// even if more builds match.
+	Limit int `json:"limit,omitempty"`
+	// PageToken is the NextPageToken from a previous [ListBuildsResponse].
+	// If empty, then the list starts at the most recent build.
+	PageToken string `json:"pageToken,omitempty"`
+}
+
+// ListBuildsResponse is the result for [ListBuildsMethod].
+type ListBuildsResponse struct {
+	// Builds is the list of matching builds.
+	// Results and Expand are not populated:
+	// use [GetBuildMethod] to get the details of an individual build.
+	Builds []*Build `json:"builds"`
+	// NextPageToken is an opaque string that can be passed as PageToken
+	// to get the next page of builds.
+	// It is empty if there are no more builds.
+	NextPageToken string `json:"nextPageToken,omitempty"`
+}
+
 // GetBuildResultMethod is the name of the method
 // that queries the status of a single builder in a [Build].
 // [GetBuildResultRequest] is used for the request
@@ -202,12 +272,67 @@ type GetBuildResultRequest struct {
 	DrvPath zbstore.Path `json:"drvPath"`
 }
 
+// FindBuildResultMethod is the name of the method
+// that searches for the most recent [BuildResult] matching some criteria.
+// [FindBuildResultRequest] is used for the request
+// and [FindBuildResultResponse] is used for the response.
+const FindBuildResultMethod = "zb.findBuildResult"
+
+// FindBuildResultRequest is the set of parameters for [FindBuildResultMethod].
+// Empty fields do not restrict the search.
+type FindBuildResultRequest struct {
+	// DrvPath restricts the search to build results for the given derivation.
+	DrvPath zbstore.Path `json:"drvPath,omitempty"`
+	// OutputPath restricts the search to build results
+	// that produced the given store object.
+	// Results from builders that ran are preferred
+	// over results that reused an existing realization.
+	OutputPath zbstore.Path `json:"outputPath,omitempty"`
+	// Failed restricts the search to build results that did not succeed.
+	Failed bool `json:"failed,omitempty"`
+}
+
+// FindBuildResultResponse is the result for [FindBuildResultMethod].
+// If no build result matched the request,
+// then BuildID is empty and Result is nil.
+type FindBuildResultResponse struct {
+	BuildID string       `json:"buildID"`
+	Result  *BuildResult `json:"result"`
+}
+
 // BuildResult is the result of a single derivation in a [Build].
 type BuildResult struct {
 	DrvPath zbstore.Path     `json:"drvPath"`
 	Status  BuildStatus      `json:"status"`
 	Outputs []*RealizeOutput `json:"outputs"`
 	LogSize int64            `json:"logSize"`
+
+	// StartedAt is the time that the store started realizing the derivation,
+	// or null if it has not started.
+	StartedAt Nullable[time.Time] `json:"startedAt"`
+	// EndedAt is the time that the store finished realizing the derivation,
+	// or null if it has not finished.
+	EndedAt Nullable[time.Time] `json:"endedAt"`
+	// Error is a description of why the derivation was not realized successfully.
+	// It is empty if the status is not [BuildFail], [BuildError], or [BuildTimedOut].
+	Error string `json:"error,omitempty"`
+
+	// PeakMemory is the maximum number of bytes of memory
+	// used by the builder's processes at once,
+	// or null if it was not measured.
+	PeakMemory Nullable[int64] `json:"peakMemory"`
+	// CPUTime is the number of seconds of CPU time
+	// used by the builder's processes,
+	// or null if it was not measured.
+	CPUTime Nullable[float64] `json:"cpuTime"`
+}
+
+// Duration returns the length of time the store spent realizing the derivation.
+func (result *BuildResult) Duration() time.Duration {
+	if !result.StartedAt.Valid || !result.EndedAt.Valid {
+		return 0
+	}
+	return result.EndedAt.X.Sub(result.StartedAt.X)
 }
 
 // OutputForName returns the [*RealizeOutput] with the given name.
@@ -264,6 +389,12 @@ type RealizeOutput struct {
 	// Path is the store path of the output if successfully built,
 	// or null if the build failed.
 	Path Nullable[zbstore.Path] `json:"path"`
+	// CheckPath is the store path produced by building the derivation again
+	// for a [RealizeRequest] with Check set,
+	// or null if the output was not checked.
+	// If CheckPath differs from Path,
+	// then the derivation did not produce the same output twice.
+	CheckPath Nullable[zbstore.Path] `json:"checkPath"`
 }
 
 // CancelBuildMethod is the name of the method that informs the store
@@ -317,25 +448,127 @@ type ReadLogResponse struct {
 
 // Payload returns the log's byte content.
 func (resp *ReadLogResponse) Payload() ([]byte, error) {
+	return decodeLogPayload(resp.Text, resp.Base64)
+}
+
+// SetPayload sets resp.Text and resp.Base64 to reflect the given payload.
+func (resp *ReadLogResponse) SetPayload(src []byte) {
+	resp.Text, resp.Base64 = encodeLogPayload(src)
+}
+
+// WatchBuildMethod is the name of the method that follows the progress of a build.
+// [WatchBuildRequest] is used for the request
+// and [Build] is used for the response.
+// While the request is in progress,
+// the store sends [BuildEventMethod] notifications to the client
+// over the same connection.
+// Events that occurred before the request started are sent first,
+// so the client observes every event in the build.
+// The store responds once the build has finished
+// and every event for the build has been sent.
+const WatchBuildMethod = "zb.watchBuild"
+
+// WatchBuildRequest is the set of parameters for [WatchBuildMethod].
+type WatchBuildRequest struct {
+	BuildID string `json:"buildID"`
+	// If Logs is true, then the store sends [BuildLogEvent] events.
+	Logs bool `json:"logs,omitempty"`
+	// DrvPath is an optional derivation path.
+	// If not empty, then only events for the derivation are sent
+	// and the store responds once the derivation has finished
+	// instead of waiting for the whole build.
+	DrvPath zbstore.Path `json:"drvPath,omitempty"`
+	// LogStart is the first byte of the log to send
+	// if DrvPath is not empty.
+	LogStart int64 `json:"logStart,omitempty"`
+	// WatchID is an optional identifier chosen by the client
+	// that the store copies into every [BuildEvent] sent for the request.
+	// Notifications are delivered to every request in flight on a connection,
+	// so clients with concurrent watches should use a unique WatchID for each
+	// to tell their events apart.
+	WatchID string `json:"watchID,omitempty"`
+}
+
+// BuildEventMethod is the name of the method
+// that the store uses to notify a client of progress in a build.
+// The store only sends these notifications
+// while the client has a [WatchBuildMethod] request in progress.
+// [BuildEvent] is used for the notification parameters.
+const BuildEventMethod = "zb.buildEvent"
+
+// BuildEventKind is an enumeration of types of [BuildEvent].
+type BuildEventKind string
+
+// Defined build event kinds.
+const (
+	// DerivationStartedEvent is sent when the store starts realizing a derivation.
+	DerivationStartedEvent BuildEventKind = "derivationStarted"
+	// DerivationFinishedEvent is sent when the store has successfully realized a derivation.
+	DerivationFinishedEvent BuildEventKind = "derivationFinished"
+	// DerivationFailedEvent is sent when the store was unable to realize a derivation.
+	DerivationFailedEvent BuildEventKind = "derivationFailed"
+	// OutputRealizedEvent is sent when an output of a derivation has a store path.
+	OutputRealizedEvent BuildEventKind = "outputRealized"
+	// BuildLogEvent is sent when a builder writes to its log.
+	BuildLogEvent BuildEventKind = "log"
+)
+
+// BuildEvent is the set of parameters for [BuildEventMethod].
+type BuildEvent struct {
+	BuildID string         `json:"buildID"`
+	Kind    BuildEventKind `json:"kind"`
+	DrvPath zbstore.Path   `json:"drvPath"`
+	// WatchID is the [WatchBuildRequest.WatchID]
+	// of the request that the event was sent for.
+	WatchID string `json:"watchID,omitempty"`
+
+	// Result is the derivation's build result at the time of the event.
+	// It is set for [DerivationStartedEvent], [DerivationFinishedEvent],
+	// and [DerivationFailedEvent].
+	Result *BuildResult `json:"result,omitempty"`
+	// Output is the realized output for [OutputRealizedEvent].
+	Output *RealizeOutput `json:"output,omitempty"`
+	// Log is the log content for [BuildLogEvent].
+	Log *LogChunk `json:"log,omitempty"`
+}
+
+// LogChunk is a contiguous part of a builder's log in a [BuildEvent].
+// At most one of Text or Base64 should be set;
+// the payload fields can be read with [*LogChunk.Payload]
+// and can be written with [*LogChunk.SetPayload].
+type LogChunk struct {
+	// Offset is the position of the first byte of the chunk in the log.
+	Offset int64  `json:"offset"`
+	Text   string `json:"text,omitempty"`
+	Base64 string `json:"base64,omitempty"`
+}
+
+// Payload returns the chunk's byte content.
+func (chunk *LogChunk) Payload() ([]byte, error) {
+	return decodeLogPayload(chunk.Text, chunk.Base64)
+}
+
+// SetPayload sets chunk.Text and chunk.Base64 to reflect the given payload.
+func (chunk *LogChunk) SetPayload(src []byte) {
+	chunk.Text, chunk.Base64 = encodeLogPayload(src)
+}
+
+func decodeLogPayload(text, b64 string) ([]byte, error) {
 	switch {
-	case resp.Base64 != "":
-		return base64.StdEncoding.DecodeString(resp.Base64)
-	case resp.Text != "":
-		return []byte(resp.Text), nil
+	case b64 != "":
+		return base64.StdEncoding.DecodeString(b64)
+	case text != "":
+		return []byte(text), nil
 	default:
 		return nil, nil
 	}
 }
 
-// SetPayload sets resp.Text and resp.Base64 to reflect the given payload.
-func (resp *ReadLogResponse) SetPayload(src []byte) {
+func encodeLogPayload(src []byte) (text, b64 string) {
 	if utf8.Valid(src) {
-		resp.Text = string(src)
-		resp.Base64 = ""
-	} else {
-		resp.Text = ""
-		resp.Base64 = base64.StdEncoding.EncodeToString(src)
+		return string(src), ""
 	}
+	return "", base64.StdEncoding.EncodeToString(src)
 }
 
 // ExportMethod is the name of the method that triggers an export of store objects.
@@ -354,6 +587,121 @@ type ExportRequest struct {
 	ExcludeReferences bool `json:"excludeReferences"`
 }
 
+// AddRootMethod is the name of the method that registers an indirect garbage collection root.
+// An indirect root is a symlink outside the store directory that points to a store object.
+// The store object (and its closure) will not be collected
+// as long as the symlink continues to point to it.
+// If the symlink is removed, then the store unregisters the root
+// during its next garbage collection.
+// [AddRootRequest] is used for the request and the response is null.
+const AddRootMethod = "zb.addRoot"
+
+// AddRootRequest is the set of parameters for [AddRootMethod].
+type AddRootRequest struct {
+	// Link is the absolute path of the symlink on the store's filesystem.
+	Link string `json:"link"`
+}
+
+// GCMethod is the name of the method that deletes store objects
+// that are not reachable from any garbage collection root.
+// [GCRequest] is used for the request
+// and [GCResponse] is used for the response.
+const GCMethod = "zb.gc"
+
+// GCRequest is the set of parameters for [GCMethod].
+type GCRequest struct {
+	// MaxFreed is the maximum number of bytes to free.
+	// If zero, then all unreachable store objects are deleted.
+	// The store may free more than MaxFreed bytes
+	// if it cannot stop at exactly MaxFreed bytes.
+	MaxFreed int64 `json:"maxFreed,omitempty"`
+	// If DryRun is true, then the store reports
+	// which store objects it would have deleted
+	// without deleting them.
+	DryRun bool `json:"dryRun,omitempty"`
+}
+
+// GCResponse is the result for [GCMethod].
+type GCResponse struct {
+	// Paths is the list of store objects deleted
+	// (or that would have been deleted in a dry run).
+	Paths []zbstore.Path `json:"paths"`
+	// BytesFreed is the sum of the NAR sizes of the store objects in Paths.
+	BytesFreed int64 `json:"bytesFreed"`
+}
+
+// VerifyMethod is the name of the method that checks
+// that the store's database is consistent with its store directory.
+// [VerifyRequest] is used for the request
+// and [VerifyResponse] is used for the response.
+const VerifyMethod = "zb.verify"
+
+// VerifyRequest is the set of parameters for [VerifyMethod].
+type VerifyRequest struct {
+	// If CheckContents is true, then the store serializes each store object
+	// and compares its NAR hash, NAR size, and content address
+	// to the values recorded when the object was added.
+	// Otherwise, the store only checks that each store object is present.
+	CheckContents bool `json:"checkContents,omitempty"`
+	// If Repair is true, then the store attempts to fix the problems it finds.
+	Repair bool `json:"repair,omitempty"`
+}
+
+// VerifyResponse is the result for [VerifyMethod].
+type VerifyResponse struct {
+	// Checked is the number of store objects that were checked.
+	Checked int `json:"checked"`
+	// Problems is the list of problems found in the store.
+	Problems []*VerifyProblem `json:"problems"`
+}
+
+// VerifyProblemKind is an enumeration of types of [VerifyProblem].
+type VerifyProblemKind string
+
+// Defined verify problem kinds.
+const (
+	// MissingObject is a store object that is recorded in the database
+	// but is not present in the store directory.
+	MissingObject VerifyProblemKind = "missing"
+	// CorruptObject is a store object whose content
+	// does not match the NAR hash, NAR size, or content address
+	// recorded in the database.
+	CorruptObject VerifyProblemKind = "corrupt"
+	// OrphanObject is a file in the store directory
+	// that is not recorded in the database.
+	OrphanObject VerifyProblemKind = "orphan"
+)
+
+// VerifyRepair is an enumeration of actions taken to fix a [VerifyProblem].
+type VerifyRepair string
+
+// Defined verify repairs.
+const (
+	// Substituted indicates that the store object was downloaded again
+	// from one of the store's substituters.
+	Substituted VerifyRepair = "substituted"
+	// Quarantined indicates that the file was moved out of the store directory
+	// into the store's quarantine directory.
+	Quarantined VerifyRepair = "quarantined"
+	// Forgotten indicates that the store object was removed from the database
+	// (and moved to the quarantine directory if present)
+	// so that the next build that needs it will rebuild or substitute it.
+	Forgotten VerifyRepair = "forgotten"
+)
+
+// VerifyProblem is a single problem found by [VerifyMethod].
+type VerifyProblem struct {
+	Path    zbstore.Path      `json:"path"`
+	Kind    VerifyProblemKind `json:"kind"`
+	Message string            `json:"message"`
+
+	// Repair is the action taken to fix the problem
+	// or the empty string if the problem was not fixed.
+	Repair VerifyRepair `json:"repair,omitempty"`
+	// RepairError is a description of why the problem could not be fixed, if any.
+	RepairError string `json:"repairError,omitempty"`
+}
+
 // Nullable wraps a type to permit a null JSON serialization.
 // The zero value is null.
 type Nullable[T any] struct {
@@ -11,13 +11,14 @@ dofile = nil
 ---@field system string
 ---@field builder string
 ---@field args string[]
+---@field outputs string[]?
 ---@field drvPath string
 ---@field out string
 ---@field [string] string|number|boolean|derivation|(string|number|boolean|derivation)[]
 ---@operator concat:string
 
 ---Create a derivation (a buildable target).
----@param args { name: string, system: string, builder: string, args: string[], [string]: string|number|boolean|(string|number|boolean)[] }
+---@param args { name: string, system: string, builder: string, args: string[], outputs: string[]?, [string]: string|number|boolean|(string|number|boolean)[] }
 ---@return derivation
 function derivation(args) end
 